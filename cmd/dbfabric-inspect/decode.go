@@ -0,0 +1,315 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/database-fabric/db"
+	"github.com/database-fabric/db/block"
+	"github.com/database-fabric/db/index/linkedlist"
+	"github.com/database-fabric/db/index/tree"
+	"github.com/database-fabric/db/table"
+	"github.com/database-fabric/db/util"
+	"strconv"
+	"strings"
+)
+
+var keyTypeNames = map[db.KeyType]string{
+	db.ChainKeyType:"chain",
+	db.DataBaseKeyType:"database",
+	db.TableKeyType:"table",
+	db.TallyKeyType:"tally",
+	db.RelationKeyType:"relation",
+	db.BlockKeyType:"block",
+	db.IndexKeyType:"index",
+	db.MerkleKeyType:"merkle",
+	db.ChunkKeyType:"chunk",
+	db.CompactKeyType:"compact",
+	db.DropKeyType:"drop",
+	db.LayoutKeyType:"layout",
+	db.CounterKeyType:"counter",
+	db.ChunkRefKeyType:"chunkRef",
+}
+
+var indexTypeNames = map[db.IndexType]string{
+	db.BPTreeHeadIndexType:"treeHead",
+	db.BPTreeNodeIndexType:"treeNode",
+	db.LinkedHeadIndexType:"linkedHead",
+	db.LinkedNodeIndexType:"linkedNode",
+}
+
+/**
+	Key解码结果，只设置Key类型对应的字段
+ */
+type KeyInfo struct {
+	Key string `json:"key"`
+	Type string `json:"type"`
+	KeyType db.KeyType `json:"keyType"`
+	IndexType *db.IndexType `json:"indexType,omitempty"`
+	Database db.DatabaseID `json:"database,omitempty"`
+	Table db.TableID `json:"table,omitempty"`
+	Column db.ColumnID `json:"column,omitempty"`
+	Shard *int8 `json:"shard,omitempty"`
+	Block db.BlockID `json:"block,omitempty"`
+	Row db.RowID `json:"row,omitempty"`
+	Pointer int64 `json:"pointer,omitempty"`
+	Height *uint8 `json:"height,omitempty"`
+	Index *int64 `json:"index,omitempty"`
+	Hash string `json:"hash,omitempty"`
+	Group *int64 `json:"group,omitempty"`
+}
+
+//树节点显示结构，二进制值使用hex
+type treeNodeView struct {
+	Pointer tree.Pointer `json:"pointer"`
+	Type string `json:"type"`
+	Prev tree.Pointer `json:"prev"`
+	Next tree.Pointer `json:"next"`
+	Keys []string `json:"keys"`
+	Values []string `json:"values"`
+}
+
+type linkedNodeView struct {
+	Pointer linkedlist.Pointer `json:"pointer"`
+	Prev linkedlist.Pointer `json:"prev"`
+	Next linkedlist.Pointer `json:"next"`
+	Values []string `json:"values"`
+}
+
+type chunkView struct {
+	Length int `json:"length"`
+	Hash string `json:"hash"`
+	Head string `json:"head"` //前32字节
+}
+
+/**
+	解码Key，格式为：类型-组合键(~分隔)，索引Key为：类型-索引类型-组合键，链Key为：-类型
+ */
+func decodeKey(key string) (*KeyInfo,error) {
+	position := strings.Index(key, "-")
+	if position < 0 {
+		return nil,fmt.Errorf("key `%s` prefix not found", key)
+	}
+	prefix,rest := key[:position],key[position+1:]
+	if prefix == db.ChainPrefix {
+		prefix,rest = rest,""
+	}
+	keyType,err := strconv.ParseUint(prefix, 10, 8); if err != nil {
+		return nil,fmt.Errorf("key `%s` type error %s", key, err)
+	}
+	info := &KeyInfo{Key:key,KeyType:db.KeyType(keyType)}
+	name,ok := keyTypeNames[info.KeyType]
+	if !ok {
+		return nil,fmt.Errorf("key `%s` type `%d` unknown", key, keyType)
+	}
+	info.Type = name
+	if info.KeyType == db.IndexKeyType {
+		position = strings.Index(rest, "-")
+		if position < 0 {
+			return nil,fmt.Errorf("key `%s` index type not found", key)
+		}
+		indexType,err := strconv.ParseUint(rest[:position], 10, 8); if err != nil {
+			return nil,fmt.Errorf("key `%s` index type error %s", key, err)
+		}
+		it := db.IndexType(indexType)
+		info.IndexType = &it
+		if name,ok := indexTypeNames[it]; ok {
+			info.Type = name
+		}
+		rest = rest[position+1:]
+	}
+	var parts []string
+	if rest != "" {
+		parts = strings.Split(rest, "~")
+	}
+	numbers := make([]int64, len(parts))
+	for i,part := range parts {
+		if (info.KeyType == db.ChunkKeyType || info.KeyType == db.ChunkRefKeyType) && i == 1 {//哈希
+			continue
+		}
+		numbers[i],err = parseKeyPart(part); if err != nil {
+			return nil,fmt.Errorf("key `%s` part `%s` error %s", key, part, err)
+		}
+	}
+	expect := map[db.KeyType][]int{
+		db.ChainKeyType:{0},
+		db.DataBaseKeyType:{1},
+		db.TableKeyType:{2},
+		db.TallyKeyType:{2,3},
+		db.RelationKeyType:{1},
+		db.BlockKeyType:{3},
+		db.MerkleKeyType:{5},
+		db.ChunkKeyType:{3},
+		db.CompactKeyType:{3},
+		db.DropKeyType:{2},
+		db.LayoutKeyType:{0},
+		db.CounterKeyType:{4},
+		db.ChunkRefKeyType:{2},
+	}
+	if counts,ok := expect[info.KeyType]; ok && !containsInt(counts, len(parts)) {
+		return nil,fmt.Errorf("key `%s` parts %d error", key, len(parts))
+	}
+	if info.KeyType == db.IndexKeyType {
+		indexParts := map[db.IndexType]int{db.BPTreeHeadIndexType:3,db.BPTreeNodeIndexType:4,db.LinkedHeadIndexType:4,db.LinkedNodeIndexType:5}
+		treeIndex := *info.IndexType == db.BPTreeHeadIndexType || *info.IndexType == db.BPTreeNodeIndexType
+		if indexParts[*info.IndexType] != len(parts) && !(treeIndex && indexParts[*info.IndexType]+1 == len(parts)) {
+			return nil,fmt.Errorf("key `%s` parts %d error", key, len(parts))
+		}
+		info.Column = db.ColumnID(numbers[2])
+		if treeIndex && indexParts[*info.IndexType]+1 == len(parts) {//主键索引分片，分片号在列之后
+			shard := int8(numbers[3])
+			info.Shard = &shard
+			numbers = append(numbers[:3], numbers[4:]...)
+		}
+		switch *info.IndexType {
+		case db.BPTreeNodeIndexType:
+			info.Pointer = numbers[3]
+		case db.LinkedHeadIndexType:
+			info.Row = numbers[3]
+		case db.LinkedNodeIndexType:
+			info.Row,info.Pointer = numbers[3],numbers[4]
+		}
+	}
+	if len(parts) > 0 {
+		info.Database = db.DatabaseID(numbers[0])
+	}
+	if len(parts) > 1 && info.KeyType != db.ChunkKeyType && info.KeyType != db.ChunkRefKeyType {
+		info.Table = db.TableID(numbers[1])
+	}
+	switch info.KeyType {
+	case db.TallyKeyType:
+		if len(parts) == 3 {
+			shard := int8(numbers[2])
+			info.Shard = &shard
+		}
+	case db.BlockKeyType,db.CompactKeyType:
+		info.Block = db.BlockID(numbers[2])
+	case db.MerkleKeyType:
+		shard,height := int8(numbers[2]),uint8(numbers[3])
+		info.Shard,info.Height,info.Index = &shard,&height,&numbers[4]
+	case db.ChunkKeyType:
+		info.Hash,info.Index = parts[1],&numbers[2]
+	case db.ChunkRefKeyType:
+		info.Hash = parts[1]
+	case db.CounterKeyType:
+		info.Index,info.Group = &numbers[2],&numbers[3]
+	}
+	return info,nil
+}
+
+/**
+	Key中的整数，新布局为保序编码，未迁移的旧布局为十进制
+ */
+func parseKeyPart(part string) (int64,error) {
+	if util.IsDecimalKeyString(part) || strings.HasPrefix(part, "-") {
+		return strconv.ParseInt(part, 10, 64)
+	}
+	return util.KeyStringToInt64(part)
+}
+
+/**
+	按Key类型解码值
+ */
+func decodeValue(info *KeyInfo, value []byte) (interface{},error) {
+	if len(value) == 0 {
+		return nil,nil
+	}
+	switch info.KeyType {
+	case db.ChainKeyType,db.DataBaseKeyType:
+		var names []string
+		return names,json.Unmarshal(value, &names)
+	case db.TableKeyType:
+		return table.DecodeTableData(value)
+	case db.TallyKeyType:
+		tally := &db.TableTally{}
+		return tally,json.Unmarshal(value, tally)
+	case db.RelationKeyType:
+		relation := &db.Relation{}
+		return relation,json.Unmarshal(value, relation)
+	case db.BlockKeyType:
+		return block.DecodeBlock(value)
+	case db.CompactKeyType:
+		record := &db.BlockCompact{}
+		return record,json.Unmarshal(value, record)
+	case db.DropKeyType:
+		drop := &db.TableDrop{}
+		return drop,json.Unmarshal(value, drop)
+	case db.LayoutKeyType:
+		return string(value),nil
+	case db.CounterKeyType:
+		counter := &db.CounterValue{}
+		return counter,json.Unmarshal(value, counter)
+	case db.MerkleKeyType:
+		return hex.EncodeToString(value),nil
+	case db.ChunkRefKeyType:
+		return util.BytesToInt64(value),nil
+	case db.ChunkKeyType:
+		hash := sha256.Sum256(value)
+		head := value
+		if len(head) > 32 {
+			head = head[:32]
+		}
+		return chunkView{Length:len(value),Hash:hex.EncodeToString(hash[:]),Head:hex.EncodeToString(head)},nil
+	case db.IndexKeyType:
+		switch *info.IndexType {
+		case db.BPTreeHeadIndexType:
+			return tree.DecodeTreeHead(value)
+		case db.BPTreeNodeIndexType:
+			node,err := tree.DecodeTreeNode(value); if err != nil {
+				return nil,err
+			}
+			return newTreeNodeView(tree.Pointer(info.Pointer), node),nil
+		case db.LinkedHeadIndexType:
+			return linkedlist.DecodeLinkedHead(value)
+		case db.LinkedNodeIndexType:
+			node,err := linkedlist.DecodeLinkedNode(value); if err != nil {
+				return nil,err
+			}
+			return newLinkedNodeView(linkedlist.Pointer(info.Pointer), node),nil
+		}
+	}
+	return hex.EncodeToString(value),nil
+}
+
+func newTreeNodeView(pointer tree.Pointer, node *tree.TreeNode) treeNodeView {
+	nodeTypes := map[tree.NodeType]string{tree.NodeTypeRoot:"root",tree.NodeTypeChild:"child",tree.NodeTypeLeaf:"leaf"}
+	view := treeNodeView{Pointer:pointer,Type:nodeTypes[node.Type],Prev:node.Prev,Next:node.Next,Keys:formatBytesList(node.Keys)}
+	if node.Type == tree.NodeTypeLeaf {
+		view.Values = formatBytesList(node.Values)
+	}else{//非叶子节点值为子节点指针
+		view.Values = make([]string, 0, len(node.Values))
+		for _,value := range node.Values {
+			view.Values = append(view.Values, tree.PointerToString(tree.BytesToPointer(value)))
+		}
+	}
+	return view
+}
+
+func newLinkedNodeView(pointer linkedlist.Pointer, node *linkedlist.LinkedNode) linkedNodeView {
+	return linkedNodeView{Pointer:pointer,Prev:node.Prev,Next:node.Next,Values:formatBytesList(node.Values)}
+}
+
+/**
+	8字节值按行ID显示(主键和外键索引)，其他值使用hex
+ */
+func formatBytesList(values [][]byte) []string {
+	list := make([]string, 0, len(values))
+	for _,value := range values {
+		if len(value) == 8 {
+			list = append(list, fmt.Sprintf("%d(%s)", util.BytesToInt64(value), hex.EncodeToString(value)))
+		}else{
+			list = append(list, hex.EncodeToString(value))
+		}
+	}
+	return list
+}
+
+func containsInt(values []int, value int) bool {
+	for _,v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
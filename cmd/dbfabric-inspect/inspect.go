@@ -0,0 +1,432 @@
+package main
+
+import (
+	"fmt"
+	"github.com/database-fabric/db"
+	"github.com/database-fabric/db/database"
+	"github.com/database-fabric/db/index/linkedlist"
+	"github.com/database-fabric/db/index/tree"
+	"github.com/database-fabric/db/storage"
+	"github.com/database-fabric/db/storage/state"
+	"github.com/database-fabric/db/util"
+	"github.com/database-fabric/op/history"
+	rowop "github.com/database-fabric/op/row"
+	"github.com/hyperledger/fabric/protos/ledger/queryresult"
+	"math"
+	"sort"
+	"strconv"
+)
+
+/**
+	离线状态检查，只读取状态，不写入
+ */
+type Inspector struct {
+	state state.ChainCodeState
+}
+
+func NewInspector(state state.ChainCodeState) *Inspector {
+	return &Inspector{state}
+}
+
+type command struct {
+	args string
+	desc string
+	run func(inspector *Inspector, args []string) (interface{},error)
+}
+
+var commands = map[string]command{
+	"keys":{"[type]", "列出所有Key及解码结果，type为Key类型名称(如block、treeNode)", (*Inspector).keys},
+	"key":{"<key>", "解码单个Key和值", (*Inspector).key},
+	"snapshot":{"", "导出状态快照(JSON数组，值为base64)", (*Inspector).snapshot},
+	"databases":{"", "列出数据库和表", (*Inspector).databases},
+	"table":{"<database> <table>", "表结构、统计和关系，表可使用ID或名称", (*Inspector).table},
+	"tree":{"<database> <table> <column>", "索引树头和全部节点", (*Inspector).tree},
+	"linked":{"<database> <table> <column> <row>", "索引链表头和全部节点", (*Inspector).linked},
+	"block":{"<database> <table> <block>", "解码数据块", (*Inspector).block},
+	"row":{"<database> <table> <row>", "按ID重建行当前数据和历史版本", (*Inspector).row},
+	"check":{"<database> <table>", "检查索引、数据块和统计的一致性及可执行的修复", (*Inspector).check},
+}
+
+type checkView struct {
+	Check *db.TableCheck `json:"check"`
+	Repairs []db.TableRepair `json:"repairs"`
+}
+
+type tableView struct {
+	Id db.TableID `json:"id"`
+	Name string `json:"name"`
+}
+
+type databaseView struct {
+	Id db.DatabaseID `json:"id"`
+	Name string `json:"name"`
+	Tables []tableView `json:"tables"`
+}
+
+type tableDetail struct {
+	Table *db.TableData `json:"table"`
+	Tally *db.TableTally `json:"tally"`
+	Shards []*db.TableTally `json:"shards,omitempty"`
+	Relations []db.RelationKey `json:"relations"`
+}
+
+type treeView struct {
+	Head *tree.TreeHead `json:"head"`
+	Nodes []treeNodeView `json:"nodes"`
+}
+
+type linkedView struct {
+	Head *linkedlist.LinkedHead `json:"head"`
+	Nodes []linkedNodeView `json:"nodes"`
+}
+
+type rowView struct {
+	Row db.JsonData `json:"row"`
+	Version *db.RowVersion `json:"version"`
+	History db.Pagination `json:"history"`
+}
+
+type keyValue struct {
+	Info *KeyInfo `json:"info"`
+	Value interface{} `json:"value"`
+}
+
+////////////////// Public Function //////////////////
+
+func (inspector *Inspector) Run(args []string) (interface{},error) {
+	if len(args) == 0 {
+		return nil,fmt.Errorf("command is null")
+	}
+	cmd,ok := commands[args[0]]
+	if !ok {
+		return nil,fmt.Errorf("command `%s` not found", args[0])
+	}
+	return cmd.run(inspector, args[1:])
+}
+
+////////////////// Command Function //////////////////
+
+func (inspector *Inspector) keys(args []string) (interface{},error) {
+	kvs,err := inspector.allKeys(); if err != nil {
+		return nil,err
+	}
+	list := make([]interface{}, 0, len(kvs))
+	for _,kv := range kvs {
+		info,err := decodeKey(kv.Key)
+		if err != nil {//非数据库Key
+			if len(args) == 0 {
+				list = append(list, map[string]string{"key":kv.Key,"error":err.Error()})
+			}
+			continue
+		}
+		if len(args) > 0 && info.Type != args[0] {
+			continue
+		}
+		list = append(list, info)
+	}
+	return list,nil
+}
+
+func (inspector *Inspector) key(args []string) (interface{},error) {
+	if len(args) != 1 {
+		return nil,fmt.Errorf("key is null")
+	}
+	info,err := decodeKey(args[0]); if err != nil {
+		return nil,err
+	}
+	value,err := inspector.state.GetKey(args[0]); if err != nil {
+		return nil,err
+	}
+	decoded,err := decodeValue(info, value); if err != nil {
+		return nil,err
+	}
+	return keyValue{info,decoded},nil
+}
+
+func (inspector *Inspector) snapshot(args []string) (interface{},error) {
+	return inspector.allKeys()
+}
+
+/**
+	数据库从链Key获取名称，没有链Key时扫描数据库Key
+ */
+func (inspector *Inspector) databases(args []string) (interface{},error) {
+	names,err := inspector.names(storageKey(db.ChainPrefix, util.UInt8ToString(db.ChainKeyType))); if err != nil {
+		return nil,err
+	}
+	ids := make([]db.DatabaseID, 0, len(names))
+	for i := range names {
+		ids = append(ids, db.DatabaseID(i+1))
+	}
+	if len(ids) == 0 {
+		kvs,err := inspector.allKeys(); if err != nil {
+			return nil,err
+		}
+		for _,kv := range kvs {
+			if info,err := decodeKey(kv.Key); err == nil && info.KeyType == db.DataBaseKeyType {
+				ids = append(ids, info.Database)
+			}
+		}
+		sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	}
+	list := make([]databaseView, 0, len(ids))
+	for _,id := range ids {
+		view := databaseView{Id:id,Tables:make([]tableView, 0)}
+		if int(id) <= len(names) {
+			view.Name = names[id-1]
+		}
+		tables,err := inspector.names(storageKey(util.UInt8ToString(db.DataBaseKeyType), util.Int64ToKeyString(int64(id)))); if err != nil {
+			return nil,err
+		}
+		for i,name := range tables {
+			if name != "" {//删除的表
+				view.Tables = append(view.Tables, tableView{db.TableID(i+1),name})
+			}
+		}
+		list = append(list, view)
+	}
+	return list,nil
+}
+
+func (inspector *Inspector) table(args []string) (interface{},error) {
+	databaseImpl,tableData,err := inspector.openTable(args, 2); if err != nil {
+		return nil,err
+	}
+	detail := tableDetail{Table:tableData,Relations:make([]db.RelationKey, 0)}
+	detail.Tally,err = databaseImpl.GetTableTally(tableData.Id); if err != nil {
+		return nil,err
+	}
+	if tableData.TallyShards > 1 {
+		databaseStorage := storage.NewDatabaseStorage(inspector.state)
+		for shard := int8(0); shard < tableData.TallyShards; shard++ {
+			value,err := databaseStorage.GetTableTallyShard(inspector.databaseID(args), tableData.Id, shard); if err != nil {
+				return nil,err
+			}
+			tally,err := decodeValue(&KeyInfo{KeyType:db.TallyKeyType}, value); if err != nil {
+				return nil,err
+			}
+			if tally != nil {
+				detail.Shards = append(detail.Shards, tally.(*db.TableTally))
+			}
+		}
+	}
+	relation,err := databaseImpl.GetRelation(); if err != nil {
+		return nil,err
+	}
+	for _,key := range relation.Keys {
+		if key.TableID == tableData.Id || key.ForeignKey.Reference.TableID == tableData.Id {
+			detail.Relations = append(detail.Relations, key)
+		}
+	}
+	return detail,nil
+}
+
+/**
+	树节点按指针顺序读取，已删除的节点跳过
+ */
+func (inspector *Inspector) tree(args []string) (interface{},error) {
+	_,tableData,err := inspector.openTable(args, 3); if err != nil {
+		return nil,err
+	}
+	column,err := parseID(args[2], "column"); if err != nil {
+		return nil,err
+	}
+	key := db.ColumnKey{Database:inspector.databaseID(args),Table:tableData.Id,Column:db.ColumnID(column)}
+	treeStorage := storage.NewBPTreeStorage(inspector.state)
+	value,err := treeStorage.GetHead(key); if err != nil {
+		return nil,err
+	}
+	if len(value) == 0 {
+		return nil,fmt.Errorf("tree head of column `%d` not found", column)
+	}
+	view := treeView{Nodes:make([]treeNodeView, 0)}
+	view.Head,err = tree.DecodeTreeHead(value); if err != nil {
+		return nil,err
+	}
+	for pointer := tree.Pointer(1); pointer <= view.Head.NodeOrder; pointer++ {
+		value,err := treeStorage.GetNode(key, int64(pointer)); if err != nil {
+			return nil,err
+		}
+		if len(value) == 0 {
+			continue
+		}
+		node,err := tree.DecodeTreeNode(value); if err != nil {
+			return nil,fmt.Errorf("tree node `%d` %s", pointer, err)
+		}
+		view.Nodes = append(view.Nodes, newTreeNodeView(pointer, node))
+	}
+	return view,nil
+}
+
+/**
+	链表节点从头指针按Next顺序读取
+ */
+func (inspector *Inspector) linked(args []string) (interface{},error) {
+	_,tableData,err := inspector.openTable(args, 4); if err != nil {
+		return nil,err
+	}
+	column,err := parseID(args[2], "column"); if err != nil {
+		return nil,err
+	}
+	rowID,err := parseID(args[3], "row"); if err != nil {
+		return nil,err
+	}
+	key := db.ColumnRowKey{ColumnKey:db.ColumnKey{Database:inspector.databaseID(args),Table:tableData.Id,Column:db.ColumnID(column)},Row:db.RowID(rowID)}
+	linkedStorage := storage.NewLinkedListStorage(inspector.state)
+	value,err := linkedStorage.GetHead(key); if err != nil {
+		return nil,err
+	}
+	if len(value) == 0 {
+		return nil,fmt.Errorf("linked head of row `%d` not found", rowID)
+	}
+	view := linkedView{Nodes:make([]linkedNodeView, 0)}
+	view.Head,err = linkedlist.DecodeLinkedHead(value); if err != nil {
+		return nil,err
+	}
+	visited := make(map[linkedlist.Pointer]bool)
+	for pointer := view.Head.First; pointer > 0 && !visited[pointer]; {
+		visited[pointer] = true
+		value,err := linkedStorage.GetNode(key, int64(pointer)); if err != nil {
+			return nil,err
+		}
+		if len(value) == 0 {
+			return nil,fmt.Errorf("linked node `%d` not found", pointer)
+		}
+		node,err := linkedlist.DecodeLinkedNode(value); if err != nil {
+			return nil,fmt.Errorf("linked node `%d` %s", pointer, err)
+		}
+		view.Nodes = append(view.Nodes, newLinkedNodeView(pointer, node))
+		pointer = node.Next
+	}
+	return view,nil
+}
+
+func (inspector *Inspector) block(args []string) (interface{},error) {
+	_,tableData,err := inspector.openTable(args, 3); if err != nil {
+		return nil,err
+	}
+	blockID,err := parseID(args[2], "block"); if err != nil {
+		return nil,err
+	}
+	value,err := storage.NewBlockStorage(inspector.state).GetBlockData(inspector.databaseID(args), tableData.Id, db.BlockID(blockID)); if err != nil {
+		return nil,err
+	}
+	if len(value) == 0 {
+		return nil,fmt.Errorf("block `%d` not found", blockID)
+	}
+	return decodeValue(&KeyInfo{KeyType:db.BlockKeyType}, value)
+}
+
+/**
+	使用行操作重建行(合并增量和大字段)，历史版本按时间升序
+ */
+func (inspector *Inspector) row(args []string) (interface{},error) {
+	databaseImpl,tableData,err := inspector.openTable(args, 3); if err != nil {
+		return nil,err
+	}
+	rowID,err := parseID(args[2], "row"); if err != nil {
+		return nil,err
+	}
+	table := &db.Table{Data:tableData,Primary:&tableData.Columns[tableData.PrimaryKey.ColumnID-1]}
+	view := rowView{}
+	view.Version,err = databaseImpl.QueryRowVersion(tableData, db.RowID(rowID)); if err != nil {
+		return nil,err
+	}
+	if view.Version.BlockID == 0 {
+		return nil,fmt.Errorf("row `%d` not found", rowID)
+	}
+	view.Row,err = rowop.NewRowOperation(databaseImpl).QueryRow(table, db.RowID(rowID)); if err != nil {
+		return nil,err
+	}
+	view.History,err = history.NewHistoryOperation(databaseImpl).QueryRowHistoryWithPagination(table, db.RowID(rowID), db.ASC, math.MaxInt32, ""); if err != nil {
+		return nil,err
+	}
+	return view,nil
+}
+
+/**
+	离线一致性检查，只输出修复操作不执行
+ */
+func (inspector *Inspector) check(args []string) (interface{},error) {
+	databaseImpl,tableData,err := inspector.openTable(args, 2); if err != nil {
+		return nil,err
+	}
+	check,err := databaseImpl.CheckTable(tableData.Id); if err != nil {
+		return nil,err
+	}
+	return checkView{Check:check,Repairs:check.Repairs()},nil
+}
+
+////////////////// Private Function //////////////////
+
+func (inspector *Inspector) allKeys() ([]*queryresult.KV,error) {
+	iterator,err := inspector.state.GetStub().GetStateByRange("", ""); if err != nil {
+		return nil,err
+	}
+	defer iterator.Close()
+	kvs := make([]*queryresult.KV, 0)
+	for iterator.HasNext() {
+		kv,err := iterator.Next(); if err != nil {
+			return nil,err
+		}
+		kvs = append(kvs, kv)
+	}
+	return kvs,nil
+}
+
+func (inspector *Inspector) names(key string) ([]string,error) {
+	value,err := inspector.state.GetKey(key); if err != nil {
+		return nil,err
+	}
+	names,err := decodeValue(&KeyInfo{KeyType:db.DataBaseKeyType}, value); if err != nil || names == nil {
+		return nil,err
+	}
+	return names.([]string),nil
+}
+
+func (inspector *Inspector) databaseID(args []string) db.DatabaseID {
+	id,_ := parseID(args[0], "database")
+	return db.DatabaseID(id)
+}
+
+/**
+	打开表，args前两个参数为数据库ID和表(ID或名称)
+ */
+func (inspector *Inspector) openTable(args []string, num int) (*database.DatabaseImpl,*db.TableData,error) {
+	if len(args) != num {
+		return nil,nil,fmt.Errorf("args must is %d", num)
+	}
+	databaseID,err := parseID(args[0], "database"); if err != nil {
+		return nil,nil,err
+	}
+	databaseImpl := database.NewDatabaseImpl(&db.DataBase{Id:db.DatabaseID(databaseID),Relation:&db.Relation{}}, inspector.state)
+	tableID,err := parseID(args[1], "table")
+	if err != nil {
+		id,err := databaseImpl.GetTableID(args[1]); if err != nil {
+			return nil,nil,err
+		}
+		tableID = int64(id)
+	}
+	if tableID <= 0 {
+		return nil,nil,fmt.Errorf("table `%s` not found", args[1])
+	}
+	tableData,err := databaseImpl.QueryTableDataByID(db.TableID(tableID)); if err != nil {
+		return nil,nil,err
+	}
+	if len(tableData.Columns) == 0 {
+		return nil,nil,fmt.Errorf("table `%s` not found", args[1])
+	}
+	tableData.Id = db.TableID(tableID)
+	return databaseImpl,tableData,nil
+}
+
+func storageKey(prefix string, key string) string {
+	return prefix + "-" + key
+}
+
+func parseID(value string, name string) (int64,error) {
+	id,err := strconv.ParseInt(value, 10, 64); if err != nil {
+		return 0,fmt.Errorf("%s id `%s` error", name, value)
+	}
+	return id,nil
+}
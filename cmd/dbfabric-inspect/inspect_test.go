@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/database-fabric/db"
+	"github.com/database-fabric/db/database"
+	"github.com/database-fabric/db/storage/state/leveldb"
+	rowop "github.com/database-fabric/op/row"
+	"github.com/hyperledger/fabric/protos/ledger/queryresult"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestInspect(t *testing.T) {
+	//Key解码
+	{
+		info,err := decodeKey("-0"); if err != nil {
+			panic(err.Error())
+		}
+		assert.Equal(t, "chain", info.Type, "chain key error")
+		info,err = decodeKey("3-a1~a2~a1"); if err != nil {
+			panic(err.Error())
+		}
+		assert.EqualValues(t, 2, info.Table, "tally key table error")
+		assert.EqualValues(t, 1, *info.Shard, "tally key shard error")
+		info,err = decodeKey("6-3-a1~a2~a3~a4~a5"); if err != nil {
+			panic(err.Error())
+		}
+		assert.Equal(t, "linkedNode", info.Type, "linked node key error")
+		assert.EqualValues(t, 4, info.Row, "linked node key row error")
+		assert.EqualValues(t, 5, info.Pointer, "linked node key pointer error")
+		info,err = decodeKey("6-1-a1~a2~a1~a3~a7"); if err != nil {
+			panic(err.Error())
+		}
+		assert.Equal(t, "treeNode", info.Type, "shard tree node key error")
+		assert.EqualValues(t, 3, *info.Shard, "shard tree node key shard error")
+		assert.EqualValues(t, 7, info.Pointer, "shard tree node key pointer error")
+		info,err = decodeKey("8-a1~abcd~b12"); if err != nil {
+			panic(err.Error())
+		}
+		assert.Equal(t, "abcd", info.Hash, "chunk key hash error")
+		assert.EqualValues(t, 12, *info.Index, "chunk key index error")
+		info,err = decodeKey("13-a1~abcd"); if err != nil {
+			panic(err.Error())
+		}
+		assert.Equal(t, "chunkRef", info.Type, "chunk ref key error")
+		assert.Equal(t, "abcd", info.Hash, "chunk ref key hash error")
+		info,err = decodeKey("5-1~2~10"); if err != nil {
+			panic(err.Error())
+		}
+		assert.EqualValues(t, 10, info.Block, "legacy block key error")
+		info,err = decodeKey("-11"); if err != nil {
+			panic(err.Error())
+		}
+		assert.Equal(t, "layout", info.Type, "layout key error")
+		info,err = decodeKey("12-a1~a2~a0~T8"); if err != nil {
+			panic(err.Error())
+		}
+		assert.Equal(t, "counter", info.Type, "counter key error")
+		assert.EqualValues(t, -1, *info.Group, "counter key group error")
+		_,err = decodeKey("5-1~2")
+		assert.NotNil(t, err, "block key parts error")
+		_,err = decodeKey("x-1")
+		assert.NotNil(t, err, "key type error")
+	}
+	source,err := leveldb.NewMemLevelDBState(); if err != nil {
+		panic(err.Error())
+	}
+	defer source.Close()
+	source.Begin("tx1", time.Now())
+	databaseImpl := database.NewDatabaseImpl(&db.DataBase{Id:db.DatabaseID(1),Relation:&db.Relation{}}, source)
+	tableData := &db.TableData{Name:"TestInspect",
+		Columns:[]db.Column{
+			{Id:db.ColumnID(1),ColumnConfig:db.ColumnConfig{Name:"id",Type:db.INT,NotNull:true},Order:1},
+			{Id:db.ColumnID(2),ColumnConfig:db.ColumnConfig{Name:"name",Type:db.VARCHAR},Order:2},
+		},
+		PrimaryKey:db.PrimaryKey{ColumnID:db.ColumnID(1),AutoIncrement:true}}
+	if _,err := databaseImpl.CreateTableData(tableData); err != nil {
+		panic(err.Error())
+	}
+	if _,err := rowop.NewRowOperation(databaseImpl).Add(tableData.Name, `[{"name":"a"},{"name":"b"}]`); err != nil {
+		panic(err.Error())
+	}
+	if _,err := source.Commit(); err != nil {
+		panic(err.Error())
+	}
+	//快照导出后加载
+	snapshot,err := NewInspector(source).Run([]string{"snapshot"}); if err != nil {
+		panic(err.Error())
+	}
+	snapshotBytes,err := json.Marshal(snapshot); if err != nil {
+		panic(err.Error())
+	}
+	var kvs []*queryresult.KV
+	if err := json.Unmarshal(snapshotBytes, &kvs); err != nil {
+		panic(err.Error())
+	}
+	target,err := leveldb.NewMemLevelDBState(); if err != nil {
+		panic(err.Error())
+	}
+	defer target.Close()
+	if err := loadSnapshot(target, kvs); err != nil {
+		panic(err.Error())
+	}
+	inspector := NewInspector(target)
+	//所有Key都能解码
+	{
+		result,err := inspector.Run([]string{"keys"}); if err != nil {
+			panic(err.Error())
+		}
+		for _,item := range result.([]interface{}) {
+			_,ok := item.(*KeyInfo)
+			assert.True(t, ok, "decode key error %v", item)
+		}
+		for _,kv := range kvs {
+			if _,err := inspector.Run([]string{"key", kv.Key}); err != nil {
+				panic(err.Error())
+			}
+		}
+		blocks,err := inspector.Run([]string{"keys", "block"}); if err != nil {
+			panic(err.Error())
+		}
+		assert.True(t, len(blocks.([]interface{})) > 0, "block keys error")
+	}
+	//数据库、表、树、块、行
+	{
+		result,err := inspector.Run([]string{"databases"}); if err != nil {
+			panic(err.Error())
+		}
+		databases := result.([]databaseView)
+		assert.Equal(t, 1, len(databases), "databases error")
+		assert.Equal(t, tableData.Name, databases[0].Tables[0].Name, "database tables error")
+		result,err = inspector.Run([]string{"table", "1", tableData.Name}); if err != nil {
+			panic(err.Error())
+		}
+		assert.EqualValues(t, 2, result.(tableDetail).Tally.AddRow, "table tally error")
+		result,err = inspector.Run([]string{"tree", "1", "1", "1"}); if err != nil {
+			panic(err.Error())
+		}
+		assert.EqualValues(t, 2, result.(treeView).Head.KeyNum, "tree head error")
+		assert.True(t, len(result.(treeView).Nodes) > 0, "tree nodes error")
+		_,err = inspector.Run([]string{"block", "1", "1", "1"}); if err != nil {
+			panic(err.Error())
+		}
+		result,err = inspector.Run([]string{"row", "1", tableData.Name, "2"}); if err != nil {
+			panic(err.Error())
+		}
+		assert.Equal(t, "b", result.(rowView).Row["name"], "row error")
+		_,err = inspector.Run([]string{"row", "1", tableData.Name, "3"})
+		assert.NotNil(t, err, "row not found error")
+		result,err = inspector.Run([]string{"check", "1", tableData.Name}); if err != nil {
+			panic(err.Error())
+		}
+		assert.Empty(t, result.(checkView).Check.Issues, "check issues error")
+		_,err = inspector.Run([]string{"unknown"})
+		assert.NotNil(t, err, "unknown command error")
+	}
+}
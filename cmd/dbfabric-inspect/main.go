@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/database-fabric/db/storage/state/leveldb"
+	"github.com/hyperledger/fabric/protos/ledger/queryresult"
+	"io/ioutil"
+	"os"
+	"sort"
+)
+
+/**
+	离线账本检查工具，打开状态快照或本地LevelDB存储，解码所有Key类型
+	dbfabric-inspect (-snapshot file | -leveldb dir) command [args...]
+ */
+func main() {
+	snapshot := flag.String("snapshot", "", "world state json snapshot: [{\"key\":\"...\",\"value\":\"base64\"}]")
+	levelDB := flag.String("leveldb", "", "local leveldb store path")
+	flag.Usage = usage
+	flag.Parse()
+	if (*snapshot == "") == (*levelDB == "") || flag.NArg() == 0 {
+		usage()
+		os.Exit(2)
+	}
+	levelDBState,err := openState(*snapshot, *levelDB); if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	defer levelDBState.Close()
+	result,err := NewInspector(levelDBState).Run(flag.Args()); if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(result); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: dbfabric-inspect (-snapshot file | -leveldb dir) command [args...]\n\n")
+	flag.PrintDefaults()
+	fmt.Fprintf(os.Stderr, "\ncommands:\n")
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _,name := range names {
+		fmt.Fprintf(os.Stderr, "  %-10s %-36s %s\n", name, commands[name].args, commands[name].desc)
+	}
+}
+
+/**
+	快照加载到内存存储，LevelDB直接打开
+ */
+func openState(snapshot string, path string) (*leveldb.LevelDBState,error) {
+	if path != "" {
+		return leveldb.NewLevelDBState(path)
+	}
+	data,err := ioutil.ReadFile(snapshot); if err != nil {
+		return nil,err
+	}
+	var kvs []*queryresult.KV
+	if err := json.Unmarshal(data, &kvs); err != nil {
+		return nil,fmt.Errorf("snapshot json %s", err)
+	}
+	levelDBState,err := leveldb.NewMemLevelDBState(); if err != nil {
+		return nil,err
+	}
+	if err := loadSnapshot(levelDBState, kvs); err != nil {
+		levelDBState.Close()
+		return nil,err
+	}
+	return levelDBState,nil
+}
+
+func loadSnapshot(levelDBState *leveldb.LevelDBState, kvs []*queryresult.KV) error {
+	stub := levelDBState.GetLevelDBStub()
+	txLog := &leveldb.TxLog{Seq:stub.GetLogSequence()+1,TxID:"snapshot",Writes:make([]leveldb.TxWrite, 0, len(kvs))}
+	for _,kv := range kvs {
+		txLog.Writes = append(txLog.Writes, leveldb.TxWrite{Key:kv.Key,Value:kv.Value})
+	}
+	return stub.Apply(txLog)
+}
@@ -0,0 +1,126 @@
+package blob
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/database-fabric/db"
+	"github.com/database-fabric/db/storage"
+	"github.com/database-fabric/db/storage/state"
+	"github.com/database-fabric/db/util"
+	"sort"
+)
+
+/**
+	大字段存储，值按BlobChunkSize分片写入块外的Key，Key为库ID+值哈希+分片序号
+	相同内容只写入一次，行中只保存引用(哈希与长度)，读取行时按需读取
+	块中保存的每个引用计入内容的引用计数，压缩删除块和删除表时释放，计数为0时删除分片
+ */
+type BlobService struct {
+	database *db.DataBase
+	storage *storage.BlobStorage
+}
+
+func NewBlobService(database *db.DataBase, state state.ChainCodeState) *BlobService {
+	return &BlobService{database,storage.NewBlobStorage(state)}
+}
+
+/**
+	写入大字段值，返回编码后的引用，新内容的引用计数为0，写入块时增加
+ */
+func (service *BlobService) PutBlobData(value []byte) ([]byte,error) {
+	hash := sha256.Sum256(value)
+	reference := &db.BlobReference{Hash:hash[:],Length:int64(len(value))}
+	key := hex.EncodeToString(reference.Hash)
+	first,err := service.storage.GetChunkData(service.database.Id, key, 0); if err != nil {
+		return nil,err
+	}
+	if len(first) == 0 {//相同内容已存在时不重复写入
+		for index:=int64(0);index*db.BlobChunkSize < reference.Length;index++ {
+			end := (index+1)*db.BlobChunkSize
+			if end > reference.Length {
+				end = reference.Length
+			}
+			if err := service.storage.PutChunkData(service.database.Id, key, index, value[index*db.BlobChunkSize:end]); err != nil {
+				return nil,err
+			}
+		}
+		if err := service.storage.PutChunkRef(service.database.Id, key, util.Int64ToBytes(0)); err != nil {
+			return nil,err
+		}
+	}
+	return util.EncodeBlobReference(reference),nil
+}
+
+/**
+	按引用读取大字段值，校验长度与哈希
+ */
+func (service *BlobService) GetBlobData(value []byte) ([]byte,error) {
+	reference,err := util.DecodeBlobReference(value); if err != nil {
+		return nil,err
+	}
+	key := hex.EncodeToString(reference.Hash)
+	data := make([]byte, 0, reference.Length)
+	for index:=int64(0);int64(len(data)) < reference.Length;index++ {
+		chunk,err := service.storage.GetChunkData(service.database.Id, key, index); if err != nil {
+			return nil,err
+		}
+		if len(chunk) == 0 {
+			return nil,fmt.Errorf("blob `%s` chunk `%d` is not found", key, index)
+		}
+		data = append(data, chunk...)
+	}
+	hash := sha256.Sum256(data)
+	if int64(len(data)) != reference.Length || !bytes.Equal(hash[:], reference.Hash) {
+		return nil,fmt.Errorf("blob `%s` data error", key)
+	}
+	return data,nil
+}
+
+/**
+	按块中保存的引用(可以重复)增加或减少引用计数，delta为每个引用的增量，不是引用格式的值跳过
+	没有引用计数的内容(旧版本写入)不处理，计数减为0时删除所有分片和计数
+	相同内容的引用计数为同一个Key，并发写入或清除相同内容的事务会产生写冲突
+ */
+func (service *BlobService) UpdateBlobReferences(references [][]byte, delta int64) error {
+	counts := map[string]int64{}
+	lengths := map[string]int64{}
+	var keys []string
+	for _,value := range references {
+		reference,err := util.DecodeBlobReference(value); if err != nil {
+			continue
+		}
+		key := hex.EncodeToString(reference.Hash)
+		if _,ok := counts[key]; !ok {
+			keys = append(keys, key)
+		}
+		counts[key] += delta
+		lengths[key] = reference.Length
+	}
+	sort.Strings(keys)
+	for _,key := range keys {
+		value,err := service.storage.GetChunkRef(service.database.Id, key); if err != nil {
+			return err
+		}
+		if len(value) == 0 {
+			continue
+		}
+		count := util.BytesToInt64(value)+counts[key]
+		if count > 0 {
+			if err := service.storage.PutChunkRef(service.database.Id, key, util.Int64ToBytes(count)); err != nil {
+				return err
+			}
+			continue
+		}
+		for index:=int64(0);index*db.BlobChunkSize < lengths[key];index++ {
+			if err := service.storage.DelChunkData(service.database.Id, key, index); err != nil {
+				return err
+			}
+		}
+		if err := service.storage.DelChunkRef(service.database.Id, key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package block
+
+import (
+	"github.com/database-fabric/db"
+	"github.com/database-fabric/protos/db/row"
+)
+
+func hasBlobColumn(table *db.TableData) bool {
+	for _,column := range table.Columns {
+		if db.IsBlobType(column.Type) {
+			return true
+		}
+	}
+	return false
+}
+
+/**
+	块中保存的行(增量行只有修改的列)中的大字段引用
+ */
+func blobReferences(table *db.TableData, rows []*row.RowData) [][]byte {
+	var references [][]byte
+	for _,rowData := range rows {
+		for i,columnData := range rowData.Columns {
+			index := i
+			if len(rowData.Delta) > 0 {
+				if i >= len(rowData.Delta) {
+					break
+				}
+				index = int(rowData.Delta[i])
+			}
+			if index < len(table.Columns) && db.IsBlobType(table.Columns[index].Type) && len(columnData.Data) > 0 {
+				references = append(references, columnData.Data)
+			}
+		}
+	}
+	return references
+}
+
+/**
+	按块连接方式拼接一组连接块中保存的所有行(包括已移动的版本和重复写入的行)，增量行不合并
+ */
+func storedRows(blocks []*row.BlockData) []*row.RowData {
+	var rows []*row.RowData
+	for k,block := range blocks {
+		for j,blockRow := range block.Rows {
+			if j == 0 && k > 0 && len(rows) > 0 {
+				prev := blocks[k-1]
+				if last := rows[len(rows)-1]; prev.Join != row.BlockData_JOIN_NONE && last.Id == blockRow.Id {//行在上一个块中继续
+					columns := blockRow.Columns
+					if prev.Join == row.BlockData_JOIN_COLUMN && len(columns) > 0 && len(last.Columns) > 0 {
+						lastColumn := last.Columns[len(last.Columns)-1]
+						lastColumn.Data = append(lastColumn.Data, columns[0].Data...)
+						columns = columns[1:]
+					}
+					for _,columnData := range columns {
+						last.Columns = append(last.Columns, &row.ColumnData{Data:append([]byte{}, columnData.Data...)})
+					}
+					continue
+				}
+			}
+			rowData := &row.RowData{Id:blockRow.Id,Op:blockRow.Op,Delta:blockRow.Delta,Columns:make([]*row.ColumnData, 0, len(blockRow.Columns))}
+			for _,columnData := range blockRow.Columns {
+				rowData.Columns = append(rowData.Columns, &row.ColumnData{Data:append([]byte{}, columnData.Data...)})
+			}
+			rows = append(rows, rowData)
+		}
+	}
+	return rows
+}
+
+/**
+	删除表时分批释放所有块中保存的大字段引用，每批最多扫描size个块，连接的块在同一批中释放
+	进度保存在drop.Blobs中，全部释放后设置drop.Released
+ */
+func (service *BlockService) ReleaseTableBlobs(table *db.TableData, tallies []*db.TableTally, drop *db.TableDrop, size int32) error {
+	if !hasBlobColumn(table) {
+		drop.Released = true
+		return nil
+	}
+	lasts := make([]db.BlockID, len(tallies))
+	copy(lasts, drop.Blobs)
+	drop.Blobs = lasts
+	scanned := int32(0)
+	for i,tally := range tallies {
+		if start := shardStart(table, tally); lasts[i] < start {
+			lasts[i] = start
+		}
+		for lasts[i] < tally.Block {
+			if scanned >= size {
+				return nil
+			}
+			var blocks []*row.BlockData
+			id := lasts[i]
+			for id < tally.Block {
+				id++
+				block,err := service.loadRebuildBlock(table, id); if err != nil {
+					return err
+				}
+				if block == nil {//压缩时已删除
+					break
+				}
+				blocks = append(blocks, block)
+				if block.Join == row.BlockData_JOIN_NONE {
+					break
+				}
+			}
+			if err := service.blobService.UpdateBlobReferences(blobReferences(table, storedRows(blocks)), -1); err != nil {
+				return err
+			}
+			scanned += int32(id-lasts[i])
+			lasts[i] = id
+		}
+	}
+	drop.Released,drop.Blobs = true,nil
+	return nil
+}
@@ -3,32 +3,40 @@ package block
 import (
 	"fmt"
 	"github.com/database-fabric/db"
+	"github.com/database-fabric/db/blob"
 	"github.com/database-fabric/db/index"
 	"github.com/database-fabric/db/storage"
 	"github.com/database-fabric/db/storage/state"
 	"github.com/database-fabric/protos/db/row"
-	"github.com/golang/protobuf/proto"
+	"sort"
 )
 
 type BlockService struct {
 	database *db.DataBase
 	storage *storage.BlockStorage
 	indexService *index.IndexService
+	blobService *blob.BlobService
+	checksums map[blockKey][]byte //已读取块的校验和，用于校验块连接
 }
 
 func NewBlockService(database *db.DataBase, state state.ChainCodeState) *BlockService {
 	indexService := index.NewIndexService(state)
-	return &BlockService{database,storage.NewBlockStorage(state),indexService}
+	return &BlockService{database,storage.NewBlockStorage(state),indexService,blob.NewBlobService(database, state),map[blockKey][]byte{}}
 }
 
 const(
-	maxSize = 1024*4
 	keySize = 25
 	blockSize = 150
-	useSize int64 = maxSize-keySize-blockSize
+	useSize int64 = db.DefaultBlockSize-keySize-blockSize //默认块可用容量
 	rowSize = 25
+	joinScanSize = 64 //行连接块一次流式读取的最大块数
 )
 
+//表数据块可用容量，按表存储配置的块大小计算
+func getUseSize(table *db.TableData) int64 {
+	return int64(table.Storage.GetBlockSize())-keySize-blockSize
+}
+
 //记录行数据切割位置(为了不对底层列值数据数组进行频繁copy，减少内存copy)
 type BlockRowData struct {
 	Row *row.RowData //行数据指针
@@ -51,31 +59,90 @@ func (service *BlockService) QueryRowBlockID(table *db.TableData, rowID db.RowID
 	return service.indexService.GetPrimaryKeyIndex(service.database.Id, table, rowID)
 }
 
+func (service *BlockService) QueryRowVersion(table *db.TableData, rowID db.RowID) (*db.RowVersion,error) {
+	return service.indexService.GetPrimaryKeyIndexVersion(service.database.Id, table, rowID)
+}
+
+/**
+	区间[start,end]未删除的行数量，start、end为0时不限制，start大于end时交换(降序区间)
+ */
+func (service *BlockService) QueryRowCountByRange(table *db.TableData, start db.RowID, end db.RowID) (db.Total,error) {
+	if start > 0 && end > 0 && start > end {
+		start,end = end,start
+	}
+	return service.indexService.CountPrimaryKeyIndexByRange(service.database.Id, table, start, end, true)
+}
+
+func (service *BlockService) QueryIndexStats(table *db.TableData, column db.ColumnID) (*db.IndexStats,error) {
+	if column == table.PrimaryKey.ColumnID {
+		return service.indexService.GetIndexStats(table.PrimaryColumnKeys(service.database.Id),true)
+	}
+	columnKey := db.ColumnKey{Database:service.database.Id,Table:table.Id,Column:column}
+	return service.indexService.GetIndexStats([]db.ColumnKey{columnKey},false)
+}
+
+func (service *BlockService) MigrateIndex(table *db.TableData, checkpoint *db.TableMigrateCheckpoint, size int32) error {
+	return service.indexService.MigrateIndex(service.database.Id, table, checkpoint, size)
+}
+
 func (service *BlockService) QueryRowIDByForeignKey(tableID db.TableID, foreignKey db.ForeignKey, referenceRowID db.RowID, size int32) ([]db.RowID,error) {
 	return service.indexService.GetForeignKeyIndex(service.database.Id, tableID, foreignKey, referenceRowID, size)
 }
 
-func (service *BlockService) QueryRowDataByRange(table *db.TableData, start db.RowID, end db.RowID, order db.OrderType, size int32) ([]*row.RowData,error) {
-	rowBlockIDList,err := service.indexService.GetPrimaryKeyIndexByRange(service.database.Id, table, start, end, order, size); if err != nil {
-		return nil,err
+func (service *BlockService) QueryRowIDByForeignKeyCursor(tableID db.TableID, foreignKey db.ForeignKey, referenceRowID db.RowID, cursor *db.Cursor, size int32) ([]db.RowID,*db.Cursor,error) {
+	return service.indexService.GetForeignKeyIndexByCursor(service.database.Id, tableID, foreignKey, referenceRowID, cursor, size)
+}
+
+/**
+	范围查询行数据，columns为投影列，为空时返回全部列
+ */
+func (service *BlockService) QueryRowDataByRange(table *db.TableData, start db.RowID, end db.RowID, order db.OrderType, size int32, columns ...db.ColumnID) ([]*row.RowData,error) {
+	rows,_,err := service.QueryRowDataByCursor(table, start, end, nil, order, size, columns...)
+	return rows,err
+}
+
+/**
+	游标分页范围查询行数据，cursor为上一页返回的游标(为空时从start开始)，返回下一页游标(没有下一页时为空)
+ */
+func (service *BlockService) QueryRowDataByCursor(table *db.TableData, start db.RowID, end db.RowID, cursor *db.Cursor, order db.OrderType, size int32, columns ...db.ColumnID) ([]*row.RowData,*db.Cursor,error) {
+	rowBlockIDList,next,err := service.indexService.GetPrimaryKeyIndexByCursor(service.database.Id, table, start, end, cursor, order, size); if err != nil {
+		return nil,nil,err
+	}
+	blockIDs := make([]db.BlockID, len(rowBlockIDList))
+	for i,rowBlockID := range rowBlockIDList {
+		blockIDs[i] = rowBlockID.BlockID
+	}
+	if err := service.prefetchBlockData(table.Id, blockIDs); err != nil {
+		return nil,nil,err
 	}
 	rows := make([]*row.RowData, 0, len(rowBlockIDList))
 	for _,rowBlockID := range rowBlockIDList {
 		if rowBlockID.BlockID == 0 {
 			rows = append(rows, &row.RowData{Id: rowBlockID.RowID})
 		}else{
-			rowData,err := service.getRowData(table.Id, rowBlockID.BlockID, rowBlockID.RowID); if err != nil {
-				return nil,err
+			rowData,err := service.getRowData(table.Id, rowBlockID.BlockID, rowBlockID.RowID, columns); if err != nil {
+				return nil,nil,err
 			}
 			rows = append(rows, rowData)
 		}
 	}
-	return rows,nil
+	return rows,next,nil
 }
 
 func (service *BlockService) QueryRowDataHistoryByRange(table *db.TableData, rowID db.RowID, order db.OrderType, size int32) ([]*db.RowDataHistory,db.Total,error) {
-	blocks,total,err := service.indexService.GetPrimaryKeyIndexHistoryByRange(service.database.Id, table, rowID, order, size); if err != nil {
-		return nil,total,err
+	rows,total,_,err := service.QueryRowDataHistoryByCursor(table, rowID, nil, order, size)
+	return rows,total,err
+}
+
+/**
+	游标分页查询行历史版本，cursor为上一页返回的游标(为空时从第一个或最新版本开始)，返回下一页游标
+ */
+func (service *BlockService) QueryRowDataHistoryByCursor(table *db.TableData, rowID db.RowID, cursor *db.Cursor, order db.OrderType, size int32) ([]*db.RowDataHistory,db.Total,*db.Cursor,error) {
+	blocks,total,next,err := service.indexService.GetPrimaryKeyIndexHistoryByCursor(service.database.Id, table, rowID, cursor, order, size); if err != nil {
+		return nil,total,nil,err
+	}
+	if err := service.prefetchBlockData(table.Id, blocks); err != nil {
+		return nil,total,nil,err
 	}
 	rows := make([]*db.RowDataHistory, 0, len(blocks))
 	for _,blockID := range blocks {
@@ -83,22 +150,25 @@ func (service *BlockService) QueryRowDataHistoryByRange(table *db.TableData, row
 			rows = append(rows, &db.RowDataHistory{Row:&row.RowData{Id: rowID}})
 		}else{
 			rowData,err := service.getRowDataHistory(table.Id, blockID, rowID); if err != nil {
-				return nil,total,err
+				return nil,total,nil,err
 			}
 			rows = append(rows, rowData)
 		}
 	}
-	return rows,total,nil
+	return rows,total,next,nil
 }
 
-func (service *BlockService) QueryRowData(table *db.TableData, rowID db.RowID) (*row.RowData,error) {
+/**
+	查询行数据，columns为投影列，为空时返回全部列
+ */
+func (service *BlockService) QueryRowData(table *db.TableData, rowID db.RowID, columns ...db.ColumnID) (*row.RowData,error) {
 	blockID,err := service.QueryRowBlockID(table, rowID); if err != nil {
 		return nil,err
 	}
 	if blockID == 0 {
 		return nil,nil
 	}
-	return service.getRowData(table.Id, blockID, rowID)
+	return service.getRowData(table.Id, blockID, rowID, columns)
 }
 
 
@@ -107,18 +177,21 @@ func (service *BlockService) getRowDataHistory(tableID db.TableID, blockID db.Bl
 		return nil,err
 	}
 	rowData := service.initRowData(rowID)
-	err = service.joinBlockRowData(tableID, blockID, rowData, block); if err != nil {
+	err = service.joinBlockRowData(tableID, blockID, rowData, block, nil); if err != nil {
+		return nil,err
+	}
+	rowData,err = service.mergeDeltaRow(tableID, rowData, nil); if err != nil {
 		return nil,err
 	}
 	return &db.RowDataHistory{TxID:block.TxId,Time:block.Time,Row:rowData},nil
 }
 
-func (service *BlockService) getRowData(tableID db.TableID, blockID db.BlockID, rowID db.RowID) (*row.RowData,error) {
+func (service *BlockService) getRowData(tableID db.TableID, blockID db.BlockID, rowID db.RowID, columns []db.ColumnID) (*row.RowData,error) {
 	rowData := service.initRowData(rowID)
-	err := service.joinBlockRowData(tableID, blockID, rowData,nil); if err != nil {
+	err := service.joinBlockRowData(tableID, blockID, rowData, nil, newProjection(columns)); if err != nil {
 		return nil,err
 	}
-	return rowData,nil
+	return service.mergeDeltaRow(tableID, rowData, columns)
 }
 
 func (service *BlockService) initRowData(rowID db.RowID) *row.RowData {
@@ -134,37 +207,125 @@ func (service *BlockService) getBlockData(tableID db.TableID, blockID db.BlockID
 	if len(bytes) == 0 {
 		return nil,fmt.Errorf("block `%d` is not found", blockID)
 	}
-	block := &row.BlockData{}
-	if err := proto.Unmarshal(bytes, block); err != nil {
+	return service.decodeBlockData(tableID, blockID, bytes)
+}
+
+func (service *BlockService) decodeBlockData(tableID db.TableID, blockID db.BlockID, bytes []byte) (*row.BlockData,error) {
+	block,err := DecodeBlock(bytes); if err != nil {
 		return nil,fmt.Errorf("block `%d` convert error `%s`", blockID, err.Error())
 	}
+	if err := service.verifyBlock(tableID, blockID, block); err != nil {
+		return nil,err
+	}
 	return block,nil
 }
 
-func (service *BlockService) joinRowData(rowData *row.RowData, joinRow *row.RowData, joinType row.BlockData_JoinType, index *int) {
+/**
+	预读块，块ID排序去重后连续的块使用一次区间读取(代替逐个读取)，读取的块放入事务缓存
+	只读取查询到的块ID之间的区间，读集中不包含不存在的块
+ */
+func (service *BlockService) prefetchBlockData(tableID db.TableID, blockIDs []db.BlockID) error {
+	ids := make([]db.BlockID, 0, len(blockIDs))
+	for _,id := range blockIDs {
+		if id > 0 {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	start := 0
+	for i:=1;i<=len(ids);i++ {
+		if i < len(ids) && ids[i] <= ids[i-1]+1 {
+			continue
+		}
+		if ids[i-1] > ids[start] {
+			err := service.storage.GetBlockDataByRange(service.database.Id, tableID, ids[start], ids[i-1], func(db.BlockID, []byte) (bool,error) {
+				return true,nil
+			}); if err != nil {
+				return err
+			}
+		}
+		start = i
+	}
+	return nil
+}
+
+/**
+	流式读取行的连接块，从blockID开始直到行不再连接到下一个块(或达到joinScanSize)
+ */
+func (service *BlockService) scanJoinBlocks(tableID db.TableID, blockID db.BlockID, rowID db.RowID) (map[db.BlockID]*row.BlockData,error) {
+	blocks := map[db.BlockID]*row.BlockData{}
+	err := service.storage.GetBlockDataByRange(service.database.Id, tableID, blockID, blockID+joinScanSize-1, func(id db.BlockID, value []byte) (bool,error) {
+		if id != blockID+db.BlockID(len(blocks)) {//块不连续
+			return false,nil
+		}
+		block,err := service.decodeBlockData(tableID, id, value); if err != nil {
+			return false,err
+		}
+		blocks[id] = block
+		last := len(block.Rows)-1
+		return last >= 0 && block.Rows[last].Id == rowID && block.Join != row.BlockData_JOIN_NONE,nil
+	})
+	return blocks,err
+}
+
+func (service *BlockService) joinRowData(rowData *row.RowData, joinRow *row.RowData, joinType row.BlockData_JoinType, index *int, projection *projection) {
 	if joinType == row.BlockData_JOIN_ROW {
 		*index++
 		for i,columnData := range joinRow.Columns {
 			p := *index+i
-			rowData.Columns[p].Data = append(rowData.Columns[p].Data, columnData.Data...)
+			if projection.contains(p) {
+				rowData.Columns[p].Data = append(rowData.Columns[p].Data, columnData.Data...)
+			}
 		}
 		*index = *index + len(joinRow.Columns)-1
 	}else if joinType == row.BlockData_JOIN_COLUMN {
-		rowData.Columns[*index].Data = append(rowData.Columns[*index].Data, joinRow.Columns[0].Data...)
+		if projection.contains(*index) {
+			rowData.Columns[*index].Data = append(rowData.Columns[*index].Data, joinRow.Columns[0].Data...)
+		}
 		if len(joinRow.Columns) > 1 {
 			for i,columnData := range joinRow.Columns[1:] {
 				p := *index+i+1
-				rowData.Columns[p].Data = append(rowData.Columns[p].Data, columnData.Data...)
+				if projection.contains(p) {
+					rowData.Columns[p].Data = append(rowData.Columns[p].Data, columnData.Data...)
+				}
 			}
 			*index = *index + len(joinRow.Columns)-1
 		}
 	}
 }
 
-func (service *BlockService) joinBlockRowData(tableID db.TableID, blockID db.BlockID, rowData *row.RowData, firstBlock *row.BlockData) error {
+/**
+	列投影，按列下标(列ID-1)过滤，为空时包含全部列
+ */
+type projection struct {
+	columns map[int]bool
+	last int //最大列下标，该列之前的列连接完成后不再读取后续块
+}
+
+func newProjection(columns []db.ColumnID) *projection {
+	if len(columns) == 0 {
+		return nil
+	}
+	projection := &projection{columns:make(map[int]bool, len(columns))}
+	for _,column := range columns {
+		index := int(column)-1
+		projection.columns[index] = true
+		if index > projection.last {
+			projection.last = index
+		}
+	}
+	return projection
+}
+
+func (projection *projection) contains(index int) bool {
+	return projection == nil || projection.columns[index]
+}
+
+func (service *BlockService) joinBlockRowData(tableID db.TableID, blockID db.BlockID, rowData *row.RowData, firstBlock *row.BlockData, projection *projection) error {
 	var block *row.BlockData
 	var joinRows []*row.RowData
 	var joinTypes []row.BlockData_JoinType
+	var joinBlocks map[db.BlockID]*row.BlockData
 	columnLenMap := map[int]int{}
 	columnIndex := 0
 	for {
@@ -186,9 +347,12 @@ func (service *BlockService) joinBlockRowData(tableID db.TableID, blockID db.Blo
 			}
 		}
 		if rowIndex < 0 {
-			return fmt.Errorf("row `%d` is not found in block `%d`", blockID, rowData.Id)
+			return fmt.Errorf("row `%d` is not found in block `%d`", rowData.Id, blockID)
 		}
 		joinRow := block.Rows[rowIndex]
+		if len(joinRows) == 0 && joinRow.Base > 0 {//增量行列下标与表列不对应，不使用投影
+			projection = nil
+		}
 		joinRows = append(joinRows, joinRow)
 		joinTypes = append(joinTypes, block.Join)
 
@@ -203,11 +367,25 @@ func (service *BlockService) joinBlockRowData(tableID db.TableID, blockID db.Blo
 		if len(block.Rows) != (rowIndex+1) || block.Join == row.BlockData_JOIN_NONE { //查找的行是块中最后一条并且块需要连接到下个块
 			break
 		}
+		if projection != nil && projection.last < columnIndex { //投影列已全部连接完成，不读取后续块
+			break
+		}
 		blockID++
+		if joinBlocks == nil {//行切割到后续的块，一次流式读取连接块
+			var err error
+			joinBlocks,err = service.scanJoinBlocks(tableID, blockID, rowData.Id); if err != nil {
+				return err
+			}
+		}
+		firstBlock = joinBlocks[blockID]
 	}
 	rowData.Columns = make([]*row.ColumnData, len(columnLenMap))
 	for i:=0;i<len(rowData.Columns);i++ {
-		rowData.Columns[i] = &row.ColumnData{Data: make([]byte, 0, columnLenMap[i])}
+		if projection.contains(i) {
+			rowData.Columns[i] = &row.ColumnData{Data: make([]byte, 0, columnLenMap[i])}
+		}else{
+			rowData.Columns[i] = &row.ColumnData{}
+		}
 	}
 	index := -1
 	for i,joinRow := range joinRows {
@@ -215,12 +393,16 @@ func (service *BlockService) joinBlockRowData(tableID db.TableID, blockID db.Blo
 		if i > 0 {
 			join = joinTypes[i-1]
 		}
-		service.joinRowData(rowData, joinRow, join, &index)
+		service.joinRowData(rowData, joinRow, join, &index, projection)
 	}
+	rowData.Op,rowData.Base,rowData.Depth,rowData.Delta = joinRows[0].Op,joinRows[0].Base,joinRows[0].Depth,joinRows[0].Delta
 	return nil
 }
 
-func (service *BlockService) combineRowData(use *int64, rowData *row.RowData, combineRows *[]BlockRowData, blocks *[]BlockData) {
+/**
+	行数据装箱，容量按压缩后大小计算：列值大小为原始大小/scale(压缩率)，切割位置按原始大小计算
+ */
+func (service *BlockService) combineRowData(useSize int64, scale int64, use *int64, rowData *row.RowData, combineRows *[]BlockRowData, blocks *[]BlockData) {
 	current := int16(1)
 	end := int16(len(rowData.Columns))
 	temp := BlockRowData{Row:rowData,ColumnStart:current}
@@ -233,7 +415,8 @@ func (service *BlockService) combineRowData(use *int64, rowData *row.RowData, co
 			*use = useSize
 		}
 		*use = *use - rowSize
-		size := int64(len(rowData.Columns[current-1].Data))
+		dataSize := int64(len(rowData.Columns[current-1].Data))
+		size := (dataSize+scale-1)/scale
 		if *use == size {
 			blockRow := temp
 			blockRow.ColumnEnd = current
@@ -250,22 +433,23 @@ func (service *BlockService) combineRowData(use *int64, rowData *row.RowData, co
 			blockRow := temp
 			blockRow.ColumnEnd = current
 			blockRow.LastDataStart = 1
-			blockRow.LastDataEnd = *use
+			blockRow.LastDataEnd = *use*scale
 			*combineRows = append(*combineRows, blockRow)
 			*blocks = append(*blocks, BlockData{Rows:*combineRows,Join: row.BlockData_JOIN_COLUMN})
 			*combineRows = nil
 			temp = BlockRowData{Row:rowData,ColumnStart:current}
 
-			currentSize := size - *use
-			cap := useSize+rowSize
+			start := *use*scale
+			currentSize := dataSize - start
+			cap := (useSize+rowSize)*scale
 			count := currentSize/cap
 			have := currentSize%cap
 			//fmt.Println(currentSize,cap,count,have)
 			for i:=int64(0);i<count;i++{
 				blockRowLoop := temp
 				blockRowLoop.ColumnEnd = current
-				blockRowLoop.LastDataStart = *use+cap*i+1
-				blockRowLoop.LastDataEnd = *use+cap*(i+1)
+				blockRowLoop.LastDataStart = start+cap*i+1
+				blockRowLoop.LastDataEnd = start+cap*(i+1)
 				join := row.BlockData_JOIN_COLUMN
 				if i == count-1 && have == 0 {
 					join = row.BlockData_JOIN_ROW
@@ -277,9 +461,9 @@ func (service *BlockService) combineRowData(use *int64, rowData *row.RowData, co
 			}
 			*use = useSize
 			if have > 0 {
-				temp.FirstDataStart = size - have + 1
-				temp.FirstDataEnd = size
-				*use -= have
+				temp.FirstDataStart = dataSize - have + 1
+				temp.FirstDataEnd = dataSize
+				*use -= (have+scale-1)/scale
 			}else{
 				temp.ColumnStart++
 			}
@@ -293,74 +477,196 @@ func (service *BlockService) combineRowData(use *int64, rowData *row.RowData, co
 	}
 }
 
+/**
+	按表块容量装箱，压缩表按各行压缩率计算容量，压缩后超出块容量时降低块中各行压缩率重新装箱
+ */
+func (service *BlockService) packBlockData(table *db.TableData, tally *db.TableTally, txID string, timestamp int64, rows []*row.RowData) ([]BlockData,[][]byte,[][]byte,error) {
+	tableUseSize := getUseSize(table)
+	maxBlockSize := tableUseSize+blockSize
+	scales := compressScales(table, rows)
+	rowIndexMap := make(map[*row.RowData]int, len(rows))
+	for i,rowData := range rows {
+		rowIndexMap[rowData] = i
+	}
+	for {
+		use := tableUseSize
+		var combineRows []BlockRowData
+		var blocks []BlockData
+		for i:=0;i<len(rows);i++ {
+			service.combineRowData(tableUseSize, scales[i], &use, rows[i], &combineRows, &blocks)
+		}
+		if len(combineRows) > 0 {
+			blocks = append(blocks, BlockData{Rows:combineRows})
+		}
+		values := make([][]byte, 0, len(blocks))
+		checksums := make([][]byte, 0, len(blocks))
+		isReduced := false
+		prevHash := tally.BlockHash
+		for i,b := range blocks {
+			block := &row.BlockData{Id: tally.Block+db.BlockID(i+1),TxId:txID,Time:timestamp,Rows:service.splitBlockRows(b),Join:b.Join}
+			if err := sealBlock(block, prevHash); err != nil {
+				return nil,nil,nil,err
+			}
+			prevHash = block.Checksum
+			checksums = append(checksums, block.Checksum)
+			value,err := encodeBlock(table.Storage.Compression, block); if err != nil {
+				return nil,nil,nil,err
+			}
+			if int64(len(value)) > maxBlockSize {
+				for _,blockRow := range b.Rows {
+					if reduceScale(scales, rowIndexMap[blockRow.Row]) {
+						isReduced = true
+					}
+				}
+			}
+			values = append(values, value)
+		}
+		if !isReduced {
+			return blocks,values,checksums,nil
+		}
+	}
+}
+
+/**
+	按切割位置生成块中的行数据
+ */
+func (service *BlockService) splitBlockRows(b BlockData) []*row.RowData {
+	rows := make([]*row.RowData, 0, len(b.Rows))
+	for _,blockRow := range b.Rows {
+		//fmt.Println(blockRow.ColumnStart,blockRow.ColumnEnd,blockRow.FirstDataStart,blockRow.FirstDataEnd,blockRow.LastDataStart,blockRow.LastDataEnd)
+		var columns []*row.ColumnData
+		isSplit := blockRow.FirstDataStart > 0 || blockRow.LastDataStart > 0
+		if !isSplit && blockRow.ColumnStart == 1 && blockRow.ColumnEnd == int16(len(blockRow.Row.Columns)) {
+			columns = blockRow.Row.Columns
+		}else if !isSplit {
+			columns = blockRow.Row.Columns[blockRow.ColumnStart-1:blockRow.ColumnEnd]
+		}else{
+			//fmt.Println(blockRow.ColumnStart,blockRow.ColumnEnd)
+			splitColumns := blockRow.Row.Columns[blockRow.ColumnStart-1:blockRow.ColumnEnd]
+			columns = make([]*row.ColumnData, 0, len(splitColumns))
+			for _,columnData := range splitColumns {
+				temp := columnData.Data
+				columns = append(columns, &row.ColumnData{Data: temp})
+			}
+			if blockRow.FirstDataStart > 0 {
+				columns[0].Data = columns[0].Data[blockRow.FirstDataStart-1:blockRow.FirstDataEnd]
+			}
+			if blockRow.LastDataStart > 0 {
+				columns[len(columns)-1].Data = columns[len(columns)-1].Data[blockRow.LastDataStart-1:blockRow.LastDataEnd]
+			}
+		}
+		//fmt.Print("len ")
+		//for _,c := range columns {
+		//	fmt.Printf("%d,",len(c.Data))
+		//}
+		//fmt.Print("\n")
+		rowData := &row.RowData{Id: blockRow.Row.Id,Op:blockRow.Row.Op,Columns:columns}
+		if blockRow.ColumnStart == 1 && blockRow.FirstDataStart == 0 {//增量信息保存在行的第一部分
+			rowData.Base,rowData.Depth,rowData.Delta = blockRow.Row.Base,blockRow.Row.Depth,blockRow.Row.Delta
+		}
+		rows = append(rows, rowData)
+	}
+	return rows
+}
+
 func (service *BlockService) SetBlockData(table *db.TableData, tally *db.TableTally, rows []*row.RowData) error {
 	txID,timestamp,err := service.storage.GetTxID(); if err != nil {
 		return err
 	}
-	use := useSize
-	var combineRows []BlockRowData
-	var blocks []BlockData
 	for i:=0;i<len(rows);i++ {
-		service.rowTally(tally, rows[i])
-		service.combineRowData(&use, rows[i], &combineRows, &blocks)
+		if err := service.prepareDeltaRow(table, rows[i]); err != nil {
+			return err
+		}
+		if err := service.rowTally(table, tally, rows[i]); err != nil {
+			return err
+		}
 	}
-	if len(combineRows) > 0 {
-		blocks = append(blocks, BlockData{Rows:combineRows})
+	blockIDs,err := service.putBlockData(table, tally, rows, txID, timestamp); if err != nil {
+		return err
 	}
+	deltas := newCounterDeltas()
+	rowIDMap := make(map[db.RowID]bool, len(rows))
+	for i,rowData := range rows {
+		if rowIDMap[rowData.Id] {//过滤重复行
+			continue
+		}
+		rowIDMap[rowData.Id] = true
+		version,err := service.QueryRowVersion(table, rowData.Id); if err != nil {
+			return err
+		}
+		live := version.BlockID > 0 && version.Op != db.DELETE
+		service.liveTally(tally, live, rowData)
+		if len(table.Counters) > 0 && live {//计数器减去旧版本
+			if err := service.counterRow(table, deltas, version.BlockID, rowData.Id, -1); err != nil {
+				return err
+			}
+		}
+		if err := service.addIndex(table, blockIDs[i], rowData); err != nil {
+			return err
+		}
+		if len(table.Counters) > 0 && uint8(rowData.Op) != db.DELETE {//计数器加上合并增量后的新版本
+			if err := service.counterRow(table, deltas, blockIDs[i], rowData.Id, 1); err != nil {
+				return err
+			}
+		}
+	}
+	return service.putCounters(table, deltas)
+}
+
+/**
+	按主键索引中行最新版本计算行数量变化：未删除变为删除减一，不存在或已删除变为未删除加一
+	同一批次中重复的行只有第一个写入索引，不重复计算
+ */
+func (service *BlockService) liveTally(tally *db.TableTally, live bool, rowData *row.RowData) {
+	if uint8(rowData.Op) == db.DELETE {
+		if live {
+			tally.LiveRow--
+		}
+	}else if !live {
+		tally.LiveRow++
+	}
+}
+
+/**
+	行数据装箱写入统计分片的新块，更新累加器和统计的块位置，返回每行第一部分所在块ID
+ */
+func (service *BlockService) putBlockData(table *db.TableData, tally *db.TableTally, rows []*row.RowData, txID string, timestamp int64) ([]db.BlockID,error) {
+	blocks,values,checksums,err := service.packBlockData(table, tally, txID, timestamp, rows); if err != nil {
+		return nil,err
+	}
+	if hasBlobColumn(table) {//块中保存的大字段引用计入引用计数
+		if err := service.blobService.UpdateBlobReferences(blobReferences(table, rows), 1); err != nil {
+			return nil,err
+		}
+	}
+	rowIndexMap := make(map[*row.RowData]int, len(rows))
+	for i,rowData := range rows {
+		rowIndexMap[rowData] = i
+	}
+	blockIDs := make([]db.BlockID, len(rows))
 	id := tally.Block
-	rowIDMap := make(map[db.RowID]db.BlockID, len(rows))
-	for _,b := range blocks {
+	for i,b := range blocks {
 		id++
-		rows := make([]*row.RowData, 0, len(b.Rows))
 		for _,blockRow := range b.Rows {
-			//fmt.Println(blockRow.ColumnStart,blockRow.ColumnEnd,blockRow.FirstDataStart,blockRow.FirstDataEnd,blockRow.LastDataStart,blockRow.LastDataEnd)
-			var columns []*row.ColumnData
-			isSplit := blockRow.FirstDataStart > 0 || blockRow.LastDataStart > 0
-			if !isSplit && blockRow.ColumnStart == 1 && blockRow.ColumnEnd == int16(len(blockRow.Row.Columns)) {
-				columns = blockRow.Row.Columns
-			}else if !isSplit {
-				columns = blockRow.Row.Columns[blockRow.ColumnStart-1:blockRow.ColumnEnd]
-			}else{
-				//fmt.Println(blockRow.ColumnStart,blockRow.ColumnEnd)
-				splitColumns := blockRow.Row.Columns[blockRow.ColumnStart-1:blockRow.ColumnEnd]
-				columns = make([]*row.ColumnData, 0, len(splitColumns))
-				for _,columnData := range splitColumns {
-					temp := columnData.Data
-					columns = append(columns, &row.ColumnData{Data: temp})
-				}
-				if blockRow.FirstDataStart > 0 {
-					columns[0].Data = columns[0].Data[blockRow.FirstDataStart-1:blockRow.FirstDataEnd]
-				}
-				if blockRow.LastDataStart > 0 {
-					columns[len(columns)-1].Data = columns[len(columns)-1].Data[blockRow.LastDataStart-1:blockRow.LastDataEnd]
-				}
-			}
-			//fmt.Print("len ")
-			//for _,c := range columns {
-			//	fmt.Printf("%d,",len(c.Data))
-			//}
-			//fmt.Print("\n")
-			rows = append(rows, &row.RowData{Id: blockRow.Row.Id,Op:blockRow.Row.Op,Columns:columns})
-
-			//过滤重复行并添加索引
-			_,exists := rowIDMap[blockRow.Row.Id]
-			if !exists {//过滤重复行
-				rowIDMap[blockRow.Row.Id] = id
-				if err := service.addIndex(table, id, blockRow.Row); err != nil {
-					return err
-				}
+			if index := rowIndexMap[blockRow.Row]; blockIDs[index] == 0 {
+				blockIDs[index] = id
 			}
 		}
-		block := &row.BlockData{Id: id,TxId:txID,Time:timestamp,Rows:rows,Join:b.Join}
-		bytes,err := proto.Marshal(block); if err != nil {
-			return err
-		}
-		if err := service.storage.PutBlockData(service.database.Id, table.Id, id, bytes); err != nil {
-			return err
+		if err := service.storage.PutBlockData(service.database.Id, table.Id, id, values[i]); err != nil {
+			return nil,err
 		}
 	}
+	if table.TallyShards > 1 && id >= (db.BlockID(tally.Shard)+1)<<db.TallyShardBlockBits {
+		return nil,fmt.Errorf("table `%s` tally shard `%d` block id overflow", table.Name, tally.Shard)
+	}
+	if err := service.appendMerkle(tally, checksums); err != nil {
+		return nil,err
+	}
 	tally.Block = id
-	return nil
+	if len(checksums) > 0 {
+		tally.BlockHash = checksums[len(checksums)-1]
+	}
+	return blockIDs,nil
 }
 
 /**
@@ -371,8 +677,8 @@ func (service *BlockService) addIndex(table *db.TableData, blockID db.BlockID, r
 	if err := service.indexService.PutPrimaryKeyIndex(service.database.Id, table, row.Id, uint8(row.Op), blockID); err != nil {
 		return err
 	}
-	//外键，只需要在新增行时记录外键与主键关系
-	if uint8(row.Op) == db.ADD {
+	//外键，新增和修改行时记录外键与主键关系(修改外键值后旧值的索引不删除，查询时验证行当前的外键值)
+	if uint8(row.Op) != db.DELETE {
 		if err := service.indexService.PutForeignKeysIndex(service.database.Id, table, row.Id, row); err != nil {
 			return err
 		}
@@ -381,12 +687,15 @@ func (service *BlockService) addIndex(table *db.TableData, blockID db.BlockID, r
 	return nil
 }
 
-func (service *BlockService) rowTally(tally *db.TableTally, row *row.RowData) {
+func (service *BlockService) rowTally(table *db.TableData, tally *db.TableTally, row *row.RowData) error {
 	if uint8(row.Op) == db.ADD {
 		tally.AddRow++
 		if row.Id == 0 {//自增
-			tally.Increment++
-			row.Id = tally.Increment
+			id,err := service.nextIncrement(table, tally); if err != nil {
+				return err
+			}
+			tally.Increment = id
+			row.Id = id
 		}else if row.Id > tally.Increment {//自增计数更新
 			tally.Increment = row.Id
 		}
@@ -395,4 +704,33 @@ func (service *BlockService) rowTally(tally *db.TableTally, row *row.RowData) {
 	}else if uint8(row.Op) == db.DELETE {
 		tally.DelRow++
 	}
+	return nil
+}
+
+/**
+	分片表自增ID在分片间交错分配，分片i只分配(ID-1)%分片数==i的ID，各分片互不重复
+	指定ID新增的行只更新写入分片的自增计数，其他分片分配到已存在的ID时跳过(只读取本分片的主键索引，不与其他分片冲突)
+ */
+func (service *BlockService) nextIncrement(table *db.TableData, tally *db.TableTally) (db.RowID,error) {
+	if table.TallyShards <= 1 {
+		return tally.Increment + 1,nil
+	}
+	shards := db.RowID(table.TallyShards)
+	shard := db.RowID(tally.Shard)
+	next := tally.Increment + 1
+	r := (next - 1) % shards
+	if r <= shard {
+		next += shard - r
+	}else{
+		next += shards - r + shard
+	}
+	for {
+		blockID,err := service.indexService.GetPrimaryKeyIndex(service.database.Id, table, next); if err != nil {
+			return 0,err
+		}
+		if blockID == 0 {
+			return next,nil
+		}
+		next += shards
+	}
 }
\ No newline at end of file
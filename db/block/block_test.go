@@ -3,11 +3,18 @@ package block
 import (
 	"fmt"
 	"github.com/database-fabric/db"
+	"github.com/database-fabric/db/index"
+	"github.com/database-fabric/db/index/tree"
+	"github.com/database-fabric/db/proof"
+	"github.com/database-fabric/db/storage"
 	"github.com/database-fabric/db/storage/state"
 	"github.com/database-fabric/db/util"
 	"github.com/database-fabric/protos/db/row"
 	"github.com/database-fabric/test"
+	"github.com/golang/protobuf/proto"
 	"github.com/stretchr/testify/assert"
+	"math/rand"
+	"strings"
 	"testing"
 )
 
@@ -194,4 +201,774 @@ func TestBlock(t *testing.T) {
 			validRowData(columnLength)
 		}
 	}
-}
\ No newline at end of file
+	//表配置块容量
+	{
+		bigTable := &db.TableData{Id:db.TableID(3),Name:"BigTable",
+			Columns:[]db.Column{{},{}},
+			PrimaryKey:db.PrimaryKey{ColumnID:db.ColumnID(1),AutoIncrement:true},
+			Storage:db.StorageConfig{BlockSize:db.DefaultBlockSize*4,TreeType:1}}
+		bigTally := &db.TableTally{TableID:bigTable.Id}
+		columnData := make([]byte, db.DefaultBlockSize*2)
+		size := db.RowID(10)
+		rows := make([]*row.RowData, 0, size)
+		for i:=db.RowID(1);i<=size;i++ {
+			rows = append(rows, &row.RowData{Id: i,Op:uint32(db.ADD),Columns:[]*row.ColumnData{{Data: util.RowIDToBytes(i)}, {Data: columnData}}})
+		}
+		if err := blockService.SetBlockData(bigTable, bigTally, rows); err != nil {
+			panic(err.Error())
+		}
+		assert.True(t, bigTally.Block < db.BlockID(size), "big block num error")
+		rowList, err := blockService.QueryRowDataByRange(bigTable, db.RowID(1), size, db.ASC,100);if err != nil {
+			panic(err.Error())
+		}
+		assert.EqualValues(t, len(rowList), size,"big row len error")
+		for _,rowData := range rowList {
+			assert.EqualValues(t, len(rowData.Columns[1].Data), len(columnData),"big row data len error")
+		}
+	}
+	//块压缩
+	{
+		newTable := func(id db.TableID, compression int8) (*db.TableData,*db.TableTally) {
+			table := &db.TableData{Id:id,Name:"CompressTable",
+				Columns:[]db.Column{{},{}},
+				PrimaryKey:db.PrimaryKey{ColumnID:db.ColumnID(1),AutoIncrement:true},
+				Storage:db.StorageConfig{Compression:compression}}
+			return table,&db.TableTally{TableID:id}
+		}
+		html := []byte(strings.Repeat("<div class=\"description\"><p>database fabric</p></div>", 100))
+		random := make([]byte, db.DefaultBlockSize*2)
+		rand.New(rand.NewSource(1)).Read(random)
+		size := db.RowID(50)
+		newRows := func() []*row.RowData {
+			rows := make([]*row.RowData, 0, size)
+			for i:=db.RowID(1);i<=size;i++ {
+				data := html
+				if i%10 == 0 {
+					data = random
+				}
+				rows = append(rows, &row.RowData{Id: i,Op:uint32(db.ADD),Columns:[]*row.ColumnData{{Data: util.RowIDToBytes(i)}, {Data: data}}})
+			}
+			return rows
+		}
+		plainTable,plainTally := newTable(db.TableID(4), db.CompressionNone)
+		if err := blockService.SetBlockData(plainTable, plainTally, newRows()); err != nil {
+			panic(err.Error())
+		}
+		compressTable,compressTally := newTable(db.TableID(5), db.CompressionSnappy)
+		if err := blockService.SetBlockData(compressTable, compressTally, newRows()); err != nil {
+			panic(err.Error())
+		}
+		assert.True(t, compressTally.Block < plainTally.Block, "compress block num error")
+		for id:=db.BlockID(1);id<=compressTally.Block;id++ {
+			value,err := blockService.storage.GetBlockData(database.Id, compressTable.Id, id); if err != nil {
+				panic(err.Error())
+			}
+			assert.True(t, int64(len(value)) <= useSize+blockSize, fmt.Sprintf("block `%d` size error", id))
+		}
+		rowList, err := blockService.QueryRowDataByRange(compressTable, db.RowID(1), size, db.ASC,100);if err != nil {
+			panic(err.Error())
+		}
+		assert.EqualValues(t, len(rowList), size,"compress row len error")
+		for _,rowData := range rowList {
+			data := html
+			if rowData.Id%10 == 0 {
+				data = random
+			}
+			assert.EqualValues(t, data, rowData.Columns[1].Data, fmt.Sprintf("compress row `%d` data error", rowData.Id))
+		}
+	}
+	//块校验和与块连接
+	{
+		verifyTable := &db.TableData{Id:db.TableID(6),Name:"VerifyTable",
+			Columns:[]db.Column{{},{}},
+			PrimaryKey:db.PrimaryKey{ColumnID:db.ColumnID(1),AutoIncrement:true}}
+		verifyTally := &db.TableTally{TableID:verifyTable.Id}
+		columnData := make([]byte, 1024)
+		for i:=0;i<3;i++ {
+			rows := make([]*row.RowData, 0, 10)
+			for j:=0;j<10;j++ {
+				rows = append(rows, &row.RowData{Op:uint32(db.ADD),Columns:[]*row.ColumnData{{Data: util.RowIDToBytes(0)}, {Data: columnData}}})
+			}
+			if err := blockService.SetBlockData(verifyTable, verifyTally, rows); err != nil {
+				panic(err.Error())
+			}
+		}
+		tallies := []*db.TableTally{verifyTally}
+		verify,err := blockService.VerifyTable(verifyTable, tallies); if err != nil {
+			panic(err.Error())
+		}
+		assert.EqualValues(t, verifyTally.Block, verify.Blocks, "verify block num error")
+		assert.EqualValues(t, 0, verify.Legacy, "verify legacy num error")
+		assert.Empty(t, verify.Breaks, "verify breaks error")
+		//篡改块数据
+		tamperID := db.BlockID(2)
+		value,err := blockService.storage.GetBlockData(database.Id, verifyTable.Id, tamperID); if err != nil {
+			panic(err.Error())
+		}
+		block,err := DecodeBlock(value); if err != nil {
+			panic(err.Error())
+		}
+		rowID := db.RowID(block.Rows[0].Id)
+		columns := block.Rows[0].Columns
+		columns[len(columns)-1].Data = []byte("tampered")
+		value,err = proto.Marshal(block); if err != nil {
+			panic(err.Error())
+		}
+		if err := blockService.storage.PutBlockData(database.Id, verifyTable.Id, tamperID, value); err != nil {
+			panic(err.Error())
+		}
+		_,err = blockService.QueryRowData(verifyTable, rowID)
+		assert.NotNil(t, err, "tampered block read error")
+		assert.Contains(t, err.Error(), "checksum error")
+		verify,err = blockService.VerifyTable(verifyTable, tallies); if err != nil {
+			panic(err.Error())
+		}
+		assert.Equal(t, []db.BlockBreak{{BlockID:tamperID,Error:"block `2` checksum error"}}, verify.Breaks)
+		//重新计算校验和后与下一个块连接断开
+		if err := sealBlock(block, block.PrevHash); err != nil {
+			panic(err.Error())
+		}
+		value,err = proto.Marshal(block); if err != nil {
+			panic(err.Error())
+		}
+		if err := blockService.storage.PutBlockData(database.Id, verifyTable.Id, tamperID, value); err != nil {
+			panic(err.Error())
+		}
+		verify,err = blockService.VerifyTable(verifyTable, tallies); if err != nil {
+			panic(err.Error())
+		}
+		assert.Equal(t, []db.BlockBreak{{BlockID:tamperID+1,Error:"block `3` prev hash error"}}, verify.Breaks)
+		//删除最后一个块
+		if err := blockService.storage.PutBlockData(database.Id, verifyTable.Id, verifyTally.Block, nil); err != nil {
+			panic(err.Error())
+		}
+		verify,err = blockService.VerifyTable(verifyTable, tallies); if err != nil {
+			panic(err.Error())
+		}
+		assert.Len(t, verify.Breaks, 3, "verify truncate error")
+		assert.Contains(t, verify.Breaks[2].Error, "is not equal to table tally")
+	}
+	//行版本证明
+	{
+		proofTable := &db.TableData{Id:db.TableID(7),Name:"ProofTable",
+			Columns:[]db.Column{{},{}},
+			PrimaryKey:db.PrimaryKey{ColumnID:db.ColumnID(1)}}
+		proofTally := &db.TableTally{TableID:proofTable.Id}
+		size := db.RowID(10)
+		newColumns := func(id db.RowID, version byte, length int) []*row.ColumnData {
+			columns := []*row.ColumnData{{Data: util.RowIDToBytes(id)}}
+			for i:=0;i<length;i++ {
+				columns = append(columns, &row.ColumnData{Data: []byte(strings.Repeat(string('a'+version), 1024))})
+			}
+			return columns
+		}
+		//第一版新增，第二版修改，第三版行3分裂到多个块
+		for version:=byte(0);version<2;version++ {
+			op := db.ADD
+			if version > 0 {
+				op = db.UPDATE
+			}
+			rows := make([]*row.RowData, 0, size)
+			for i:=db.RowID(1);i<=size;i++ {
+				rows = append(rows, &row.RowData{Id: i,Op:uint32(op),Columns:newColumns(i, version, 1)})
+			}
+			if err := blockService.SetBlockData(proofTable, proofTally, rows); err != nil {
+				panic(err.Error())
+			}
+		}
+		splitRow := db.RowID(3)
+		if err := blockService.SetBlockData(proofTable, proofTally, []*row.RowData{{Id: splitRow,Op:uint32(db.UPDATE),Columns:newColumns(splitRow, 2, 12)}}); err != nil {
+			panic(err.Error())
+		}
+		assert.EqualValues(t, 0, proofTally.MerkleStart, "merkle start error")
+		queryProof := func(rowID db.RowID, version int64) *proof.RowProof {
+			blockID,err := blockService.QueryRowVersionBlockID(proofTable, rowID, version); if err != nil {
+				panic(err.Error())
+			}
+			rowProof,err := blockService.QueryRowProof(proofTable, []*db.TableTally{proofTally}, rowID, version, blockID); if err != nil {
+				panic(err.Error())
+			}
+			return rowProof
+		}
+		for i:=db.RowID(1);i<=size;i++ {
+			for version:=int64(1);version<=2;version++ {
+				rowProof := queryProof(i, version)
+				assert.Nil(t, proof.VerifyRowProof(rowProof), fmt.Sprintf("row `%d` version `%d` proof error", i, version))
+				rowData := &row.RowData{}
+				if err := proto.Unmarshal(rowProof.Row, rowData); err != nil {
+					panic(err.Error())
+				}
+				assert.Equal(t, newColumns(i, byte(version-1), 1)[1].Data, rowData.Columns[1].Data, "proof row data error")
+			}
+		}
+		rowProof := queryProof(splitRow, 0)
+		assert.True(t, len(rowProof.Blocks) > 1, "split row proof blocks error")
+		assert.Nil(t, proof.VerifyRowProof(rowProof), "split row proof error")
+		assert.Equal(t, proofTally.MerkleRoot, rowProof.Root, "proof root error")
+		_,err := blockService.QueryRowVersionBlockID(proofTable, splitRow, 4)
+		assert.NotNil(t, err, "row version not found error")
+		//篡改证明
+		rowData := &row.RowData{}
+		if err := proto.Unmarshal(rowProof.Row, rowData); err != nil {
+			panic(err.Error())
+		}
+		rowData.Columns[1].Data = []byte("tampered")
+		tampered := *rowProof
+		tampered.Row,err = proto.Marshal(rowData); if err != nil {
+			panic(err.Error())
+		}
+		assert.NotNil(t, proof.VerifyRowProof(&tampered), "tampered row proof error")
+		tampered = *rowProof
+		tampered.Blocks = rowProof.Blocks[:len(rowProof.Blocks)-1]
+		assert.NotNil(t, proof.VerifyRowProof(&tampered), "truncated row proof error")
+		tampered = *rowProof
+		tampered.Blocks = append([]proof.BlockProof{}, rowProof.Blocks...)
+		tampered.Blocks[0].Siblings = append([][]byte{[]byte("tampered")}, rowProof.Blocks[0].Siblings[1:]...)
+		assert.NotNil(t, proof.VerifyRowProof(&tampered), "tampered sibling proof error")
+		//增量行证明包含基础版本链
+		deltaRow := db.RowID(5)
+		for i:=0;i<2;i++ {
+			base,err := blockService.QueryRowVersionBlockID(proofTable, deltaRow, 0); if err != nil {
+				panic(err.Error())
+			}
+			rows := []*row.RowData{{Id:deltaRow,Op:uint32(db.UPDATE),Base:int32(base),Columns:[]*row.ColumnData{{Data:[]byte(fmt.Sprintf("delta%d", i))}},Delta:[]uint32{1}}}
+			if err := blockService.SetBlockData(proofTable, proofTally, rows); err != nil {
+				panic(err.Error())
+			}
+		}
+		rowProof = queryProof(deltaRow, 0)
+		assert.NotNil(t, rowProof.Base, "delta row base proof error")
+		assert.NotNil(t, rowProof.Base.Base, "delta row base chain error")
+		assert.Nil(t, rowProof.Base.Base.Base, "delta row full base error")
+		assert.Nil(t, proof.VerifyRowProof(rowProof), "delta row proof error")
+		rowData = &row.RowData{}
+		if err := proto.Unmarshal(rowProof.Row, rowData); err != nil {
+			panic(err.Error())
+		}
+		assert.Equal(t, util.RowIDToBytes(deltaRow), rowData.Columns[0].Data, "delta proof primary column error")
+		assert.Equal(t, []byte("delta1"), rowData.Columns[1].Data, "delta proof merged column error")
+		assert.EqualValues(t, 0, rowData.Base, "delta proof merged base error")
+		rowProof = queryProof(deltaRow, 3)
+		assert.Nil(t, proof.VerifyRowProof(rowProof), "delta row version proof error")
+		rowData = &row.RowData{}
+		if err := proto.Unmarshal(rowProof.Row, rowData); err != nil {
+			panic(err.Error())
+		}
+		assert.Equal(t, []byte("delta0"), rowData.Columns[1].Data, "delta proof version column error")
+		rowProof = queryProof(deltaRow, 0)
+		tampered = *rowProof
+		tampered.Base = nil
+		assert.NotNil(t, proof.VerifyRowProof(&tampered), "delta proof without base error")
+		tampered = *rowProof
+		tamperedBase := *rowProof.Base
+		tamperedBase.Origin = rowProof.Base.Blocks[0].BlockID+1
+		tampered.Base = &tamperedBase
+		assert.NotNil(t, proof.VerifyRowProof(&tampered), "delta proof base link error")
+		tampered = *rowProof
+		tamperedBase = *rowProof.Base
+		tamperedBase.Row = rowProof.Row
+		tampered.Base = &tamperedBase
+		assert.NotNil(t, proof.VerifyRowProof(&tampered), "delta proof base row error")
+	}
+	//列投影
+	{
+		projectTable := &db.TableData{Id:db.TableID(8),Name:"ProjectTable",
+			Columns:[]db.Column{{},{},{}},
+			PrimaryKey:db.PrimaryKey{ColumnID:db.ColumnID(1)}}
+		projectTally := &db.TableTally{TableID:projectTable.Id}
+		rowID := db.RowID(1)
+		largeData := []byte(strings.Repeat("a", 3*db.DefaultBlockSize))
+		rows := []*row.RowData{{Id:rowID,Op:uint32(db.ADD),Columns:[]*row.ColumnData{{Data:util.RowIDToBytes(rowID)},{Data:[]byte("name")},{Data:largeData}}}}
+		if err := blockService.SetBlockData(projectTable, projectTally, rows); err != nil {
+			panic(err.Error())
+		}
+		assert.True(t, projectTally.Block > 1, "project row split error")
+		rowData,err := blockService.QueryRowData(projectTable, rowID, db.ColumnID(3)); if err != nil {
+			panic(err.Error())
+		}
+		assert.Equal(t, largeData, rowData.Columns[2].Data, "project large column error")
+		assert.Empty(t, rowData.Columns[1].Data, "project skip column error")
+		//删除后续块，投影列都在第一个块时不读取后续块
+		if err := blockService.storage.PutBlockData(database.Id, projectTable.Id, projectTally.Block, nil); err != nil {
+			panic(err.Error())
+		}
+		rowData,err = blockService.QueryRowData(projectTable, rowID, db.ColumnID(1), db.ColumnID(2)); if err != nil {
+			panic(err.Error())
+		}
+		assert.Equal(t, []byte("name"), rowData.Columns[1].Data, "project first block column error")
+		rowList,err := blockService.QueryRowDataByRange(projectTable, db.RowID(0), db.RowID(0), db.ASC, 10, db.ColumnID(2)); if err != nil {
+			panic(err.Error())
+		}
+		assert.Len(t, rowList, 1, "project range error")
+		assert.Equal(t, []byte("name"), rowList[0].Columns[1].Data, "project range column error")
+		_,err = blockService.QueryRowData(projectTable, rowID)
+		assert.NotNil(t, err, "project all columns error")
+	}
+	//增量行
+	{
+		deltaTable := &db.TableData{Id:db.TableID(9),Name:"DeltaTable",
+			Columns:[]db.Column{{},{},{},{}},
+			PrimaryKey:db.PrimaryKey{ColumnID:db.ColumnID(1)}}
+		deltaTally := &db.TableTally{TableID:deltaTable.Id}
+		rowID := db.RowID(1)
+		wide := []byte(strings.Repeat("w", 2*db.DefaultBlockSize))
+		rows := []*row.RowData{{Id:rowID,Op:uint32(db.ADD),Columns:[]*row.ColumnData{{Data:util.RowIDToBytes(rowID)},{Data:[]byte("status0")},{Data:wide},{Data:[]byte("c")}}}}
+		if err := blockService.SetBlockData(deltaTable, deltaTally, rows); err != nil {
+			panic(err.Error())
+		}
+		for i:=1;i<=db.MaxDeltaDepth+1;i++ {
+			blockID,err := blockService.QueryRowBlockID(deltaTable, rowID); if err != nil {
+				panic(err.Error())
+			}
+			status := []byte(fmt.Sprintf("status%d", i))
+			deltaRow := &row.RowData{Id:rowID,Op:uint32(db.UPDATE),Base:int32(blockID),Columns:[]*row.ColumnData{{Data:status}},Delta:[]uint32{1}}
+			prevBlock := deltaTally.Block
+			if err := blockService.SetBlockData(deltaTable, deltaTally, []*row.RowData{deltaRow}); err != nil {
+				panic(err.Error())
+			}
+			block,err := blockService.getBlockData(deltaTable.Id, deltaTally.Block); if err != nil {
+				panic(err.Error())
+			}
+			if i == db.MaxDeltaDepth {//达到最大层数写入完整行
+				assert.EqualValues(t, 0, block.Rows[0].Base, "delta snapshot error")
+				assert.True(t, deltaTally.Block-prevBlock > 1, "delta snapshot blocks error")
+			}else{
+				assert.EqualValues(t, blockID, block.Rows[0].Base, "delta base error")
+				assert.EqualValues(t, i%db.MaxDeltaDepth, block.Rows[0].Depth, "delta depth error")
+				assert.EqualValues(t, 1, deltaTally.Block-prevBlock, "delta block num error")
+			}
+			rowData,err := blockService.QueryRowData(deltaTable, rowID); if err != nil {
+				panic(err.Error())
+			}
+			assert.Len(t, rowData.Columns, 4, "delta merge columns error")
+			assert.Equal(t, status, rowData.Columns[1].Data, "delta merge column error")
+			assert.Equal(t, wide, rowData.Columns[2].Data, "delta merge base column error")
+			assert.Equal(t, []byte("c"), rowData.Columns[3].Data, "delta merge last column error")
+			assert.EqualValues(t, 0, rowData.Base, "delta merge base error")
+			rowData,err = blockService.QueryRowData(deltaTable, rowID, db.ColumnID(4)); if err != nil {
+				panic(err.Error())
+			}
+			assert.Equal(t, []byte("c"), rowData.Columns[3].Data, "delta project column error")
+		}
+		histories,total,err := blockService.QueryRowDataHistoryByRange(deltaTable, rowID, db.ASC, 100); if err != nil {
+			panic(err.Error())
+		}
+		assert.EqualValues(t, db.MaxDeltaDepth+2, total, "delta history total error")
+		for i,history := range histories {
+			assert.Equal(t, []byte(fmt.Sprintf("status%d", i)), history.Row.Columns[1].Data, "delta history column error")
+			assert.Equal(t, wide, history.Row.Columns[2].Data, "delta history base column error")
+		}
+	}
+	//表一致性检查
+	{
+		parentTable := &db.TableData{Id:db.TableID(10),Name:"CheckParent",
+			Columns:[]db.Column{{},{}},
+			PrimaryKey:db.PrimaryKey{ColumnID:db.ColumnID(1),AutoIncrement:true}}
+		childTable := &db.TableData{Id:db.TableID(11),Name:"CheckChild",
+			Columns:[]db.Column{{},{},{}},
+			PrimaryKey:db.PrimaryKey{ColumnID:db.ColumnID(1),AutoIncrement:true},
+			ForeignKeys:[]db.ForeignKey{{ColumnID:db.ColumnID(2),Reference:db.ReferenceKey{TableID:db.TableID(10),ColumnID:db.ColumnID(1)}}},
+			Storage:db.StorageConfig{SplitRule:db.SplitRuleKeyNum}}
+		parentTally := &db.TableTally{TableID:parentTable.Id}
+		childTally := &db.TableTally{TableID:childTable.Id}
+		parents := make([]*row.RowData, 0, 3)
+		for i:=0;i<3;i++ {
+			parents = append(parents, &row.RowData{Op:uint32(db.ADD),Columns:[]*row.ColumnData{{Data:util.RowIDToBytes(0)},{Data:[]byte("parent")}}})
+		}
+		if err := blockService.SetBlockData(parentTable, parentTally, parents); err != nil {
+			panic(err.Error())
+		}
+		//外键引用行1超过集合容量转为链表，大行跨块
+		for i:=0;i<60;i++ {
+			parentID := db.RowID(1)
+			data := []byte("child")
+			if i%10 == 0 {
+				parentID = 2
+			}
+			if i == 30 {
+				data = []byte(strings.Repeat("c", 2*db.DefaultBlockSize))
+			}
+			rows := []*row.RowData{{Op:uint32(db.ADD),Columns:[]*row.ColumnData{{Data:util.RowIDToBytes(0)},{Data:util.RowIDToBytes(parentID)},{Data:data}}}}
+			if err := blockService.SetBlockData(childTable, childTally, rows); err != nil {
+				panic(err.Error())
+			}
+		}
+		for i:=0;i<55;i++ {
+			rows := []*row.RowData{{Id:db.RowID(5),Op:uint32(db.UPDATE),Columns:[]*row.ColumnData{{Data:util.RowIDToBytes(5)},{Data:util.RowIDToBytes(2)},{Data:[]byte(fmt.Sprintf("update%d", i))}}}}
+			if err := blockService.SetBlockData(childTable, childTally, rows); err != nil {
+				panic(err.Error())
+			}
+		}
+		check,err := blockService.CheckTable(childTable, []*db.TableTally{childTally}); if err != nil {
+			panic(err.Error())
+		}
+		assert.Empty(t, check.Issues, "check issues error")
+		assert.Empty(t, check.Repairs(), "check repairs error")
+		assert.EqualValues(t, childTally.Block, check.Blocks, "check blocks error")
+		assert.EqualValues(t, 115, check.Rows, "check rows error")
+		assert.Len(t, check.Indexes, 2, "check indexes error")
+		assert.True(t, check.Indexes[0].Height > 1, "check primary height error")
+		assert.EqualValues(t, 60, check.Indexes[0].Keys, "check primary keys error")
+		assert.EqualValues(t, 115, check.Indexes[0].Values, "check primary values error")
+		assert.EqualValues(t, 1, check.Indexes[0].Linked, "check primary linked error")
+		assert.EqualValues(t, 2, check.Indexes[1].Keys, "check foreign keys error")
+		assert.EqualValues(t, 61, check.Indexes[1].Values, "check foreign values error")//行5修改外键后新值索引一次，旧值的索引保留
+		assert.EqualValues(t, 1, check.Indexes[1].Linked, "check foreign linked error")
+		//统计与块不一致
+		brokenTally := *childTally
+		brokenTally.AddRow--
+		brokenTally.Block--
+		check,err = blockService.CheckTable(childTable, []*db.TableTally{&brokenTally}); if err != nil {
+			panic(err.Error())
+		}
+		assert.Len(t, check.Issues, 3, "check tally issues error")
+		for _,issue := range check.Issues {
+			assert.Equal(t, db.CheckKindTally, issue.Kind, "check tally issue kind error")
+		}
+		repairs := check.Repairs()
+		assert.Len(t, repairs, 1, "check tally repairs error")
+		assert.Equal(t, db.RepairTally, repairs[0].Type, "check tally repair type error")
+		assert.Equal(t, childTally.Block, repairs[0].Tally.Block, "check tally repair block error")
+		assert.Equal(t, childTally.AddRow, repairs[0].Tally.AddRow, "check tally repair rows error")
+		assert.Equal(t, childTally.BlockHash, repairs[0].Tally.BlockHash, "check tally repair hash error")
+		//引用行已删除
+		deleteRows := []*row.RowData{{Id:db.RowID(2),Op:uint32(db.DELETE),Columns:[]*row.ColumnData{{Data:util.RowIDToBytes(2)}}}}
+		if err := blockService.SetBlockData(parentTable, parentTally, deleteRows); err != nil {
+			panic(err.Error())
+		}
+		check,err = blockService.CheckTable(childTable, []*db.TableTally{childTally}); if err != nil {
+			panic(err.Error())
+		}
+		assert.Len(t, check.Issues, 7, "check foreign key issues error")
+		assert.Equal(t, db.CheckKindForeignKey, check.Issues[0].Kind, "check foreign key issue kind error")
+		assert.Equal(t, "row `1` reference row `2` is deleted", check.Issues[0].Error)
+		assert.Empty(t, check.Repairs(), "check foreign key repairs error")
+		parentCheck,err := blockService.CheckTable(parentTable, []*db.TableTally{parentTally}); if err != nil {
+			panic(err.Error())
+		}
+		assert.Empty(t, parentCheck.Issues, "check parent issues error")
+		//叶子节点关键字顺序破坏
+		treeStorage := storage.NewBPTreeStorage(state)
+		columnKey := db.ColumnKey{Database:database.Id,Table:childTable.Id,Column:db.ColumnID(1)}
+		headBytes,err := treeStorage.GetHead(columnKey); if err != nil {
+			panic(err.Error())
+		}
+		head,err := tree.DecodeTreeHead(headBytes); if err != nil {
+			panic(err.Error())
+		}
+		pointer := int64(head.FirstLeaf)
+		nodeBytes,err := treeStorage.GetNode(columnKey, pointer); if err != nil {
+			panic(err.Error())
+		}
+		node,err := tree.DecodeTreeNode(nodeBytes); if err != nil {
+			panic(err.Error())
+		}
+		node.Keys[0],node.Keys[1] = node.Keys[1],node.Keys[0]
+		if err := treeStorage.PutNode(columnKey, pointer, tree.EncodeTreeNode(node)); err != nil {
+			panic(err.Error())
+		}
+		check,err = blockService.CheckTable(childTable, []*db.TableTally{childTally}); if err != nil {
+			panic(err.Error())
+		}
+		repairs = check.Repairs()
+		assert.Equal(t, []db.TableRepair{{Type:db.RepairRebuildIndex,Column:db.ColumnID(1)}}, repairs, "check tree repairs error")
+		assert.Equal(t, db.CheckKindPrimaryKey, check.Issues[0].Kind, "check tree index issue kind error")
+		assert.Equal(t, db.CheckKindTree, check.Issues[2].Kind, "check tree issue kind error")
+		assert.Contains(t, check.Issues[2].Error, "is not ascending")
+		if err := treeStorage.PutNode(columnKey, pointer, nodeBytes); err != nil {
+			panic(err.Error())
+		}
+		//删除跨块大行的后续块
+		rowBlockID,err := blockService.QueryRowBlockID(childTable, db.RowID(31)); if err != nil {
+			panic(err.Error())
+		}
+		if err := blockService.storage.PutBlockData(database.Id, childTable.Id, rowBlockID+1, nil); err != nil {
+			panic(err.Error())
+		}
+		check,err = blockService.CheckTable(childTable, []*db.TableTally{childTally}); if err != nil {
+			panic(err.Error())
+		}
+		assert.Equal(t, "block `"+util.Int64ToString(int64(rowBlockID+1))+"` is not found", check.Issues[0].Error)
+		assert.Equal(t, db.CheckKindBlock, check.Issues[0].Kind, "check block issue kind error")
+		assert.Equal(t, db.CheckKindForeignKey, check.Issues[1].Kind, "check block continued row error")
+		assert.Len(t, check.Issues, 8, "check block issues error")
+	}
+	//按块数据重建索引
+	{
+		rebuildTable := &db.TableData{Id:db.TableID(12),Name:"RebuildChild",
+			Columns:[]db.Column{{},{},{}},
+			PrimaryKey:db.PrimaryKey{ColumnID:db.ColumnID(1),AutoIncrement:true},
+			ForeignKeys:[]db.ForeignKey{{ColumnID:db.ColumnID(2),Reference:db.ReferenceKey{TableID:db.TableID(10),ColumnID:db.ColumnID(1)}}},
+			TallyShards:2,PrimaryShards:2,
+			Storage:db.StorageConfig{SplitRule:db.SplitRuleKeyNum}}
+		tallies := []*db.TableTally{{TableID:rebuildTable.Id},{TableID:rebuildTable.Id,Shard:1,Block:db.BlockID(1)<<db.TallyShardBlockBits}}
+		for i:=0;i<60;i++ {
+			parentID := db.RowID(1)
+			data := []byte("child")
+			if i%10 == 0 {
+				parentID = 3
+			}
+			if i == 30 {
+				data = []byte(strings.Repeat("r", 2*db.DefaultBlockSize))
+			}
+			rows := []*row.RowData{{Op:uint32(db.ADD),Columns:[]*row.ColumnData{{Data:util.RowIDToBytes(0)},{Data:util.RowIDToBytes(parentID)},{Data:data}}}}
+			if err := blockService.SetBlockData(rebuildTable, tallies[i%2], rows); err != nil {
+				panic(err.Error())
+			}
+		}
+		for i:=0;i<55;i++ {
+			rows := []*row.RowData{{Id:db.RowID(5),Op:uint32(db.UPDATE),Columns:[]*row.ColumnData{{Data:util.RowIDToBytes(5)},{Data:util.RowIDToBytes(3)},{Data:[]byte(fmt.Sprintf("update%d", i))}}}}
+			if err := blockService.SetBlockData(rebuildTable, tallies[0], rows); err != nil {
+				panic(err.Error())
+			}
+		}
+		before,err := blockService.CheckTable(rebuildTable, tallies); if err != nil {
+			panic(err.Error())
+		}
+		assert.Empty(t, before.Issues, "rebuild before check issues error")
+		histories,total,err := blockService.QueryRowDataHistoryByRange(rebuildTable, db.RowID(5), db.DESC,100); if err != nil {
+			panic(err.Error())
+		}
+		//主键索引叶子节点关键字顺序破坏，外键索引写入不存在的行
+		treeStorage := storage.NewBPTreeStorage(state)
+		columnKey := db.ColumnKey{Database:database.Id,Table:rebuildTable.Id,Column:db.ColumnID(1)}
+		headBytes,err := treeStorage.GetHead(columnKey); if err != nil {
+			panic(err.Error())
+		}
+		head,err := tree.DecodeTreeHead(headBytes); if err != nil {
+			panic(err.Error())
+		}
+		pointer := int64(head.FirstLeaf)
+		nodeBytes,err := treeStorage.GetNode(columnKey, pointer); if err != nil {
+			panic(err.Error())
+		}
+		node,err := tree.DecodeTreeNode(nodeBytes); if err != nil {
+			panic(err.Error())
+		}
+		node.Keys[0],node.Keys[1] = node.Keys[1],node.Keys[0]
+		if err := treeStorage.PutNode(columnKey, pointer, tree.EncodeTreeNode(node)); err != nil {
+			panic(err.Error())
+		}
+		if err := blockService.indexService.PutForeignKeyIndex(database.Id, rebuildTable, db.ColumnID(2), db.RowID(999), util.RowIDToBytes(3)); err != nil {
+			panic(err.Error())
+		}
+		check,err := blockService.CheckTable(rebuildTable, tallies); if err != nil {
+			panic(err.Error())
+		}
+		repairs := check.Repairs()
+		assert.Equal(t, []db.TableRepair{{Type:db.RepairRebuildIndex,Column:db.ColumnID(1)},{Type:db.RepairRebuildIndex,Column:db.ColumnID(2)}}, repairs, "rebuild repairs error")
+		//每批在新的事务中执行
+		for _,repair := range repairs {
+			checkpoint := &db.RebuildCheckpoint{}
+			batches := 0
+			for !checkpoint.Done {
+				checkpoint,err = NewBlockService(database, state).RebuildIndex(rebuildTable, repair.Column, tallies, *checkpoint,8); if err != nil {
+					panic(err.Error())
+				}
+				batches++
+			}
+			assert.True(t, checkpoint.Discarded, "rebuild discarded error")
+			assert.EqualValues(t, before.Blocks, checkpoint.Blocks, "rebuild blocks error")
+			assert.Equal(t, []db.BlockID{tallies[0].Block,tallies[1].Block}, checkpoint.Shards, "rebuild shards error")
+			assert.True(t, batches > int(before.Blocks)/8, "rebuild batches error")
+		}
+		check,err = blockService.CheckTable(rebuildTable, tallies); if err != nil {
+			panic(err.Error())
+		}
+		assert.Empty(t, check.Issues, "rebuild check issues error")
+		assert.Equal(t, before.Indexes, check.Indexes, "rebuild indexes error")
+		rebuildHistories,rebuildTotal,err := NewBlockService(database, state).QueryRowDataHistoryByRange(rebuildTable, db.RowID(5), db.DESC,100); if err != nil {
+			panic(err.Error())
+		}
+		assert.Equal(t, total, rebuildTotal, "rebuild history total error")
+		assert.Equal(t, histories, rebuildHistories, "rebuild histories error")
+		_,err = blockService.RebuildIndex(rebuildTable, db.ColumnID(3), tallies, db.RebuildCheckpoint{},0)
+		assert.NotNil(t, err, "rebuild column without index error")
+	}
+	//分片表指定ID新增，其他分片自增时跳过已存在的ID
+	{
+		shardTable := &db.TableData{Id:db.TableID(14),Name:"ShardTable",
+			Columns:[]db.Column{{}},
+			PrimaryKey:db.PrimaryKey{ColumnID:db.ColumnID(1),AutoIncrement:true},
+			TallyShards:2,PrimaryShards:2}
+		tallies := []*db.TableTally{{TableID:shardTable.Id},{TableID:shardTable.Id,Shard:1,Block:db.BlockID(1)<<db.TallyShardBlockBits}}
+		rows := []*row.RowData{{Id:db.RowID(2),Op:uint32(db.ADD),Columns:[]*row.ColumnData{{Data:util.RowIDToBytes(2)}}}}
+		if err := blockService.SetBlockData(shardTable, tallies[0], rows); err != nil {
+			panic(err.Error())
+		}
+		rows = []*row.RowData{{Op:uint32(db.ADD),Columns:[]*row.ColumnData{{Data:util.RowIDToBytes(0)}}}}
+		if err := blockService.SetBlockData(shardTable, tallies[1], rows); err != nil {
+			panic(err.Error())
+		}
+		assert.EqualValues(t, 4, rows[0].Id, "shard increment skip error")
+		rowBlockIDs,err := blockService.indexService.GetPrimaryKeyIndexByRange(database.Id, shardTable, 0, 0, db.ASC,10); if err != nil {
+			panic(err.Error())
+		}
+		assert.Equal(t, 2, len(rowBlockIDs), "shard primary index rows error")
+		check,err := blockService.CheckTable(shardTable, tallies); if err != nil {
+			panic(err.Error())
+		}
+		assert.Empty(t, check.Issues, "shard check issues error")
+		assert.Equal(t, 2, len(check.Indexes), "shard check indexes error")
+	}
+	//按保留策略压缩
+	{
+		compactTable := &db.TableData{Id:db.TableID(13),Name:"CompactChild",
+			Columns:[]db.Column{{},{},{}},
+			PrimaryKey:db.PrimaryKey{ColumnID:db.ColumnID(1),AutoIncrement:true},
+			ForeignKeys:[]db.ForeignKey{{ColumnID:db.ColumnID(2),Reference:db.ReferenceKey{TableID:db.TableID(10),ColumnID:db.ColumnID(1)}}},
+			TallyShards:2,
+			Storage:db.StorageConfig{SplitRule:db.SplitRuleKeyNum}}
+		tallies := []*db.TableTally{{TableID:compactTable.Id},{TableID:compactTable.Id,Shard:1,Block:db.BlockID(1)<<db.TallyShardBlockBits}}
+		_,_,err := blockService.CompactTable(compactTable, tallies, db.CompactCheckpoint{},0)
+		assert.NotNil(t, err, "compact without retention error")
+		for i:=0;i<20;i++ {
+			data := []byte("compact")
+			if i == 9 {
+				data = []byte(strings.Repeat("c", 2*db.DefaultBlockSize))
+			}
+			rows := []*row.RowData{{Op:uint32(db.ADD),Columns:[]*row.ColumnData{{Data:util.RowIDToBytes(0)},{Data:util.RowIDToBytes(1)},{Data:data}}}}
+			if err := blockService.SetBlockData(compactTable, tallies[i%2], rows); err != nil {
+				panic(err.Error())
+			}
+		}
+		//行5修改超过集合容量转为链表，大行10修改，行7、8删除
+		update := func(rowID db.RowID, i int) {
+			blockID,err := blockService.QueryRowBlockID(compactTable, rowID); if err != nil {
+				panic(err.Error())
+			}
+			rows := []*row.RowData{{Id:rowID,Op:uint32(db.UPDATE),Base:int32(blockID),Columns:[]*row.ColumnData{{Data:[]byte(fmt.Sprintf("update%d", i))}},Delta:[]uint32{2}}}
+			if err := blockService.SetBlockData(compactTable, tallies[i%2], rows); err != nil {
+				panic(err.Error())
+			}
+		}
+		for i:=0;i<60;i++ {
+			update(db.RowID(5), i)
+		}
+		for i:=0;i<5;i++ {
+			update(db.RowID(10), i)
+		}
+		for i,rowID := range []db.RowID{7,8} {
+			rowData,err := blockService.QueryRowData(compactTable, rowID); if err != nil {
+				panic(err.Error())
+			}
+			rows := []*row.RowData{{Id:rowID,Op:uint32(db.DELETE),Columns:rowData.Columns}}
+			if err := blockService.SetBlockData(compactTable, tallies[i], rows); err != nil {
+				panic(err.Error())
+			}
+		}
+		deletedBlock,err := blockService.QueryRowVersionBlockID(compactTable, db.RowID(7), 1); if err != nil {
+			panic(err.Error())
+		}
+		before,err := blockService.QueryRowDataByRange(compactTable, db.RowID(1), db.RowID(20), db.ASC, 100); if err != nil {
+			panic(err.Error())
+		}
+		histories,_,err := blockService.QueryRowDataHistoryByRange(compactTable, db.RowID(5), db.DESC, 3); if err != nil {
+			panic(err.Error())
+		}
+		check,err := blockService.CheckTable(compactTable, tallies); if err != nil {
+			panic(err.Error())
+		}
+		assert.Empty(t, check.Issues, "compact before check issues error")
+		compactTable.Retention = db.RetentionConfig{KeepVersions:3,PurgeDeletedBefore:histories[0].Time+1}
+		//每批在新的事务中执行
+		checkpoint := &db.CompactCheckpoint{}
+		batches := 0
+		for !checkpoint.Done {
+			checkpoint,_,err = NewBlockService(database, state).CompactTable(compactTable, tallies, *checkpoint, 4); if err != nil {
+				panic(err.Error())
+			}
+			batches++
+		}
+		assert.True(t, batches > 1, "compact batches error")
+		assert.EqualValues(t, 58+3+4, checkpoint.Versions, "compact versions error")
+		assert.True(t, checkpoint.Purged > 0, "compact purged blocks error")
+		assert.True(t, checkpoint.Moved > 0, "compact moved versions error")
+		value,err := blockService.storage.GetBlockData(database.Id, compactTable.Id, deletedBlock); if err != nil {
+			panic(err.Error())
+		}
+		assert.Empty(t, value, "compact deleted row block error")
+		compactService := NewBlockService(database, state)
+		check,err = compactService.CheckTable(compactTable, tallies); if err != nil {
+			panic(err.Error())
+		}
+		assert.Empty(t, check.Issues, "compact check issues error")
+		assert.EqualValues(t, checkpoint.Purged, check.Purged, "compact check purged error")
+		verify,err := compactService.VerifyTable(compactTable, tallies); if err != nil {
+			panic(err.Error())
+		}
+		assert.Empty(t, verify.Breaks, "compact verify breaks error")
+		assert.EqualValues(t, checkpoint.Purged, verify.Purged, "compact verify purged error")
+		after,err := compactService.QueryRowDataByRange(compactTable, db.RowID(1), db.RowID(20), db.ASC, 100); if err != nil {
+			panic(err.Error())
+		}
+		assert.Equal(t, len(before)-2, len(after), "compact rows error")
+		for _,rowData := range after {
+			for _,beforeRow := range before {
+				if beforeRow.Id == rowData.Id {
+					assert.Equal(t, beforeRow.Columns, rowData.Columns, "compact row columns error")
+				}
+			}
+			assert.True(t, rowData.Id != 7 && rowData.Id != 8, "compact deleted row error")
+		}
+		version,err := compactService.QueryRowVersion(compactTable, db.RowID(7)); if err != nil {
+			panic(err.Error())
+		}
+		assert.EqualValues(t, 0, version.BlockID, "compact deleted row version error")
+		compactHistories,total,err := compactService.QueryRowDataHistoryByRange(compactTable, db.RowID(5), db.DESC, 100); if err != nil {
+			panic(err.Error())
+		}
+		assert.EqualValues(t, 3, total, "compact history total error")
+		for i,history := range compactHistories {
+			assert.Equal(t, histories[i].TxID, history.TxID, "compact history tx error")
+			assert.Equal(t, histories[i].Row.Columns, history.Row.Columns, "compact history columns error")
+		}
+		assert.EqualValues(t, db.ADD, compactHistories[2].Row.Op, "compact first version op error")
+		//压缩后重建外键索引，清除所有版本的行外键索引在重建后删除
+		rebuild := &db.RebuildCheckpoint{}
+		for !rebuild.Done {
+			rebuild,err = NewBlockService(database, state).RebuildIndex(compactTable, db.ColumnID(2), tallies, *rebuild,0); if err != nil {
+				panic(err.Error())
+			}
+		}
+		//同一秒中行5在不同分片修改，版本顺序无法恢复，拒绝重建主键索引且旧索引保留
+		rebuild = &db.RebuildCheckpoint{}
+		for err == nil && !rebuild.Checked {
+			rebuild,err = NewBlockService(database, state).RebuildIndex(compactTable, db.ColumnID(1), tallies, *rebuild,4)
+		}
+		assert.NotNil(t, err, "rebuild same time versions error")
+		assert.Contains(t, err.Error(), "at the same time")
+		rebuildService := NewBlockService(database, state)
+		check,err = rebuildService.CheckTable(compactTable, tallies); if err != nil {
+			panic(err.Error())
+		}
+		assert.Empty(t, check.Issues, "compact rebuild check issues error")
+		rebuildHistories,_,err := rebuildService.QueryRowDataHistoryByRange(compactTable, db.RowID(5), db.DESC, 100); if err != nil {
+			panic(err.Error())
+		}
+		assert.Equal(t, compactHistories, rebuildHistories, "compact rebuild histories error")
+		//再次压缩没有可清除的版本
+		checkpoint,_,err = NewBlockService(database, state).CompactTable(compactTable, tallies, db.CompactCheckpoint{},0); if err != nil {
+			panic(err.Error())
+		}
+		assert.True(t, checkpoint.Done, "compact again done error")
+		assert.EqualValues(t, 0, checkpoint.Versions, "compact again versions error")
+		assert.Empty(t, checkpoint.Skipped, "compact again skipped error")
+		//块中的版本不在主键索引中时跳过并记录块
+		values,err := compactService.indexService.GetPrimaryKeyVersions(database.Id, compactTable, db.RowID(5)); if err != nil {
+			panic(err.Error())
+		}
+		missing,_ := new(index.PrimaryParse).BlockID(values[0])
+		if err := compactService.indexService.RewritePrimaryKeyIndex(database.Id, compactTable, db.RowID(5), values[1:]); err != nil {
+			panic(err.Error())
+		}
+		checkpoint,_,err = NewBlockService(database, state).CompactTable(compactTable, tallies, db.CompactCheckpoint{},0); if err != nil {
+			panic(err.Error())
+		}
+		assert.True(t, checkpoint.Done, "compact skipped done error")
+		assert.Contains(t, checkpoint.Skipped, missing, "compact skipped block error")
+		if err := compactService.indexService.RewritePrimaryKeyIndex(database.Id, compactTable, db.RowID(5), values); err != nil {
+			panic(err.Error())
+		}
+	}
+}
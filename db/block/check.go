@@ -0,0 +1,409 @@
+package block
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/database-fabric/db"
+	"github.com/database-fabric/db/index"
+	"github.com/database-fabric/db/util"
+	"github.com/database-fabric/protos/db/row"
+	"sort"
+)
+
+/**
+	表一致性检查：
+	1、按统计分片遍历块，校验块连接和Join连续，按块中的行版本重新计算统计
+	2、主键索引每个版本指向包含该行的块(操作类型一致)，块中每个行版本都有索引
+	3、外键索引中的行存在，引用行存在且未删除，新增行的外键值都有索引
+	4、计数器分组值等于按未删除行的最新版本重新计算的值
+ */
+type tableChecker struct {
+	table *db.TableData
+	references map[db.TableID]*db.TableData //外键引用的表，主键索引分片时按引用表查询引用行
+	check *db.TableCheck
+	versions map[db.RowID]map[db.BlockID]db.OpType //块中行版本(行第一部分所在块)
+	foreignValues map[db.RowID]map[db.ColumnID][]byte //新增、修改行版本的外键值(最后一个版本)
+	rowOps map[db.RowID]db.OpType //主键索引中行最新版本的操作类型
+	rowBlocks map[db.RowID]db.BlockID //主键索引中行最新版本的块ID
+	uncertain map[db.RowID]db.BlockID //缺失块之后第一行，无法确定是否为行的后续部分
+}
+
+func (checker *tableChecker) addIssue(issue db.CheckIssue, format string, args ...interface{}) {
+	issue.Error = fmt.Sprintf(format, args...)
+	checker.check.Issues = append(checker.check.Issues, issue)
+}
+
+func (service *BlockService) CheckTable(table *db.TableData, tallies []*db.TableTally, references ...*db.TableData) (*db.TableCheck,error) {
+	checker := &tableChecker{
+		table:table,
+		references:map[db.TableID]*db.TableData{},
+		check:&db.TableCheck{TableID:table.Id,Indexes:[]db.IndexCheck{},Issues:[]db.CheckIssue{},Tallies:make([]*db.TableTally, 0, len(tallies))},
+		versions:map[db.RowID]map[db.BlockID]db.OpType{},
+		foreignValues:map[db.RowID]map[db.ColumnID][]byte{},
+		rowOps:map[db.RowID]db.OpType{},
+		rowBlocks:map[db.RowID]db.BlockID{},
+		uncertain:map[db.RowID]db.BlockID{},
+	}
+	for _,reference := range references {
+		checker.references[reference.Id] = reference
+	}
+	for _,tally := range tallies {
+		if err := service.checkBlocks(checker, tally); err != nil {
+			return nil,err
+		}
+	}
+	if err := service.checkPrimaryIndex(checker); err != nil {
+		return nil,err
+	}
+	service.checkLiveRows(checker)
+	if len(table.Counters) > 0 {
+		if err := service.checkCounters(checker); err != nil {
+			return nil,err
+		}
+	}
+	for _,foreignKey := range table.ForeignKeys {
+		if err := service.checkForeignIndex(checker, foreignKey); err != nil {
+			return nil,err
+		}
+	}
+	return checker.check,nil
+}
+
+/**
+	遍历分片的块，统计中记录的块必须存在(压缩删除的块除外)，统计之后存在的块为未记录块
+	行跨块时只有第一部分计为一个版本，后续部分必须紧接在上一个块的最后一行之后
+	压缩时已移动到新块的版本不计入
+ */
+func (service *BlockService) checkBlocks(checker *tableChecker, tally *db.TableTally) error {
+	table := checker.table
+	start := db.BlockID(0)
+	if table.TallyShards > 1 {
+		start = db.BlockID(tally.Shard)<<db.TallyShardBlockBits
+	}
+	expect := *tally
+	expect.AddRow,expect.UpdateRow,expect.DelRow = 0,0,0
+	blockIssue := db.CheckIssue{Kind:db.CheckKindBlock,Shard:tally.Shard}
+	tallyIssue := db.CheckIssue{Kind:db.CheckKindTally,Shard:tally.Shard,Repair:db.RepairTally}
+	var prevHash []byte
+	var prevBlock *row.BlockData
+	gap,afterGap := false,false
+	for id:=start+1;;id++ {
+		blockIssue.Block = id
+		value,err := service.storage.GetBlockData(service.database.Id, table.Id, id); if err != nil {
+			return err
+		}
+		record,err := service.getBlockCompact(table.Id, id); if err != nil {
+			return err
+		}
+		if len(value) == 0 {
+			if id > tally.Block {
+				break
+			}
+			if record != nil && record.Purged {//压缩删除的块，使用记录中的校验和连接
+				checker.check.Purged++
+				if len(prevHash) > 0 && !bytes.Equal(prevHash, record.PrevHash) {
+					checker.addIssue(blockIssue, "purged block `%d` prev hash error", id)
+				}
+				if id == tally.Block && !bytes.Equal(record.Checksum, tally.BlockHash) {
+					tallyIssue.Block = id
+					checker.addIssue(tallyIssue, "block `%d` hash is not equal to table tally", id)
+					expect.BlockHash = record.Checksum
+				}
+				prevHash,prevBlock = record.Checksum,nil
+				continue
+			}
+			checker.addIssue(blockIssue, "block `%d` is not found", id)
+			prevHash,prevBlock = nil,nil
+			gap,afterGap = true,true
+			continue
+		}
+		checker.check.Blocks++
+		block,err := DecodeBlock(value); if err != nil {
+			checker.addIssue(blockIssue, "block `%d` convert error `%s`", id, err.Error())
+			prevHash,prevBlock = nil,nil
+			gap,afterGap = true,true
+			continue
+		}
+		if err := checkBlock(id, block, prevHash); err != nil {
+			checker.addIssue(blockIssue, "%s", err.Error())
+			prevHash = nil
+		}else{
+			prevHash = block.Checksum
+		}
+		if id > tally.Block {
+			if expect.Block == tally.Block {
+				tallyIssue.Block = id
+				checker.addIssue(tallyIssue, "block `%d` is not recorded in tally block `%d`", id, tally.Block)
+			}
+			expect.Block,expect.BlockHash = id,block.Checksum
+		}else if id == tally.Block && !bytes.Equal(block.Checksum, tally.BlockHash) {
+			tallyIssue.Block = id
+			checker.addIssue(tallyIssue, "block `%d` hash is not equal to table tally", id)
+			expect.BlockHash = block.Checksum
+		}
+		if len(block.Rows) == 0 {
+			checker.addIssue(blockIssue, "block `%d` has no row", id)
+		}
+		for i,blockRow := range block.Rows {
+			if i == 0 && prevBlock != nil && prevBlock.Join != row.BlockData_JOIN_NONE {
+				joinID := prevBlock.Rows[len(prevBlock.Rows)-1].Id
+				if blockRow.Id == joinID {//行的后续部分
+					continue
+				}
+				blockIssue.Row = joinID
+				checker.addIssue(blockIssue, "block `%d` join row `%d` is not continued", id-1, joinID)
+				blockIssue.Row = 0
+			}
+			if record != nil {
+				if _,moved := record.Moved[db.RowID(blockRow.Id)]; moved {//压缩时已移动到新块
+					continue
+				}
+			}
+			if i == 0 && afterGap {//不计入统计，有索引时记录为行版本
+				checker.uncertain[db.RowID(blockRow.Id)] = id
+				continue
+			}
+			service.checkBlockRow(checker, &expect, id, blockRow, i == len(block.Rows)-1 && block.Join == row.BlockData_JOIN_COLUMN)
+		}
+		if len(block.Rows) == 0 {
+			prevBlock = nil
+		}else{
+			prevBlock = block
+		}
+		afterGap = false
+	}
+	if prevBlock != nil && prevBlock.Join != row.BlockData_JOIN_NONE {
+		blockIssue.Block = expect.Block
+		checker.addIssue(blockIssue, "block `%d` join is not closed", expect.Block)
+	}
+	tallyIssue.Block = 0
+	if gap {//块缺失时重新计算的行数不准确
+		tallyIssue.Repair = db.RepairNone
+	}
+	if expect.AddRow != tally.AddRow || expect.UpdateRow != tally.UpdateRow || expect.DelRow != tally.DelRow {
+		checker.addIssue(tallyIssue, "tally rows add `%d` update `%d` delete `%d` error, blocks add `%d` update `%d` delete `%d`",
+			tally.AddRow, tally.UpdateRow, tally.DelRow, expect.AddRow, expect.UpdateRow, expect.DelRow)
+	}
+	if expect.Increment != tally.Increment {
+		checker.addIssue(tallyIssue, "tally increment `%d` is less than row `%d`", tally.Increment, expect.Increment)
+	}
+	checker.check.Tallies = append(checker.check.Tallies, &expect)
+	return nil
+}
+
+/**
+	记录行版本，partial为行最后一列在下一个块中继续(该列值不完整)
+ */
+func (service *BlockService) checkBlockRow(checker *tableChecker, expect *db.TableTally, blockID db.BlockID, blockRow *row.RowData, partial bool) {
+	rowID := db.RowID(blockRow.Id)
+	op := db.OpType(blockRow.Op)
+	switch op {
+	case db.ADD:
+		expect.AddRow++
+	case db.UPDATE:
+		expect.UpdateRow++
+	case db.DELETE:
+		expect.DelRow++
+	default:
+		checker.addIssue(db.CheckIssue{Kind:db.CheckKindBlock,Block:blockID,Row:rowID}, "block `%d` row `%d` op `%d` error", blockID, rowID, op)
+	}
+	if rowID > expect.Increment {
+		expect.Increment = rowID
+	}
+	checker.check.Rows++
+	rowVersions,ok := checker.versions[rowID]
+	if !ok {
+		rowVersions = map[db.BlockID]db.OpType{}
+		checker.versions[rowID] = rowVersions
+	}
+	rowVersions[blockID] = op
+	if op == db.DELETE {
+		return
+	}
+	for _,foreignKey := range checker.table.ForeignKeys {
+		position := index.ForeignKeyPosition(blockRow, foreignKey.ColumnID)
+		if position < 0 || (partial && position == len(blockRow.Columns)-1) {
+			continue
+		}
+		value := blockRow.Columns[position].Data
+		if len(value) == 0 {
+			continue
+		}
+		values,ok := checker.foreignValues[rowID]
+		if !ok {
+			values = map[db.ColumnID][]byte{}
+			checker.foreignValues[rowID] = values
+		}
+		values[foreignKey.ColumnID] = value
+	}
+}
+
+/**
+	主键索引版本与块中行版本一一对应
+ */
+func (service *BlockService) checkPrimaryIndex(checker *tableChecker) error {
+	table := checker.table
+	indexed := map[db.RowID]map[db.BlockID]bool{}
+	issue := db.CheckIssue{Kind:db.CheckKindPrimaryKey,Column:table.PrimaryKey.ColumnID,Repair:db.RepairRebuildIndex}
+	for _,columnKey := range table.PrimaryColumnKeys(service.database.Id) {
+		if err := service.checkPrimaryShard(checker, columnKey, indexed); err != nil {
+			return err
+		}
+	}
+	for _,rowID := range sortRowIDs(checker.versions) {
+		blocks := make([]db.BlockID, 0, len(checker.versions[rowID]))
+		for blockID := range checker.versions[rowID] {
+			if !indexed[rowID][blockID] {
+				blocks = append(blocks, blockID)
+			}
+		}
+		sort.Slice(blocks, func(i, j int) bool { return blocks[i] < blocks[j] })
+		for _,blockID := range blocks {
+			issue.Row,issue.Block = rowID,blockID
+			checker.addIssue(issue, "row `%d` version in block `%d` is not indexed", rowID, blockID)
+		}
+	}
+	return nil
+}
+
+/**
+	检查一个主键索引分片，行必须在行ID对应的分片中
+ */
+func (service *BlockService) checkPrimaryShard(checker *tableChecker, columnKey db.ColumnKey, indexed map[db.RowID]map[db.BlockID]bool) error {
+	table := checker.table
+	parse := new(index.PrimaryParse)
+	issue := db.CheckIssue{Kind:db.CheckKindPrimaryKey,Column:columnKey.Column,Repair:db.RepairRebuildIndex}
+	indexCheck,issues,err := service.indexService.CheckIndex(columnKey, true, func(key []byte, values [][]byte) error {
+		rowID := util.BytesToRowID(key)
+		issue.Row = rowID
+		if shard := table.PrimaryShard(rowID); shard != columnKey.Shard {
+			checker.addIssue(issue, "row `%d` is indexed in primary shard `%d`, expected `%d`", rowID, columnKey.Shard, shard)
+		}
+		rowVersions := checker.versions[rowID]
+		indexed[rowID] = map[db.BlockID]bool{}
+		if len(values) == 0 {//压缩清除了行的所有版本
+			checker.rowOps[rowID] = db.DELETE
+		}
+		for _,value := range values {
+			if len(value) == 0 {
+				checker.addIssue(issue, "row `%d` version value is null", rowID)
+				continue
+			}
+			blockID,_ := parse.BlockID(value)
+			op := parse.GetBlockType(value)
+			checker.rowOps[rowID] = op
+			checker.rowBlocks[rowID] = blockID
+			issue.Block = blockID
+			blockOp,ok := rowVersions[blockID]
+			if uncertain,exists := checker.uncertain[rowID]; !ok && exists && uncertain == blockID {
+				continue
+			}
+			if !ok {
+				checker.addIssue(issue, "row `%d` version block `%d` does not contain the row", rowID, blockID)
+			}else if blockOp != op {
+				checker.addIssue(issue, "row `%d` version block `%d` op `%d` is not equal to block op `%d`", rowID, blockID, op, blockOp)
+			}
+			indexed[rowID][blockID] = true
+		}
+		issue.Block = 0
+		return nil
+	}); if err != nil {
+		return err
+	}
+	checker.check.Indexes = append(checker.check.Indexes, *indexCheck)
+	checker.check.Issues = append(checker.check.Issues, issues...)
+	return nil
+}
+
+/**
+	统计的行数量等于主键索引中最新版本未删除的行数量，分片的行数量不能单独重新计算，差值修复到第一个分片
+ */
+func (service *BlockService) checkLiveRows(checker *tableChecker) {
+	live := db.RowID(0)
+	for _,op := range checker.rowOps {
+		if op != db.DELETE {
+			live++
+		}
+	}
+	tallyLive := db.RowID(0)
+	for _,tally := range checker.check.Tallies {
+		tallyLive += tally.LiveRow
+	}
+	if live == tallyLive || len(checker.check.Tallies) == 0 {
+		return
+	}
+	expect := checker.check.Tallies[0]
+	expect.LiveRow += live - tallyLive
+	checker.addIssue(db.CheckIssue{Kind:db.CheckKindTally,Shard:expect.Shard,Repair:db.RepairTally}, "tally live rows `%d` error, primary index live rows `%d`", tallyLive, live)
+}
+
+/**
+	外键索引关键字为引用行ID，值为行ID
+ */
+func (service *BlockService) checkForeignIndex(checker *tableChecker, foreignKey db.ForeignKey) error {
+	table := checker.table
+	indexed := map[db.RowID]map[string]bool{}
+	issue := db.CheckIssue{Kind:db.CheckKindForeignKey,Column:foreignKey.ColumnID}
+	reference,ok := checker.references[foreignKey.Reference.TableID]
+	if !ok {//未提供引用表时按未分片的主键索引查询
+		reference = &db.TableData{Id:foreignKey.Reference.TableID,PrimaryKey:db.PrimaryKey{ColumnID:foreignKey.Reference.ColumnID}}
+	}
+	columnKey := db.ColumnKey{Database:service.database.Id,Table:table.Id,Column:foreignKey.ColumnID}
+	indexCheck,issues,err := service.indexService.CheckIndex(columnKey, false, func(key []byte, values [][]byte) error {
+		referenceID := util.BytesToRowID(key)
+		version,err := service.indexService.GetPrimaryKeyIndexVersion(service.database.Id, reference, referenceID); if err != nil {
+			return err
+		}
+		for _,value := range values {
+			rowID := util.BytesToRowID(value)
+			issue.Row = rowID
+			if _,ok := indexed[rowID]; !ok {
+				indexed[rowID] = map[string]bool{}
+			}
+			if indexed[rowID][string(key)] {//旧版本删除后重新新增时重复写入的索引
+				issue.Repair = db.RepairRebuildIndex
+				checker.addIssue(issue, "row `%d` of foreign key `%d` is indexed more than once", rowID, referenceID)
+				continue
+			}
+			indexed[rowID][string(key)] = true
+			op,ok := checker.rowOps[rowID]
+			if !ok {
+				issue.Repair = db.RepairRebuildIndex
+				checker.addIssue(issue, "row `%d` of foreign key `%d` is not found", rowID, referenceID)
+				continue
+			}
+			if op == db.DELETE {
+				continue
+			}
+			issue.Repair = db.RepairNone
+			if version.BlockID == 0 {
+				checker.addIssue(issue, "row `%d` reference row `%d` is not found", rowID, referenceID)
+			}else if version.Op == db.DELETE {
+				checker.addIssue(issue, "row `%d` reference row `%d` is deleted", rowID, referenceID)
+			}
+		}
+		return nil
+	}); if err != nil {
+		return err
+	}
+	checker.check.Indexes = append(checker.check.Indexes, *indexCheck)
+	checker.check.Issues = append(checker.check.Issues, issues...)
+	issue.Repair = db.RepairRebuildIndex
+	for _,rowID := range sortRowIDs(checker.versions) {
+		value := checker.foreignValues[rowID][foreignKey.ColumnID]
+		if len(value) > 0 && !indexed[rowID][string(value)] {
+			issue.Row = rowID
+			checker.addIssue(issue, "row `%d` foreign key `%d` is not indexed", rowID, util.BytesToRowID(value))
+		}
+	}
+	return nil
+}
+
+func sortRowIDs(versions map[db.RowID]map[db.BlockID]db.OpType) []db.RowID {
+	rowIDs := make([]db.RowID, 0, len(versions))
+	for rowID := range versions {
+		rowIDs = append(rowIDs, rowID)
+	}
+	sort.Slice(rowIDs, func(i, j int) bool { return rowIDs[i] < rowIDs[j] })
+	return rowIDs
+}
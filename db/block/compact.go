@@ -0,0 +1,614 @@
+package block
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/database-fabric/db"
+	"github.com/database-fabric/db/index"
+	"github.com/database-fabric/db/util"
+	"github.com/database-fabric/protos/db/row"
+)
+
+//压缩中的行版本(行第一部分所在块)
+type compactVersion struct {
+	rowID db.RowID
+	block db.BlockID
+	op db.OpType
+}
+
+//连续连接的一组块，组内块一起删除
+type compactRun struct {
+	blocks []*row.BlockData
+	end db.BlockID //已扫描的最后一个块ID
+}
+
+//移动到新块的行版本
+type compactMove struct {
+	version compactVersion
+	source *row.BlockData //原块
+	row *row.RowData //合并后的完整行
+	forced bool //第一个保留版本转换为新增
+	target db.BlockID //新块ID
+}
+
+type compactor struct {
+	table *db.TableData
+	tallies []*db.TableTally
+	checkpoint *db.CompactCheckpoint
+	parse *index.PrimaryParse
+	changed map[int]bool //有修改的统计下标
+	blocks map[db.BlockID]*row.BlockData //已读取的块，用于版本时间和原块事务
+	purged map[db.BlockID]bool //本事务中已删除的块(同一事务中仍能读取到删除前的值)
+	visited map[db.BlockID]bool //本批中按版本压缩过的块
+	skipped map[db.BlockID]bool //已跳过的块
+}
+
+/**
+	按表保留策略压缩数据块，分批在多个事务中执行，每批最多扫描size个块：
+	1、按统计分片扫描压缩开始时已写入的块，连续连接的块作为一组处理
+	2、按主键索引中的版本顺序从最早的版本开始计算清除的版本，组中有清除的版本时重写整组：
+	   保留的版本合并为完整行，按原块的事务ID和时间写入同一分片的新块，原块使用DelState删除并保存压缩记录
+	3、基础版本被清除的修改版本合并为完整行移动到新块，之前的版本全部清除时转换为新增
+	4、重写行主键索引版本，扣减统计中清除的版本数量
+	已扫描块中的版本在下一次压缩时清除，删除块中保存的大字段引用释放，引用计数为0的分片删除
+	有不在主键索引中的版本的组跳过(版本顺序无法确定)，返回的Skipped中记录跳过的块
+	返回压缩位置和有修改的统计
+ */
+func (service *BlockService) CompactTable(table *db.TableData, tallies []*db.TableTally, checkpoint db.CompactCheckpoint, size int32) (*db.CompactCheckpoint,[]*db.TableTally,error) {
+	if !table.Retention.IsEnabled() {
+		return nil,nil,fmt.Errorf("table `%s` retention is not set", table.Name)
+	}
+	if size <= 0 {
+		size = db.DefaultCompactBatchSize
+	}
+	if len(checkpoint.Ends) == 0 {
+		_,timestamp,err := service.storage.GetTxID(); if err != nil {
+			return nil,nil,err
+		}
+		checkpoint.Time = timestamp
+		checkpoint.Ends = make([]db.BlockID, len(tallies))
+		for i,tally := range tallies {
+			checkpoint.Ends[i] = tally.Block
+		}
+	}
+	if len(checkpoint.Ends) != len(tallies) {
+		return nil,nil,fmt.Errorf("table `%s` compact checkpoint shards error", table.Name)
+	}
+	lasts := make([]db.BlockID, len(tallies))
+	copy(lasts, checkpoint.Shards)
+	checkpoint.Shards = lasts
+	compactor := &compactor{table:table,tallies:tallies,checkpoint:&checkpoint,parse:new(index.PrimaryParse),
+		changed:map[int]bool{},blocks:map[db.BlockID]*row.BlockData{},purged:map[db.BlockID]bool{},visited:map[db.BlockID]bool{},skipped:map[db.BlockID]bool{}}
+	for _,id := range checkpoint.Skipped {
+		compactor.skipped[id] = true
+	}
+	checkpoint.Done = true
+	scanned := int32(0)
+	for i,tally := range tallies {
+		if start := shardStart(table, tally); lasts[i] < start {
+			lasts[i] = start
+		}
+		for lasts[i] < checkpoint.Ends[i] {
+			if scanned >= size {
+				checkpoint.Done = false
+				break
+			}
+			run,err := service.loadCompactRun(compactor, lasts[i]+1, checkpoint.Ends[i]); if err != nil {
+				return nil,nil,err
+			}
+			if err := service.compactRuns(compactor, run); err != nil {
+				return nil,nil,err
+			}
+			scanned += int32(run.end-lasts[i])
+			checkpoint.Blocks += db.BlockID(len(run.blocks))
+			lasts[i] = run.end
+		}
+	}
+	changed := make([]*db.TableTally, 0, len(compactor.changed))
+	for i,tally := range tallies {
+		if compactor.changed[i] {
+			changed = append(changed, tally)
+		}
+	}
+	return &checkpoint,changed,nil
+}
+
+func shardStart(table *db.TableData, tally *db.TableTally) db.BlockID {
+	if table.TallyShards > 1 {
+		return db.BlockID(tally.Shard)<<db.TallyShardBlockBits
+	}
+	return 0
+}
+
+//块所在统计下标
+func shardIndex(table *db.TableData, blockID db.BlockID) int {
+	if table.TallyShards > 1 {
+		return int(blockID>>db.TallyShardBlockBits)
+	}
+	return 0
+}
+
+func (service *BlockService) getBlockCompact(tableID db.TableID, blockID db.BlockID) (*db.BlockCompact,error) {
+	value,err := service.storage.GetBlockCompact(service.database.Id, tableID, blockID); if err != nil {
+		return nil,err
+	}
+	if len(value) == 0 {
+		return nil,nil
+	}
+	record := &db.BlockCompact{}
+	if err := json.Unmarshal(value, record); err != nil {
+		return nil,fmt.Errorf("block `%d` compact record convert error `%s`", blockID, err.Error())
+	}
+	return record,nil
+}
+
+func (service *BlockService) putBlockCompact(tableID db.TableID, blockID db.BlockID, record *db.BlockCompact) error {
+	value,err := util.ConvertJsonBytes(record); if err != nil {
+		return err
+	}
+	return service.storage.PutBlockCompact(service.database.Id, tableID, blockID, value)
+}
+
+/**
+	压缩一组块，基础版本被清除并且同样需要清除的版本所在的组在同一批中压缩
+ */
+func (service *BlockService) compactRuns(compactor *compactor, run *compactRun) error {
+	pending,err := service.compactRun(compactor, run); if err != nil {
+		return err
+	}
+	for len(pending) > 0 {
+		id := pending[0]
+		pending = pending[1:]
+		if compactor.visited[id] {
+			continue
+		}
+		compactor.visited[id] = true
+		start := id
+		for start-1 > shardStart(compactor.table, compactor.tallies[shardIndex(compactor.table, id)]) {
+			prev,err := service.loadCompactBlock(compactor, start-1); if err != nil {
+				return err
+			}
+			if prev == nil || prev.Join == row.BlockData_JOIN_NONE {
+				break
+			}
+			start--
+		}
+		versionRun,err := service.loadCompactRun(compactor, start, compactor.tallies[shardIndex(compactor.table, id)].Block); if err != nil {
+			return err
+		}
+		more,err := service.compactRun(compactor, versionRun); if err != nil {
+			return err
+		}
+		compactor.checkpoint.Blocks += db.BlockID(len(versionRun.blocks))
+		pending = append(pending, more...)
+	}
+	return nil
+}
+
+/**
+	读取从start开始的一组连接块，第一个块缺失(已压缩)时返回空组
+ */
+func (service *BlockService) loadCompactRun(compactor *compactor, start db.BlockID, end db.BlockID) (*compactRun,error) {
+	run := &compactRun{end:start-1}
+	for id:=start;id<=end;id++ {
+		run.end = id
+		block,err := service.loadCompactBlock(compactor, id); if err != nil {
+			return nil,err
+		}
+		if block == nil {
+			break
+		}
+		run.blocks = append(run.blocks, block)
+		if block.Join == row.BlockData_JOIN_NONE {
+			break
+		}
+	}
+	return run,nil
+}
+
+func (service *BlockService) loadCompactBlock(compactor *compactor, id db.BlockID) (*row.BlockData,error) {
+	if compactor.purged[id] {
+		return nil,nil
+	}
+	if block,ok := compactor.blocks[id]; ok {
+		return block,nil
+	}
+	block,err := service.loadRebuildBlock(compactor.table, id); if err != nil {
+		return nil,err
+	}
+	if block != nil {
+		if err := checkBlock(id, block, nil); err != nil {
+			return nil,err
+		}
+		compactor.blocks[id] = block
+	}
+	return block,nil
+}
+
+func (service *BlockService) compactBlockTime(compactor *compactor, id db.BlockID) (int64,error) {
+	block,err := service.loadCompactBlock(compactor, id); if err != nil {
+		return 0,err
+	}
+	if block == nil {
+		return 0,fmt.Errorf("block `%d` is not found", id)
+	}
+	return block.Time,nil
+}
+
+/**
+	行版本清除数量(从最早的版本开始)，最后一个版本保留，已删除行在清除时间之前删除时清除所有版本
+ */
+func (service *BlockService) purgeCount(compactor *compactor, versions []compactVersion) (int,error) {
+	retention := compactor.table.Retention
+	n := len(versions)
+	if n == 0 {
+		return 0,nil
+	}
+	if last := versions[n-1]; last.op == db.DELETE && retention.PurgeDeletedBefore > 0 {
+		t,err := service.compactBlockTime(compactor, last.block); if err != nil {
+			return 0,err
+		}
+		if t < retention.PurgeDeletedBefore {
+			return n,nil
+		}
+	}
+	if retention.KeepVersions <= 0 && retention.KeepSeconds <= 0 {
+		return 0,nil
+	}
+	p := 0
+	for ;p<n-1;p++ {
+		if retention.KeepVersions > 0 && p >= n-int(retention.KeepVersions) {
+			break
+		}
+		if retention.KeepSeconds > 0 {
+			t,err := service.compactBlockTime(compactor, versions[p].block); if err != nil {
+				return 0,err
+			}
+			if t >= compactor.checkpoint.Time-retention.KeepSeconds {
+				break
+			}
+		}
+	}
+	return p,nil
+}
+
+/**
+	组中的行版本，跳过行的后续部分和已移动到新块的版本
+ */
+func (service *BlockService) runVersions(compactor *compactor, run *compactRun) ([]compactVersion,error) {
+	var versions []compactVersion
+	for k,block := range run.blocks {
+		record,err := service.getBlockCompact(compactor.table.Id, block.Id); if err != nil {
+			return nil,err
+		}
+		rowIDs := make(map[db.RowID]bool, len(block.Rows))
+		for i,blockRow := range block.Rows {
+			rowID := db.RowID(blockRow.Id)
+			if i == 0 && k > 0 && rowID == run.blocks[k-1].Rows[len(run.blocks[k-1].Rows)-1].Id {
+				continue
+			}
+			if rowIDs[rowID] {
+				continue
+			}
+			rowIDs[rowID] = true
+			if record != nil {
+				if _,moved := record.Moved[rowID]; moved {
+					continue
+				}
+			}
+			versions = append(versions, compactVersion{rowID:rowID,block:block.Id,op:db.OpType(blockRow.Op)})
+		}
+	}
+	return versions,nil
+}
+
+/**
+	压缩一组块，组中有不在主键索引中的版本时跳过该组，组中的块记录在压缩位置的Skipped中
+	返回组外基础版本被清除并且同样需要清除的版本所在的块
+ */
+func (service *BlockService) compactRun(compactor *compactor, run *compactRun) ([]db.BlockID,error) {
+	if len(run.blocks) == 0 {
+		return nil,nil
+	}
+	table := compactor.table
+	versions,err := service.runVersions(compactor, run); if err != nil {
+		return nil,err
+	}
+	inRun := make(map[db.RowID]map[db.BlockID]bool, len(versions))
+	var rowIDs []db.RowID
+	for _,version := range versions {
+		if _,ok := inRun[version.rowID]; !ok {
+			inRun[version.rowID] = map[db.BlockID]bool{}
+			rowIDs = append(rowIDs, version.rowID)
+		}
+		inRun[version.rowID][version.block] = true
+	}
+	rowVersions := make(map[db.RowID][]compactVersion, len(rowIDs))
+	purged := map[db.RowID]map[db.BlockID]bool{}
+	var outside []*compactMove //组外移动的版本
+	var pending []db.BlockID
+	forced := map[db.RowID]db.BlockID{}
+	for _,rowID := range rowIDs {
+		values,err := service.indexService.GetPrimaryKeyVersions(service.database.Id, table, rowID); if err != nil {
+			return nil,err
+		}
+		list := make([]compactVersion, 0, len(values))
+		indexed := 0
+		for _,value := range values {
+			blockID,_ := compactor.parse.BlockID(value)
+			list = append(list, compactVersion{rowID:rowID,block:blockID,op:compactor.parse.GetBlockType(value)})
+			if inRun[rowID][blockID] {
+				indexed++
+			}
+		}
+		if indexed != len(inRun[rowID]) {//块中的版本不在索引中，无法确定版本顺序，跳过的块记录在压缩位置中
+			for _,block := range run.blocks {
+				if !compactor.skipped[block.Id] {
+					compactor.skipped[block.Id] = true
+					compactor.checkpoint.Skipped = append(compactor.checkpoint.Skipped, block.Id)
+				}
+			}
+			return nil,nil
+		}
+		rowVersions[rowID] = list
+		p,err := service.purgeCount(compactor, list); if err != nil {
+			return nil,err
+		}
+		for i:=0;i<p;i++ {
+			if inRun[rowID][list[i].block] {
+				if purged[rowID] == nil {
+					purged[rowID] = map[db.BlockID]bool{}
+				}
+				purged[rowID][list[i].block] = true
+			}
+		}
+		if purged[rowID] == nil {
+			continue
+		}
+		//修改版本的基础版本被清除，合并为完整行移动到新块，之前的版本全部清除时转换为新增
+		//基础版本被清除并且同样需要清除的版本无法再合并，在同一批中清除，第一个保留版本在本组中移动
+		broken := false
+		for i,version := range list {
+			if purged[rowID][version.block] {
+				broken = true
+				continue
+			}
+			if version.op != db.UPDATE {
+				broken = false
+			}
+			if i < p {
+				if broken {
+					pending = append(pending, version.block)
+				}
+				continue
+			}
+			if broken {
+				forced[rowID] = version.block
+				if !inRun[rowID][version.block] {
+					outside = append(outside, &compactMove{version:version,forced:true})
+				}
+			}
+			break
+		}
+	}
+	if len(purged) == 0 {
+		return nil,nil
+	}
+	var moves []*compactMove
+	for _,version := range versions {
+		if purged[version.rowID][version.block] {
+			continue
+		}
+		moves = append(moves, &compactMove{version:version,forced:forced[version.rowID] == version.block})
+	}
+	moves = append(moves, outside...)
+	for _,move := range moves {
+		if err := service.prepareCompactMove(compactor, move); err != nil {
+			return nil,err
+		}
+	}
+	if err := service.writeCompactMoves(compactor, moves); err != nil {
+		return nil,err
+	}
+	for _,version := range versions {
+		if purged[version.rowID][version.block] {
+			service.untallyVersion(compactor, version)
+			compactor.checkpoint.Versions++
+		}
+	}
+	if err := service.purgeRunBlocks(compactor, run, moves); err != nil {
+		return nil,err
+	}
+	movedTo := make(map[db.RowID]map[db.BlockID]*compactMove, len(moves))
+	for _,move := range moves {
+		if movedTo[move.version.rowID] == nil {
+			movedTo[move.version.rowID] = map[db.BlockID]*compactMove{}
+		}
+		movedTo[move.version.rowID][move.version.block] = move
+	}
+	for _,rowID := range rowIDs {
+		values := make([][]byte, 0, len(rowVersions[rowID]))
+		for _,version := range rowVersions[rowID] {
+			if purged[rowID][version.block] {
+				continue
+			}
+			if move,ok := movedTo[rowID][version.block]; ok {
+				values = append(values, compactor.parse.FormatBlockType(move.target, db.OpType(move.row.Op)))
+			}else{
+				values = append(values, compactor.parse.FormatBlockType(version.block, version.op))
+			}
+		}
+		if err := service.indexService.RewritePrimaryKeyIndex(service.database.Id, table, rowID, values); err != nil {
+			return nil,err
+		}
+	}
+	return pending,nil
+}
+
+/**
+	合并移动版本的完整行(写入新块前读取，原块删除后无法读取)
+ */
+func (service *BlockService) prepareCompactMove(compactor *compactor, move *compactMove) error {
+	version := move.version
+	source,err := service.loadCompactBlock(compactor, version.block); if err != nil {
+		return err
+	}
+	if source == nil {
+		return fmt.Errorf("block `%d` is not found", version.block)
+	}
+	move.source = source
+	move.row,err = service.getRowData(compactor.table.Id, version.block, version.rowID, nil); if err != nil {
+		return err
+	}
+	move.row.Base,move.row.Depth,move.row.Delta = 0,0,nil
+	if move.forced {
+		move.row.Op = uint32(db.ADD)
+	}
+	return nil
+}
+
+/**
+	按原块分组写入原块所在分片的新块(保留原块事务ID和时间)，新块记录版本原来所在的块
+	转换为新增的行补充外键索引，统计中修改版本转换为新增版本
+ */
+func (service *BlockService) writeCompactMoves(compactor *compactor, moves []*compactMove) error {
+	table := compactor.table
+	records := map[db.BlockID]*db.BlockCompact{}
+	for start:=0;start<len(moves); {
+		source := moves[start].source
+		end := start+1
+		for end < len(moves) && moves[end].source == source {
+			end++
+		}
+		group := moves[start:end]
+		rows := make([]*row.RowData, 0, len(group))
+		for _,move := range group {
+			rows = append(rows, move.row)
+		}
+		shard := shardIndex(table, source.Id)
+		blockIDs,err := service.putBlockData(table, compactor.tallies[shard], rows, source.TxId, source.Time); if err != nil {
+			return err
+		}
+		compactor.changed[shard] = true
+		sourceRecord,err := service.getBlockCompact(table.Id, source.Id); if err != nil {
+			return err
+		}
+		for i,move := range group {
+			move.target = blockIDs[i]
+			record,ok := records[move.target]
+			if !ok {
+				record = &db.BlockCompact{Origins:map[db.RowID]db.BlockID{}}
+				records[move.target] = record
+			}
+			origin := source.Id
+			if o,ok := sourceRecord.GetOrigin(move.version.rowID); ok {
+				origin = o
+			}
+			record.Origins[move.version.rowID] = origin
+			compactor.checkpoint.Moved++
+			if !move.forced {
+				continue
+			}
+			tally := compactor.tallies[shard]
+			tally.UpdateRow--
+			tally.AddRow++
+			if err := service.addCompactForeignKeys(table, move.row); err != nil {
+				return err
+			}
+		}
+		start = end
+	}
+	for blockID,record := range records {
+		if err := service.putBlockCompact(table.Id, blockID, record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (service *BlockService) addCompactForeignKeys(table *db.TableData, rowData *row.RowData) error {
+	for _,foreignKey := range table.ForeignKeys {
+		index := int(foreignKey.ColumnID)-1
+		if index >= len(rowData.Columns) || len(rowData.Columns[index].Data) == 0 {
+			continue
+		}
+		value := rowData.Columns[index].Data
+		exists,err := service.indexService.ContainsForeignKeyIndex(service.database.Id, table, foreignKey.ColumnID, rowData.Id, value); if err != nil {
+			return err
+		}
+		if exists {
+			continue
+		}
+		if err := service.indexService.PutForeignKeyIndex(service.database.Id, table, foreignKey.ColumnID, rowData.Id, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (service *BlockService) untallyVersion(compactor *compactor, version compactVersion) {
+	shard := shardIndex(compactor.table, version.block)
+	tally := compactor.tallies[shard]
+	switch version.op {
+	case db.ADD:
+		tally.AddRow--
+	case db.UPDATE:
+		tally.UpdateRow--
+	case db.DELETE:
+		tally.DelRow--
+	}
+	compactor.changed[shard] = true
+}
+
+/**
+	删除组中的块，保存删除块的校验和与保留版本移动到的新块
+	组外移动的版本原块保留，在压缩记录中标记已移动
+ */
+func (service *BlockService) purgeRunBlocks(compactor *compactor, run *compactRun, moves []*compactMove) error {
+	table := compactor.table
+	records := map[db.BlockID]*db.BlockCompact{}
+	getRecord := func(blockID db.BlockID) (*db.BlockCompact,error) {
+		if record,ok := records[blockID]; ok {
+			return record,nil
+		}
+		record,err := service.getBlockCompact(table.Id, blockID); if err != nil {
+			return nil,err
+		}
+		if record == nil {
+			record = &db.BlockCompact{}
+		}
+		if record.Moved == nil {
+			record.Moved = map[db.RowID]db.BlockID{}
+		}
+		records[blockID] = record
+		return record,nil
+	}
+	if hasBlobColumn(table) {//保留版本移动到新块时已增加引用
+		if err := service.blobService.UpdateBlobReferences(blobReferences(table, storedRows(run.blocks)), -1); err != nil {
+			return err
+		}
+	}
+	for _,block := range run.blocks {
+		record,err := getRecord(block.Id); if err != nil {
+			return err
+		}
+		record.Purged,record.Checksum,record.PrevHash,record.Origins = true,block.Checksum,block.PrevHash,nil
+		if err := service.storage.DelBlockData(service.database.Id, table.Id, block.Id); err != nil {
+			return err
+		}
+		compactor.purged[block.Id] = true
+		compactor.checkpoint.Purged++
+	}
+	for _,move := range moves {
+		record,err := getRecord(move.version.block); if err != nil {
+			return err
+		}
+		record.Moved[move.version.rowID] = move.target
+	}
+	for blockID,record := range records {
+		if err := service.putBlockCompact(table.Id, blockID, record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+package block
+
+import (
+	"fmt"
+	"github.com/database-fabric/db"
+	"github.com/database-fabric/protos/db/row"
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/snappy"
+)
+
+/**
+	压缩块格式：标记(0) + 压缩方式 + 压缩数据
+	protobuf编码的第一个字节为字段标签，不会为0，因此未压缩的块(包括旧数据)可以直接识别
+ */
+const (
+	compressMarker byte = 0
+	maxCompressScale int64 = 8 //按压缩率缩放的最大倍数
+	compressSampleSize = 1024*64 //估算压缩率的采样大小
+)
+
+func encodeBlock(compression int8, block *row.BlockData) ([]byte,error) {
+	value,err := proto.Marshal(block); if err != nil {
+		return nil,err
+	}
+	if compression != db.CompressionSnappy {
+		return value,nil
+	}
+	compressValue := make([]byte, 2, snappy.MaxEncodedLen(len(value))+2)
+	compressValue[0] = compressMarker
+	compressValue[1] = byte(compression)
+	compressValue = append(compressValue, snappy.Encode(nil, value)...)
+	if len(compressValue) >= len(value) {//压缩无收益，直接存储
+		return value,nil
+	}
+	return compressValue,nil
+}
+
+/**
+	块数据解码，兼容压缩和未压缩的块
+ */
+func DecodeBlock(value []byte) (*row.BlockData,error) {
+	if len(value) > 0 && value[0] == compressMarker {
+		if len(value) < 2 {
+			return nil,fmt.Errorf("compress block length error")
+		}
+		switch int8(value[1]) {
+		case db.CompressionSnappy:
+			var err error
+			value,err = snappy.Decode(nil, value[2:]); if err != nil {
+				return nil,err
+			}
+		default:
+			return nil,fmt.Errorf("compression `%d` not supported", value[1])
+		}
+	}
+	block := &row.BlockData{}
+	if err := proto.Unmarshal(value, block); err != nil {
+		return nil,err
+	}
+	return block,nil
+}
+
+/**
+	按行数据的压缩率估算装箱时的缩放倍数，未压缩的表倍数为1
+ */
+func compressScales(table *db.TableData, rows []*row.RowData) []int64 {
+	scales := make([]int64, len(rows))
+	for i,rowData := range rows {
+		scales[i] = 1
+		if table.Storage.Compression != db.CompressionNone {
+			scales[i] = compressScale(rowData)
+		}
+	}
+	return scales
+}
+
+func compressScale(rowData *row.RowData) int64 {
+	sample := make([]byte, 0, compressSampleSize)
+	for _,columnData := range rowData.Columns {
+		n := compressSampleSize - len(sample)
+		if n <= 0 {
+			break
+		}
+		if n > len(columnData.Data) {
+			n = len(columnData.Data)
+		}
+		sample = append(sample, columnData.Data[:n]...)
+	}
+	if len(sample) == 0 {
+		return 1
+	}
+	scale := int64(len(sample)) / int64(len(snappy.Encode(nil, sample)))
+	if scale < 1 {
+		return 1
+	}else if scale > maxCompressScale {
+		return maxCompressScale
+	}
+	return scale
+}
+
+/**
+	压缩后超出块容量时降低行压缩率，已为1时返回false(按原始大小装箱)
+ */
+func reduceScale(scales []int64, index int) bool {
+	if scales[index] <= 1 {
+		return false
+	}
+	scales[index] = scales[index]/2
+	return true
+}
@@ -0,0 +1,321 @@
+package block
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/database-fabric/db"
+	"github.com/database-fabric/db/util"
+	"github.com/database-fabric/protos/db/row"
+	"github.com/shopspring/decimal"
+	"math"
+	"sort"
+)
+
+/**
+	物化计数器：写入行时从行的旧版本所在分组减去、新版本所在分组加上，分组列修改时行从旧分组移动到新分组
+	一批写入中同一个分组的变化先合并，写入结束后每个分组只读写一次，数量为0的分组删除
+	每个分组只有一个Key，不按统计分片拆分，写入同一分组的并发事务读写同一个Key，提交校验时冲突
+ */
+type counterKey struct {
+	counter int
+	group int64
+}
+
+type counterDelta struct {
+	count db.Total
+	sum decimal.Decimal
+}
+
+type counterDeltas struct {
+	keys []counterKey //按首次出现的顺序写入
+	deltas map[counterKey]*counterDelta
+}
+
+func newCounterDeltas() *counterDeltas {
+	return &counterDeltas{deltas:map[counterKey]*counterDelta{}}
+}
+
+func (deltas *counterDeltas) add(key counterKey, count db.Total, sum decimal.Decimal) {
+	delta,ok := deltas.deltas[key]
+	if !ok {
+		delta = &counterDelta{sum:decimal.Zero}
+		deltas.deltas[key] = delta
+		deltas.keys = append(deltas.keys, key)
+	}
+	delta.count += count
+	delta.sum = delta.sum.Add(sum)
+}
+
+/**
+	计数器需要读取的列，主键列的值为行ID，不需要读取
+ */
+func counterColumns(table *db.TableData) []db.ColumnID {
+	var columns []db.ColumnID
+	contains := map[db.ColumnID]bool{}
+	for _,counter := range table.Counters {
+		for _,column := range []db.ColumnID{counter.Column, counter.GroupBy} {
+			if column > 0 && column != table.PrimaryKey.ColumnID && !contains[column] {
+				contains[column] = true
+				columns = append(columns, column)
+			}
+		}
+	}
+	return columns
+}
+
+func counterColumnData(table *db.TableData, rowData *row.RowData, columnID db.ColumnID) []byte {
+	if columnID == table.PrimaryKey.ColumnID {
+		return util.Int64ToBytes(rowData.Id)
+	}
+	index := int(columnID)-1
+	if index < len(rowData.Columns) {
+		return rowData.Columns[index].Data
+	}
+	if index < len(table.Columns) {
+		return table.Columns[index].Default
+	}
+	return nil
+}
+
+/**
+	行版本对计数器的贡献，sign为1时加上，为-1时减去，块ID为0时行不存在
+ */
+func (service *BlockService) counterRow(table *db.TableData, deltas *counterDeltas, blockID db.BlockID, rowID db.RowID, sign db.Total) error {
+	if blockID == 0 {
+		return nil
+	}
+	rowData := &row.RowData{Id:rowID}
+	if columns := counterColumns(table); len(columns) > 0 {
+		var err error
+		rowData,err = service.getRowData(table.Id, blockID, rowID, columns); if err != nil {
+			return err
+		}
+	}
+	for i,counter := range table.Counters {
+		key := counterKey{counter:i}
+		if counter.GroupBy > 0 {
+			key.group = util.BytesToInt64(counterColumnData(table, rowData, counter.GroupBy))
+		}
+		sum := decimal.Zero
+		if counter.Column > 0 {
+			if data := counterColumnData(table, rowData, counter.Column); len(data) > 0 {
+				if table.Columns[counter.Column-1].Type == db.INT {
+					sum = decimal.NewFromInt(util.BytesToInt64(data))
+				}else if value,err := util.StringToDecimal(string(data)); err != nil {
+					return fmt.Errorf("counter `%s` row `%d` value error %s", counter.Name, rowID, err)
+				}else{
+					sum = value
+				}
+			}
+		}
+		if sign < 0 {
+			sum = sum.Neg()
+		}
+		deltas.add(key, sign, sum)
+	}
+	return nil
+}
+
+func (service *BlockService) putCounters(table *db.TableData, deltas *counterDeltas) error {
+	for _,key := range deltas.keys {
+		delta := deltas.deltas[key]
+		if delta.count == 0 && delta.sum.IsZero() {
+			continue
+		}
+		value,err := service.getCounter(table, key); if err != nil {
+			return err
+		}
+		value.Count += delta.count
+		if value.Count < 0 {
+			return fmt.Errorf("counter `%s` group `%d` count is negative", table.Counters[key.counter].Name, key.group)
+		}
+		if value.Count == 0 {
+			if err := service.storage.DelCounter(service.database.Id, table.Id, key.counter, key.group); err != nil {
+				return err
+			}
+			continue
+		}
+		if table.Counters[key.counter].Column > 0 {
+			sum,err := util.StringToDecimal(value.Sum); if err != nil {
+				return err
+			}
+			value.Sum = util.DecimalToString(sum.Add(delta.sum))
+		}
+		bytes,err := util.ConvertJsonBytes(*value); if err != nil {
+			return err
+		}
+		if err := service.storage.PutCounter(service.database.Id, table.Id, key.counter, key.group, bytes); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (service *BlockService) getCounter(table *db.TableData, key counterKey) (*db.CounterValue,error) {
+	bytes,err := service.storage.GetCounter(service.database.Id, table.Id, key.counter, key.group); if err != nil {
+		return nil,err
+	}
+	return service.parseCounter(table, key, bytes)
+}
+
+func (service *BlockService) parseCounter(table *db.TableData, key counterKey, bytes []byte) (*db.CounterValue,error) {
+	value := &db.CounterValue{Group:key.group}
+	if len(bytes) > 0 {
+		if err := json.Unmarshal(bytes, value); err != nil {
+			return nil,err
+		}
+	}
+	if table.Counters[key.counter].Column > 0 && value.Sum == "" {
+		value.Sum = "0"
+	}
+	return value,nil
+}
+
+func findCounter(table *db.TableData, name string) (int,error) {
+	for i,counter := range table.Counters {
+		if counter.Name == name {
+			return i,nil
+		}
+	}
+	return 0,fmt.Errorf("counter `%s` not found in table `%s`", name, table.Name)
+}
+
+/**
+	计数器一个分组的值，分组不存在时数量为0
+ */
+func (service *BlockService) QueryCounter(table *db.TableData, name string, group int64) (*db.CounterValue,error) {
+	counter,err := findCounter(table, name); if err != nil {
+		return nil,err
+	}
+	return service.getCounter(table, counterKey{counter:counter,group:group})
+}
+
+/**
+	按分组值升序返回区间[start,end]中数量不为0的分组，最多size个，start大于end时交换
+	只读取已提交的分组，本事务中新增的分组不可见
+ */
+func (service *BlockService) QueryCounterByRange(table *db.TableData, name string, start int64, end int64, size int32) ([]*db.CounterValue,error) {
+	counter,err := findCounter(table, name); if err != nil {
+		return nil,err
+	}
+	if size <= 0 {
+		return nil,fmt.Errorf("counter range size must be greater than 0")
+	}
+	if start > end {
+		start,end = end,start
+	}
+	values := make([]*db.CounterValue, 0)
+	err = service.storage.GetCounterByRange(service.database.Id, table.Id, counter, start, end, func(group int64, bytes []byte) (bool,error) {
+		if len(bytes) == 0 {
+			return true,nil
+		}
+		value,err := service.parseCounter(table, counterKey{counter:counter,group:group}, bytes); if err != nil {
+			return false,err
+		}
+		values = append(values, value)
+		return int32(len(values)) < size,nil
+	})
+	return values,err
+}
+
+/**
+	按主键索引中未删除行的最新版本重新计算计数器，与存储的分组值比较
+	存储中多出的分组只能按区间读取已提交的Key发现，不一致的分组加入检查结果用于修复
+ */
+func (service *BlockService) checkCounters(checker *tableChecker) error {
+	table := checker.table
+	deltas := newCounterDeltas()
+	rowIDs := make([]db.RowID, 0, len(checker.rowOps))
+	for rowID,op := range checker.rowOps {
+		if op != db.DELETE {
+			rowIDs = append(rowIDs, rowID)
+		}
+	}
+	sort.Slice(rowIDs, func(i, j int) bool { return rowIDs[i] < rowIDs[j] })
+	for _,rowID := range rowIDs {
+		blockID := checker.rowBlocks[rowID]
+		if err := service.counterRow(table, deltas, blockID, rowID, 1); err != nil {
+			checker.addIssue(db.CheckIssue{Kind:db.CheckKindCounter,Row:rowID,Block:blockID}, "counter row `%d` error %s", rowID, err)
+		}
+	}
+	addCounter := func(key counterKey, expect *db.CounterValue, format string, args ...interface{}) {
+		checker.addIssue(db.CheckIssue{Kind:db.CheckKindCounter,Repair:db.RepairCounter}, format, args...)
+		checker.check.Counters = append(checker.check.Counters, db.CounterGroup{Counter:table.Counters[key.counter].Name,CounterValue:*expect})
+	}
+	for i,counter := range table.Counters {
+		expected := map[int64]bool{}
+		for _,key := range deltas.keys {
+			delta := deltas.deltas[key]
+			if key.counter != i || delta.count == 0 {
+				continue
+			}
+			expected[key.group] = true
+			expect := &db.CounterValue{Group:key.group,Count:delta.count}
+			if counter.Column > 0 {
+				expect.Sum = util.DecimalToString(delta.sum)
+			}
+			value,err := service.getCounter(table, key); if err != nil {
+				return err
+			}
+			if value.Count != expect.Count || !equalDecimalString(value.Sum, expect.Sum) {
+				addCounter(key, expect, "counter `%s` group `%d` count `%d` sum `%s` error, expected count `%d` sum `%s`",
+					counter.Name, key.group, value.Count, value.Sum, expect.Count, expect.Sum)
+			}
+		}
+		err := service.storage.GetCounterByRange(service.database.Id, table.Id, i, math.MinInt64, math.MaxInt64, func(group int64, bytes []byte) (bool,error) {
+			if len(bytes) == 0 || expected[group] {
+				return true,nil
+			}
+			key := counterKey{counter:i,group:group}
+			value,err := service.parseCounter(table, key, bytes); if err != nil {
+				return false,err
+			}
+			expect := &db.CounterValue{Group:group}
+			if counter.Column > 0 {
+				expect.Sum = "0"
+			}
+			addCounter(key, expect, "counter `%s` group `%d` count `%d` has no rows", counter.Name, group, value.Count)
+			return true,nil
+		}); if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func equalDecimalString(value1 string, value2 string) bool {
+	if value1 == value2 {
+		return true
+	}
+	decimal1,err := util.StringToDecimal(value1); if err != nil {
+		return false
+	}
+	decimal2,err := util.StringToDecimal(value2); if err != nil {
+		return false
+	}
+	return decimal1.Equal(decimal2)
+}
+
+/**
+	使用检查重新计算的分组值修复计数器，数量为0的分组删除
+ */
+func (service *BlockService) RepairCounters(table *db.TableData, groups []db.CounterGroup) error {
+	for _,group := range groups {
+		counter,err := findCounter(table, group.Counter); if err != nil {
+			return err
+		}
+		if group.Count == 0 {
+			if err := service.storage.DelCounter(service.database.Id, table.Id, counter, group.Group); err != nil {
+				return err
+			}
+			continue
+		}
+		bytes,err := util.ConvertJsonBytes(group.CounterValue); if err != nil {
+			return err
+		}
+		if err := service.storage.PutCounter(service.database.Id, table.Id, counter, group.Group, bytes); err != nil {
+			return err
+		}
+	}
+	return nil
+}
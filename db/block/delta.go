@@ -0,0 +1,99 @@
+package block
+
+import (
+	"fmt"
+	"github.com/database-fabric/db"
+	"github.com/database-fabric/db/proof"
+	"github.com/database-fabric/protos/db/row"
+)
+
+/**
+	增量行只保存修改的列(Delta为列下标)和基础版本块ID(Base)，读取时沿基础版本合并到最近的完整行
+	写入增量行时层数达到MaxDeltaDepth则合并为完整行写入，保证读取时合并层数有上限
+ */
+func (service *BlockService) prepareDeltaRow(table *db.TableData, rowData *row.RowData) error {
+	if rowData.Base <= 0 {
+		return nil
+	}
+	if len(rowData.Delta) != len(rowData.Columns) {
+		return fmt.Errorf("row `%d` delta columns length error", rowData.Id)
+	}
+	block,err := service.getBlockData(table.Id, db.BlockID(rowData.Base)); if err != nil {
+		return err
+	}
+	var baseRow *row.RowData
+	for _,blockRow := range block.Rows {
+		if blockRow.Id == rowData.Id {
+			baseRow = blockRow
+			break
+		}
+	}
+	if baseRow == nil {
+		return fmt.Errorf("row `%d` is not found in base block `%d`", rowData.Id, rowData.Base)
+	}
+	rowData.Depth = 1
+	if baseRow.Base > 0 {
+		rowData.Depth = baseRow.Depth+1
+	}
+	if rowData.Depth < db.MaxDeltaDepth {
+		return nil
+	}
+	//写入完整行
+	fullRow,err := service.getRowData(table.Id, db.BlockID(rowData.Base), rowData.Id, nil); if err != nil {
+		return err
+	}
+	proof.ApplyDelta(fullRow, rowData)
+	rowData.Columns = fullRow.Columns
+	rowData.Base,rowData.Depth,rowData.Delta = 0,0,nil
+	return nil
+}
+
+/**
+	合并增量行，columns为投影列
+ */
+func (service *BlockService) mergeDeltaRow(tableID db.TableID, rowData *row.RowData, columns []db.ColumnID) (*row.RowData,error) {
+	var deltas []*row.RowData
+	for rowData.Base > 0 {
+		if len(deltas) >= db.MaxDeltaDepth {
+			return nil,fmt.Errorf("row `%d` delta depth exceeds %d", rowData.Id, db.MaxDeltaDepth)
+		}
+		deltas = append(deltas, rowData)
+		base,err := service.resolveBase(tableID, rowData.Id, db.BlockID(rowData.Base)); if err != nil {
+			return nil,err
+		}
+		baseRow := service.initRowData(rowData.Id)
+		if err := service.joinBlockRowData(tableID, base, baseRow, nil, newProjection(columns)); err != nil {
+			return nil,err
+		}
+		rowData = baseRow
+	}
+	for i:=len(deltas)-1;i>=0;i-- {
+		proof.ApplyDelta(rowData, deltas[i])
+	}
+	if len(deltas) > 0 {//合并后的行使用最新版本的操作类型
+		rowData.Op = deltas[0].Op
+	}
+	return rowData,nil
+}
+
+/**
+	基础版本被压缩移动到新块(原块删除或保留)时，沿压缩记录找到行版本所在的块
+ */
+func (service *BlockService) resolveBase(tableID db.TableID, rowID db.RowID, blockID db.BlockID) (db.BlockID,error) {
+	for {
+		record,err := service.getBlockCompact(tableID, blockID); if err != nil {
+			return 0,err
+		}
+		if record == nil {
+			return blockID,nil
+		}
+		if moved,ok := record.Moved[rowID]; ok {
+			blockID = moved
+			continue
+		}
+		if record.Purged {
+			return 0,fmt.Errorf("row `%d` base block `%d` is purged", rowID, blockID)
+		}
+		return blockID,nil
+	}
+}
@@ -0,0 +1,161 @@
+package block
+
+import (
+	"fmt"
+	"github.com/database-fabric/db"
+	"github.com/database-fabric/db/proof"
+	"github.com/database-fabric/protos/db/row"
+	"github.com/golang/protobuf/proto"
+)
+
+/**
+	新写入的块追加到统计分片的块哈希累加器，写入新增节点并更新累加器根(需要在更新tally.Block前调用)
+	累加器为空时从当前块开始，之前的旧块不在累加器中
+ */
+func (service *BlockService) appendMerkle(tally *db.TableTally, checksums [][]byte) error {
+	if len(checksums) == 0 {
+		return nil
+	}
+	if len(tally.MerklePeaks) == 0 {
+		tally.MerkleStart = tally.Block
+	}
+	accumulator := &proof.Accumulator{LeafCount:uint64(tally.Block-tally.MerkleStart),Peaks:tally.MerklePeaks}
+	for _,checksum := range checksums {
+		for _,node := range accumulator.Append(checksum) {
+			if err := service.storage.PutMerkleNode(service.database.Id, tally.TableID, tally.Shard, node.Height, node.Index, node.Hash); err != nil {
+				return err
+			}
+		}
+	}
+	tally.MerklePeaks = accumulator.Peaks
+	tally.MerkleRoot = accumulator.Root()
+	return nil
+}
+
+/**
+	查询行版本所在的块ID，version从1开始，0为最新版本
+ */
+func (service *BlockService) QueryRowVersionBlockID(table *db.TableData, rowID db.RowID, version int64) (db.BlockID,error) {
+	if version < 0 {
+		return 0,fmt.Errorf("row version `%d` error", version)
+	}
+	order,size := db.ASC,int32(version)
+	if version == 0 {
+		order,size = db.DESC,1
+	}
+	blocks,_,err := service.indexService.GetPrimaryKeyIndexHistoryByRange(service.database.Id, table, rowID, order, size); if err != nil {
+		return 0,err
+	}
+	if len(blocks) < int(size) || blocks[len(blocks)-1] == 0 {
+		return 0,fmt.Errorf("row `%d` version `%d` is not found", rowID, version)
+	}
+	return blocks[len(blocks)-1],nil
+}
+
+/**
+	生成行版本证明，tallies为表的全部统计分片
+	增量行沿基础版本(压缩移动后的块)逐层生成基础版本证明，每层的Row为合并后的完整行
+ */
+func (service *BlockService) QueryRowProof(table *db.TableData, tallies []*db.TableTally, rowID db.RowID, version int64, blockID db.BlockID) (*proof.RowProof,error) {
+	rowProof,rowData,err := service.queryBlockProof(table, tallies, rowID, blockID); if err != nil {
+		return nil,err
+	}
+	rowProof.Version = version
+	proofs,rows := []*proof.RowProof{rowProof},[]*row.RowData{rowData}
+	for rowData.Base > 0 {
+		if len(proofs) > db.MaxDeltaDepth {
+			return nil,fmt.Errorf("row `%d` delta depth exceeds %d", rowID, db.MaxDeltaDepth)
+		}
+		base,err := service.resolveBase(table.Id, rowID, db.BlockID(rowData.Base)); if err != nil {
+			return nil,err
+		}
+		baseProof,baseRow,err := service.queryBlockProof(table, tallies, rowID, base); if err != nil {
+			return nil,err
+		}
+		if base != db.BlockID(rowData.Base) {
+			baseProof.Origin = rowData.Base
+		}
+		proofs[len(proofs)-1].Base = baseProof
+		proofs,rows = append(proofs, baseProof),append(rows, baseRow)
+		rowData = baseRow
+	}
+	for i:=len(proofs)-1;i>=0;i-- {
+		if i < len(proofs)-1 {
+			rowData = proof.MergeDeltaRow(rowData, rows[i])
+		}
+		proofs[i].Row,err = proto.Marshal(rowData); if err != nil {
+			return nil,err
+		}
+	}
+	return rowProof,nil
+}
+
+/**
+	生成行在块中的证明，返回拼接后的行(增量行未合并)
+ */
+func (service *BlockService) queryBlockProof(table *db.TableData, tallies []*db.TableTally, rowID db.RowID, blockID db.BlockID) (*proof.RowProof,*row.RowData,error) {
+	index := shardIndex(table, blockID)
+	if index >= len(tallies) {
+		return nil,nil,fmt.Errorf("block `%d` tally is not found", blockID)
+	}
+	tally := tallies[index]
+	if len(tally.MerklePeaks) == 0 {
+		return nil,nil,fmt.Errorf("table `%s` merkle accumulator is null", table.Name)
+	}
+	leafCount := uint64(tally.Block-tally.MerkleStart)
+	rowProof := &proof.RowProof{TableID:table.Id,RowID:rowID,Shard:tally.Shard,LeafCount:leafCount,Peaks:tally.MerklePeaks,Root:tally.MerkleRoot}
+	var blocks []*row.BlockData
+	var prevHash []byte
+	for id := blockID;;id++ {
+		if id <= tally.MerkleStart || id > tally.Block {
+			return nil,nil,fmt.Errorf("block `%d` is not in merkle accumulator", id)
+		}
+		block,err := service.verifyBlockData(table.Id, id, prevHash); if err != nil {
+			return nil,nil,err
+		}
+		blockProof,err := service.getBlockProof(tally, block, leafCount); if err != nil {
+			return nil,nil,err
+		}
+		rowProof.Blocks = append(rowProof.Blocks, *blockProof)
+		blocks = append(blocks, block)
+		prevHash = block.Checksum
+		index := -1
+		for i,blockRow := range block.Rows {
+			if blockRow.Id == rowID {
+				index = i
+				break
+			}
+		}
+		if index < 0 {
+			return nil,nil,fmt.Errorf("row `%d` is not found in block `%d`", rowID, id)
+		}
+		if index != len(block.Rows)-1 || block.Join == row.BlockData_JOIN_NONE {
+			break
+		}
+	}
+	rowData,err := proof.JoinRowData(blocks, rowID); if err != nil {
+		return nil,nil,err
+	}
+	return rowProof,rowData,nil
+}
+
+func (service *BlockService) getBlockProof(tally *db.TableTally, block *row.BlockData, leafCount uint64) (*proof.BlockProof,error) {
+	leafIndex := uint64(db.BlockID(block.Id)-tally.MerkleStart-1)
+	positions,_,err := proof.ProofPositions(leafIndex, leafCount); if err != nil {
+		return nil,err
+	}
+	blockBytes,err := proto.Marshal(block); if err != nil {
+		return nil,err
+	}
+	blockProof := &proof.BlockProof{BlockID:block.Id,Block:blockBytes,LeafIndex:leafIndex,Siblings:make([][]byte, 0, len(positions))}
+	for _,position := range positions {
+		hash,err := service.storage.GetMerkleNode(service.database.Id, tally.TableID, tally.Shard, position.Height, position.Index); if err != nil {
+			return nil,err
+		}
+		if len(hash) == 0 {
+			return nil,fmt.Errorf("merkle node `%d-%d` is not found", position.Height, position.Index)
+		}
+		blockProof.Siblings = append(blockProof.Siblings, hash)
+	}
+	return blockProof,nil
+}
@@ -0,0 +1,313 @@
+package block
+
+import (
+	"fmt"
+	"github.com/database-fabric/db"
+	"github.com/database-fabric/db/index"
+	"github.com/database-fabric/protos/db/row"
+)
+
+//重建时统计分片的重放位置
+type rebuildShard struct {
+	tally *db.TableTally
+	last db.BlockID //已重放的最后一个块ID
+	prev *row.BlockData //已重放的最后一个块，块缺失时为空
+	next *row.BlockData //下一个待重放的块，为空时分片已重放完成
+}
+
+/**
+	按块数据重建列索引(主键或外键列)，分批在多个事务中执行，每批最多处理size个树节点或块：
+	0、分片表重建主键索引时先检查行版本顺序，块时间由客户端交易时间决定(精度为秒)，
+	   同一行的版本在不同分片且块时间相同时分片之间的顺序无法恢复，拒绝重建(旧索引保留)
+	1、清除旧索引树节点、关键字链表和树头(主键索引分片时逐个分片清除)，清除完成后返回，在新的事务中重放
+	2、分片内按块ID顺序重放块，分片之间按块时间合并(保证行版本顺序与写入顺序一致)
+	   主键索引记录行每个版本第一部分所在块和操作类型，外键索引记录新增、修改行的外键值(每行每个值只记录一次，清除旧版本写入的重复索引)
+	重建期间表不能写入，未完成时使用返回的checkpoint在新的事务中继续
+ */
+func (service *BlockService) RebuildIndex(table *db.TableData, column db.ColumnID, tallies []*db.TableTally, checkpoint db.RebuildCheckpoint, size int32) (*db.RebuildCheckpoint,error) {
+	primary := column == table.PrimaryKey.ColumnID
+	if !primary && !isForeignKeyColumn(table, column) {
+		return nil,fmt.Errorf("table `%s` column `%d` has no index", table.Name, column)
+	}
+	if size <= 0 {
+		size = db.DefaultRebuildBatchSize
+	}
+	if primary && table.TallyShards > 1 && !checkpoint.Checked {
+		return service.checkRebuildOrder(table, tallies, checkpoint, size)
+	}
+	if !checkpoint.Discarded {
+		columnKey := db.ColumnKey{Database:service.database.Id,Table:table.Id,Column:column}
+		if primary {
+			columnKey.Shard = checkpoint.Lane
+		}
+		if _,err := service.indexService.DiscardIndex(columnKey, &checkpoint, size); err != nil {
+			return nil,err
+		}
+		if checkpoint.Discarded && primary && checkpoint.Lane+1 < table.PrimaryShards {//继续清除下一个主键索引分片
+			checkpoint.Lane++
+			checkpoint.Node,checkpoint.Discarded = 0,false
+		}
+		return &checkpoint,nil
+	}
+	shards := make([]*rebuildShard, len(tallies))
+	lasts := make([]db.BlockID, len(tallies))
+	copy(lasts, checkpoint.Shards)
+	checkpoint.Shards = lasts
+	for i,tally := range tallies {
+		shard,err := service.newRebuildShard(table, tally, lasts[i]); if err != nil {
+			return nil,err
+		}
+		shards[i] = shard
+	}
+	for num:=int32(0);num<size;num++ {
+		var current *rebuildShard
+		index := 0
+		for i,shard := range shards {
+			if shard.next != nil && (current == nil || shard.next.Time < current.next.Time) {
+				current,index = shard,i
+			}
+		}
+		if current == nil {
+			checkpoint.Done = true
+			break
+		}
+		block := current.next
+		if err := service.replayBlock(table, column, primary, block, current.prev); err != nil {
+			return nil,err
+		}
+		checkpoint.Blocks++
+		lasts[index] = block.Id
+		current.last,current.prev = block.Id,block
+		if err := service.nextRebuildBlock(table, current); err != nil {
+			return nil,err
+		}
+	}
+	return &checkpoint,nil
+}
+
+/**
+	按块时间分组扫描各分片的块，同一时间的块中行版本(压缩移动的版本按原块)出现在多个分片时返回错误
+	一组块在同一批中检查，使用checkpoint.Shards记录扫描位置，检查完成后清空
+ */
+func (service *BlockService) checkRebuildOrder(table *db.TableData, tallies []*db.TableTally, checkpoint db.RebuildCheckpoint, size int32) (*db.RebuildCheckpoint,error) {
+	shards := make([]*rebuildShard, len(tallies))
+	lasts := make([]db.BlockID, len(tallies))
+	copy(lasts, checkpoint.Shards)
+	checkpoint.Shards = lasts
+	for i,tally := range tallies {
+		shard,err := service.newRebuildShard(table, tally, lasts[i]); if err != nil {
+			return nil,err
+		}
+		shards[i] = shard
+	}
+	for num:=int32(0);num<size; {
+		var current *rebuildShard
+		for _,shard := range shards {
+			if shard.next != nil && (current == nil || shard.next.Time < current.next.Time) {
+				current = shard
+			}
+		}
+		if current == nil {
+			checkpoint.Checked,checkpoint.Shards = true,nil
+			break
+		}
+		t := current.next.Time
+		rowShards := make(map[db.RowID]int)
+		for i,shard := range shards {
+			for shard.next != nil && shard.next.Time == t {
+				block := shard.next
+				record,err := service.getBlockCompact(table.Id, block.Id); if err != nil {
+					return nil,err
+				}
+				for _,blockRow := range block.Rows {
+					rowID := db.RowID(blockRow.Id)
+					if record != nil {
+						if _,moved := record.Moved[rowID]; moved {
+							continue
+						}
+					}
+					rowShard := i
+					if origin,ok := record.GetOrigin(rowID); ok {
+						rowShard = shardIndex(table, origin)
+					}
+					if prev,ok := rowShards[rowID]; ok && prev != rowShard {
+						return nil,fmt.Errorf("row `%d` has versions in shard `%d` and `%d` at the same time `%d`, primary index can not be rebuilt", rowID, prev, rowShard, t)
+					}
+					rowShards[rowID] = rowShard
+				}
+				num++
+				lasts[i] = block.Id
+				shard.last,shard.prev = block.Id,block
+				if err := service.nextRebuildBlock(table, shard); err != nil {
+					return nil,err
+				}
+			}
+		}
+	}
+	return &checkpoint,nil
+}
+
+func isForeignKeyColumn(table *db.TableData, column db.ColumnID) bool {
+	for _,foreignKey := range table.ForeignKeys {
+		if foreignKey.ColumnID == column {
+			return true
+		}
+	}
+	return false
+}
+
+func (service *BlockService) newRebuildShard(table *db.TableData, tally *db.TableTally, last db.BlockID) (*rebuildShard,error) {
+	start := db.BlockID(0)
+	if table.TallyShards > 1 {
+		start = db.BlockID(tally.Shard)<<db.TallyShardBlockBits
+	}
+	shard := &rebuildShard{tally:tally,last:start}
+	if last > start {//继续上一批，读取上一个块判断行是否跨块
+		var err error
+		shard.last = last
+		shard.prev,err = service.loadRebuildBlock(table, last); if err != nil {
+			return nil,err
+		}
+	}
+	return shard,service.nextRebuildBlock(table, shard)
+}
+
+/**
+	读取分片下一个块，统计记录范围内缺失的块跳过，超出统计范围时分片重放完成
+ */
+func (service *BlockService) nextRebuildBlock(table *db.TableData, shard *rebuildShard) error {
+	shard.next = nil
+	for id:=shard.last+1;;id++ {
+		block,err := service.loadRebuildBlock(table, id); if err != nil {
+			return err
+		}
+		if block != nil {
+			shard.next = block
+			return nil
+		}
+		if id > shard.tally.Block {
+			return nil
+		}
+		shard.last,shard.prev = id,nil
+	}
+}
+
+func (service *BlockService) loadRebuildBlock(table *db.TableData, id db.BlockID) (*row.BlockData,error) {
+	value,err := service.storage.GetBlockData(service.database.Id, table.Id, id); if err != nil {
+		return nil,err
+	}
+	if len(value) == 0 {
+		return nil,nil
+	}
+	block,err := DecodeBlock(value); if err != nil {
+		return nil,fmt.Errorf("block `%d` convert error `%s`", id, err.Error())
+	}
+	block.Id = id
+	return block,nil
+}
+
+/**
+	重放块中的行版本，跳过上一个块中行的后续部分，同一块中的行只记录一次
+	压缩时已移动到新块的版本跳过，移动到该块的版本按原来的位置插入
+ */
+func (service *BlockService) replayBlock(table *db.TableData, column db.ColumnID, primary bool, block *row.BlockData, prev *row.BlockData) error {
+	record,err := service.getBlockCompact(table.Id, block.Id); if err != nil {
+		return err
+	}
+	rowIDs := make(map[db.RowID]bool, len(block.Rows))
+	for i,blockRow := range block.Rows {
+		rowID := db.RowID(blockRow.Id)
+		if i == 0 && prev != nil && prev.Join != row.BlockData_JOIN_NONE && rowID == prev.Rows[len(prev.Rows)-1].Id {
+			continue
+		}
+		if rowIDs[rowID] {
+			continue
+		}
+		rowIDs[rowID] = true
+		if record != nil {
+			if _,moved := record.Moved[rowID]; moved {//压缩时已移动到新块
+				continue
+			}
+		}
+		if primary {
+			if origin,ok := record.GetOrigin(rowID); ok {
+				if err := service.replayMovedVersion(table, rowID, db.OpType(blockRow.Op), block, origin); err != nil {
+					return err
+				}
+				continue
+			}
+			if err := service.indexService.PutPrimaryKeyIndex(service.database.Id, table, rowID, uint8(blockRow.Op), block.Id); err != nil {
+				return err
+			}
+			continue
+		}
+		if uint8(blockRow.Op) == db.DELETE {
+			continue
+		}
+		var value []byte
+		if i == len(block.Rows)-1 && block.Join != row.BlockData_JOIN_NONE {//行在后续块中继续，读取完整的列值
+			rowData,err := service.getRowData(table.Id, block.Id, rowID, []db.ColumnID{column}); if err != nil {
+				return err
+			}
+			value = index.ForeignKeyValue(rowData, column)
+		}else{
+			value = index.ForeignKeyValue(blockRow, column)
+		}
+		if len(value) == 0 {
+			continue
+		}
+		exists,err := service.indexService.ContainsForeignKeyIndex(service.database.Id, table, column, rowID, value); if err != nil {//重建的索引中外键值不重复记录
+			return err
+		}
+		if exists {
+			continue
+		}
+		if err := service.indexService.PutForeignKeyIndex(service.database.Id, table, column, rowID, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/**
+	压缩移动的版本写入块的时间和原块ID不在块顺序中，按(块时间，原块ID)插入到行版本中
+ */
+func (service *BlockService) replayMovedVersion(table *db.TableData, rowID db.RowID, op db.OpType, block *row.BlockData, origin db.BlockID) error {
+	values,err := service.indexService.GetPrimaryKeyVersions(service.database.Id, table, rowID); if err != nil {
+		return err
+	}
+	parse := new(index.PrimaryParse)
+	position := len(values)
+	for ;position > 0;position-- {
+		blockID,_ := parse.BlockID(values[position-1])
+		t,o,err := service.versionOrder(table, rowID, blockID); if err != nil {
+			return err
+		}
+		if t < block.Time || (t == block.Time && o < origin) {
+			break
+		}
+	}
+	values = append(values, nil)
+	copy(values[position+1:], values[position:])
+	values[position] = parse.FormatBlockType(block.Id, op)
+	return service.indexService.RewritePrimaryKeyIndex(service.database.Id, table, rowID, values)
+}
+
+/**
+	行版本的块时间和原块ID(压缩移动的版本为移动前所在的块)
+ */
+func (service *BlockService) versionOrder(table *db.TableData, rowID db.RowID, blockID db.BlockID) (int64,db.BlockID,error) {
+	block,err := service.loadRebuildBlock(table, blockID); if err != nil {
+		return 0,0,err
+	}
+	if block == nil {
+		return 0,0,fmt.Errorf("block `%d` is not found", blockID)
+	}
+	record,err := service.getBlockCompact(table.Id, blockID); if err != nil {
+		return 0,0,err
+	}
+	if origin,ok := record.GetOrigin(rowID); ok {
+		return block.Time,origin,nil
+	}
+	return block.Time,blockID,nil
+}
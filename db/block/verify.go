@@ -0,0 +1,120 @@
+package block
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/database-fabric/db"
+	"github.com/database-fabric/db/proof"
+	"github.com/database-fabric/protos/db/row"
+)
+
+/**
+	块校验和为Checksum为空时块protobuf编码的sha256，PrevHash为同一个表(统计分片)上一个块的校验和
+	旧块没有校验和，读取时只校验块ID
+ */
+type blockKey struct {
+	table db.TableID
+	block db.BlockID
+}
+
+func sealBlock(block *row.BlockData, prevHash []byte) error {
+	block.PrevHash = prevHash
+	checksum,err := proof.BlockChecksum(block); if err != nil {
+		return err
+	}
+	block.Checksum = checksum
+	return nil
+}
+
+/**
+	校验块ID和校验和，prevHash不为空时校验与上一个块的连接
+ */
+func checkBlock(blockID db.BlockID, block *row.BlockData, prevHash []byte) error {
+	if db.BlockID(block.Id) != blockID {
+		return fmt.Errorf("block `%d` id `%d` error", blockID, block.Id)
+	}
+	if len(block.Checksum) == 0 {
+		if len(prevHash) > 0 {
+			return fmt.Errorf("block `%d` checksum is null", blockID)
+		}
+		return nil
+	}
+	checksum,err := proof.BlockChecksum(block); if err != nil {
+		return err
+	}
+	if !bytes.Equal(checksum, block.Checksum) {
+		return fmt.Errorf("block `%d` checksum error", blockID)
+	}
+	if len(prevHash) > 0 && !bytes.Equal(prevHash, block.PrevHash) {
+		return fmt.Errorf("block `%d` prev hash error", blockID)
+	}
+	return nil
+}
+
+/**
+	读取时校验，上一个块已读取过时同时校验连接
+ */
+func (service *BlockService) verifyBlock(tableID db.TableID, blockID db.BlockID, block *row.BlockData) error {
+	if err := checkBlock(blockID, block, service.checksums[blockKey{tableID, blockID-1}]); err != nil {
+		return err
+	}
+	if len(block.Checksum) > 0 {
+		service.checksums[blockKey{tableID, blockID}] = block.Checksum
+	}
+	return nil
+}
+
+func (service *BlockService) verifyBlockData(tableID db.TableID, blockID db.BlockID, prevHash []byte) (*row.BlockData,error) {
+	value,err := service.storage.GetBlockData(service.database.Id, tableID, blockID); if err != nil {
+		return nil,err
+	}
+	if len(value) == 0 {
+		return nil,fmt.Errorf("block `%d` is not found", blockID)
+	}
+	block,err := DecodeBlock(value); if err != nil {
+		return nil,fmt.Errorf("block `%d` convert error `%s`", blockID, err.Error())
+	}
+	return block,checkBlock(blockID, block, prevHash)
+}
+
+/**
+	遍历表所有块(按统计分片)校验校验和与块连接，最后一个块需要与统计中记录的校验和一致(检测截断)
+	压缩删除的块使用压缩记录中的校验和
+ */
+func (service *BlockService) VerifyTable(table *db.TableData, tallies []*db.TableTally) (*db.TableVerify,error) {
+	verify := &db.TableVerify{TableID:table.Id,Breaks:[]db.BlockBreak{}}
+	for _,tally := range tallies {
+		start := db.BlockID(0)
+		if table.TallyShards > 1 {
+			start = db.BlockID(tally.Shard)<<db.TallyShardBlockBits
+		}
+		var prevHash []byte
+		for id:=start+1;id<=tally.Block;id++ {
+			record,err := service.getBlockCompact(table.Id, id); if err != nil {
+				return nil,err
+			}
+			if record != nil && record.Purged {//压缩删除的块使用记录中的校验和连接
+				verify.Purged++
+				if len(prevHash) > 0 && !bytes.Equal(prevHash, record.PrevHash) {
+					verify.Breaks = append(verify.Breaks, db.BlockBreak{BlockID:id,Error:fmt.Sprintf("purged block `%d` prev hash error", id)})
+				}
+				prevHash = record.Checksum
+				continue
+			}
+			verify.Blocks++
+			block,err := service.verifyBlockData(table.Id, id, prevHash); if err != nil {
+				verify.Breaks = append(verify.Breaks, db.BlockBreak{BlockID:id,Error:err.Error()})
+				prevHash = nil
+				continue
+			}
+			if len(block.Checksum) == 0 {
+				verify.Legacy++
+			}
+			prevHash = block.Checksum
+		}
+		if len(tally.BlockHash) > 0 && !bytes.Equal(prevHash, tally.BlockHash) {
+			verify.Breaks = append(verify.Breaks, db.BlockBreak{BlockID:tally.Block,Error:fmt.Sprintf("block `%d` hash is not equal to table tally", tally.Block)})
+		}
+	}
+	return verify,nil
+}
@@ -0,0 +1,84 @@
+package database
+
+import (
+	"fmt"
+	"github.com/database-fabric/db"
+	"github.com/database-fabric/db/index/tree"
+)
+
+/**
+	验证物化计数器：名称不能为空或重复，求和列为INT或DECIMAL，分组列为INT
+ */
+func ValidateCounters(table *db.TableData) error {
+	if len(table.Counters) > db.MaxCounters {
+		return fmt.Errorf("counters can not exceed %d", db.MaxCounters)
+	}
+	names := make(map[string]bool, len(table.Counters))
+	for _,counter := range table.Counters {
+		if counter.Name == "" {
+			return fmt.Errorf("counter name is null")
+		}
+		if names[counter.Name] {
+			return fmt.Errorf("counter `%s` is repeat", counter.Name)
+		}
+		names[counter.Name] = true
+		if counter.Column > 0 {
+			if column := counterColumn(table, counter.Column); column == nil || (column.Type != db.INT && column.Type != db.DECIMAL) {
+				return fmt.Errorf("counter `%s` column must be INT or DECIMAL", counter.Name)
+			}
+		}
+		if counter.GroupBy > 0 {
+			if column := counterColumn(table, counter.GroupBy); column == nil || column.Type != db.INT {
+				return fmt.Errorf("counter `%s` group by column must be INT", counter.Name)
+			}
+		}
+	}
+	return nil
+}
+
+func counterColumn(table *db.TableData, columnID db.ColumnID) *db.Column {
+	for i,column := range table.Columns {
+		if column.Id == columnID && !column.IsDeleted {
+			return &table.Columns[i]
+		}
+	}
+	return nil
+}
+
+/**
+	验证表存储配置，0值表示使用默认配置
+ */
+func ValidateStorageConfig(config db.StorageConfig) error {
+	if config.BlockSize != 0 && (config.BlockSize < db.MinBlockSize || config.BlockSize > db.MaxBlockSize) {
+		return fmt.Errorf("block size must between %d and %d", db.MinBlockSize, db.MaxBlockSize)
+	}
+	if config.NodeSize != 0 && (config.NodeSize < db.MinNodeSize || config.NodeSize > db.MaxNodeSize) {
+		return fmt.Errorf("node size must between %d and %d", db.MinNodeSize, db.MaxNodeSize)
+	}
+	if config.SplitRule < db.SplitRuleDefault || config.SplitRule > db.SplitRuleSize {
+		return fmt.Errorf("split rule `%d` error", config.SplitRule)
+	}
+	if config.Compression < db.CompressionNone || config.Compression > db.CompressionSnappy {
+		return fmt.Errorf("compression `%d` error", config.Compression)
+	}
+	return tree.ValidateTreeType(tree.TreeType(config.TreeType))
+}
+
+func ValidateRetention(retention db.RetentionConfig) error {
+	if retention.KeepVersions < 0 || retention.KeepSeconds < 0 || retention.PurgeDeletedBefore < 0 {
+		return fmt.Errorf("retention can not be negative")
+	}
+	return nil
+}
+
+func equalCounters(counters1 []db.CounterConfig, counters2 []db.CounterConfig) bool {
+	if len(counters1) != len(counters2) {
+		return false
+	}
+	for i := range counters1 {
+		if counters1[i] != counters2[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -4,11 +4,12 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/database-fabric/db"
+	"github.com/database-fabric/db/blob"
 	"github.com/database-fabric/db/block"
+	"github.com/database-fabric/db/proof"
 	"github.com/database-fabric/db/storage"
 	"github.com/database-fabric/db/storage/state"
 	"github.com/database-fabric/db/table"
-	"github.com/database-fabric/db/util"
 	"github.com/database-fabric/protos/db/row"
 )
 
@@ -18,10 +19,12 @@ type DatabaseImpl struct {
 	storage *storage.DatabaseStorage
 	tableService *table.TableService
 	blockService *block.BlockService
+	blobService *blob.BlobService
+	layoutChecked bool //Key布局已检查，同一事务只检查一次
 }
 
 func NewDatabaseImpl(database *db.DataBase, state state.ChainCodeState) *DatabaseImpl {
-	return &DatabaseImpl{database,state,storage.NewDatabaseStorage(state),nil,nil}
+	return &DatabaseImpl{database,state,storage.NewDatabaseStorage(state),nil,nil,nil,false}
 }
 
 func (service *DatabaseImpl) getTableService() *table.TableService {
@@ -38,6 +41,27 @@ func (service *DatabaseImpl) getBlockService() *block.BlockService {
 	return service.blockService
 }
 
+func (service *DatabaseImpl) getBlobService() *blob.BlobService {
+	if service.blobService == nil {
+		service.blobService = blob.NewBlobService(service.database, service.state)
+	}
+	return service.blobService
+}
+
+/**
+	旧Key布局未迁移完成时，写入和区间查询按新布局读写会遗漏或重复旧布局的Key，返回错误
+ */
+func (service *DatabaseImpl) checkKeyLayout() error {
+	if service.layoutChecked {
+		return nil
+	}
+	if err := storage.NewLayoutStorage(service.state).CheckKeyLayout(); err != nil {
+		return err
+	}
+	service.layoutChecked = true
+	return nil
+}
+
 ////////////////////////// impl database interface //////////////////////////
 
 
@@ -60,18 +84,69 @@ func (service *DatabaseImpl) GetRelationKeysByReference(reference db.ReferenceKe
 	return GetRelationKeysByReference(reference, service.database.Relation)
 }
 
+/**
+	保存数据库关系，同时更新当前数据库的关系
+ */
+func (service *DatabaseImpl) PutRelation(relation *db.Relation) error {
+	relationBytes,err := json.Marshal(relation); if err != nil {
+		return err
+	}
+	if err := service.storage.PutRelationData(service.database.Id, relationBytes); err != nil {
+		return err
+	}
+	service.database.Relation = relation
+	return nil
+}
+
+/**
+	所有表名，下标为表ID-1，删除的表为空字符串
+ */
+func (service *DatabaseImpl) GetAllTable() ([]string,error) {
+	return service.storage.GetAllTable(service.database.Id)
+}
+
 func (service *DatabaseImpl) GetTableTally(tableID db.TableID) (*db.TableTally,error) {
-	value,err := service.storage.GetTableTally(service.database.Id, tableID); if err != nil {
+	table,err := service.QueryTableDataByID(tableID); if err != nil {
 		return nil,err
 	}
-	tally := &db.TableTally{}
-	if len(value) > 0 {
-		err = json.Unmarshal(value, tally)
-		if err != nil {
-			return nil,err
-		}
+	table.Id = tableID
+	return service.sumTableTally(table)
+}
+
+/**
+	列索引统计(高度、节点数量、关键字数量和每个关键字的版本数量)
+ */
+func (service *DatabaseImpl) QueryIndexStats(tableID db.TableID, column db.ColumnID) (*db.IndexStats,error) {
+	table,err := service.QueryTableDataByID(tableID); if err != nil {
+		return nil,err
 	}
-	return tally,nil
+	table.Id = tableID
+	return service.getBlockService().QueryIndexStats(table, column)
+}
+
+/**
+	物化计数器一个分组的值
+ */
+func (service *DatabaseImpl) QueryCounter(tableID db.TableID, name string, group int64) (*db.CounterValue,error) {
+	table,err := service.QueryTableDataByID(tableID); if err != nil {
+		return nil,err
+	}
+	table.Id = tableID
+	return service.getBlockService().QueryCounter(table, name, group)
+}
+
+/**
+	物化计数器分组值区间[start,end]中的分组，最多size个
+ */
+func (service *DatabaseImpl) QueryCounterByRange(tableID db.TableID, name string, start int64, end int64, size int32) ([]*db.CounterValue,error) {
+	if err := service.checkKeyLayout(); err != nil {
+		return nil,err
+	}
+	table,err := service.QueryTableDataByID(tableID); if err != nil {
+		return nil,err
+	}
+	table.Id = tableID
+	return service.getBlockService().QueryCounterByRange(table, name, start, end, size)
 }
 
 func (service *DatabaseImpl) GetTableName(tableID db.TableID) (string,error) {
@@ -84,6 +159,25 @@ func (service *DatabaseImpl) GetTableID(name string) (db.TableID,error) {
 
 
 func (service *DatabaseImpl) CreateTableData(table *db.TableData) (db.TableID,error) {
+	if err := service.checkKeyLayout(); err != nil {
+		return 0,err
+	}
+	if err := ValidateTallyShards(table.TallyShards); err != nil {
+		return 0,err
+	}
+	if err := ValidateStorageConfig(table.Storage); err != nil {
+		return 0,err
+	}
+	if err := ValidateRetention(table.Retention); err != nil {
+		return 0,err
+	}
+	if err := ValidateCounters(table); err != nil {
+		return 0,err
+	}
+	table.PrimaryShards = 0
+	if table.TallyShards > 1 {//主键索引按统计分片分为多棵树，不同分片的事务不写同一棵树
+		table.PrimaryShards = table.TallyShards
+	}
 	tableID,err := service.storage.CreateTable(service.database.Id, table.Name); if err != nil {
 		return tableID,err
 	}
@@ -92,9 +186,31 @@ func (service *DatabaseImpl) CreateTableData(table *db.TableData) (db.TableID,er
 }
 
 func (service *DatabaseImpl) UpdateTableData(table *db.TableData) error {
+	if err := service.checkKeyLayout(); err != nil {
+		return err
+	}
 	name,err := service.GetTableName(table.Id); if err != nil {
 		return err
 	}
+	oldTable,err := service.QueryTableDataByID(table.Id); if err != nil {
+		return err
+	}
+	if oldTable.TallyShards != table.TallyShards {
+		return fmt.Errorf("table `%s` tally shards can not be modified", table.Name)
+	}
+	if oldTable.Storage != table.Storage {
+		return fmt.Errorf("table `%s` storage config can not be modified", table.Name)
+	}
+	table.PrimaryShards = oldTable.PrimaryShards
+	if !equalCounters(oldTable.Counters, table.Counters) {
+		return fmt.Errorf("table `%s` counters can not be modified", table.Name)
+	}
+	if err := ValidateCounters(table); err != nil {
+		return err
+	}
+	if err := ValidateRetention(table.Retention); err != nil {
+		return err
+	}
 	if name != table.Name {
 		tableID,err := service.GetTableID(table.Name); if err != nil {
 			return err
@@ -109,10 +225,332 @@ func (service *DatabaseImpl) UpdateTableData(table *db.TableData) error {
 	return service.getTableService().PutTableData(table)
 }
 
+/**
+	删除表：保存墓碑，表外键关系标记删除，表名置空后可以重新创建为新的表ID
+	表的Key使用DropTable分批清除
+ */
 func (service *DatabaseImpl) DeleteTableData(tableID db.TableID) error {
+	if err := service.checkKeyLayout(); err != nil {
+		return err
+	}
+	name,err := service.GetTableName(tableID); if err != nil {
+		return err
+	}
+	if name == "" {
+		return fmt.Errorf("table `%d` is already deleted", tableID)
+	}
+	txID,timestamp,err := service.storage.GetTxID(); if err != nil {
+		return err
+	}
+	if err := service.putTableDrop(tableID, &db.TableDrop{Name:name,TxID:txID,Time:timestamp}); err != nil {
+		return err
+	}
+	relation,err := service.GetRelation(); if err != nil {
+		return err
+	}
+	deleted := false
+	for i,relationKey := range relation.Keys {
+		if relationKey.TableID == tableID && !relationKey.IsDeleted {
+			relation.Keys[i].IsDeleted = true
+			deleted = true
+		}
+	}
+	if deleted {
+		if err := service.PutRelation(relation); err != nil {
+			return err
+		}
+	}
 	return service.storage.DeleteTable(service.database.Id, tableID)
 }
 
+/**
+	删除表的墓碑，表未删除时为空
+ */
+func (service *DatabaseImpl) GetTableDrop(tableID db.TableID) (*db.TableDrop,error) {
+	value,err := service.storage.GetTableDrop(service.database.Id, tableID); if err != nil {
+		return nil,err
+	}
+	if len(value) == 0 {
+		return nil,nil
+	}
+	drop := &db.TableDrop{}
+	if err := json.Unmarshal(value, drop); err != nil {
+		return nil,fmt.Errorf("table `%d` drop convert error `%s`", tableID, err.Error())
+	}
+	return drop,nil
+}
+
+func (service *DatabaseImpl) putTableDrop(tableID db.TableID, drop *db.TableDrop) error {
+	value,err := json.Marshal(drop); if err != nil {
+		return err
+	}
+	return service.storage.PutTableDrop(service.database.Id, tableID, value)
+}
+
+/**
+	分批清除已删除表的所有Key，每批最多删除size个Key，进度保存在墓碑中，未完成时在新的事务中继续
+	清除Key之前分批释放块中的大字段引用(每批最多扫描size个块)
+	区间查询只返回已提交的Key，一个区间查询返回的Key数量达到批次大小时，剩余的Key在下一个事务中查询
+	没有墓碑的已删除表(旧版本删除)补充墓碑后清除
+ */
+func (service *DatabaseImpl) DropTable(tableID db.TableID, size int32) (*db.TableDrop,error) {
+	if err := service.checkKeyLayout(); err != nil {
+		return nil,err
+	}
+	drop,err := service.GetTableDrop(tableID); if err != nil {
+		return nil,err
+	}
+	if drop == nil {
+		name,err := service.GetTableName(tableID); if err != nil {
+			return nil,err
+		}
+		if name != "" {
+			return nil,fmt.Errorf("table `%s` is not deleted", name)
+		}
+		txID,timestamp,err := service.storage.GetTxID(); if err != nil {
+			return nil,err
+		}
+		drop = &db.TableDrop{TxID:txID,Time:timestamp}
+	}
+	if drop.Done {
+		return drop,nil
+	}
+	if size <= 0 {
+		size = db.DefaultDropBatchSize
+	}
+	if !drop.Released {
+		if err := service.releaseTableBlobs(tableID, drop, size); err != nil {
+			return nil,err
+		}
+		if !drop.Released {
+			if err := service.putTableDrop(tableID, drop); err != nil {
+				return nil,err
+			}
+			return drop,nil
+		}
+	}
+	ranges := service.storage.GetTableKeyRanges(service.database.Id, tableID)
+	for drop.Ranges < int32(len(ranges)) && size > 0 {
+		keyRange := ranges[drop.Ranges]
+		if !keyRange.Prefix {
+			value,err := service.storage.GetKey(keyRange.Key); if err != nil {
+				return nil,err
+			}
+			if len(value) > 0 {
+				if err := service.storage.DelKey(keyRange.Key); err != nil {
+					return nil,err
+				}
+				drop.Keys++
+			}
+			size--
+			drop.Ranges++
+			continue
+		}
+		keys,err := service.storage.GetKeysByPrefix(keyRange.Key, size); if err != nil {
+			return nil,err
+		}
+		for _,key := range keys {
+			if err := service.storage.DelKey(key); err != nil {
+				return nil,err
+			}
+		}
+		drop.Keys += db.Total(len(keys))
+		if int32(len(keys)) == size {
+			break
+		}
+		size -= int32(len(keys))
+		drop.Ranges++
+	}
+	drop.Done = drop.Ranges == int32(len(ranges))
+	if err := service.putTableDrop(tableID, drop); err != nil {
+		return nil,err
+	}
+	return drop,nil
+}
+
+/**
+	清除Key之前释放表所有块中的大字段引用，旧版本已开始清除Key时表结构和块可能已删除，不再释放
+ */
+func (service *DatabaseImpl) releaseTableBlobs(tableID db.TableID, drop *db.TableDrop, size int32) error {
+	if drop.Ranges > 0 {
+		drop.Released = true
+		return nil
+	}
+	table,err := service.QueryTableDataByID(tableID); if err != nil {
+		return err
+	}
+	table.Id = tableID
+	tallies,err := service.getTableTallies(table); if err != nil {
+		return err
+	}
+	return service.getBlockService().ReleaseTableBlobs(table, tallies, drop, size)
+}
+
+/**
+	表结构和索引从旧JSON编码迁移为二进制编码，从checkpoint开始每批最多处理size个索引树节点，size为0时使用默认值
+	返回下一批的位置，未完成时在新的事务中继续
+ */
+func (service *DatabaseImpl) MigrateTableData(tableID db.TableID, checkpoint db.TableMigrateCheckpoint, size int32) (*db.TableMigrateCheckpoint,error) {
+	if err := service.checkKeyLayout(); err != nil {
+		return nil,err
+	}
+	if size <= 0 {
+		size = db.DefaultMigrateBatchSize
+	}
+	if !checkpoint.Table {
+		migrated,err := service.getTableService().MigrateTable(tableID); if err != nil {
+			return nil,err
+		}
+		if migrated {
+			checkpoint.Keys++
+		}
+		checkpoint.Table = true
+	}
+	table,err := service.QueryTableDataByID(tableID); if err != nil {
+		return nil,err
+	}
+	table.Id = tableID
+	if err := service.getBlockService().MigrateIndex(table, &checkpoint, size); err != nil {
+		return nil,err
+	}
+	return &checkpoint,nil
+}
+
+/**
+	校验表所有数据块的校验和与块连接
+ */
+func (service *DatabaseImpl) VerifyTable(tableID db.TableID) (*db.TableVerify,error) {
+	table,err := service.QueryTableDataByID(tableID); if err != nil {
+		return nil,err
+	}
+	table.Id = tableID
+	tallies,err := service.getTableTallies(table); if err != nil {
+		return nil,err
+	}
+	return service.getBlockService().VerifyTable(table, tallies)
+}
+
+func (service *DatabaseImpl) getTableTallies(table *db.TableData) ([]*db.TableTally,error) {
+	tallies := make([]*db.TableTally, 0, table.TallyShards+1)
+	for shard := int8(0); shard == 0 || shard < table.TallyShards; shard++ {
+		tally,err := service.getTableTallyShard(table, shard); if err != nil {
+			return nil,err
+		}
+		tallies = append(tallies, tally)
+	}
+	return tallies,nil
+}
+
+/**
+	检查表索引树、链表、数据块、统计和外键引用的一致性
+ */
+func (service *DatabaseImpl) CheckTable(tableID db.TableID) (*db.TableCheck,error) {
+	table,err := service.QueryTableDataByID(tableID); if err != nil {
+		return nil,err
+	}
+	table.Id = tableID
+	tallies,err := service.getTableTallies(table); if err != nil {
+		return nil,err
+	}
+	references := make([]*db.TableData, 0, len(table.ForeignKeys))
+	for _,foreignKey := range table.ForeignKeys {
+		reference,err := service.QueryTableDataByID(foreignKey.Reference.TableID); if err != nil {
+			return nil,err
+		}
+		reference.Id = foreignKey.Reference.TableID
+		references = append(references, reference)
+	}
+	return service.getBlockService().CheckTable(table, tallies, references...)
+}
+
+/**
+	执行检查结果中的修复操作，返回已执行的修复，不支持的修复跳过
+	重建索引每次只执行一批，返回的修复中重建位置未完成时使用返回的修复继续执行
+ */
+func (service *DatabaseImpl) RepairTable(tableID db.TableID, repairs []db.TableRepair) ([]db.TableRepair,error) {
+	if err := service.checkKeyLayout(); err != nil {
+		return nil,err
+	}
+	table,err := service.QueryTableDataByID(tableID); if err != nil {
+		return nil,err
+	}
+	table.Id = tableID
+	repaired := make([]db.TableRepair, 0, len(repairs))
+	for _,repair := range repairs {
+		switch repair.Type {
+		case db.RepairTally:
+			if repair.Tally == nil || repair.Tally.TableID != tableID {
+				return repaired,fmt.Errorf("repair tally of table `%d` error", tableID)
+			}
+			if err := service.putTableTallyShard(table, repair.Tally); err != nil {
+				return repaired,err
+			}
+		case db.RepairCounter:
+			if err := service.getBlockService().RepairCounters(table, repair.Counters); err != nil {
+				return repaired,err
+			}
+		case db.RepairRebuildIndex:
+			checkpoint := db.RebuildCheckpoint{}
+			if repair.Checkpoint != nil {
+				checkpoint = *repair.Checkpoint
+			}
+			repair.Checkpoint,err = service.rebuildIndex(table, repair.Column, checkpoint,0); if err != nil {
+				return repaired,err
+			}
+		default:
+			continue
+		}
+		repaired = append(repaired, repair)
+	}
+	return repaired,nil
+}
+
+/**
+	按块数据重建列索引，每次执行一批，未完成时使用返回的位置在新的事务中继续
+ */
+func (service *DatabaseImpl) RebuildIndex(tableID db.TableID, column db.ColumnID, checkpoint db.RebuildCheckpoint, size int32) (*db.RebuildCheckpoint,error) {
+	if err := service.checkKeyLayout(); err != nil {
+		return nil,err
+	}
+	table,err := service.QueryTableDataByID(tableID); if err != nil {
+		return nil,err
+	}
+	table.Id = tableID
+	return service.rebuildIndex(table, column, checkpoint, size)
+}
+
+func (service *DatabaseImpl) rebuildIndex(table *db.TableData, column db.ColumnID, checkpoint db.RebuildCheckpoint, size int32) (*db.RebuildCheckpoint,error) {
+	tallies,err := service.getTableTallies(table); if err != nil {
+		return nil,err
+	}
+	return service.getBlockService().RebuildIndex(table, column, tallies, checkpoint, size)
+}
+
+/**
+	按表保留策略压缩数据块，每次执行一批，只写入有修改的统计分片，未完成时使用返回的位置在新的事务中继续
+ */
+func (service *DatabaseImpl) CompactTable(tableID db.TableID, checkpoint db.CompactCheckpoint, size int32) (*db.CompactCheckpoint,error) {
+	if err := service.checkKeyLayout(); err != nil {
+		return nil,err
+	}
+	table,err := service.QueryTableDataByID(tableID); if err != nil {
+		return nil,err
+	}
+	table.Id = tableID
+	tallies,err := service.getTableTallies(table); if err != nil {
+		return nil,err
+	}
+	result,changed,err := service.getBlockService().CompactTable(table, tallies, checkpoint, size); if err != nil {
+		return nil,err
+	}
+	for _,tally := range changed {
+		if err := service.putTableTallyShard(table, tally); err != nil {
+			return nil,err
+		}
+	}
+	return result,nil
+}
+
 func (service *DatabaseImpl) QueryTableDataByName(tableName string) (*db.TableData,error) {
 	tableID,err := service.GetTableID(tableName); if err != nil {
 		return nil,err
@@ -128,35 +566,112 @@ func (service *DatabaseImpl) QueryTableDataByID(tableID db.TableID) (*db.TableDa
 }
 
 func (service *DatabaseImpl) AddRowData(table *db.TableData, rows []*row.RowData) error {
-	tally,err := service.GetTableTally(table.Id); if err != nil {
+	if err := service.checkKeyLayout(); err != nil {
 		return err
 	}
-	if err := service.getBlockService().SetBlockData(table, tally, rows); err != nil {
+	shard := TallyShard(service.state.GetStub().GetTxID(), table.TallyShards)
+	tally,err := service.getTableTallyShard(table, shard); if err != nil {
 		return err
 	}
-	value,err := util.ConvertJsonBytes(*tally); if err != nil {
+	if err := service.getBlockService().SetBlockData(table, tally, rows); err != nil {
 		return err
 	}
-	return service.storage.PutTableTally(service.database.Id, table.Id, value)
+	return service.putTableTallyShard(table, tally)
+}
+
+func (service *DatabaseImpl) PutBlobData(value []byte) ([]byte,error) {
+	if err := service.checkKeyLayout(); err != nil {
+		return nil,err
+	}
+	return service.getBlobService().PutBlobData(value)
 }
 
+func (service *DatabaseImpl) GetBlobData(reference []byte) ([]byte,error) {
+	return service.getBlobService().GetBlobData(reference)
+}
 
 func (service *DatabaseImpl) QueryRowBlockID(table *db.TableData, rowID db.RowID) (db.BlockID,error) {
 	return service.getBlockService().QueryRowBlockID(table, rowID)
 }
 
-func (service *DatabaseImpl) QueryRowData(table *db.TableData, rowID db.RowID) (*row.RowData,error) {
-	return service.getBlockService().QueryRowData(table, rowID)
+func (service *DatabaseImpl) QueryRowVersion(table *db.TableData, rowID db.RowID) (*db.RowVersion,error) {
+	return service.getBlockService().QueryRowVersion(table, rowID)
+}
+
+func (service *DatabaseImpl) QueryRowData(table *db.TableData, rowID db.RowID, columns ...db.ColumnID) (*row.RowData,error) {
+	return service.getBlockService().QueryRowData(table, rowID, columns...)
 }
 
 func (service *DatabaseImpl) QueryRowIDByForeignKey(tableID db.TableID, foreignKey db.ForeignKey, referenceRowID db.RowID, size int32) ([]db.RowID,error) {
+	if err := service.checkKeyLayout(); err != nil {
+		return nil,err
+	}
 	return service.getBlockService().QueryRowIDByForeignKey(tableID, foreignKey, referenceRowID, size)
 }
 
-func (service *DatabaseImpl) QueryRowDataByRange(table *db.TableData, start db.RowID, end db.RowID, order db.OrderType, size int32) ([]*row.RowData,error) {
-	return service.getBlockService().QueryRowDataByRange(table, start, end, order, size)
+/**
+	外键引用行的行ID游标分页查询，返回本页最后一个行ID的游标，没有下一页时为空
+ */
+func (service *DatabaseImpl) QueryRowIDByForeignKeyCursor(tableID db.TableID, foreignKey db.ForeignKey, referenceRowID db.RowID, cursor *db.Cursor, size int32) ([]db.RowID,*db.Cursor,error) {
+	if err := service.checkKeyLayout(); err != nil {
+		return nil,nil,err
+	}
+	return service.getBlockService().QueryRowIDByForeignKeyCursor(tableID, foreignKey, referenceRowID, cursor, size)
+}
+
+func (service *DatabaseImpl) QueryRowDataByRange(table *db.TableData, start db.RowID, end db.RowID, order db.OrderType, size int32, columns ...db.ColumnID) ([]*row.RowData,error) {
+	if err := service.checkKeyLayout(); err != nil {
+		return nil,err
+	}
+	return service.getBlockService().QueryRowDataByRange(table, start, end, order, size, columns...)
+}
+
+func (service *DatabaseImpl) QueryRowDataByCursor(table *db.TableData, start db.RowID, end db.RowID, cursor *db.Cursor, order db.OrderType, size int32, columns ...db.ColumnID) ([]*row.RowData,*db.Cursor,error) {
+	if err := service.checkKeyLayout(); err != nil {
+		return nil,nil,err
+	}
+	return service.getBlockService().QueryRowDataByCursor(table, start, end, cursor, order, size, columns...)
+}
+
+/**
+	区间未删除的行数量，不限制区间时读取统计中的行数量，否则按主键索引统计
+ */
+func (service *DatabaseImpl) QueryRowCountByRange(table *db.TableData, start db.RowID, end db.RowID) (db.Total,error) {
+	if err := service.checkKeyLayout(); err != nil {
+		return 0,err
+	}
+	if start == 0 && end == 0 {
+		tally,err := service.sumTableTally(table); if err != nil {
+			return 0,err
+		}
+		return db.Total(tally.LiveRow),nil
+	}
+	return service.getBlockService().QueryRowCountByRange(table, start, end)
 }
 
 func (service *DatabaseImpl) QueryRowDataHistoryByRange(table *db.TableData, rowID db.RowID, order db.OrderType, size int32) ([]*db.RowDataHistory,db.Total,error) {
+	if err := service.checkKeyLayout(); err != nil {
+		return nil,0,err
+	}
 	return service.getBlockService().QueryRowDataHistoryByRange(table, rowID, order, size)
 }
+
+func (service *DatabaseImpl) QueryRowDataHistoryByCursor(table *db.TableData, rowID db.RowID, cursor *db.Cursor, order db.OrderType, size int32) ([]*db.RowDataHistory,db.Total,*db.Cursor,error) {
+	if err := service.checkKeyLayout(); err != nil {
+		return nil,0,nil,err
+	}
+	return service.getBlockService().QueryRowDataHistoryByCursor(table, rowID, cursor, order, size)
+}
+
+/**
+	行版本证明，version从1开始，0为最新版本
+ */
+func (service *DatabaseImpl) QueryRowProof(table *db.TableData, rowID db.RowID, version int64) (*proof.RowProof,error) {
+	blockID,err := service.getBlockService().QueryRowVersionBlockID(table, rowID, version); if err != nil {
+		return nil,err
+	}
+	tallies,err := service.getTableTallies(table); if err != nil {
+		return nil,err
+	}
+	return service.getBlockService().QueryRowProof(table, tallies, rowID, version, blockID)
+}
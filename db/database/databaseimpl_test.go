@@ -1,11 +1,22 @@
 package database
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
 	"github.com/database-fabric/db"
+	"github.com/database-fabric/db/index/tree"
+	"github.com/database-fabric/db/storage"
 	"github.com/database-fabric/db/storage/state"
+	"github.com/database-fabric/db/storage/state/leveldb"
+	"github.com/database-fabric/db/util"
+	"github.com/database-fabric/protos/db/row"
 	"github.com/database-fabric/test"
 	"github.com/stretchr/testify/assert"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestDatabase(t *testing.T) {
@@ -49,4 +60,636 @@ func TestDatabase(t *testing.T) {
 	//relation,err := databaseImpl.GetRelation(); if err != nil {
 	//	panic(err.Error())
 	//}
-}
\ No newline at end of file
+	//统计分片表，不同事务写不同分片，自增ID不重复
+	{
+		shardTable := &db.TableData{Name:"TestShardTable",
+			Columns:[]db.Column{
+				{Id:db.ColumnID(1),ColumnConfig:db.ColumnConfig{Name:"id",Type:db.INT,NotNull:true},Order:1},
+				{Id:db.ColumnID(2),ColumnConfig:db.ColumnConfig{Name:"name",Type:db.VARCHAR},Order:2},
+			},
+			PrimaryKey:db.PrimaryKey{ColumnID:db.ColumnID(1),AutoIncrement:true},
+			TallyShards:4}
+		_,err := databaseImpl.CreateTableData(shardTable); if err != nil {
+			panic(err.Error())
+		}
+		rowIDs := map[db.RowID]bool{}
+		shards := map[int8]bool{}
+		for i := 0; i < 20; i++ {
+			stub.TxID = util.Int64ToString(int64(i))
+			shards[TallyShard(stub.TxID, shardTable.TallyShards)] = true
+			rows := []*row.RowData{{Op:uint32(db.ADD),Columns:[]*row.ColumnData{{Data:util.RowIDToBytes(0)},{Data:[]byte("name")}}}}
+			if err := databaseImpl.AddRowData(shardTable, rows); err != nil {
+				panic(err.Error())
+			}
+			assert.False(t, rowIDs[rows[0].Id], "row id repeat")
+			rowIDs[rows[0].Id] = true
+			blockID,err := databaseImpl.QueryRowBlockID(shardTable, rows[0].Id); if err != nil {
+				panic(err.Error())
+			}
+			assert.EqualValues(t, TallyShard(stub.TxID, shardTable.TallyShards), blockID>>db.TallyShardBlockBits, "block shard error")
+			rowData,err := databaseImpl.QueryRowData(shardTable, rows[0].Id); if err != nil {
+				panic(err.Error())
+			}
+			assert.Equal(t, "name", string(rowData.Columns[1].Data))
+		}
+		stub.TxID = ""
+		assert.True(t, len(shards) > 1, "tally shard error")
+		tally,err := databaseImpl.GetTableTally(shardTable.Id); if err != nil {
+			panic(err.Error())
+		}
+		assert.EqualValues(t, 20, tally.AddRow)
+		assert.EqualValues(t, 20, tally.Block)
+		shardTable.TallyShards = 8
+		assert.NotNil(t, databaseImpl.UpdateTableData(shardTable), "tally shards can not be modified")
+	}
+	//大字段列
+	{
+		blobTable := &db.TableData{Name:"TestBlobTable",
+			Columns:[]db.Column{
+				{Id:db.ColumnID(1),ColumnConfig:db.ColumnConfig{Name:"id",Type:db.INT,NotNull:true},Order:1},
+				{Id:db.ColumnID(2),ColumnConfig:db.ColumnConfig{Name:"data",Type:db.BLOB},Order:2},
+				{Id:db.ColumnID(3),ColumnConfig:db.ColumnConfig{Name:"note",Type:db.TEXT},Order:3},
+			},
+			PrimaryKey:db.PrimaryKey{ColumnID:db.ColumnID(1)}}
+		_,err := databaseImpl.CreateTableData(blobTable); if err != nil {
+			panic(err.Error())
+		}
+		value := make([]byte, 2*db.BlobChunkSize+100)
+		rand.Read(value)
+		data,err := databaseImpl.PutBlobData(value); if err != nil {
+			panic(err.Error())
+		}
+		sameData,err := databaseImpl.PutBlobData(value); if err != nil {
+			panic(err.Error())
+		}
+		assert.Equal(t, data, sameData, "blob content address error")
+		note,err := databaseImpl.PutBlobData([]byte("text")); if err != nil {
+			panic(err.Error())
+		}
+		rows := []*row.RowData{
+			{Id:1,Op:uint32(db.ADD),Columns:[]*row.ColumnData{{Data:util.RowIDToBytes(1)},{Data:data},{Data:note}}},
+			{Id:2,Op:uint32(db.ADD),Columns:[]*row.ColumnData{{Data:util.RowIDToBytes(2)},{},{}}},
+		}
+		if err := databaseImpl.AddRowData(blobTable, rows); err != nil {
+			panic(err.Error())
+		}
+		if err := databaseImpl.AddRowData(blobTable, []*row.RowData{newRowData(db.UPDATE, 2, data, nil)}); err != nil {
+			panic(err.Error())
+		}
+		rowData,err := databaseImpl.QueryRowData(blobTable, db.RowID(2)); if err != nil {
+			panic(err.Error())
+		}
+		assert.Equal(t, data, rowData.Columns[1].Data, "blob reference error")
+		reference,err := util.DecodeBlobReference(rowData.Columns[1].Data); if err != nil {
+			panic(err.Error())
+		}
+		assert.EqualValues(t, len(value), reference.Length, "blob reference length error")
+		blockID,err := databaseImpl.QueryRowBlockID(blobTable, db.RowID(1)); if err != nil {
+			panic(err.Error())
+		}
+		assert.Equal(t, blockID, db.BlockID(1), "blob row block error")
+		blob,err := databaseImpl.GetBlobData(data); if err != nil {
+			panic(err.Error())
+		}
+		assert.Equal(t, value, blob, "blob data error")
+		blob,err = databaseImpl.GetBlobData(note); if err != nil {
+			panic(err.Error())
+		}
+		assert.Equal(t, "text", string(blob), "text data error")
+		//篡改分片
+		blobStorage := storage.NewBlobStorage(state)
+		key := hex.EncodeToString(reference.Hash)
+		chunk,err := blobStorage.GetChunkData(database.Id, key, 2); if err != nil {
+			panic(err.Error())
+		}
+		assert.Len(t, chunk, 100, "blob last chunk error")
+		if err := blobStorage.PutChunkData(database.Id, key, 1, []byte("tampered")); err != nil {
+			panic(err.Error())
+		}
+		_,err = databaseImpl.GetBlobData(data)
+		assert.NotNil(t, err, "tampered blob error")
+	}
+	//表一致性检查与统计修复
+	{
+		checkTable := &db.TableData{Name:"TestCheckTable",
+			Columns:[]db.Column{
+				{Id:db.ColumnID(1),ColumnConfig:db.ColumnConfig{Name:"id",Type:db.INT,NotNull:true},Order:1},
+				{Id:db.ColumnID(2),ColumnConfig:db.ColumnConfig{Name:"name",Type:db.VARCHAR},Order:2},
+			},
+			PrimaryKey:db.PrimaryKey{ColumnID:db.ColumnID(1),AutoIncrement:true}}
+		tableID,err := databaseImpl.CreateTableData(checkTable); if err != nil {
+			panic(err.Error())
+		}
+		if err := databaseImpl.AddRowData(checkTable, []*row.RowData{newRowData(db.ADD, 0, "a"),newRowData(db.ADD, 0, "b"),newRowData(db.ADD, 0, "c")}); err != nil {
+			panic(err.Error())
+		}
+		if err := databaseImpl.AddRowData(checkTable, []*row.RowData{newRowData(db.DELETE, 2)}); err != nil {
+			panic(err.Error())
+		}
+		check,err := databaseImpl.CheckTable(tableID); if err != nil {
+			panic(err.Error())
+		}
+		assert.Empty(t, check.Issues, "check table issues error")
+		assert.EqualValues(t, 4, check.Rows, "check table rows error")
+		//统计计数丢失
+		tally,err := databaseImpl.GetTableTally(tableID); if err != nil {
+			panic(err.Error())
+		}
+		tally.DelRow = 0
+		if err := databaseImpl.putTableTallyShard(checkTable, tally); err != nil {
+			panic(err.Error())
+		}
+		check,err = databaseImpl.CheckTable(tableID); if err != nil {
+			panic(err.Error())
+		}
+		assert.Equal(t, 1, len(check.Issues), "check table tally issue error")
+		repaired,err := databaseImpl.RepairTable(tableID, append(check.Repairs(), db.TableRepair{Type:db.RepairRebuildIndex,Column:db.ColumnID(1)})); if err != nil {
+			panic(err.Error())
+		}
+		assert.Equal(t, 2, len(repaired), "repair table error")
+		tally,err = databaseImpl.GetTableTally(tableID); if err != nil {
+			panic(err.Error())
+		}
+		assert.EqualValues(t, 1, tally.DelRow, "repair table tally error")
+		//重建索引先清除旧索引，使用返回的修复继续重放
+		rebuild := repaired[1]
+		assert.True(t, rebuild.Checkpoint.Discarded, "repair rebuild discarded error")
+		assert.False(t, rebuild.Checkpoint.Done, "repair rebuild done error")
+		repaired,err = databaseImpl.RepairTable(tableID, []db.TableRepair{rebuild}); if err != nil {
+			panic(err.Error())
+		}
+		assert.True(t, repaired[0].Checkpoint.Done, "repair rebuild replay error")
+		assert.EqualValues(t, tally.Block, repaired[0].Checkpoint.Blocks, "repair rebuild blocks error")
+		check,err = databaseImpl.CheckTable(tableID); if err != nil {
+			panic(err.Error())
+		}
+		assert.Empty(t, check.Issues, "repair table issues error")
+		checkpoint,err := databaseImpl.RebuildIndex(tableID, db.ColumnID(2), db.RebuildCheckpoint{},0)
+		assert.Nil(t, checkpoint, "rebuild column without index error")
+		assert.NotNil(t, err, "rebuild column without index error")
+	}
+	//旧JSON编码的索引分批迁移
+	{
+		migrateTable := &db.TableData{Name:"TestMigrateTable",
+			Columns:[]db.Column{
+				{Id:db.ColumnID(1),ColumnConfig:db.ColumnConfig{Name:"id",Type:db.INT,NotNull:true},Order:1},
+				{Id:db.ColumnID(2),ColumnConfig:db.ColumnConfig{Name:"name",Type:db.VARCHAR},Order:2},
+			},
+			PrimaryKey:db.PrimaryKey{ColumnID:db.ColumnID(1),AutoIncrement:true},
+			Storage:db.StorageConfig{NodeSize:db.MinNodeSize}}
+		tableID,err := databaseImpl.CreateTableData(migrateTable); if err != nil {
+			panic(err.Error())
+		}
+		rows := make([]*row.RowData, 0, 200)
+		for i:=0;i<200;i++ {
+			rows = append(rows, newRowData(db.ADD, 0, fmt.Sprintf("m%d", i)))
+		}
+		if err := databaseImpl.AddRowData(migrateTable, rows); err != nil {
+			panic(err.Error())
+		}
+		treeStorage := storage.NewBPTreeStorage(state)
+		columnKey := migrateTable.PrimaryColumnKey(database.Id, 1)
+		headBytes,err := treeStorage.GetHead(columnKey); if err != nil {
+			panic(err.Error())
+		}
+		treeHead,err := tree.DecodeTreeHead(headBytes); if err != nil {
+			panic(err.Error())
+		}
+		assert.True(t, treeHead.NodeOrder > 2, "migrate tree nodes error")
+		for pointer:=int64(1);pointer<=int64(treeHead.NodeOrder);pointer++ {
+			nodeBytes,err := treeStorage.GetNode(columnKey, pointer); if err != nil {
+				panic(err.Error())
+			}
+			node,err := tree.DecodeTreeNode(nodeBytes); if err != nil {
+				panic(err.Error())
+			}
+			nodeBytes,err = util.ConvertJsonBytes(*node); if err != nil {
+				panic(err.Error())
+			}
+			if err := treeStorage.PutNode(columnKey, pointer, nodeBytes); err != nil {
+				panic(err.Error())
+			}
+		}
+		checkpoint,batches := db.TableMigrateCheckpoint{},0
+		for !checkpoint.Done {
+			result,err := databaseImpl.MigrateTableData(tableID, checkpoint,2); if err != nil {
+				panic(err.Error())
+			}
+			checkpoint = *result
+			batches++
+		}
+		assert.True(t, batches > 1, "migrate batch error")
+		assert.EqualValues(t, treeHead.NodeOrder, checkpoint.Keys, "migrate keys error")
+		for pointer:=int64(1);pointer<=int64(treeHead.NodeOrder);pointer++ {
+			nodeBytes,err := treeStorage.GetNode(columnKey, pointer); if err != nil {
+				panic(err.Error())
+			}
+			assert.False(t, util.IsJsonEncode(nodeBytes), "migrate node encode error")
+		}
+		rowData,err := databaseImpl.QueryRowData(migrateTable, db.RowID(150)); if err != nil {
+			panic(err.Error())
+		}
+		assert.Equal(t, "m149", string(rowData.Columns[1].Data), "migrate row data error")
+	}
+	//保留策略压缩
+	{
+		compactTable := &db.TableData{Name:"TestCompactTable",
+			Columns:[]db.Column{
+				{Id:db.ColumnID(1),ColumnConfig:db.ColumnConfig{Name:"id",Type:db.INT,NotNull:true},Order:1},
+				{Id:db.ColumnID(2),ColumnConfig:db.ColumnConfig{Name:"name",Type:db.VARCHAR},Order:2},
+			},
+			PrimaryKey:db.PrimaryKey{ColumnID:db.ColumnID(1),AutoIncrement:true},
+			Retention:db.RetentionConfig{KeepVersions:-1}}
+		_,err := databaseImpl.CreateTableData(compactTable)
+		assert.NotNil(t, err, "negative retention error")
+		compactTable.Retention = db.RetentionConfig{}
+		tableID,err := databaseImpl.CreateTableData(compactTable); if err != nil {
+			panic(err.Error())
+		}
+		compactTable.Id = tableID
+		if err := databaseImpl.AddRowData(compactTable, []*row.RowData{newRowData(db.ADD, 0, "a"),newRowData(db.ADD, 0, "b")}); err != nil {
+			panic(err.Error())
+		}
+		for i:=0;i<5;i++ {
+			if err := databaseImpl.AddRowData(compactTable, []*row.RowData{newRowData(db.UPDATE, 1, fmt.Sprintf("a%d", i))}); err != nil {
+				panic(err.Error())
+			}
+		}
+		_,err = databaseImpl.CompactTable(tableID, db.CompactCheckpoint{},0)
+		assert.NotNil(t, err, "compact without retention error")
+		compactTable.Retention = db.RetentionConfig{KeepVersions:2}
+		if err := databaseImpl.UpdateTableData(compactTable); err != nil {
+			panic(err.Error())
+		}
+		checkpoint,err := databaseImpl.CompactTable(tableID, db.CompactCheckpoint{},0); if err != nil {
+			panic(err.Error())
+		}
+		assert.True(t, checkpoint.Done, "compact done error")
+		assert.EqualValues(t, 4, checkpoint.Versions, "compact versions error")
+		histories,total,err := databaseImpl.QueryRowDataHistoryByRange(compactTable, db.RowID(1), db.DESC,10); if err != nil {
+			panic(err.Error())
+		}
+		assert.EqualValues(t, 2, total, "compact history total error")
+		assert.Equal(t, "a4", string(histories[0].Row.Columns[1].Data), "compact history data error")
+		assert.EqualValues(t, db.ADD, histories[1].Row.Op, "compact first version op error")
+		tally,err := databaseImpl.GetTableTally(tableID); if err != nil {
+			panic(err.Error())
+		}
+		assert.EqualValues(t, 2, tally.AddRow, "compact tally add error")
+		assert.EqualValues(t, 1, tally.UpdateRow, "compact tally update error")
+		check,err := databaseImpl.CheckTable(tableID); if err != nil {
+			panic(err.Error())
+		}
+		assert.Empty(t, check.Issues, "compact check issues error")
+	}
+	//大字段引用计数，压缩和删除表时清除无引用的分片
+	{
+		refTable := &db.TableData{Name:"TestBlobRefTable",
+			Columns:[]db.Column{
+				{Id:db.ColumnID(1),ColumnConfig:db.ColumnConfig{Name:"id",Type:db.INT,NotNull:true},Order:1},
+				{Id:db.ColumnID(2),ColumnConfig:db.ColumnConfig{Name:"data",Type:db.BLOB},Order:2},
+			},
+			PrimaryKey:db.PrimaryKey{ColumnID:db.ColumnID(1)}}
+		tableID,err := databaseImpl.CreateTableData(refTable); if err != nil {
+			panic(err.Error())
+		}
+		refTable.Id = tableID
+		blobStorage := storage.NewBlobStorage(state)
+		putBlob := func() ([]byte,string) {
+			value := make([]byte, db.BlobChunkSize+10)
+			rand.Read(value)
+			data,err := databaseImpl.PutBlobData(value); if err != nil {
+				panic(err.Error())
+			}
+			reference,err := util.DecodeBlobReference(data); if err != nil {
+				panic(err.Error())
+			}
+			return data,hex.EncodeToString(reference.Hash)
+		}
+		refCount := func(key string) int64 {
+			value,err := blobStorage.GetChunkRef(database.Id, key); if err != nil {
+				panic(err.Error())
+			}
+			return util.BytesToInt64(value)
+		}
+		exists := func(key string) bool {
+			chunk,err := blobStorage.GetChunkData(database.Id, key, 1); if err != nil {
+				panic(err.Error())
+			}
+			return len(chunk) > 0
+		}
+		first,firstKey := putBlob()
+		second,secondKey := putBlob()
+		third,thirdKey := putBlob()
+		assert.EqualValues(t, 0, refCount(firstKey), "blob new ref error")
+		//旧版本写入的分片没有引用计数
+		legacyValue := []byte("legacy")
+		legacyHash := sha256.Sum256(legacyValue)
+		legacyKey := hex.EncodeToString(legacyHash[:])
+		if err := blobStorage.PutChunkData(database.Id, legacyKey, 0, legacyValue); err != nil {
+			panic(err.Error())
+		}
+		legacy := util.EncodeBlobReference(&db.BlobReference{Hash:legacyHash[:],Length:int64(len(legacyValue))})
+		if err := databaseImpl.AddRowData(refTable, []*row.RowData{newRowData(db.ADD, 1, first),newRowData(db.ADD, 2, first),newRowData(db.ADD, 3, legacy)}); err != nil {
+			panic(err.Error())
+		}
+		assert.EqualValues(t, 2, refCount(firstKey), "blob shared ref error")
+		if err := databaseImpl.AddRowData(refTable, []*row.RowData{newRowData(db.UPDATE, 1, second)}); err != nil {
+			panic(err.Error())
+		}
+		refTable.Retention = db.RetentionConfig{KeepVersions:1}
+		if err := databaseImpl.UpdateTableData(refTable); err != nil {
+			panic(err.Error())
+		}
+		//行1旧版本清除，行2仍引用
+		if _,err := databaseImpl.CompactTable(tableID, db.CompactCheckpoint{},0); err != nil {
+			panic(err.Error())
+		}
+		assert.EqualValues(t, 1, refCount(firstKey), "blob compact ref error")
+		assert.True(t, exists(firstKey), "blob referenced chunk error")
+		if err := databaseImpl.AddRowData(refTable, []*row.RowData{newRowData(db.UPDATE, 2, third)}); err != nil {
+			panic(err.Error())
+		}
+		if _,err := databaseImpl.CompactTable(tableID, db.CompactCheckpoint{},0); err != nil {
+			panic(err.Error())
+		}
+		assert.False(t, exists(firstKey), "blob unreferenced chunk error")
+		assert.EqualValues(t, 0, refCount(firstKey), "blob unreferenced ref error")
+		_,err = databaseImpl.GetBlobData(first)
+		assert.NotNil(t, err, "blob purged data error")
+		//保留的修改版本转换为新增时复制到新块，原块保留，两个块中的引用都计数
+		assert.EqualValues(t, 2, refCount(secondKey), "blob kept ref error")
+		assert.EqualValues(t, 2, refCount(thirdKey), "blob kept ref error")
+		blob,err := databaseImpl.GetBlobData(third); if err != nil {
+			panic(err.Error())
+		}
+		assert.Len(t, blob, db.BlobChunkSize+10, "blob kept data error")
+		//删除表分批释放引用
+		if err := databaseImpl.DeleteTableData(tableID); err != nil {
+			panic(err.Error())
+		}
+		drop := &db.TableDrop{}
+		batches := 0
+		for !drop.Done {
+			drop,err = databaseImpl.DropTable(tableID,1); if err != nil {
+				panic(err.Error())
+			}
+			batches++
+		}
+		assert.True(t, drop.Released, "drop blob released error")
+		assert.True(t, batches > 2, "drop blob batches error")
+		assert.False(t, exists(secondKey), "drop blob chunk error")
+		assert.False(t, exists(thirdKey), "drop blob chunk error")
+		_,err = databaseImpl.GetBlobData(second)
+		assert.NotNil(t, err, "drop blob data error")
+		chunk,err := blobStorage.GetChunkData(database.Id, legacyKey, 0); if err != nil {
+			panic(err.Error())
+		}
+		assert.Equal(t, legacyValue, chunk, "legacy blob chunk error")
+	}
+	//行数量与索引统计
+	{
+		countTable := &db.TableData{Name:"TestCountTable",
+			Columns:[]db.Column{
+				{Id:db.ColumnID(1),ColumnConfig:db.ColumnConfig{Name:"id",Type:db.INT,NotNull:true},Order:1},
+				{Id:db.ColumnID(2),ColumnConfig:db.ColumnConfig{Name:"name",Type:db.VARCHAR},Order:2},
+			},
+			PrimaryKey:db.PrimaryKey{ColumnID:db.ColumnID(1),AutoIncrement:true}}
+		tableID,err := databaseImpl.CreateTableData(countTable); if err != nil {
+			panic(err.Error())
+		}
+		countTable.Id = tableID
+		for i:=0;i<10;i++ {
+			if err := databaseImpl.AddRowData(countTable, []*row.RowData{newRowData(db.ADD, 0, fmt.Sprintf("c%d", i))}); err != nil {
+				panic(err.Error())
+			}
+		}
+		if err := databaseImpl.AddRowData(countTable, []*row.RowData{newRowData(db.DELETE, 3),newRowData(db.DELETE, 4)}); err != nil {
+			panic(err.Error())
+		}
+		//删除后重新新增，同一批次重复删除只计算一次
+		if err := databaseImpl.AddRowData(countTable, []*row.RowData{newRowData(db.ADD, 3, "r3")}); err != nil {
+			panic(err.Error())
+		}
+		if err := databaseImpl.AddRowData(countTable, []*row.RowData{newRowData(db.DELETE, 5),newRowData(db.DELETE, 5)}); err != nil {
+			panic(err.Error())
+		}
+		tally,err := databaseImpl.GetTableTally(tableID); if err != nil {
+			panic(err.Error())
+		}
+		assert.EqualValues(t, 8, tally.LiveRow, "tally live rows error")
+		count,err := databaseImpl.QueryRowCountByRange(countTable, db.RowID(0), db.RowID(0)); if err != nil {
+			panic(err.Error())
+		}
+		assert.EqualValues(t, 8, count, "row count error")
+		count,err = databaseImpl.QueryRowCountByRange(countTable, db.RowID(6), db.RowID(2)); if err != nil {
+			panic(err.Error())
+		}
+		assert.EqualValues(t, 3, count, "row range count error")
+		count,err = databaseImpl.QueryRowCountByRange(countTable, db.RowID(4), db.RowID(0)); if err != nil {
+			panic(err.Error())
+		}
+		assert.EqualValues(t, 5, count, "row count from start error")
+		check,err := databaseImpl.CheckTable(tableID); if err != nil {
+			panic(err.Error())
+		}
+		assert.Len(t, check.Issues, 0, "check live rows error")
+		stats,err := databaseImpl.QueryIndexStats(tableID, db.ColumnID(1)); if err != nil {
+			panic(err.Error())
+		}
+		assert.EqualValues(t, 10, stats.LeafKeys, "index stats keys error")
+		assert.EqualValues(t, 14, stats.Versions, "index stats versions error")
+		assert.EqualValues(t, 3, stats.MaxVersions, "index stats max versions error")
+		assert.InDelta(t, 1.4, stats.AvgVersions, 0.001, "index stats avg versions error")
+		assert.True(t, stats.Height > 0 && stats.Nodes > 0 && stats.Keys >= 10, "index stats head error")
+		//旧统计没有行数量，读取时按新增与删除次数计算
+		value,err := util.ConvertJsonBytes(db.TableTally{TableID:tableID,AddRow:5,DelRow:2}); if err != nil {
+			panic(err.Error())
+		}
+		legacy := &db.TableTally{}
+		if err := databaseImpl.unmarshalTableTally([]byte(strings.Replace(string(value), `"liveRow":0,`, "", 1)), legacy); err != nil {
+			panic(err.Error())
+		}
+		assert.EqualValues(t, 3, legacy.LiveRow, "legacy tally live rows error")
+		//统计行数量错误时检查修复
+		tally.LiveRow = 6
+		if err := databaseImpl.putTableTallyShard(countTable, tally); err != nil {
+			panic(err.Error())
+		}
+		check,err = databaseImpl.CheckTable(tableID); if err != nil {
+			panic(err.Error())
+		}
+		assert.Len(t, check.Issues, 1, "check live rows issue error")
+		if _,err := databaseImpl.RepairTable(tableID, check.Repairs()); err != nil {
+			panic(err.Error())
+		}
+		tally,err = databaseImpl.GetTableTally(tableID); if err != nil {
+			panic(err.Error())
+		}
+		assert.EqualValues(t, 8, tally.LiveRow, "repair live rows error")
+	}
+	//物化计数器，分组区间只读取已提交的Key，使用LevelDB状态分事务提交
+	{
+		source,err := leveldb.NewMemLevelDBState(); if err != nil {
+			panic(err.Error())
+		}
+		defer source.Close()
+		source.Begin("tx1", time.Now())
+		databaseImpl := NewDatabaseImpl(&db.DataBase{Id:db.DatabaseID(1),Relation:&db.Relation{}}, source)
+		counterTable := &db.TableData{Name:"TestCounterTable",
+			Columns:[]db.Column{
+				{Id:db.ColumnID(1),ColumnConfig:db.ColumnConfig{Name:"id",Type:db.INT,NotNull:true},Order:1},
+				{Id:db.ColumnID(2),ColumnConfig:db.ColumnConfig{Name:"user",Type:db.INT},Order:2},
+				{Id:db.ColumnID(3),ColumnConfig:db.ColumnConfig{Name:"amount",Type:db.DECIMAL},Order:3},
+				{Id:db.ColumnID(4),ColumnConfig:db.ColumnConfig{Name:"qty",Type:db.INT},Order:4},
+			},
+			PrimaryKey:db.PrimaryKey{ColumnID:db.ColumnID(1),AutoIncrement:true},
+			Counters:[]db.CounterConfig{{Name:"userAmount",Column:db.ColumnID(3),GroupBy:db.ColumnID(2)},{Name:"rows"},{Name:"userQty",Column:db.ColumnID(4),GroupBy:db.ColumnID(2)}}}
+		invalid := *counterTable
+		invalid.Name = "TestCounterInvalid"
+		invalid.Counters = []db.CounterConfig{{Name:"amountGroup",GroupBy:db.ColumnID(3)}}
+		_,err = databaseImpl.CreateTableData(&invalid)
+		assert.NotNil(t, err, "counter group by type error")
+		tableID,err := databaseImpl.CreateTableData(counterTable); if err != nil {
+			panic(err.Error())
+		}
+		rows := []*row.RowData{newRowData(db.ADD, 0, 1, "1.5", 1),newRowData(db.ADD, 0, 2, "2.25", 2),newRowData(db.ADD, 0, 1, "0.25", 3),
+			newRowData(db.ADD, 0, -1, "4", 4),newRowData(db.ADD, 0, 2, nil, 5),newRowData(db.ADD, 0, 1, "1", 6)}
+		if err := databaseImpl.AddRowData(counterTable, rows); err != nil {
+			panic(err.Error())
+		}
+		//修改分组列时行移动到新分组，删除的行从分组减去
+		if err := databaseImpl.AddRowData(counterTable, []*row.RowData{newRowData(db.UPDATE, 3, 2, "0.75", 3)}); err != nil {
+			panic(err.Error())
+		}
+		if err := databaseImpl.AddRowData(counterTable, []*row.RowData{newRowData(db.UPDATE, 1, 1, "2", 1)}); err != nil {
+			panic(err.Error())
+		}
+		if err := databaseImpl.AddRowData(counterTable, []*row.RowData{newRowData(db.DELETE, 6)}); err != nil {
+			panic(err.Error())
+		}
+		if _,err := source.Commit(); err != nil {
+			panic(err.Error())
+		}
+		source.Begin("tx2", time.Now())
+		value,err := databaseImpl.QueryCounter(tableID, "userAmount", 1); if err != nil {
+			panic(err.Error())
+		}
+		assert.EqualValues(t, 1, value.Count, "counter count error")
+		assert.Equal(t, "2", value.Sum, "counter sum error")
+		value,err = databaseImpl.QueryCounter(tableID, "userAmount", 2); if err != nil {
+			panic(err.Error())
+		}
+		assert.EqualValues(t, 3, value.Count, "counter moved count error")
+		assert.Equal(t, "3", value.Sum, "counter moved sum error")
+		value,err = databaseImpl.QueryCounter(tableID, "userQty", 2); if err != nil {
+			panic(err.Error())
+		}
+		assert.Equal(t, "10", value.Sum, "counter int sum error")
+		value,err = databaseImpl.QueryCounter(tableID, "rows", 0); if err != nil {
+			panic(err.Error())
+		}
+		assert.EqualValues(t, 5, value.Count, "counter rows error")
+		assert.Equal(t, "", value.Sum, "counter rows sum error")
+		value,err = databaseImpl.QueryCounter(tableID, "userAmount", 9); if err != nil {
+			panic(err.Error())
+		}
+		assert.EqualValues(t, 0, value.Count, "counter empty group error")
+		assert.Equal(t, "0", value.Sum, "counter empty sum error")
+		_,err = databaseImpl.QueryCounter(tableID, "unknown", 0)
+		assert.NotNil(t, err, "counter name error")
+		//分组值区间按数值顺序返回
+		values,err := databaseImpl.QueryCounterByRange(tableID, "userAmount", 5, -5, 10); if err != nil {
+			panic(err.Error())
+		}
+		assert.Len(t, values, 3, "counter range error")
+		assert.EqualValues(t, []int64{-1,1,2}, []int64{values[0].Group,values[1].Group,values[2].Group}, "counter range order error")
+		assert.Equal(t, "4", values[0].Sum, "counter range sum error")
+		values,err = databaseImpl.QueryCounterByRange(tableID, "userAmount", 1, 2, 1); if err != nil {
+			panic(err.Error())
+		}
+		assert.Len(t, values, 1, "counter range size error")
+		//分组数量为0时删除
+		if err := databaseImpl.AddRowData(counterTable, []*row.RowData{newRowData(db.DELETE, 4)}); err != nil {
+			panic(err.Error())
+		}
+		if _,err := source.Commit(); err != nil {
+			panic(err.Error())
+		}
+		source.Begin("tx3", time.Now())
+		values,err = databaseImpl.QueryCounterByRange(tableID, "userAmount", -5, 5, 10); if err != nil {
+			panic(err.Error())
+		}
+		assert.Len(t, values, 2, "counter range deleted group error")
+		//计数器与块数据不一致时检查并重新计算修复
+		check,err := databaseImpl.CheckTable(tableID); if err != nil {
+			panic(err.Error())
+		}
+		assert.Empty(t, check.Issues, "counter check error")
+		blockStorage := storage.NewBlockStorage(source)
+		if err := blockStorage.PutCounter(database.Id, tableID, 0, 1, []byte(`{"group":1,"count":3,"sum":"7"}`)); err != nil {
+			panic(err.Error())
+		}
+		if err := blockStorage.PutCounter(database.Id, tableID, 0, 7, []byte(`{"group":7,"count":1,"sum":"1"}`)); err != nil {
+			panic(err.Error())
+		}
+		if _,err := source.Commit(); err != nil {
+			panic(err.Error())
+		}
+		source.Begin("tx4", time.Now())
+		check,err = databaseImpl.CheckTable(tableID); if err != nil {
+			panic(err.Error())
+		}
+		assert.Len(t, check.Issues, 2, "counter check issues error")
+		assert.Len(t, check.Counters, 2, "counter check groups error")
+		repairs := check.Repairs()
+		assert.Len(t, repairs, 1, "counter repairs error")
+		assert.Equal(t, db.RepairCounter, repairs[0].Type, "counter repair type error")
+		if _,err := databaseImpl.RepairTable(tableID, repairs); err != nil {
+			panic(err.Error())
+		}
+		if _,err := source.Commit(); err != nil {
+			panic(err.Error())
+		}
+		source.Begin("tx5", time.Now())
+		value,err = databaseImpl.QueryCounter(tableID, "userAmount", 1); if err != nil {
+			panic(err.Error())
+		}
+		assert.EqualValues(t, 1, value.Count, "counter repair count error")
+		assert.Equal(t, "2", value.Sum, "counter repair sum error")
+		value,err = databaseImpl.QueryCounter(tableID, "userAmount", 7); if err != nil {
+			panic(err.Error())
+		}
+		assert.EqualValues(t, 0, value.Count, "counter repair stale group error")
+		check,err = databaseImpl.CheckTable(tableID); if err != nil {
+			panic(err.Error())
+		}
+		assert.Empty(t, check.Issues, "counter check after repair error")
+		//计数器创建后不可修改
+		updateTable,err := databaseImpl.QueryTableDataByID(tableID); if err != nil {
+			panic(err.Error())
+		}
+		updateTable.Counters = updateTable.Counters[:1]
+		assert.NotNil(t, databaseImpl.UpdateTableData(updateTable), "counter modify error")
+	}
+}
+
+/**
+	构造行数据，主键列为空(行ID在RowData.Id中)，int值按整数编码，string值按字节写入，nil为空值
+ */
+func newRowData(op db.OpType, rowID db.RowID, values ...interface{}) *row.RowData {
+	rowData := &row.RowData{Id:rowID,Op:uint32(op),Columns:[]*row.ColumnData{{}}}
+	for _,value := range values {
+		columnData := &row.ColumnData{}
+		switch v := value.(type) {
+		case int:
+			columnData.Data = util.Int64ToBytes(int64(v))
+		case string:
+			columnData.Data = []byte(v)
+		case []byte:
+			columnData.Data = v
+		}
+		rowData.Columns = append(rowData.Columns, columnData)
+	}
+	return rowData
+}
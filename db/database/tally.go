@@ -0,0 +1,99 @@
+package database
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/database-fabric/db"
+	"github.com/database-fabric/db/util"
+	"hash/fnv"
+	"math"
+)
+
+/**
+	按事务ID选择统计分片，同一事务内始终落在同一个分片
+ */
+func TallyShard(txID string, shards int8) int8 {
+	if shards <= 1 {
+		return 0
+	}
+	h := fnv.New32a()
+	h.Write([]byte(txID))
+	return int8(h.Sum32() % uint32(shards))
+}
+
+func ValidateTallyShards(shards int8) error {
+	if shards < 0 || shards > db.MaxTallyShards {
+		return fmt.Errorf("tally shards must between 0 and %d", db.MaxTallyShards)
+	}
+	return nil
+}
+
+/**
+	旧统计没有记录行数量，使用新增与删除次数之差初始化
+ */
+func (service *DatabaseImpl) unmarshalTableTally(value []byte, tally *db.TableTally) error {
+	if len(value) == 0 {
+		return nil
+	}
+	tally.LiveRow = math.MinInt64
+	if err := json.Unmarshal(value, tally); err != nil {
+		return err
+	}
+	if tally.LiveRow == math.MinInt64 {
+		tally.LiveRow = tally.AddRow - tally.DelRow
+	}
+	return nil
+}
+
+/**
+	读取分片统计，分片不存在时初始化块ID起始位置
+ */
+func (service *DatabaseImpl) getTableTallyShard(table *db.TableData, shard int8) (*db.TableTally,error) {
+	if table.TallyShards <= 1 {
+		value,err := service.storage.GetTableTally(service.database.Id, table.Id); if err != nil {
+			return nil,err
+		}
+		tally := &db.TableTally{TableID:table.Id}
+		return tally,service.unmarshalTableTally(value, tally)
+	}
+	value,err := service.storage.GetTableTallyShard(service.database.Id, table.Id, shard); if err != nil {
+		return nil,err
+	}
+	tally := &db.TableTally{TableID:table.Id,Shard:shard,Block:db.BlockID(shard)<<db.TallyShardBlockBits}
+	return tally,service.unmarshalTableTally(value, tally)
+}
+
+func (service *DatabaseImpl) putTableTallyShard(table *db.TableData, tally *db.TableTally) error {
+	value,err := util.ConvertJsonBytes(*tally); if err != nil {
+		return err
+	}
+	if table.TallyShards <= 1 {
+		return service.storage.PutTableTally(service.database.Id, table.Id, value)
+	}
+	return service.storage.PutTableTallyShard(service.database.Id, table.Id, tally.Shard, value)
+}
+
+/**
+	汇总所有分片统计(只读，仅用于查询，写入路径只读写当前事务所在分片)
+	Increment取各分片最大值，Block为所有分片已使用块数量
+ */
+func (service *DatabaseImpl) sumTableTally(table *db.TableData) (*db.TableTally,error) {
+	if table.TallyShards <= 1 {
+		return service.getTableTallyShard(table, 0)
+	}
+	sum := &db.TableTally{TableID:table.Id}
+	for shard := int8(0); shard < table.TallyShards; shard++ {
+		tally,err := service.getTableTallyShard(table, shard); if err != nil {
+			return nil,err
+		}
+		sum.AddRow += tally.AddRow
+		sum.UpdateRow += tally.UpdateRow
+		sum.DelRow += tally.DelRow
+		sum.LiveRow += tally.LiveRow
+		sum.Block += tally.Block - db.BlockID(shard)<<db.TallyShardBlockBits
+		if tally.Increment > sum.Increment {
+			sum.Increment = tally.Increment
+		}
+	}
+	return sum,nil
+}
@@ -13,6 +13,20 @@ const (
 	RelationKeyType
 	BlockKeyType
 	IndexKeyType
+	MerkleKeyType
+	ChunkKeyType
+	CompactKeyType
+	DropKeyType
+	LayoutKeyType
+	CounterKeyType
+	ChunkRefKeyType
+)
+
+//Key布局版本，记录在链Key布局中
+type KeyLayout = uint8
+const (
+	KeyLayoutDecimal KeyLayout = iota //Key中的整数为十进制字符串，字符串顺序与数值顺序不一致
+	KeyLayoutOrdered //Key中的整数为保序编码，区间查询按数值顺序返回
 )
 
 type IndexType = uint8
@@ -40,15 +54,34 @@ const (
 	DECIMAL
 	VARCHAR
 	BOOL
+	BLOB //大字段二进制，json使用base64字符串，值存储在块外
+	TEXT //大字段文本，值存储在块外
 )
 
+//大字段值按内容哈希分片存储在块外，行中只保存引用
+const BlobChunkSize = 64*1024
+
+//大字段引用，列数据中保存编码后的引用
+type BlobReference struct {
+	Hash []byte `json:"hash"` //值sha256哈希
+	Length int64 `json:"length"` //值长度
+}
+
+func IsBlobType(dataType DataType) bool {
+	return dataType == BLOB || dataType == TEXT
+}
+
 type OpType = uint8
 const (
 	ADD OpType = iota
 	UPDATE
 	DELETE
+	UPSERT //行存在时修改，否则新增，只用于写入操作，存储时转换为ADD或UPDATE
 )
 
+//增量行最大层数，修改行时达到该层数写入完整行
+const MaxDeltaDepth = 8
+
 type StateType = uint8
 const (
 	SetState StateType = iota
@@ -78,6 +111,12 @@ type RowBlockID struct {
 	RowID RowID
 	BlockID BlockID
 }
+//行当前版本
+type RowVersion struct {
+	BlockID BlockID `json:"blockID"` //最新版本块ID，为0时行不存在
+	Op OpType `json:"op"` //最新版本操作类型
+	Version Total `json:"version"` //版本数
+}
 type RowDataHistory struct {
 	TxID string `json:"txID"` //事务ID
 	Time int64 `json:"time"` //事务时间戳
@@ -104,6 +143,7 @@ type ColumnKey struct {
 	Database DatabaseID `json:"database"`
 	Table TableID `json:"table"`
 	Column ColumnID `json:"column"`
+	Shard int8 `json:"shard,omitempty"` //主键索引分片，统计分片表的主键索引按行ID分为多棵树，分片0沿用原索引Key
 }
 
 //列键下行键数据
@@ -112,13 +152,270 @@ type ColumnRowKey struct {
 	Row RowID `json:"row"`
 }
 
+//统计分片，分片数为0时表使用单个统计Key，否则每个事务按事务ID选择一个分片，避免并发事务写同一个Key
+//分片记录块ID高位为分片号，低位为分片内递增序号，保证分片内块ID连续
+const (
+	MaxTallyShards int8 = 64
+	TallyShardBlockBits = 24
+)
+
 type TableTally struct {
 	TableID TableID `json:"tableID"`
+	Shard int8 `json:"shard"` //统计分片号，汇总统计时为0
 	Increment RowID `json:"increment"`
 	AddRow RowID `json:"addRow"`
 	UpdateRow RowID `json:"updateRow"`
 	DelRow RowID `json:"delRow"`
+	LiveRow RowID `json:"liveRow"` //未删除的行数量，按主键索引中行最新版本的变化累加(分片的值可能为负数，汇总后为表的行数)
 	Block BlockID `json:"block"`
+	BlockHash []byte `json:"blockHash"` //最后一个块的校验和，下一个块的PrevHash
+	MerkleStart BlockID `json:"merkleStart"` //累加器第一个叶子的前一个块ID(之前的旧块不在累加器中)
+	MerklePeaks [][]byte `json:"merklePeaks"` //块哈希累加器山峰
+	MerkleRoot []byte `json:"merkleRoot"` //块哈希累加器根
+}
+
+//表数据块校验结果
+type TableVerify struct {
+	TableID TableID `json:"tableID"`
+	Blocks BlockID `json:"blocks"` //校验的块数量
+	Legacy BlockID `json:"legacy"` //没有校验和的旧块数量
+	Purged BlockID `json:"purged"` //压缩时删除的块数量(使用压缩记录中的校验和连接)
+	Breaks []BlockBreak `json:"breaks"` //校验失败的块
+}
+
+type BlockBreak struct {
+	BlockID BlockID `json:"blockID"`
+	Error string `json:"error"`
+}
+
+//表一致性检查结果，Issues为空表示索引、块和统计一致
+type TableCheck struct {
+	TableID TableID `json:"tableID"`
+	Blocks BlockID `json:"blocks"` //检查的块数量
+	Purged BlockID `json:"purged"` //压缩时删除的块数量
+	Rows Total `json:"rows"` //块中的行版本数量
+	Indexes []IndexCheck `json:"indexes"`
+	Issues []CheckIssue `json:"issues"`
+	Tallies []*TableTally `json:"tallies"` //按块数据重新计算的统计(分片)
+	Counters []CounterGroup `json:"counters,omitempty"` //与存储不一致的计数器分组，按未删除行的最新版本重新计算，数量为0时删除分组
+}
+
+//索引检查统计
+type IndexCheck struct {
+	Column ColumnID `json:"column"`
+	Shard int8 `json:"shard,omitempty"` //主键索引分片
+	Height int8 `json:"height"`
+	Nodes int32 `json:"nodes"` //可达的树节点数量
+	Keys Total `json:"keys"` //叶子节点关键字数量
+	Values Total `json:"values"` //关键字值数量(集合和链表展开)
+	Linked Total `json:"linked"` //链表数量
+}
+
+//索引统计，高度、节点数量和关键字数量(包括非叶子节点)来自树头，版本按叶子节点关键字值统计
+type IndexStats struct {
+	Column ColumnID `json:"column"`
+	Height int8 `json:"height"`
+	Nodes int32 `json:"nodes"`
+	Keys int64 `json:"keys"`
+	LeafKeys Total `json:"leafKeys"` //叶子节点关键字数量
+	Versions Total `json:"versions"` //关键字值数量(集合和链表展开)
+	AvgVersions float64 `json:"avgVersions"` //每个关键字平均版本数
+	MaxVersions Total `json:"maxVersions"` //单个关键字最大版本数
+}
+
+//检查问题类型
+type CheckKind = string
+const (
+	CheckKindTree CheckKind = "tree"
+	CheckKindLinked CheckKind = "linked"
+	CheckKindBlock CheckKind = "block"
+	CheckKindTally CheckKind = "tally"
+	CheckKindPrimaryKey CheckKind = "primaryKey"
+	CheckKindForeignKey CheckKind = "foreignKey"
+	CheckKindCounter CheckKind = "counter"
+)
+
+//修复方式，为空时需要人工处理
+type RepairType = string
+const (
+	RepairNone RepairType = ""
+	RepairRebuildIndex RepairType = "rebuildIndex" //按块数据重建列索引
+	RepairTally RepairType = "tally" //使用重新计算的统计
+	RepairCounter RepairType = "counter" //使用重新计算的计数器分组值
+)
+
+type CheckIssue struct {
+	Kind CheckKind `json:"kind"`
+	Column ColumnID `json:"column,omitempty"`
+	Pointer int32 `json:"pointer,omitempty"` //树或链表节点指针
+	Row RowID `json:"row,omitempty"`
+	Block BlockID `json:"block,omitempty"`
+	Shard int8 `json:"shard,omitempty"`
+	Error string `json:"error"`
+	Repair RepairType `json:"repair,omitempty"`
+}
+
+//修复操作，同一修复对象只出现一次
+type TableRepair struct {
+	Type RepairType `json:"type"`
+	Column ColumnID `json:"column,omitempty"`
+	Tally *TableTally `json:"tally,omitempty"`
+	Checkpoint *RebuildCheckpoint `json:"checkpoint,omitempty"` //重建索引位置，未完成时使用该位置继续修复
+	Counters []CounterGroup `json:"counters,omitempty"`
+}
+
+/**
+	检查结果转换为修复操作
+ */
+func (check *TableCheck) Repairs() []TableRepair {
+	repairs := []TableRepair{}
+	columns := map[ColumnID]bool{}
+	shards := map[int8]bool{}
+	counters := false
+	for _,issue := range check.Issues {
+		switch issue.Repair {
+		case RepairRebuildIndex:
+			if !columns[issue.Column] {
+				columns[issue.Column] = true
+				repairs = append(repairs, TableRepair{Type:RepairRebuildIndex,Column:issue.Column})
+			}
+		case RepairTally:
+			if !shards[issue.Shard] {
+				shards[issue.Shard] = true
+				for _,tally := range check.Tallies {
+					if tally.Shard == issue.Shard {
+						repairs = append(repairs, TableRepair{Type:RepairTally,Tally:tally})
+					}
+				}
+			}
+		case RepairCounter:
+			if !counters {
+				counters = true
+				repairs = append(repairs, TableRepair{Type:RepairCounter,Counters:check.Counters})
+			}
+		}
+	}
+	return repairs
+}
+
+//索引重建位置，重建分批在多个事务中执行，先清除旧索引再按块ID顺序重放块，每批返回下一批的位置
+type RebuildCheckpoint struct {
+	Checked bool `json:"checked,omitempty"` //分片表已检查行版本在分片之间的顺序可以恢复(只检查主键索引)
+	Lane int8 `json:"lane,omitempty"` //正在清除的主键索引分片
+	Node int32 `json:"node"` //已清除的旧索引树节点指针
+	Discarded bool `json:"discarded"` //旧索引已清除
+	Shards []BlockID `json:"shards"` //各统计分片已重放的最后一个块ID，按分片号排列
+	Blocks BlockID `json:"blocks"` //已重放的块数量
+	Done bool `json:"done"`
+}
+
+//每批重建默认处理的树节点和块数量
+const DefaultRebuildBatchSize = 256
+
+//表数据保留策略，0值不限制，启用的规则同时满足时才清除版本，行最新版本总是保留(已删除行除外)
+type RetentionConfig struct {
+	KeepVersions int32 `json:"keepVersions"` //保留每行最后N个版本
+	KeepSeconds int64 `json:"keepSeconds"` //保留最近N秒内写入的版本
+	PurgeDeletedBefore int64 `json:"purgeDeletedBefore"` //清除该时间戳(秒)之前删除的行的所有版本
+}
+
+func (retention RetentionConfig) IsEnabled() bool {
+	return retention.KeepVersions > 0 || retention.KeepSeconds > 0 || retention.PurgeDeletedBefore > 0
+}
+
+//物化计数器，写入行时按分组列的值增量维护未删除行的数量和求和列的合计
+//每个分组只有一个Key，不按统计分片拆分，写入同一分组的并发事务在提交校验时冲突(TallyShards不能减少该冲突)
+//计数器与块数据不一致时使用表一致性检查重新计算并修复
+type CounterConfig struct {
+	Name string `json:"name"`
+	Column ColumnID `json:"column"` //求和列(INT或DECIMAL)，为0时只计数，空值不参与求和
+	GroupBy ColumnID `json:"groupBy"` //分组列(INT)，为0时不分组，空值为分组0
+}
+
+//每个表最多的计数器数量，每行写入时更新每个计数器的分组Key
+const MaxCounters = 16
+
+//计数器一个分组的值
+type CounterValue struct {
+	Group int64 `json:"group"`
+	Count Total `json:"count"`
+	Sum string `json:"sum"` //decimal字符串，没有求和列时为空
+}
+
+//计数器名称与分组的值，用于检查修复
+type CounterGroup struct {
+	Counter string `json:"counter"`
+	CounterValue
+}
+
+//块压缩记录，块被压缩删除后保留校验和用于块连接校验，保留的行版本移动到新块
+type BlockCompact struct {
+	Purged bool `json:"purged"` //块已删除
+	Checksum []byte `json:"checksum,omitempty"` //删除块的校验和
+	PrevHash []byte `json:"prevHash,omitempty"` //删除块的上一个块校验和
+	Moved map[RowID]BlockID `json:"moved,omitempty"` //保留的行版本移动到的新块
+	Origins map[RowID]BlockID `json:"origins,omitempty"` //新块中行版本原来所在的块
+}
+
+/**
+	行版本移动前所在的块，记录为空时不存在
+ */
+func (record *BlockCompact) GetOrigin(rowID RowID) (BlockID,bool) {
+	if record == nil {
+		return 0,false
+	}
+	origin,ok := record.Origins[rowID]
+	return origin,ok
+}
+
+//压缩位置，压缩分批在多个事务中执行，每批返回下一批的位置
+type CompactCheckpoint struct {
+	Shards []BlockID `json:"shards"` //各统计分片已扫描的最后一个块ID，按分片号排列
+	Ends []BlockID `json:"ends"` //压缩开始时各统计分片的最后一个块ID，之后写入的块不压缩
+	Time int64 `json:"time"` //压缩开始时的事务时间戳，按该时间计算保留时间
+	Blocks BlockID `json:"blocks"` //已扫描的块数量
+	Purged BlockID `json:"purged"` //已删除的块数量
+	Versions Total `json:"versions"` //已清除的行版本数量
+	Moved Total `json:"moved"` //移动到新块的行版本数量
+	Skipped []BlockID `json:"skipped,omitempty"` //跳过的块ID(块中有不在主键索引中的版本，检查修复主键索引后重新压缩)
+	Done bool `json:"done"`
+}
+
+//每批压缩默认扫描的块数量
+const DefaultCompactBatchSize = 256
+
+//删除表的墓碑，表ID不再分配，表名可以重新创建为新的表ID，Key清除分批在多个事务中执行
+type TableDrop struct {
+	Name string `json:"name"` //删除前的表名
+	TxID string `json:"txID"` //删除表的事务ID
+	Time int64 `json:"time"` //删除表的事务时间戳
+	Released bool `json:"released,omitempty"` //块中的大字段引用已释放
+	Blobs []BlockID `json:"blobs,omitempty"` //各统计分片已释放大字段引用的最后一个块ID，按分片号排列
+	Ranges int32 `json:"ranges"` //已清除完成的Key区间数量
+	Keys Total `json:"keys"` //已删除的Key数量
+	Done bool `json:"done"`
+}
+
+//每批清除默认删除的Key数量
+const DefaultDropBatchSize = 256
+
+//Key布局迁移位置，迁移分批在多个事务中执行，每批返回下一批的位置
+type KeyMigrateCheckpoint struct {
+	Ranges int32 `json:"ranges"` //已迁移完成的Key类型区间数量
+	Keys Total `json:"keys"` //已迁移的Key数量
+	Done bool `json:"done"`
+}
+
+//每批迁移默认处理的Key数量
+const DefaultMigrateBatchSize = 256
+
+//表结构和索引编码迁移位置，迁移分批在多个事务中执行，每批返回下一批的位置
+type TableMigrateCheckpoint struct {
+	Table bool `json:"table"` //表结构已迁移
+	Index int32 `json:"index"` //已迁移完成的索引数量，主键索引各分片在前，外键索引在后
+	Node int32 `json:"node"` //正在迁移的索引下一个树节点指针
+	Keys Total `json:"keys"` //已重写的Key数量
+	Done bool `json:"done"`
 }
 
 type DataBase struct {
@@ -142,8 +439,43 @@ type TableData struct {
 	Columns []Column `json:"columns"`
 	PrimaryKey PrimaryKey `json:"primaryKey"`
 	ForeignKeys []ForeignKey `json:"foreignKeys"`
+	TallyShards int8 `json:"tallyShards"` //统计分片数，创建后不可修改
+	PrimaryShards int8 `json:"primaryShards"` //主键索引分片数，创建统计分片表时等于统计分片数，0或1时使用单个索引树
+	Storage StorageConfig `json:"storage"` //存储配置，创建后不可修改
+	Retention RetentionConfig `json:"retention"` //数据保留策略，压缩时按该策略清除行版本
+	Counters []CounterConfig `json:"counters"` //物化计数器，创建后不可修改
+}
+
+//表存储配置，0值使用默认配置
+type StorageConfig struct {
+	BlockSize int32 `json:"blockSize"` //数据块容量，大行数据的表可设置更大的块
+	NodeSize int32 `json:"nodeSize"` //索引节点容量(索引树节点和链表节点)
+	SplitRule int8 `json:"splitRule"` //索引节点分裂规则
+	TreeType int8 `json:"treeType"` //主键索引树类型，对应tree.TreeType
+	Compression int8 `json:"compression"` //数据块压缩方式
 }
 
+const (
+	DefaultBlockSize = 1024*4
+	MinBlockSize = 1024
+	MaxBlockSize = 1024*1024
+	DefaultNodeSize = 1024*4
+	MinNodeSize = 1024
+	MaxNodeSize = 1024*64
+)
+
+//数据块压缩方式，压缩结果必须确定(各节点背书结果一致)，因此只使用版本固定的纯Go实现
+const (
+	CompressionNone int8 = iota
+	CompressionSnappy
+)
+
+const (
+	SplitRuleDefault int8 = iota //跟随全局配置
+	SplitRuleKeyNum //关键字个数验证
+	SplitRuleSize //容量验证
+)
+
 type Column struct {
 	Id ColumnID `json:"id"`
 	ColumnConfig
@@ -197,10 +529,27 @@ type Schema struct {
 	Model Model `json:"model"`
 }
 
+//分页总数未统计(区间分页不扫描索引统计总数，使用QueryRowCount统计)
+const UnknownTotal = Total(-1)
+
 type Pagination struct {
 	PageSize int32 `json:"pageSize"`
-	Total Total `json:"total"`
+	Total Total `json:"total"` //总数，未统计时为UnknownTotal
 	List []JsonData `json:"list"`
+	Cursor string `json:"cursor,omitempty"` //下一页游标，为空时没有下一页
+}
+
+//分页游标，记录本页最后一个结果的位置，编码为不透明字符串返回，下一次查询传入后从该位置之后继续
+//区间查询：Node为索引树叶子节点指针，Position为节点中的下标，Key为关键字(节点分裂合并后按关键字重新定位)
+//历史查询：Node为链表节点指针(值集合时为0)，Position为节点中的下标，Index为写入顺序的值序号，Key为行关键字
+//Total为第一页统计的总数，之后的页使用游标中的总数，不再按索引重新统计区间行数量
+type Cursor struct {
+	Order OrderType
+	Node int64
+	Position int32
+	Index int64
+	Key []byte
+	Total Total
 }
 
 func (relationKey *RelationKey) Equal(key RelationKey) bool {
@@ -214,3 +563,45 @@ func (foreignKey *ForeignKey) Equal(key ForeignKey) bool {
 func (referenceKey *ReferenceKey) Equal(key ReferenceKey) bool {
 	return referenceKey.TableID == key.TableID && referenceKey.ColumnID == key.ColumnID
 }
+
+func (config *StorageConfig) GetBlockSize() int32 {
+	if config.BlockSize == 0 {
+		return DefaultBlockSize
+	}
+	return config.BlockSize
+}
+
+func (config *StorageConfig) GetNodeSize() int32 {
+	if config.NodeSize == 0 {
+		return DefaultNodeSize
+	}
+	return config.NodeSize
+}
+
+/**
+	行所在的主键索引分片，与统计分片的自增ID分配一致：分片i包含(ID-1)%分片数==i的行
+ */
+func (table *TableData) PrimaryShard(rowID RowID) int8 {
+	if table.PrimaryShards <= 1 || rowID <= 0 {
+		return 0
+	}
+	return int8((rowID-1)%RowID(table.PrimaryShards))
+}
+
+/**
+	主键索引列键，database为表所在数据库
+ */
+func (table *TableData) PrimaryColumnKey(database DatabaseID, rowID RowID) ColumnKey {
+	return ColumnKey{Database:database,Table:table.Id,Column:table.PrimaryKey.ColumnID,Shard:table.PrimaryShard(rowID)}
+}
+
+/**
+	主键索引所有分片的列键，按分片号排列
+ */
+func (table *TableData) PrimaryColumnKeys(database DatabaseID) []ColumnKey {
+	keys := []ColumnKey{{Database:database,Table:table.Id,Column:table.PrimaryKey.ColumnID}}
+	for shard := int8(1);shard < table.PrimaryShards;shard++ {
+		keys = append(keys, ColumnKey{Database:database,Table:table.Id,Column:table.PrimaryKey.ColumnID,Shard:shard})
+	}
+	return keys
+}
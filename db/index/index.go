@@ -1,6 +1,8 @@
 package index
 
 import (
+	"bytes"
+	"fmt"
 	"github.com/database-fabric/db"
 	"github.com/database-fabric/db/index/linkedlist"
 	"github.com/database-fabric/db/index/tree"
@@ -9,6 +11,8 @@ import (
 	"github.com/database-fabric/db/storage/state"
 	"github.com/database-fabric/db/util"
 	"github.com/database-fabric/protos/db/row"
+	"sort"
+	"strings"
 )
 
 type IndexService struct {
@@ -43,7 +47,7 @@ func (service *IndexService) getITree(primary bool) tree.TreeInterface {
 
 func (service *IndexService) getTreeHead(key db.ColumnKey) (*tree.TreeHead,error) {
 	var err error
-	name := util.DatabaseIDToString(key.Database)+"_"+util.TableIDToString(key.Table)+"_"+util.ColumnIDToString(key.Column)
+	name := treeName(key)
 	treeHead, ok := service.treeHeadMap[name]
 	if ok {
 		return treeHead,nil
@@ -55,6 +59,15 @@ func (service *IndexService) getTreeHead(key db.ColumnKey) (*tree.TreeHead,error
 	return treeHead,nil
 }
 
+//树头缓存名称，主键索引分片0沿用原名称
+func treeName(key db.ColumnKey) string {
+	name := util.DatabaseIDToString(key.Database)+"_"+util.TableIDToString(key.Table)+"_"+util.ColumnIDToString(key.Column)
+	if key.Shard > 0 {
+		name += "#"+util.Int64ToString(int64(key.Shard))
+	}
+	return name
+}
+
 ///////////////////// Linked Function //////////////////////
 
 func (service *IndexService) getILinked() linkedlist.LinkedListInterface {
@@ -78,10 +91,22 @@ func (service *IndexService) getLinkedHead(columnKey db.ColumnKey, rowID db.RowI
 
 ///////////////////// Common IndexData Function //////////////////////
 
-func (service *IndexService) putIndexData(columnKey db.ColumnKey, key []byte, value []byte, insertType tree.InsertType, primary bool) error {
+/**
+	索引树为空时按表存储配置初始化，只有主键索引树使用表配置的树类型
+ */
+func (service *IndexService) setTreeConfig(treeHead *tree.TreeHead, table *db.TableData, primary bool) {
+	treeType := tree.TreeTypeDefault
+	if primary {
+		treeType = tree.TreeType(table.Storage.TreeType)
+	}
+	treeHead.SetConfig(treeType, table.Storage.NodeSize, table.Storage.SplitRule)
+}
+
+func (service *IndexService) putIndexData(table *db.TableData, columnKey db.ColumnKey, key []byte, value []byte, insertType tree.InsertType, primary bool) error {
 	treeHead,err := service.getTreeHead(columnKey); if err != nil {
 		return err
 	}
+	service.setTreeConfig(treeHead, table, primary)
 	refNode,err := service.getITree(primary).Insert(treeHead, key, value, insertType); if err != nil {
 		return err
 	}
@@ -89,17 +114,21 @@ func (service *IndexService) putIndexData(columnKey db.ColumnKey, key []byte, va
 		linkedHead,err := service.getLinkedHead(columnKey, util.BytesToRowID(refNode.Kv.Key)); if err != nil {
 			return err
 		}
+		linkedHead.SetNodeSize(table.Storage.NodeSize)
 		return service.getILinked().Insert(linkedHead, refNode.Values)
 	}
 	return nil
 }
 
 func (service *IndexService) getIndexDataValues(columnKey db.ColumnKey, kv *db.KV, order db.OrderType, size int32, primary bool) ([][]byte,db.Total,error) {
-	isLinked := (!primary || size > 1) && kv.VType == db.ValueTypeLinkedList
+	isLinked := kv.VType == db.ValueTypeLinkedList
 	if  isLinked {
 		linkedHead,err := service.getLinkedHead(columnKey, util.BytesToRowID(kv.Key)); if err != nil {
 			return nil,0,err
 		}
+		if primary && size == 1 && order == db.DESC {//主键链表关键字值为最新版本
+			return [][]byte{kv.Value},db.Total(linkedHead.Num),nil
+		}
 		return service.getILinked().SearchByRange(linkedHead, order, size)
 	}else if primary || kv.VType == db.ValueTypeCollection {
 		values,err := service.primaryInsert.parse.CollectionBytes(kv.Value); if err != nil {
@@ -122,26 +151,70 @@ func (service *IndexService) getIndexData(columnKey db.ColumnKey, key []byte, or
 	treeHead,err := service.getTreeHead(columnKey); if err != nil {
 		return nil,0,err
 	}
+	if bptree.TreeIsNull(treeHead) {//空树没有数据
+		return nil,0,nil
+	}
 	kv,err := service.getITree(primary).Search(treeHead, key); if err != nil {
 		return nil,0,err
 	}
+	if kv == nil {//key不存在
+		return nil,0,nil
+	}
 	return service.getIndexDataValues(columnKey, kv, order, size, primary)
 }
 
-func (service *IndexService) getIndexDataByRange(columnKey db.ColumnKey, start []byte, end []byte, order db.OrderType, size int32, primary bool) ([]*db.KV,error) {
+/**
+	关键字所有值(链表全部展开)，按写入顺序
+ */
+func (service *IndexService) getIndexAllData(columnKey db.ColumnKey, key []byte, primary bool) ([][]byte,error) {
 	treeHead,err := service.getTreeHead(columnKey); if err != nil {
 		return nil,err
 	}
-	kvList,err := service.getITree(primary).SearchByRange(treeHead, start, end, order, size); if err != nil {
+	if bptree.TreeIsNull(treeHead) {
+		return nil,nil
+	}
+	kv,err := service.getITree(primary).Search(treeHead, key); if err != nil {
 		return nil,err
 	}
+	if kv == nil {
+		return nil,nil
+	}
+	switch kv.VType {
+	case db.ValueTypeLinkedList:
+		linkedHead,err := service.getLinkedHead(columnKey, util.BytesToRowID(kv.Key)); if err != nil {
+			return nil,err
+		}
+		values,_,err := service.getILinked().SearchByRange(linkedHead, db.ASC, linkedlist.Pointer(linkedHead.Num))
+		return values,err
+	case db.ValueTypeCollection:
+		return service.primaryInsert.parse.CollectionBytes(kv.Value)
+	default:
+		return [][]byte{kv.Value},nil
+	}
+}
+
+func (service *IndexService) getIndexDataByRange(columnKey db.ColumnKey, start []byte, end []byte, order db.OrderType, size int32, primary bool) ([]*db.KV,error) {
+	kvList,_,err := service.getIndexDataByCursor(columnKey, start, end, nil, order, size, primary)
+	return kvList,err
+}
+
+/**
+	游标分页区间查询，返回本页最后一个关键字的游标
+ */
+func (service *IndexService) getIndexDataByCursor(columnKey db.ColumnKey, start []byte, end []byte, cursor *db.Cursor, order db.OrderType, size int32, primary bool) ([]*db.KV,*db.Cursor,error) {
+	treeHead,err := service.getTreeHead(columnKey); if err != nil {
+		return nil,nil,err
+	}
+	kvList,next,err := service.getITree(primary).SearchByCursor(treeHead, start, end, cursor, order, size); if err != nil {
+		return nil,nil,err
+	}
 	valueOrder := db.ASC
 	if primary {
 		valueOrder = db.DESC
 	}
 	for _,kv := range kvList {
 		values,_,err := service.getIndexDataValues(columnKey, kv, valueOrder,1, primary); if err != nil {
-			return nil,err
+			return nil,nil,err
 		}
 		if len(values) > 0 {
 			kv.Value = values[0]
@@ -149,18 +222,75 @@ func (service *IndexService) getIndexDataByRange(columnKey db.ColumnKey, start [
 			kv.Value = nil
 		}
 	}
-	return kvList,nil
+	return kvList,next,nil
 }
 
+/**
+	关键字的值游标分页查询(链表使用节点游标，值集合使用值序号)，返回值总数和本页最后一个值的游标
+ */
+func (service *IndexService) getIndexDataValuesByCursor(columnKey db.ColumnKey, key []byte, cursor *db.Cursor, order db.OrderType, size int32, primary bool) ([][]byte,db.Total,*db.Cursor,error) {
+	if cursor != nil && !bytes.Equal(cursor.Key, key) {
+		return nil,0,nil,fmt.Errorf("cursor key not match")
+	}
+	treeHead,err := service.getTreeHead(columnKey); if err != nil {
+		return nil,0,nil,err
+	}
+	if bptree.TreeIsNull(treeHead) {
+		return nil,0,nil,nil
+	}
+	kv,err := service.getITree(primary).Search(treeHead, key); if err != nil {
+		return nil,0,nil,err
+	}
+	if kv == nil {
+		return nil,0,nil,nil
+	}
+	var values [][]byte
+	var next *db.Cursor
+	var total db.Total
+	if kv.VType == db.ValueTypeLinkedList {
+		linkedHead,err := service.getLinkedHead(columnKey, util.BytesToRowID(kv.Key)); if err != nil {
+			return nil,0,nil,err
+		}
+		values,total,next,err = service.getILinked().SearchByCursor(linkedHead, cursor, order, linkedlist.Pointer(size)); if err != nil {
+			return nil,0,nil,err
+		}
+	}else{
+		all := [][]byte{kv.Value}
+		if primary || kv.VType == db.ValueTypeCollection {
+			all,err = service.primaryInsert.parse.CollectionBytes(kv.Value); if err != nil {
+				return nil,0,nil,err
+			}
+		}
+		total = db.Total(len(all))
+		step,index := int64(1),int64(0)
+		if order == db.DESC {
+			step,index = -1,int64(len(all))-1
+		}
+		if cursor != nil {
+			index = cursor.Index+step
+		}
+		for ;index >= 0 && index < int64(len(all)) && int32(len(values)) < size;index += step {
+			values = append(values, all[index])
+			next = &db.Cursor{Order:order,Position:int32(index),Index:index}
+		}
+		if int32(len(values)) < size {
+			next = nil
+		}
+	}
+	if next != nil {
+		next.Key = key
+	}
+	return values,total,next,nil
+}
 ///////////////////// PrimaryKey Index Function //////////////////////
 
 func (service *IndexService) PutPrimaryKeyIndex(database db.DatabaseID, table *db.TableData, rowID db.RowID, op db.OpType, blockID db.BlockID) error {
-	columnKey := db.ColumnKey{Database:database,Table:table.Id,Column:table.PrimaryKey.ColumnID}
-	return service.putIndexData(columnKey, util.RowIDToBytes(rowID), service.primaryInsert.parse.FormatBlockType(blockID, op), tree.InsertTypeAppend,true)
+	columnKey := table.PrimaryColumnKey(database, rowID)
+	return service.putIndexData(table, columnKey, util.RowIDToBytes(rowID), service.primaryInsert.parse.FormatBlockType(blockID, op), tree.InsertTypeAppend,true)
 }
 
 func (service *IndexService) GetPrimaryKeyIndex(database db.DatabaseID, table *db.TableData, rowID db.RowID) (db.BlockID,error) {
-	columnKey := db.ColumnKey{Database:database,Table:table.Id,Column:table.PrimaryKey.ColumnID}
+	columnKey := table.PrimaryColumnKey(database, rowID)
 	values,_,err := service.getIndexData(columnKey, util.RowIDToBytes(rowID), db.DESC,1,true); if err != nil {
 		return 0,err
 	}
@@ -170,16 +300,158 @@ func (service *IndexService) GetPrimaryKeyIndex(database db.DatabaseID, table *d
 	return 0,nil
 }
 
-func (service *IndexService) GetPrimaryKeyIndexByRange(database db.DatabaseID, table *db.TableData, start db.RowID, end db.RowID, order db.OrderType, size int32) ([]db.RowBlockID,error) {
-	columnKey := db.ColumnKey{Database:database,Table:table.Id,Column:table.PrimaryKey.ColumnID}
-	kvList,err := service.getIndexDataByRange(columnKey, util.RowIDToBytes(start), util.RowIDToBytes(end), order, size,true); if err != nil {
+/**
+	行当前版本，返回最新版本块ID、操作类型和版本数，行不存在时块ID为0
+ */
+func (service *IndexService) GetPrimaryKeyIndexVersion(database db.DatabaseID, table *db.TableData, rowID db.RowID) (*db.RowVersion,error) {
+	columnKey := table.PrimaryColumnKey(database, rowID)
+	values,total,err := service.getIndexData(columnKey, util.RowIDToBytes(rowID), db.DESC,1,true); if err != nil {
 		return nil,err
 	}
-	return service.primaryInsert.parse.RowBlockIDList(kvList)
+	version := &db.RowVersion{Version:total}
+	if len(values) > 0 {
+		version.BlockID,err = service.primaryInsert.parse.BlockID(values[0]); if err != nil {
+			return nil,err
+		}
+		version.Op = service.primaryInsert.parse.GetBlockType(values[0])
+	}
+	return version,nil
+}
+
+/**
+	行主键索引所有版本(块ID加操作类型)，按写入顺序
+ */
+func (service *IndexService) GetPrimaryKeyVersions(database db.DatabaseID, table *db.TableData, rowID db.RowID) ([][]byte,error) {
+	columnKey := table.PrimaryColumnKey(database, rowID)
+	return service.getIndexAllData(columnKey, util.RowIDToBytes(rowID), true)
+}
+
+/**
+	重写行主键索引版本(压缩后保留的版本)，版本为空时行不存在
+	版本数量超过集合容量时重写链表，否则删除原有链表
+ */
+func (service *IndexService) RewritePrimaryKeyIndex(database db.DatabaseID, table *db.TableData, rowID db.RowID, values [][]byte) error {
+	columnKey := table.PrimaryColumnKey(database, rowID)
+	treeHead,err := service.getTreeHead(columnKey); if err != nil {
+		return err
+	}
+	service.setTreeConfig(treeHead, table, true)
+	key := util.RowIDToBytes(rowID)
+	var oldKV *db.KV
+	if !bptree.TreeIsNull(treeHead) {
+		oldKV,err = service.getITree(true).Search(treeHead, key); if err != nil {
+			return err
+		}
+	}
+	value,err := service.primaryInsert.parse.BytesByCollectionBytes(values); if err != nil {
+		return err
+	}
+	refNode,err := service.getITree(true).Insert(treeHead, key, value, tree.InsertTypeRewrite); if err != nil {
+		return err
+	}
+	isLinked := refNode.Kv.VType == db.ValueTypeLinkedList
+	if !isLinked && (oldKV == nil || oldKV.VType != db.ValueTypeLinkedList) {
+		return nil
+	}
+	linkedHead,err := service.getLinkedHead(columnKey, rowID); if err != nil {
+		return err
+	}
+	if isLinked {
+		linkedHead.SetNodeSize(table.Storage.NodeSize)
+		return service.getILinked().Rewrite(linkedHead, refNode.Values)
+	}
+	if linkedHead.Order > 0 {
+		if _,err := service.getILinked().Discard(linkedHead); err != nil {
+			return err
+		}
+	}
+	*linkedHead = linkedlist.LinkedHead{Key:linkedHead.Key}//同一事务中读取不到删除结果，缓存空链表头
+	return nil
+}
+
+func (service *IndexService) GetPrimaryKeyIndexByRange(database db.DatabaseID, table *db.TableData, start db.RowID, end db.RowID, order db.OrderType, size int32) ([]db.RowBlockID,error) {
+	list,_,err := service.GetPrimaryKeyIndexByCursor(database, table, start, end, nil, order, size)
+	return list,err
+}
+
+/**
+	主键索引游标分页区间查询，cursor为上一页返回的游标，为空时从start开始
+	主键索引分片时每个分片查询一页后按行ID合并，游标只保留最后一个行ID，各分片按关键字重新定位
+ */
+func (service *IndexService) GetPrimaryKeyIndexByCursor(database db.DatabaseID, table *db.TableData, start db.RowID, end db.RowID, cursor *db.Cursor, order db.OrderType, size int32) ([]db.RowBlockID,*db.Cursor,error) {
+	var kvList []*db.KV
+	var next *db.Cursor
+	if table.PrimaryShards <= 1 {
+		var err error
+		kvList,next,err = service.getIndexDataByCursor(table.PrimaryColumnKey(database,0), util.RowIDToBytes(start), util.RowIDToBytes(end), cursor, order, size,true); if err != nil {
+			return nil,nil,err
+		}
+	}else{
+		var err error
+		kvList,next,err = service.getShardIndexDataByCursor(table.PrimaryColumnKeys(database), util.RowIDToBytes(start), util.RowIDToBytes(end), cursor, order, size); if err != nil {
+			return nil,nil,err
+		}
+	}
+	list,err := service.primaryInsert.parse.RowBlockIDList(kvList); if err != nil {
+		return nil,nil,err
+	}
+	return list,next,nil
+}
+
+func (service *IndexService) getShardIndexDataByCursor(columnKeys []db.ColumnKey, start []byte, end []byte, cursor *db.Cursor, order db.OrderType, size int32) ([]*db.KV,*db.Cursor,error) {
+	if cursor != nil && cursor.Order != order {
+		return nil,nil,fmt.Errorf("cursor order `%d` not match `%d`", cursor.Order, order)
+	}
+	var kvList []*db.KV
+	more := false
+	for _,columnKey := range columnKeys {
+		treeHead,err := service.getTreeHead(columnKey); if err != nil {
+			return nil,nil,err
+		}
+		if bptree.TreeIsNull(treeHead) {
+			continue
+		}
+		var shardCursor *db.Cursor
+		if cursor != nil {
+			shardCursor = &db.Cursor{Order:order,Key:cursor.Key}
+		}
+		list,next,err := service.getIndexDataByCursor(columnKey, start, end, shardCursor, order, size,true); if err != nil {
+			return nil,nil,err
+		}
+		kvList = append(kvList, list...)
+		more = more || next != nil
+	}
+	sort.Slice(kvList, func(i, j int) bool {
+		if order == db.DESC {
+			return bytes.Compare(kvList[i].Key, kvList[j].Key) > 0
+		}
+		return bytes.Compare(kvList[i].Key, kvList[j].Key) < 0
+	})
+	if int32(len(kvList)) > size {
+		kvList,more = kvList[:size],true
+	}
+	if !more || len(kvList) == 0 {
+		return kvList,nil,nil
+	}
+	return kvList,&db.Cursor{Order:order,Key:kvList[len(kvList)-1].Key},nil
+}
+
+/**
+	行历史版本游标分页查询，cursor为上一页返回的游标，为空时从第一个(升序)或最新(降序)版本开始
+ */
+func (service *IndexService) GetPrimaryKeyIndexHistoryByCursor(database db.DatabaseID, table *db.TableData, rowID db.RowID, cursor *db.Cursor, order db.OrderType, size int32) ([]db.BlockID,db.Total,*db.Cursor,error) {
+	columnKey := table.PrimaryColumnKey(database, rowID)
+	values,total,next,err := service.getIndexDataValuesByCursor(columnKey, util.RowIDToBytes(rowID), cursor, order, size,true); if err != nil {
+		return nil,0,nil,err
+	}
+	blocks,err := service.primaryInsert.parse.BlockIDList(values); if err != nil {
+		return nil,0,nil,err
+	}
+	return blocks,total,next,nil
 }
 
 func (service *IndexService) GetPrimaryKeyIndexHistoryByRange(database db.DatabaseID, table *db.TableData, rowID db.RowID, order db.OrderType, size int32) ([]db.BlockID,db.Total,error) {
-	columnKey := db.ColumnKey{Database:database,Table:table.Id,Column:table.PrimaryKey.ColumnID}
+	columnKey := table.PrimaryColumnKey(database, rowID)
 	values,total,err := service.getIndexData(columnKey, util.RowIDToBytes(rowID), order, size,true); if err != nil {
 		return nil,0,err
 	}
@@ -189,21 +461,118 @@ func (service *IndexService) GetPrimaryKeyIndexHistoryByRange(database db.Databa
 	return blocks,total,nil
 }
 
+/**
+	主键区间[start,end]行数量，start、end为0时不限制
+	live为false时按树节点关键字数量累加，不解析关键字值，live为true时只统计最新版本未删除的行(不读取块)
+ */
+func (service *IndexService) CountPrimaryKeyIndexByRange(database db.DatabaseID, table *db.TableData, start db.RowID, end db.RowID, live bool) (db.Total,error) {
+	var filter func(kv *db.KV) (bool,error)
+	if live {
+		filter = func(kv *db.KV) (bool,error) {
+			value := kv.Value
+			if kv.VType != db.ValueTypeLinkedList {//主键链表关键字值为最新版本
+				values,err := service.primaryInsert.parse.CollectionBytes(kv.Value); if err != nil {
+					return false,err
+				}
+				if len(values) == 0 {//压缩清除了行的所有版本
+					return false,nil
+				}
+				value = values[len(values)-1]
+			}
+			return service.primaryInsert.parse.GetBlockType(value) != db.DELETE,nil
+		}
+	}
+	total := db.Total(0)
+	for _,columnKey := range table.PrimaryColumnKeys(database) {
+		treeHead,err := service.getTreeHead(columnKey); if err != nil {
+			return 0,err
+		}
+		count,err := service.getITree(true).CountByRange(treeHead, util.RowIDToBytes(start), util.RowIDToBytes(end), filter); if err != nil {
+			return 0,err
+		}
+		total += db.Total(count)
+	}
+	return total,nil
+}
+
 ///////////////////// ForeignKey Index Function //////////////////////
 
+/**
+	记录新增、修改行的外键值索引，增量行只记录修改的外键列
+	外键值的索引中已包含行时不重复记录(删除后重新新增、外键值修改后再改回)
+ */
 func (service *IndexService) PutForeignKeysIndex(database db.DatabaseID, table *db.TableData, rowID db.RowID, row *row.RowData) error {
 	for _,foreignKey := range table.ForeignKeys {
-		value := row.Columns[foreignKey.ColumnID-1].Data
-		if len(value) > 0 {
-			columnKey := db.ColumnKey{Database:database,Table:table.Id,Column:foreignKey.ColumnID}
-			if err := service.putIndexData(columnKey, value, util.RowIDToBytes(rowID), tree.InsertTypeAppend,false); err != nil {
-				return err
-			}
+		value := ForeignKeyValue(row, foreignKey.ColumnID)
+		if len(value) == 0 {
+			continue
+		}
+		exists,err := service.ContainsForeignKeyIndex(database, table, foreignKey.ColumnID, rowID, value); if err != nil {
+			return err
 		}
+		if exists {
+			continue
+		}
+		if err := service.PutForeignKeyIndex(database, table, foreignKey.ColumnID, rowID, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/**
+	行中外键列的值，增量行未修改外键列时为空
+ */
+func ForeignKeyValue(row *row.RowData, column db.ColumnID) []byte {
+	if position := ForeignKeyPosition(row, column); position >= 0 {
+		return row.Columns[position].Data
 	}
 	return nil
 }
 
+/**
+	外键列在行数据列中的下标，增量行按修改的列下标查找，不存在时为-1
+ */
+func ForeignKeyPosition(row *row.RowData, column db.ColumnID) int {
+	index := int(column)-1
+	if len(row.Delta) > 0 {
+		for i,delta := range row.Delta {
+			if int(delta) == index && i < len(row.Columns) {
+				return i
+			}
+		}
+		return -1
+	}
+	if index < len(row.Columns) {
+		return index
+	}
+	return -1
+}
+
+func (service *IndexService) PutForeignKeyIndex(database db.DatabaseID, table *db.TableData, column db.ColumnID, rowID db.RowID, value []byte) error {
+	if len(value) == 0 {
+		return nil
+	}
+	columnKey := db.ColumnKey{Database:database,Table:table.Id,Column:column}
+	return service.putIndexData(table, columnKey, value, util.RowIDToBytes(rowID), tree.InsertTypeAppend,false)
+}
+
+/**
+	外键值的索引中是否包含行
+ */
+func (service *IndexService) ContainsForeignKeyIndex(database db.DatabaseID, table *db.TableData, column db.ColumnID, rowID db.RowID, value []byte) (bool,error) {
+	columnKey := db.ColumnKey{Database:database,Table:table.Id,Column:column}
+	values,err := service.getIndexAllData(columnKey, value, false); if err != nil {
+		return false,err
+	}
+	for _,v := range values {
+		if util.BytesToRowID(v) == rowID {
+			return true,nil
+		}
+	}
+	return false,nil
+}
+
 func (service *IndexService) GetForeignKeyIndex(database db.DatabaseID, tableID db.TableID, foreignKey db.ForeignKey, referenceRowID db.RowID, size int32) ([]db.RowID,error) {
 	columnKey := db.ColumnKey{Database:database,Table:tableID,Column:foreignKey.ColumnID}
 	values,_,err := service.getIndexData(columnKey, util.RowIDToBytes(referenceRowID), db.ASC, size,false); if err != nil {
@@ -212,6 +581,223 @@ func (service *IndexService) GetForeignKeyIndex(database db.DatabaseID, tableID
 	return service.primaryInsert.parse.RowIDList(values)
 }
 
+/**
+	外键引用行的行ID游标分页查询(按写入顺序)，cursor为上一页返回的游标，为空时从第一个开始
+ */
+func (service *IndexService) GetForeignKeyIndexByCursor(database db.DatabaseID, tableID db.TableID, foreignKey db.ForeignKey, referenceRowID db.RowID, cursor *db.Cursor, size int32) ([]db.RowID,*db.Cursor,error) {
+	columnKey := db.ColumnKey{Database:database,Table:tableID,Column:foreignKey.ColumnID}
+	values,_,next,err := service.getIndexDataValuesByCursor(columnKey, util.RowIDToBytes(referenceRowID), cursor, db.ASC, size,false); if err != nil {
+		return nil,nil,err
+	}
+	rowIDs,err := service.primaryInsert.parse.RowIDList(values); if err != nil {
+		return nil,nil,err
+	}
+	return rowIDs,next,nil
+}
+
+///////////////////// Migrate Index Function //////////////////////
+
+/**
+	表索引(主键、外键索引树及其链表)从旧JSON编码迁移为二进制编码，从checkpoint开始最多处理size个树节点
+	叶子节点关键字的链表与节点在同一批迁移，更新checkpoint的位置和重写的Key数量
+ */
+func (service *IndexService) MigrateIndex(database db.DatabaseID, table *db.TableData, checkpoint *db.TableMigrateCheckpoint, size int32) error {
+	columnKeys := table.PrimaryColumnKeys(database)
+	for _,foreignKey := range table.ForeignKeys {
+		columnKeys = append(columnKeys, db.ColumnKey{Database:database,Table:table.Id,Column:foreignKey.ColumnID})
+	}
+	for size > 0 && int(checkpoint.Index) < len(columnKeys) {
+		columnKey := columnKeys[checkpoint.Index]
+		start := tree.Pointer(checkpoint.Node)
+		if start < 1 {
+			start = 1
+		}
+		next,num,err := service.iTree.MigrateNodes(columnKey, start, tree.Pointer(size), func(kv *db.KV) error {
+			if kv.VType != db.ValueTypeLinkedList {
+				return nil
+			}
+			n,err := service.iLinked.Migrate(db.ColumnRowKey{ColumnKey:columnKey,Row:util.BytesToRowID(kv.Key)}); if err != nil {
+				return err
+			}
+			checkpoint.Keys += db.Total(n)
+			return nil
+		}); if err != nil {
+			return err
+		}
+		checkpoint.Keys += db.Total(num)
+		if next == 0 {
+			checkpoint.Index++
+			checkpoint.Node = 0
+			size--
+		}else{
+			checkpoint.Node = int32(next)
+			size -= int32(next-start)
+		}
+	}
+	checkpoint.Done = int(checkpoint.Index) == len(columnKeys)
+	return nil
+}
+
+///////////////////// Check Index Function //////////////////////
+
+/**
+	检查列索引树及其链表，visit按关键字顺序访问展开后的值(主键为块ID加操作类型，外键为行ID)
+ */
+func (service *IndexService) CheckIndex(columnKey db.ColumnKey, primary bool, visit func(key []byte, values [][]byte) error) (*db.IndexCheck,[]db.CheckIssue,error) {
+	treeHead,err := service.iTree.SearchHead(columnKey); if err != nil {
+		return nil,nil,err
+	}
+	if treeHead == nil {
+		return &db.IndexCheck{Column:columnKey.Column,Shard:columnKey.Shard},nil,nil
+	}
+	var issues []db.CheckIssue
+	addIssue := func(kv *db.KV, format string, args ...interface{}) {
+		issues = append(issues, db.CheckIssue{Kind:db.CheckKindTree,Column:columnKey.Column,Row:util.BytesToRowID(kv.Key),
+			Error:fmt.Sprintf(format, args...),Repair:db.RepairRebuildIndex})
+	}
+	valueNum,linkedNum := db.Total(0),db.Total(0)
+	check,treeIssues,err := service.getITree(primary).Check(treeHead, func(kv *db.KV) error {
+		var values [][]byte
+		switch kv.VType {
+		case db.ValueTypeLinkedList:
+			linkedHead,err := service.iLinked.SearchHead(db.ColumnRowKey{ColumnKey:columnKey,Row:util.BytesToRowID(kv.Key)}); if err != nil {
+				return err
+			}
+			if linkedHead == nil {
+				addIssue(kv, "key `%v` linkedlist head not found", kv.Key)
+				return nil
+			}
+			linkedNum++
+			var linkedIssues []db.CheckIssue
+			values,linkedIssues,err = service.iLinked.Check(linkedHead); if err != nil {
+				return err
+			}
+			issues = append(issues, linkedIssues...)
+		case db.ValueTypeCollection:
+			values,err = service.primaryInsert.parse.CollectionBytes(kv.Value); if err != nil {
+				addIssue(kv, "key `%v` collection error `%s`", kv.Key, err.Error())
+				return nil
+			}
+		case db.ValueTypeData:
+			values = [][]byte{kv.Value}
+		default:
+			addIssue(kv, "key `%v` value type `%d` error", kv.Key, kv.VType)
+			return nil
+		}
+		valueNum += db.Total(len(values))
+		return visit(kv.Key, values)
+	}); if err != nil {
+		return nil,nil,err
+	}
+	check.Shard,check.Values,check.Linked = columnKey.Shard,valueNum,linkedNum
+	return check,append(treeIssues, issues...),nil
+}
+
+/**
+	列索引统计，树高度、节点数量和关键字数量读取树头，每个关键字的版本数量(链表读取链表头)按叶子节点统计
+	主键索引分片时columnKeys为所有分片的列键，高度取最大值，其他统计累加
+ */
+func (service *IndexService) GetIndexStats(columnKeys []db.ColumnKey, primary bool) (*db.IndexStats,error) {
+	stats := &db.IndexStats{Column:columnKeys[0].Column}
+	for _,columnKey := range columnKeys {
+		if err := service.addIndexStats(stats, columnKey, primary); err != nil {
+			return nil,err
+		}
+	}
+	if stats.LeafKeys > 0 {
+		stats.AvgVersions = float64(stats.Versions) / float64(stats.LeafKeys)
+	}
+	return stats,nil
+}
+
+func (service *IndexService) addIndexStats(stats *db.IndexStats, columnKey db.ColumnKey, primary bool) error {
+	treeHead,err := service.iTree.SearchHead(columnKey); if err != nil {
+		return err
+	}
+	if treeHead == nil {
+		return nil
+	}
+	if treeHead.Height > stats.Height {
+		stats.Height = treeHead.Height
+	}
+	stats.Nodes += int32(treeHead.NodeNum)
+	stats.Keys += treeHead.KeyNum
+	leafKeys,err := service.getITree(primary).CountByRange(treeHead, nil, nil, func(kv *db.KV) (bool,error) {
+		versions := db.Total(1)
+		if kv.VType == db.ValueTypeLinkedList {
+			linkedHead,err := service.getLinkedHead(columnKey, util.BytesToRowID(kv.Key)); if err != nil {
+				return false,err
+			}
+			versions = db.Total(linkedHead.Num)
+		}else if primary || kv.VType == db.ValueTypeCollection {
+			values,err := service.primaryInsert.parse.CollectionBytes(kv.Value); if err != nil {
+				return false,err
+			}
+			versions = db.Total(len(values))
+		}
+		stats.Versions += versions
+		if versions > stats.MaxVersions {
+			stats.MaxVersions = versions
+		}
+		return true,nil
+	}); if err != nil {
+		return err
+	}
+	stats.LeafKeys += db.Total(leafKeys)
+	return nil
+}
+
+///////////////////// Rebuild Index Function //////////////////////
+
+/**
+	清除列索引，从checkpoint.Node之后的树节点开始最多清除size个节点(包括节点关键字的链表)
+	节点全部清除后删除树头并设置checkpoint.Discarded，返回清除的节点数量
+	同一事务中读取不到已删除的数据，清除完成后需要在新的事务中重放
+ */
+func (service *IndexService) DiscardIndex(columnKey db.ColumnKey, checkpoint *db.RebuildCheckpoint, size int32) (int32,error) {
+	treeHead,err := service.iTree.SearchHead(columnKey); if err != nil {
+		return 0,err
+	}
+	num := int32(0)
+	if treeHead != nil {
+		for pointer := tree.Pointer(checkpoint.Node)+1; pointer <= treeHead.NodeOrder; pointer++ {
+			if num >= size {
+				return num,nil
+			}
+			err := service.iTree.DiscardNode(columnKey, pointer, func(kv *db.KV) error {
+				if kv.VType != db.ValueTypeLinkedList {
+					return nil
+				}
+				linkedHead,err := service.iLinked.SearchHead(db.ColumnRowKey{ColumnKey:columnKey,Row:util.BytesToRowID(kv.Key)}); if err != nil {
+					return err
+				}
+				if linkedHead == nil {
+					return nil
+				}
+				n,err := service.iLinked.Discard(linkedHead)
+				num += int32(n)
+				return err
+			}); if err != nil {
+				return num,err
+			}
+			checkpoint.Node = int32(pointer)
+			num++
+		}
+		if err := service.iTree.DiscardHead(columnKey); err != nil {
+			return num,err
+		}
+	}
+	delete(service.treeHeadMap, treeName(columnKey))
+	name := util.DatabaseIDToString(columnKey.Database)+"_"+util.TableIDToString(columnKey.Table)+"_"+util.ColumnIDToString(columnKey.Column)
+	for linkedName := range service.linkedHeadMap {
+		if strings.HasPrefix(linkedName, name+"_") {
+			delete(service.linkedHeadMap, linkedName)
+		}
+	}
+	checkpoint.Discarded = true
+	return num,nil
+}
+
 ///////////////////// Other Index Function //////////////////////
 
 func (service *IndexService) QueryRowIdByIndex(key db.ColumnKey, value []byte) (db.RowID,error) {
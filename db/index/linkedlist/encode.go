@@ -0,0 +1,83 @@
+package linkedlist
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/database-fabric/db"
+	"github.com/database-fabric/db/util"
+)
+
+/**
+	链表头、节点二进制编码(兼容读取旧JSON编码)
+ */
+
+func EncodeLinkedHead(head *LinkedHead) []byte {
+	encoder := util.NewEncoder(util.EncodeBinaryV1, 40)
+	encoder.PutInt64(int64(head.Key.Database))
+	encoder.PutInt64(int64(head.Key.Table))
+	encoder.PutInt64(int64(head.Key.Column))
+	encoder.PutInt64(int64(head.Key.Row))
+	encoder.PutInt64(int64(head.Order))
+	encoder.PutInt64(head.Num)
+	encoder.PutInt64(int64(head.First))
+	encoder.PutInt64(int64(head.Last))
+	encoder.PutInt64(int64(head.NodeSize))
+	return encoder.Bytes()
+}
+
+func DecodeLinkedHead(value []byte) (*LinkedHead, error) {
+	head := &LinkedHead{}
+	if util.IsJsonEncode(value) {
+		return head,json.Unmarshal(value, head)
+	}
+	decoder,version,err := util.NewDecoder(value); if err != nil {
+		return nil,err
+	}
+	if version != util.EncodeBinaryV1 {
+		return nil,fmt.Errorf("linked head encode version `%d` error", version)
+	}
+	head.Key.Database = db.DatabaseID(decoder.Int64())
+	head.Key.Table = db.TableID(decoder.Int64())
+	head.Key.Column = db.ColumnID(decoder.Int64())
+	head.Key.Row = db.RowID(decoder.Int64())
+	head.Order = Pointer(decoder.Int64())
+	head.Num = decoder.Int64()
+	head.First = Pointer(decoder.Int64())
+	head.Last = Pointer(decoder.Int64())
+	if decoder.Remaining() > 0 {
+		head.NodeSize = int32(decoder.Int64())
+	}
+	return head,decoder.Err()
+}
+
+func EncodeLinkedNode(node *LinkedNode) []byte {
+	encoder := util.NewEncoder(util.EncodeBinaryV1, LinkedNodeSize(node))
+	encoder.PutInt64(int64(node.Prev))
+	encoder.PutInt64(int64(node.Next))
+	encoder.PutBytesList(node.Values)
+	return encoder.Bytes()
+}
+
+func DecodeLinkedNode(value []byte) (*LinkedNode, error) {
+	node := &LinkedNode{}
+	if util.IsJsonEncode(value) {
+		return node,json.Unmarshal(value, node)
+	}
+	decoder,version,err := util.NewDecoder(value); if err != nil {
+		return nil,err
+	}
+	if version != util.EncodeBinaryV1 {
+		return nil,fmt.Errorf("linked node encode version `%d` error", version)
+	}
+	node.Prev = Pointer(decoder.Int64())
+	node.Next = Pointer(decoder.Int64())
+	node.Values = decoder.BytesList()
+	return node,decoder.Err()
+}
+
+/**
+	节点二进制编码大小，直接计算无需序列化
+ */
+func LinkedNodeSize(node *LinkedNode) int {
+	return 1 + util.VarintSize(int64(node.Prev)) + util.VarintSize(int64(node.Next)) + util.BytesListSize(node.Values)
+}
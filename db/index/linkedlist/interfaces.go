@@ -8,8 +8,17 @@ type LinkedListInterface interface {
 	SearchHead(key db.ColumnRowKey) (*LinkedHead, error)
 
 	SearchByRange(head *LinkedHead, order db.OrderType, size Pointer) ([][]byte,db.Total,error)
+	SearchByCursor(head *LinkedHead, cursor *db.Cursor, order db.OrderType, size Pointer) ([][]byte,db.Total,*db.Cursor,error)
 
 	Insert(head *LinkedHead, values [][]byte) error
 
 	Print(head *LinkedHead) error
+
+	Migrate(key db.ColumnRowKey) (int, error)
+
+	Check(head *LinkedHead) ([][]byte, []db.CheckIssue, error)
+
+	Discard(head *LinkedHead) (int, error)
+
+	Rewrite(head *LinkedHead, values [][]byte) error
 }
\ No newline at end of file
@@ -14,7 +14,7 @@ const (
 
 const (
 	//容量值配置
-	MAX_NODE_SIZE     = 1024 * 4 //节点最大容量4KB
+	MAX_NODE_SIZE     = db.DefaultNodeSize //默认节点最大容量4KB(链表头未设置节点容量时使用)
 )
 
 type LinkedHead struct {
@@ -23,6 +23,7 @@ type LinkedHead struct {
 	Num   int64    `json:"num"` //值列表总数
 	First Pointer  `json:"first"`   //头指针
 	Last  Pointer  `json:"last"`    //尾指针
+	NodeSize int32 `json:"nodeSize"` //节点最大容量，0为默认容量
 }
 
 type LinkedNode struct {
@@ -31,6 +32,24 @@ type LinkedNode struct {
 	Values [][]byte `json:"values"`   //值集合
 }
 
+/**
+	设置链表节点容量，链表已有节点时不可修改
+ */
+func (head *LinkedHead) SetNodeSize(nodeSize int32) bool {
+	if head.Order > 0 {
+		return false
+	}
+	head.NodeSize = nodeSize
+	return true
+}
+
+func (head *LinkedHead) GetNodeSize() int {
+	if head.NodeSize == 0 {
+		return MAX_NODE_SIZE
+	}
+	return int(head.NodeSize)
+}
+
 func BytesToPointer(value []byte) Pointer {
 	return util.BytesToInt32(value)
 }
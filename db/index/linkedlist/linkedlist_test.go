@@ -61,5 +61,51 @@ func TestLinkedList(t *testing.T) {
 		assert.EqualValues(t, len(list), pageSize,"list len error")
 		assert.EqualValues(t, list[0], PointerToBytes(start),"list start error")
 		assert.EqualValues(t, list[len(list)-1], PointerToBytes(pageSize),"list end error")
+		//游标分页，跨节点连续且不重复
+		for _,order := range []db.OrderType{db.ASC,db.DESC} {
+			var all [][]byte
+			var cursor *db.Cursor
+			pages := 0
+			for {
+				list,total,next,err := linkedListImpl.SearchByCursor(linkedHead, cursor, order, Pointer(70)); if err != nil {
+					panic(err.Error())
+				}
+				assert.EqualValues(t, size, total, "cursor total error")
+				all = append(all, list...)
+				pages++
+				if next == nil {
+					break
+				}
+				cursor = next
+			}
+			assert.Equal(t, 15, pages, "cursor pages error")
+			assert.EqualValues(t, size, len(all), "cursor list len error")
+			for i,value := range all {
+				expect := start+Pointer(i)
+				if order == db.DESC {
+					expect = size-Pointer(i)
+				}
+				assert.EqualValues(t, PointerToBytes(expect), value, "cursor value error")
+			}
+		}
+		//追加后游标继续读取新值，游标节点不存在时按值序号定位
+		list,_,cursor,err := linkedListImpl.SearchByCursor(linkedHead,nil, db.ASC, size); if err != nil {
+			panic(err.Error())
+		}
+		assert.NotNil(t, cursor, "cursor full page error")
+		if err := linkedListImpl.Insert(linkedHead,[][]byte{PointerToBytes(size+1)}); err != nil {
+			panic(err.Error())
+		}
+		list,_,_,err = linkedListImpl.SearchByCursor(linkedHead, cursor, db.ASC, size); if err != nil {
+			panic(err.Error())
+		}
+		assert.Equal(t, [][]byte{PointerToBytes(size+1)}, list, "cursor append error")
+		seek := &db.Cursor{Order:db.ASC,Node:int64(linkedHead.Order+10),Index:int64(pageSize-1)}
+		list,_,_,err = linkedListImpl.SearchByCursor(linkedHead, seek, db.ASC,1); if err != nil {
+			panic(err.Error())
+		}
+		assert.Equal(t, [][]byte{PointerToBytes(pageSize+1)}, list, "cursor seek error")
+		_,_,_,err = linkedListImpl.SearchByCursor(linkedHead, cursor, db.DESC,1)
+		assert.NotNil(t, err, "cursor order error")
 	}
 }
\ No newline at end of file
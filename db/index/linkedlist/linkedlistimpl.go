@@ -1,7 +1,6 @@
 package linkedlist
 
 import (
-	"encoding/json"
 	"fmt"
 	"github.com/database-fabric/db"
 	"github.com/database-fabric/db/storage"
@@ -26,8 +25,8 @@ func (service *LinkedListImpl) getHead(key db.ColumnRowKey) (*LinkedHead, error)
 	}
 	var head *LinkedHead
 	if headBytes != nil && len(headBytes) > 0{
-		head = &LinkedHead{}
-		if err := json.Unmarshal(headBytes, head); err != nil {
+		head, err = DecodeLinkedHead(headBytes)
+		if err != nil {
 			return nil, err
 		}
 	}
@@ -46,18 +45,14 @@ func (service *LinkedListImpl) createHead(key db.ColumnRowKey) (*LinkedHead, err
 }
 
 func (service *LinkedListImpl) getNode(pointer Pointer, head *LinkedHead) (*LinkedNode, error) {
-	nodeBytes, err := service.storage.GetNode(head.Key, util.Int64ToString(int64(pointer)))
+	nodeBytes, err := service.storage.GetNode(head.Key, int64(pointer))
 	if err != nil {
 		return nil, err
 	}
 	if nodeBytes == nil || len(nodeBytes) == 0 {
 		return nil, fmt.Errorf("node `%d` not found", pointer)
 	} else {
-		node := &LinkedNode{}
-		if err := json.Unmarshal(nodeBytes, node); err != nil {
-			return nil, err
-		}
-		return node,nil
+		return DecodeLinkedNode(nodeBytes)
 	}
 }
 
@@ -77,20 +72,13 @@ func (service *LinkedListImpl) createNode(prevNode *LinkedNode, head *LinkedHead
 
 func (service *LinkedListImpl) putNode(nodes map[Pointer]*LinkedNode, head *LinkedHead) error {
 	for pointer,node := range nodes {
-		nodeBytes, err := util.ConvertJsonBytes(*node)
-		if err != nil {
-			return err
-		}
-		if err := service.storage.PutNode(head.Key, util.Int64ToString(int64(pointer)), nodeBytes); err != nil {
+		nodeBytes := EncodeLinkedNode(node)
+		if err := service.storage.PutNode(head.Key, int64(pointer), nodeBytes); err != nil {
 			return err
 		}
 		node = nil
 	}
-	headBytes, err := util.ConvertJsonBytes(*head)
-	if err != nil {
-		return err
-	}
-	if err := service.storage.PutHead(head.Key, headBytes); err != nil {
+	if err := service.storage.PutHead(head.Key, EncodeLinkedHead(head)); err != nil {
 		return err
 	}
 	head = nil
@@ -98,21 +86,16 @@ func (service *LinkedListImpl) putNode(nodes map[Pointer]*LinkedNode, head *Link
 }
 
 func GetNodeSize(node *LinkedNode) (int, error) {
-	nodeBytes, err := util.ConvertJsonBytes(*node)
-	if err != nil {
-		return 0, err
-	}
-	nodeSize := len(nodeBytes) + NODE_NAME_SIZE
-	return nodeSize, nil
+	return LinkedNodeSize(node) + NODE_NAME_SIZE, nil
 }
 
-func IsSplit(value []byte, node *LinkedNode) (bool, error) {
+func IsSplit(value []byte, node *LinkedNode, maxSize int) (bool, error) {
 	nodeSize, err := GetNodeSize(node)
 	if err != nil {
 		return false, err
 	}
-	addSize := len(value)
-	if nodeSize+addSize > MAX_NODE_SIZE {
+	addSize := util.BytesSize(value)
+	if nodeSize+addSize > maxSize {
 		return true,nil
 	}
 	return false,nil
@@ -188,7 +171,7 @@ func (service *LinkedListImpl) Insert(head *LinkedHead, values [][]byte) error {
 	nodes[pointer] = node
 	temp := make([][]byte, 0, len(values))
 	for i,value := range values {
-		split,err := IsSplit(value, node); if err != nil {
+		split,err := IsSplit(value, node, head.GetNodeSize()); if err != nil {
 			return err
 		}
 		if split {
@@ -247,6 +230,110 @@ func (service *LinkedListImpl) SearchByRange(head *LinkedHead, order db.OrderTyp
 	return nil,0,nil
 }
 
+/**
+	游标分页查询，cursor为上一页返回的游标(为空时从头或尾开始)，从游标位置之后继续查询
+	返回的游标为本页最后一个值的节点和下标，本页数量不足size时没有下一页，返回空游标
+	游标节点不存在(链表重写后)时按值序号从第一个节点重新定位
+*/
+func (service *LinkedListImpl) SearchByCursor(head *LinkedHead, cursor *db.Cursor, order db.OrderType, size Pointer) ([][]byte,db.Total,*db.Cursor,error) {
+	if head == nil {
+		return nil,0,nil,fmt.Errorf("linkedlist head is null")
+	}
+	if cursor != nil && cursor.Order != order {
+		return nil,0,nil,fmt.Errorf("cursor order `%d` not match `%d`", cursor.Order, order)
+	}
+	if head.First == 0 {
+		return nil,0,nil,nil
+	}
+	var err error
+	var node *LinkedNode
+	var pointer Pointer
+	var position int
+	var index int64
+	if cursor == nil {
+		pointer,index = head.First,0
+		if order == db.DESC {
+			pointer,index = head.Last,head.Num-1
+		}
+		node,err = service.getNode(pointer, head); if err != nil {
+			return nil,0,nil,err
+		}
+		if order == db.DESC {
+			position = len(node.Values)-1
+		}
+	}else{
+		pointer,position,index = Pointer(cursor.Node),int(cursor.Position),cursor.Index
+		node,err = service.getNode(pointer, head)
+		if err != nil || position >= len(node.Values) {
+			pointer,node,position,err = service.seekNode(head, index); if err != nil {
+				return nil,0,nil,err
+			}
+		}
+		if order == db.ASC {
+			position,index = position+1,index+1
+		}else{
+			position,index = position-1,index-1
+		}
+	}
+	list := make([][]byte, 0, size)
+	var next *db.Cursor
+	for node != nil && size > 0 {
+		for position >= 0 && position < len(node.Values) && size > 0 {
+			list = append(list, node.Values[position])
+			size--
+			next = &db.Cursor{Order:order,Node:int64(pointer),Position:int32(position),Index:index}
+			if order == db.ASC {
+				position,index = position+1,index+1
+			}else{
+				position,index = position-1,index-1
+			}
+		}
+		if size == 0 {
+			break
+		}
+		if order == db.ASC {
+			pointer = node.Next
+		}else{
+			pointer = node.Prev
+		}
+		node = nil//查询完node设置为空释放内存
+		if pointer > Pointer(0) {
+			node,err = service.getNode(pointer, head); if err != nil {
+				return nil,0,nil,err
+			}
+			position = 0
+			if order == db.DESC {
+				position = len(node.Values)-1
+			}
+		}
+	}
+	if size > 0 {
+		next = nil
+	}
+	return list,db.Total(head.Num),next,nil
+}
+
+/**
+	按值序号从第一个节点查找值所在的节点和下标
+ */
+func (service *LinkedListImpl) seekNode(head *LinkedHead, index int64) (Pointer,*LinkedNode,int,error) {
+	if index < 0 || index >= head.Num {
+		return 0,nil,0,fmt.Errorf("cursor index `%d` out of range", index)
+	}
+	pointer := head.First
+	for pointer > Pointer(0) {
+		node,err := service.getNode(pointer, head); if err != nil {
+			return 0,nil,0,err
+		}
+		if index < int64(len(node.Values)) {
+			return pointer,node,int(index),nil
+		}
+		index -= int64(len(node.Values))
+		pointer = node.Next
+	}
+	return 0,nil,0,fmt.Errorf("cursor index out of range")
+}
+
 func (service *LinkedListImpl) Print(head *LinkedHead) error {
 	if head == nil {
 		return fmt.Errorf("linkedlist head is null")
@@ -269,3 +356,143 @@ func (service *LinkedListImpl) Print(head *LinkedHead) error {
 	}
 	return nil
 }
+
+
+/**
+	旧JSON编码的链表头和节点重写为二进制编码，返回重写数量
+ */
+func (service *LinkedListImpl) Migrate(key db.ColumnRowKey) (int, error) {
+	headBytes, err := service.storage.GetHead(key); if err != nil {
+		return 0, err
+	}
+	if len(headBytes) == 0 {
+		return 0, nil
+	}
+	head, err := DecodeLinkedHead(headBytes); if err != nil {
+		return 0, err
+	}
+	num := 0
+	for pointer := Pointer(1); pointer <= head.Order; pointer++ {
+		nodeBytes, err := service.storage.GetNode(key, int64(pointer)); if err != nil {
+			return num, err
+		}
+		if !util.IsJsonEncode(nodeBytes) {
+			continue
+		}
+		node, err := DecodeLinkedNode(nodeBytes); if err != nil {
+			return num, err
+		}
+		if err := service.storage.PutNode(key, int64(pointer), EncodeLinkedNode(node)); err != nil {
+			return num, err
+		}
+		num++
+	}
+	if util.IsJsonEncode(headBytes) {
+		if err := service.storage.PutHead(key, EncodeLinkedHead(head)); err != nil {
+			return num, err
+		}
+		num++
+	}
+	return num, nil
+}
+/**
+	检查链表结构：节点从First沿Next连续，Prev指向上一个节点，尾指针、节点数量和值数量与链表头一致
+	返回链表所有值，节点缺失或指针错误时返回已读取的值
+ */
+func (service *LinkedListImpl) Check(head *LinkedHead) ([][]byte, []db.CheckIssue, error) {
+	if head == nil {
+		return nil, nil, fmt.Errorf("linkedlist head is null")
+	}
+	var issues []db.CheckIssue
+	addIssue := func(pointer Pointer, format string, args ...interface{}) {
+		issues = append(issues, db.CheckIssue{Kind:db.CheckKindLinked,Column:head.Key.Column,Row:head.Key.Row,Pointer:pointer,
+			Error:fmt.Sprintf(format, args...),Repair:db.RepairRebuildIndex})
+	}
+	var values [][]byte
+	if head.Order == 0 {
+		if head.First != 0 || head.Last != 0 || head.Num != 0 {
+			addIssue(0, "linkedlist head without node error")
+		}
+		return values, issues, nil
+	}
+	if head.First != 1 {
+		addIssue(head.First, "linkedlist first `%d` error", head.First)
+	}
+	prev := Pointer(0)
+	nodeNum := Pointer(0)
+	pointer := head.First
+	for pointer > 0 {
+		if nodeNum >= head.Order || pointer > head.Order {//超出节点序号，链表存在环或指针越界
+			addIssue(pointer, "linkedlist node `%d` out of order `%d`", pointer, head.Order)
+			break
+		}
+		nodeBytes, err := service.storage.GetNode(head.Key, int64(pointer)); if err != nil {
+			return nil, nil, err
+		}
+		if len(nodeBytes) == 0 {
+			addIssue(pointer, "linkedlist node `%d` not found", pointer)
+			break
+		}
+		node, err := DecodeLinkedNode(nodeBytes); if err != nil {
+			addIssue(pointer, "linkedlist node `%d` decode error `%s`", pointer, err.Error())
+			break
+		}
+		if node.Prev != prev {
+			addIssue(pointer, "linkedlist node `%d` prev `%d` error, expect `%d`", pointer, node.Prev, prev)
+		}
+		if len(node.Values) == 0 {
+			addIssue(pointer, "linkedlist node `%d` is empty", pointer)
+		}
+		values = append(values, node.Values...)
+		nodeNum++
+		prev = pointer
+		pointer = node.Next
+	}
+	if prev != head.Last {
+		addIssue(prev, "linkedlist last `%d` error, expect `%d`", head.Last, prev)
+	}
+	if nodeNum != head.Order {
+		addIssue(0, "linkedlist node num `%d` is not equal to order `%d`", nodeNum, head.Order)
+	}
+	if int64(len(values)) != head.Num {
+		addIssue(0, "linkedlist value num `%d` is not equal to head num `%d`", len(values), head.Num)
+	}
+	return values, issues, nil
+}
+
+/**
+	删除链表所有节点和链表头，返回删除的节点数量
+ */
+func (service *LinkedListImpl) Discard(head *LinkedHead) (int, error) {
+	if head == nil {
+		return 0, fmt.Errorf("linkedlist head is null")
+	}
+	num := 0
+	for pointer := Pointer(1); pointer <= head.Order; pointer++ {
+		if err := service.storage.DelNode(head.Key, int64(pointer)); err != nil {
+			return num, err
+		}
+		num++
+	}
+	return num, service.storage.DelHead(head.Key)
+}
+
+/**
+	重写链表所有值，从第一个节点开始覆盖写入，多余的旧节点删除
+ */
+func (service *LinkedListImpl) Rewrite(head *LinkedHead, values [][]byte) error {
+	if head == nil {
+		return fmt.Errorf("linkedlist head is null")
+	}
+	order := head.Order
+	head.Order,head.Num,head.First,head.Last = 0,0,0,0
+	if err := service.Insert(head, values); err != nil {
+		return err
+	}
+	for pointer := head.Order+1; pointer <= order; pointer++ {
+		if err := service.storage.DelNode(head.Key, int64(pointer)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
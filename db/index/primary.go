@@ -31,6 +31,9 @@ func(parse *PrimaryParse) RowBlockIDList(kvList []*db.KV) ([]db.RowBlockID,error
 	if len(kvList) > 0 {
 		rowBlockIDList = make([]db.RowBlockID, 0, len(kvList))
 		for _,kv := range kvList {
+			if len(kv.Value) == 0 {//压缩清除了所有版本的行不存在
+				continue
+			}
 			blockID,err := parse.BlockID(kv.Value); if err != nil {
 				return rowBlockIDList,err
 			}
@@ -116,4 +119,17 @@ func(insertImpl *PrimaryInsert) Append(kv *db.KV, oldKV *db.KV) (*tree.RefNode,e
 	}
 	refNode.Update = true
 	return refNode,nil
-}
\ No newline at end of file
+}
+
+/*
+	重写历史block记录，转为链表结构时关键字值保存最新的记录
+*/
+func(insertImpl *PrimaryInsert) Rewrite(kv *db.KV, oldKV *db.KV) (*tree.RefNode,error) {
+	refNode,err := insertImpl.DefaultInsert.Rewrite(kv, oldKV); if err != nil {
+		return nil,err
+	}
+	if kv.VType == db.ValueTypeLinkedList {
+		kv.Value = refNode.Values[len(refNode.Values)-1]
+	}
+	return refNode,nil
+}
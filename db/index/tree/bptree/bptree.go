@@ -5,7 +5,6 @@ import (
 	"fmt"
 	"github.com/database-fabric/db"
 	"github.com/database-fabric/db/index/tree"
-	"github.com/database-fabric/db/util"
 )
 
 type Position = int16 //关键字位置，最大值32768(一个节点关键字数量不超过1000)
@@ -66,12 +65,7 @@ func SplitMid(num Position) Position {
 }
 
 func GetNodeSize(node *tree.TreeNode) (int, error) {
-	nodeBytes, err := util.ConvertJsonBytes(*node)
-	if err != nil {
-		return 0, err
-	}
-	nodeSize := len(nodeBytes) + tree.NODE_NAME_SIZE
-	return nodeSize, nil
+	return tree.TreeNodeSize(node) + tree.NODE_NAME_SIZE, nil
 }
 
 //////////////////////////////// TreeNodeCache Function ////////////////////////////////
@@ -242,7 +236,7 @@ func (split *TreeSplit) GetIsSplit() (bool, error) {
 		return false,nil
 	}
 	isSplit := false
-	if tree.NODE_SPLIT_RULE == 1 {
+	if split.Cache.Head.GetSplitRule() == db.SplitRuleKeyNum {
 		if keyNum >= tree.MAX_NODE_KEY_NUM {//当前数量已满，无法插入
 			isSplit = true
 		}
@@ -252,7 +246,7 @@ func (split *TreeSplit) GetIsSplit() (bool, error) {
 			return false, err
 		}
 		addSize := len(kv.Key) + len(kv.Value) + tree.NODE_POINTER_SIZE*2
-		if nodeSize+addSize > tree.MAX_NODE_SIZE {
+		if nodeSize+addSize > split.Cache.Head.GetNodeSize() {
 			isSplit = true
 		}
 	}
@@ -479,7 +473,7 @@ func (split *TreeSplit) moveKey() error {
 		if compare == tree.CompareEq {//变更
 			node.Keys[position] = insertKV.Key
 			node.Values[position] = insertKV.Value
-		}else if position+1 == keyNum { //插入到最右边
+		}else if position+1 == keyNum && compare != tree.CompareLt { //插入到最右边(只有一个key时插入到左边需要移动)
 			node.Keys = append(node.Keys, insertKV.Key)
 			node.Values = append(node.Values, insertKV.Value)
 		} else { //插入中间，需要移动右边元素
@@ -9,6 +9,7 @@ import (
 	"github.com/database-fabric/db/util"
 	"github.com/database-fabric/test"
 	"github.com/stretchr/testify/assert"
+	"math/rand"
 	"testing"
 )
 
@@ -117,6 +118,67 @@ func TestBPTree(t *testing.T){
 		assert.EqualValues(t, list[len(list)-1].Key, endKey,"end key error")
 		assert.EqualValues(t, list[len(list)-1].Value, endKey,"end value error")
 		assert.EqualValues(t, list[len(list)-1].VType, db.ValueTypeData,"end type error")
+		//游标分页查询，结果与区间查询一致
+		for _,order := range []db.OrderType{db.ASC,db.DESC} {
+			var keys [][]byte
+			var cursor *db.Cursor
+			for {
+				list,next,err := bPTreeImpl.SearchByCursor(treeHead,nil,nil, cursor, order,70); if err != nil {
+					panic(err.Error())
+				}
+				for _,kv := range list {
+					keys = append(keys, kv.Key)
+				}
+				if next == nil {
+					break
+				}
+				cursor = next
+			}
+			list,err = bPTreeImpl.SearchByRange(treeHead,nil,nil, order, size); if err != nil {
+				panic(err.Error())
+			}
+			assert.EqualValues(t, len(list), len(keys), "cursor key num error")
+			for i,kv := range list {
+				assert.EqualValues(t, kv.Key, keys[i], "cursor key error")
+			}
+		}
+		//游标节点关键字变化时按关键字重新定位，区间结束位置不变
+		list,cursor,err := bPTreeImpl.SearchByCursor(treeHead, tree.PointerToBytes(100), tree.PointerToBytes(200), nil, db.ASC,10); if err != nil {
+			panic(err.Error())
+		}
+		assert.EqualValues(t, list[len(list)-1].Key, tree.PointerToBytes(109), "cursor page end error")
+		cursor.Position++
+		list,cursor,err = bPTreeImpl.SearchByCursor(treeHead, tree.PointerToBytes(100), tree.PointerToBytes(200), cursor, db.ASC,100); if err != nil {
+			panic(err.Error())
+		}
+		assert.EqualValues(t, list[0].Key, tree.PointerToBytes(110), "cursor relocate error")
+		assert.Len(t, list, 91, "cursor end key error")
+		assert.Nil(t, cursor, "cursor last page error")
+		//区间计数，区间边界可以不存在
+		count,err := bPTreeImpl.CountByRange(treeHead,nil,nil,nil); if err != nil {
+			panic(err.Error())
+		}
+		assert.EqualValues(t, size, count, "count all error")
+		count,err = bPTreeImpl.CountByRange(treeHead, tree.PointerToBytes(100), tree.PointerToBytes(200),nil); if err != nil {
+			panic(err.Error())
+		}
+		assert.EqualValues(t, 101, count, "count range error")
+		count,err = bPTreeImpl.CountByRange(treeHead, tree.PointerToBytes(0), tree.PointerToBytes(10),nil); if err != nil {
+			panic(err.Error())
+		}
+		assert.EqualValues(t, 10, count, "count start error")
+		count,err = bPTreeImpl.CountByRange(treeHead, tree.PointerToBytes(990), tree.PointerToBytes(2000),nil); if err != nil {
+			panic(err.Error())
+		}
+		assert.EqualValues(t, 11, count, "count end error")
+		count,err = bPTreeImpl.CountByRange(treeHead, tree.PointerToBytes(500),nil, func(kv *db.KV) (bool,error) {
+			return tree.BytesToPointer(kv.Value)%2 == 0,nil
+		}); if err != nil {
+			panic(err.Error())
+		}
+		assert.EqualValues(t, 251, count, "count filter error")
+		_,err = bPTreeImpl.CountByRange(treeHead, tree.PointerToBytes(200), tree.PointerToBytes(100),nil)
+		assert.NotNil(t, err, "count range order error")
 		//精确查询-存在
 		findKey := tree.PointerToBytes(678)
 		kv,err := bPTreeImpl.Search(treeHead, findKey); if err != nil {
@@ -146,6 +208,129 @@ func TestBPTree(t *testing.T){
 		//}
 		fmt.Println(util.ConvertJsonString(*treeHead))
 	}
+	//树节点容量配置
+	{
+		insert := func(column db.ColumnID, nodeSize int32) *tree.TreeHead {
+			key := db.ColumnKey{Database:db.DatabaseID(1),Table:db.TableID(1),Column:column}
+			treeHead,err := bPTreeImpl.CreateHead(key, tree.TreeTypeDefault); if err != nil {
+				panic(err.Error())
+			}
+			assert.True(t, treeHead.SetConfig(tree.TreeTypeAsc, nodeSize, db.SplitRuleSize))
+			for i:=tree.Pointer(1); i <= 2000; i++ {
+				v := tree.PointerToBytes(i)
+				if _,err := bPTreeImpl.Insert(treeHead, v, v, tree.InsertTypeDefault); err != nil {
+					panic(err.Error())
+				}
+			}
+			assert.False(t, treeHead.SetConfig(tree.TreeTypeDefault, 0, 0),"config modified after insert")
+			return treeHead
+		}
+		smallHead := insert(db.ColumnID(4), db.MinNodeSize)
+		bigHead := insert(db.ColumnID(5), db.DefaultNodeSize*4)
+		assert.True(t, smallHead.NodeNum > bigHead.NodeNum,"node num error")
+		for pointer:=tree.Pointer(1); pointer <= smallHead.NodeOrder; pointer++ {
+			node,err := bPTreeImpl.getNode(pointer, smallHead); if err != nil {
+				panic(err.Error())
+			}
+			if node == nil {
+				continue
+			}
+			size,err := GetNodeSize(node); if err != nil {
+				panic(err.Error())
+			}
+			assert.True(t, size <= db.MinNodeSize,"node size error")
+		}
+		queryHead,err := bPTreeImpl.SearchHead(smallHead.Key); if err != nil {
+			panic(err.Error())
+		}
+		assert.EqualValues(t, db.MinNodeSize, queryHead.NodeSize,"head node size error")
+		assert.EqualValues(t, tree.TreeTypeAsc, queryHead.Type,"head type error")
+		assert.EqualValues(t, db.SplitRuleSize, queryHead.SplitRule,"head split rule error")
+	}
+	//旧JSON编码兼容读取并迁移
+	{
+		key := db.ColumnKey{Database:db.DatabaseID(1),Table:db.TableID(1),Column:db.ColumnID(3)}
+		treeHead,err := bPTreeImpl.CreateHead(key, tree.TreeTypeAsc); if err != nil {
+			panic(err.Error())
+		}
+		size := tree.Pointer(500)
+		for i:=tree.Pointer(1); i <= size; i++ {
+			v := tree.PointerToBytes(i)
+			if _,err := bPTreeImpl.Insert(treeHead, v, v, tree.InsertTypeDefault); err != nil {
+				panic(err.Error())
+			}
+		}
+		for pointer:=tree.Pointer(1); pointer <= treeHead.NodeOrder; pointer++ {
+			node,err := bPTreeImpl.getNode(pointer, treeHead); if err != nil {
+				panic(err.Error())
+			}
+			nodeBytes,err := util.ConvertJsonBytes(*node); if err != nil {
+				panic(err.Error())
+			}
+			if err := bPTreeImpl.storage.PutNode(key, int64(pointer), nodeBytes); err != nil {
+				panic(err.Error())
+			}
+		}
+		headBytes,err := util.ConvertJsonBytes(*treeHead); if err != nil {
+			panic(err.Error())
+		}
+		if err := bPTreeImpl.storage.PutHead(key, headBytes); err != nil {
+			panic(err.Error())
+		}
+		kv,err := bPTreeImpl.Search(treeHead, tree.PointerToBytes(size)); if err != nil {
+			panic(err.Error())
+		}
+		assert.NotNil(t, kv,"legacy node search error")
+		num,err := bPTreeImpl.Migrate(key); if err != nil {
+			panic(err.Error())
+		}
+		assert.EqualValues(t, treeHead.NodeOrder+1, num,"migrate num error")
+		num,err = bPTreeImpl.Migrate(key); if err != nil {
+			panic(err.Error())
+		}
+		assert.EqualValues(t, 0, num,"migrate again num error")
+		list,err := bPTreeImpl.SearchByRange(treeHead,nil,nil, db.ASC, size); if err != nil {
+			panic(err.Error())
+		}
+		assert.EqualValues(t, size, len(list),"migrate key num error")
+	}
+	//乱序插入(分片自增主键不保证顺序)
+	{
+		key := db.ColumnKey{Database:db.DatabaseID(1),Table:db.TableID(1),Column:db.ColumnID(2)}
+		treeHead,err := bPTreeImpl.CreateHead(key, tree.TreeTypeDefault); if err != nil {
+			panic(err.Error())
+		}
+		size := 2000
+		for _,i := range rand.New(rand.NewSource(1)).Perm(size) {
+			v := tree.PointerToBytes(tree.Pointer(i+1))
+			if _,err := bPTreeImpl.Insert(treeHead, v, v, tree.InsertTypeDefault); err != nil {
+				panic(err.Error())
+			}
+		}
+		list, err := bPTreeImpl.SearchByRange(treeHead,nil,nil, db.ASC, tree.Pointer(size)); if err != nil {
+			panic(err.Error())
+		}
+		assert.EqualValues(t, size, len(list),"key num error")
+		for i,kv := range list {
+			assert.EqualValues(t, tree.PointerToBytes(tree.Pointer(i+1)), kv.Key,"key order error")
+		}
+		kv,err := bPTreeImpl.Search(treeHead, tree.PointerToBytes(1)); if err != nil {
+			panic(err.Error())
+		}
+		assert.NotNil(t, kv,"find first key error")
+		//乱序插入后树结构检查
+		visits := 0
+		check,issues,err := bPTreeImpl.Check(treeHead, func(kv *db.KV) error {
+			visits++
+			return nil
+		}); if err != nil {
+			panic(err.Error())
+		}
+		assert.Empty(t, issues, "check tree issues error")
+		assert.EqualValues(t, size, check.Keys, "check tree keys error")
+		assert.Equal(t, size, visits, "check tree visit error")
+		assert.EqualValues(t, treeHead.NodeNum, check.Nodes, "check tree nodes error")
+	}
 
 
 	//主键：顺序插入1000条
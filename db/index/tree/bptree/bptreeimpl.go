@@ -2,12 +2,12 @@ package bptree
 
 import (
 	"bytes"
-	"encoding/json"
 	"fmt"
 	"github.com/database-fabric/db"
 	"github.com/database-fabric/db/index/tree"
 	"github.com/database-fabric/db/storage"
 	"github.com/database-fabric/db/util"
+	"sort"
 )
 
 /////////////////// Service Function ///////////////////
@@ -29,8 +29,8 @@ func (service *BPTreeImpl) getHead(key db.ColumnKey) (*tree.TreeHead, error) {
 	}
 	var head *tree.TreeHead
 	if headBytes != nil && len(headBytes) > 0{
-		head = &tree.TreeHead{}
-		if err := json.Unmarshal(headBytes, head); err != nil {
+		head, err = tree.DecodeTreeHead(headBytes)
+		if err != nil {
 			return nil, err
 		}
 	}
@@ -55,18 +55,14 @@ func (service *BPTreeImpl) createHead(key db.ColumnKey, treeType tree.TreeType,
 }
 
 func (service *BPTreeImpl) getNode(pointer tree.Pointer, head *tree.TreeHead) (*tree.TreeNode, error) {
-	nodeBytes, err := service.storage.GetNode(head.Key, util.Int64ToString(int64(pointer)))
+	nodeBytes, err := service.storage.GetNode(head.Key, int64(pointer))
 	if err != nil {
 		return nil, err
 	}
 	if nodeBytes == nil || len(nodeBytes) == 0 {
 		return nil, fmt.Errorf("node `%d` not found", pointer)
 	} else {
-		node := &tree.TreeNode{}
-		if err := json.Unmarshal(nodeBytes, node); err != nil {
-			return nil, err
-		}
-		return node,nil
+		return tree.DecodeTreeNode(nodeBytes)
 	}
 }
 
@@ -91,19 +87,12 @@ func (service *BPTreeImpl) putNode(cache *TreeNodeCache) error {
 		//fmt.Println(nodePosition.Node.Keys)
 		//fmt.Println(nodePosition.Node.Values)
 		//fmt.Println(util.ConvertJsonString(*nodePosition.Node))
-		nodeBytes, err := util.ConvertJsonBytes(*nodePosition.Node)
-		if err != nil {
-			return err
-		}
-		if err := service.storage.PutNode(cache.Head.Key, util.Int64ToString(int64(pointer)), nodeBytes); err != nil {
+		nodeBytes := tree.EncodeTreeNode(nodePosition.Node)
+		if err := service.storage.PutNode(cache.Head.Key, int64(pointer), nodeBytes); err != nil {
 			return err
 		}
 	}
-	headBytes, err := util.ConvertJsonBytes(*cache.Head)
-	if err != nil {
-		return err
-	}
-	if err := service.storage.PutHead(cache.Head.Key, headBytes); err != nil {
+	if err := service.storage.PutHead(cache.Head.Key, tree.EncodeTreeHead(cache.Head)); err != nil {
 		return err
 	}
 	return nil
@@ -397,42 +386,75 @@ func (service *BPTreeImpl) Search(head *tree.TreeHead, key []byte) (*db.KV, erro
 	降序：startKey为空默认为最右，endKey为空默认为最左
 */
 func (service *BPTreeImpl) SearchByRange(head *tree.TreeHead, startKey []byte, endKey []byte, order db.OrderType, size tree.Pointer) ([]*db.KV, error) {
+	list,_,err := service.searchByRange(head, startKey, endKey, nil, order, size)
+	return list,err
+}
+
+/**
+	游标分页查询，cursor为上一页返回的游标(为空时从startKey开始)，从游标位置之后继续查询
+	返回的游标为本页最后一个关键字的位置，本页数量不足size时没有下一页，返回空游标
+*/
+func (service *BPTreeImpl) SearchByCursor(head *tree.TreeHead, startKey []byte, endKey []byte, cursor *db.Cursor, order db.OrderType, size tree.Pointer) ([]*db.KV, *db.Cursor, error) {
+	if cursor != nil && cursor.Order != order {
+		return nil,nil,fmt.Errorf("cursor order `%d` not match `%d`", cursor.Order, order)
+	}
+	return service.searchByRange(head, startKey, endKey, cursor, order, size)
+}
+
+func (service *BPTreeImpl) searchByRange(head *tree.TreeHead, startKey []byte, endKey []byte, cursor *db.Cursor, order db.OrderType, size tree.Pointer) ([]*db.KV, *db.Cursor, error) {
 	var err error
 	var node *tree.TreeNode
+	current := tree.Pointer(0)
 	position := Position(0)
-	if order == db.ASC {
+	pageSize := size
+	if cursor != nil {
+		//游标节点中的关键字未变化时直接从游标之后继续，否则按关键字重新定位
+		current = tree.Pointer(cursor.Node)
+		node,err = service.getNode(current, head)
+		position = Position(cursor.Position)
+		if err != nil || node.Type != tree.NodeTypeLeaf || position >= Position(len(node.Keys)) || !bytes.Equal(node.Keys[position], cursor.Key) {
+			node,startKey = nil,cursor.Key
+		}else if order == db.ASC {
+			position++
+		}else{
+			position--
+		}
+	}else if order == db.ASC {
 		if len(startKey) == 0 {
-			node,err = service.getNode(head.FirstLeaf, head); if err != nil {
-				return nil,err
+			current = head.FirstLeaf
+			node,err = service.getNode(current, head); if err != nil {
+				return nil,nil,err
 			}
 			startKey = node.Keys[position]
 		}
 		if len(endKey) > 0 && bytes.Compare(endKey, startKey) != 1 {
-			return nil,fmt.Errorf("asc must startKey `%v` <= endKey `%v`", startKey, endKey)
+			return nil,nil,fmt.Errorf("asc must startKey `%v` <= endKey `%v`", startKey, endKey)
 		}
 	}else{
 		if len(startKey) == 0 {
-			node,err = service.getNode(head.LastLeaf, head); if err != nil {
-				return nil,err
+			current = head.LastLeaf
+			node,err = service.getNode(current, head); if err != nil {
+				return nil,nil,err
 			}
 			position = Position(len(node.Keys)-1)
 			startKey = node.Keys[position]
 		}
 		if  len(endKey) > 0 && bytes.Compare(startKey, endKey) != 1 {
-			return nil,fmt.Errorf("desc must endKey `%v` <= startKey `%v`", endKey, startKey)
+			return nil,nil,fmt.Errorf("desc must endKey `%v` <= startKey `%v`", endKey, startKey)
 		}
 	}
 	if node == nil {
 		cache,err := createTreeNodeCache(head,false); if err != nil {
-			return nil,err
+			return nil,nil,err
 		}
 		if TreeIsNull(head) {
-			return nil, fmt.Errorf("tree is null")
+			return nil,nil, fmt.Errorf("tree is null")
 		}
 		keyData, err := service.findPosition(startKey, cache)
 		if err != nil {
-			return nil, err
+			return nil,nil, err
 		}
+		current = keyData.KeyPosition.NodePosition.Pointer
 		node = keyData.KeyPosition.NodePosition.Node
 		position = keyData.KeyPosition.Position
 		compare := keyData.KeyPosition.Compare
@@ -440,32 +462,46 @@ func (service *BPTreeImpl) SearchByRange(head *tree.TreeHead, startKey []byte, e
 			position--
 		}else if compare == tree.CompareGt && order == db.ASC {//大于，升序需要往右移
 			position++
+		}else if compare == tree.CompareEq && cursor != nil {//游标关键字已返回，从下一个开始
+			if order == db.ASC {
+				position++
+			}else{
+				position--
+			}
 		}
 	}
 	if node != nil {
 		list := make([]*db.KV, 0, size)
+		var next *db.Cursor
 		isLoop := true
 		for isLoop {
 			pointer := tree.Pointer(0)
+			num := len(list)
 			if order == db.ASC {
 				pointer = node.Next
 				if position < Position(len(node.Keys)) {
 					isLoop,err = service.rangeSearchByAsc(node, position, endKey, &size, &list); if err != nil {
-						return nil,err
+						return nil,nil,err
 					}
 				}
 			}else{
 				pointer = node.Prev
 				if position >= 0 {
 					isLoop,err = service.rangeSearchByDesc(node, position, endKey, &size, &list); if err != nil {
-						return nil,err
+						return nil,nil,err
 					}
 				}
 			}
+			if size == 0 && len(list) > num {//本页已满，记录最后一个关键字的位置
+				last := list[len(list)-1].Key
+				index := Position(sort.Search(len(node.Keys), func(i int) bool { return bytes.Compare(node.Keys[i], last) >= 0 }))
+				next = &db.Cursor{Order:order,Node:int64(current),Position:int32(index),Key:last}
+			}
 			node = nil//查询完node设置为空释放内存
 			if isLoop && pointer > tree.Pointer(0) {
+				current = pointer
 				node,err = service.getNode(pointer, head); if err != nil {
-					return nil,err
+					return nil,nil,err
 				}
 				if order == db.ASC {
 					position = Position(0)
@@ -476,9 +512,82 @@ func (service *BPTreeImpl) SearchByRange(head *tree.TreeHead, startKey []byte, e
 				isLoop = false
 			}
 		}
-		return list, nil
+		if tree.Pointer(len(list)) < pageSize {
+			next = nil
+		}
+		return list,next, nil
 	}
-	return nil, nil
+	return nil,nil, nil
+}
+
+/**
+	区间计数，统计[startKey,endKey]内的关键字数量，startKey为空默认为最左，endKey为空默认为最右
+	filter为空时按叶子节点关键字数量累加，只比较区间边界所在节点的关键字，不解析关键字值
+	filter不为空时解析区间内每个关键字值，只统计filter返回true的关键字
+*/
+func (service *BPTreeImpl) CountByRange(head *tree.TreeHead, startKey []byte, endKey []byte, filter func(kv *db.KV) (bool,error)) (int64, error) {
+	if TreeIsNull(head) {
+		return 0,nil
+	}
+	if len(startKey) > 0 && len(endKey) > 0 && bytes.Compare(startKey, endKey) == 1 {
+		return 0,fmt.Errorf("count must startKey `%v` <= endKey `%v`", startKey, endKey)
+	}
+	var node *tree.TreeNode
+	position := Position(0)
+	if len(startKey) == 0 {
+		var err error
+		node,err = service.getNode(head.FirstLeaf, head); if err != nil {
+			return 0,err
+		}
+	}else{
+		cache,err := createTreeNodeCache(head,false); if err != nil {
+			return 0,err
+		}
+		keyData,err := service.findPosition(startKey, cache); if err != nil {
+			return 0,err
+		}
+		node = keyData.KeyPosition.NodePosition.Node
+		position = keyData.KeyPosition.Position
+		if keyData.KeyPosition.Compare == tree.CompareGt {//大于，起始位置往右移
+			position++
+		}
+	}
+	count := int64(0)
+	for node != nil {
+		end := Position(len(node.Keys))
+		isLoop := true
+		if len(endKey) > 0 && end > 0 && bytes.Compare(node.Keys[end-1], endKey) == 1 {//区间结束在本节点内
+			end = Position(sort.Search(len(node.Keys), func(i int) bool { return bytes.Compare(node.Keys[i], endKey) == 1 }))
+			isLoop = false
+		}
+		if filter == nil {
+			if end > position {
+				count += int64(end - position)
+			}
+		}else{
+			for i:=position;i<end;i++ {
+				kv,err := service.parseValue(node.Keys[i], node.Values[i]); if err != nil {
+					return 0,err
+				}
+				ok,err := filter(kv); if err != nil {
+					return 0,err
+				}
+				if ok {
+					count++
+				}
+			}
+		}
+		pointer := node.Next
+		node = nil
+		if isLoop && pointer > tree.Pointer(0) {
+			var err error
+			node,err = service.getNode(pointer, head); if err != nil {
+				return 0,err
+			}
+			position = Position(0)
+		}
+	}
+	return count,nil
 }
 
 func (service *BPTreeImpl) Print(head *tree.TreeHead, printData bool) error {
@@ -506,3 +615,285 @@ func (service *BPTreeImpl) Print(head *tree.TreeHead, printData bool) error {
 	}
 	return nil
 }
+
+
+/**
+	旧JSON编码的树头和节点重写为二进制编码(包括叶子节点中的集合值)，返回重写数量
+ */
+func (service *BPTreeImpl) Migrate(key db.ColumnKey) (int, error) {
+	_, num, err := service.MigrateNodes(key, 1, 0, nil)
+	return num, err
+}
+
+/**
+	从start开始重写最多size个树节点(size为0时不限制)，叶子节点的关键字按visit访问(用于迁移关键字的链表)
+	返回下一批开始的节点指针，全部节点处理完成后重写树头并返回0
+ */
+func (service *BPTreeImpl) MigrateNodes(key db.ColumnKey, start tree.Pointer, size tree.Pointer, visit func(kv *db.KV) error) (tree.Pointer, int, error) {
+	headBytes, err := service.storage.GetHead(key); if err != nil {
+		return 0, 0, err
+	}
+	if len(headBytes) == 0 {
+		return 0, 0, nil
+	}
+	head, err := tree.DecodeTreeHead(headBytes); if err != nil {
+		return 0, 0, err
+	}
+	if start < 1 {
+		start = 1
+	}
+	num := 0
+	pointer := start
+	for ; pointer <= head.NodeOrder; pointer++ {
+		if size > 0 && pointer-start >= size {
+			return pointer, num, nil
+		}
+		nodeBytes, err := service.storage.GetNode(key, int64(pointer)); if err != nil {
+			return 0, num, err
+		}
+		if len(nodeBytes) == 0 {
+			continue
+		}
+		node, err := tree.DecodeTreeNode(nodeBytes); if err != nil {
+			return 0, num, err
+		}
+		isWrite := util.IsJsonEncode(nodeBytes)
+		if node.Type == tree.NodeTypeLeaf {
+			for i, value := range node.Values {
+				last := len(value) - 1
+				if last < 0 {
+					continue
+				}
+				if visit != nil && i < len(node.Keys) {
+					if err := visit(&db.KV{Key:node.Keys[i],Value:value[:last],VType:value[last]}); err != nil {
+						return 0, num, err
+					}
+				}
+				if value[last] != db.ValueTypeCollection || !util.IsJsonEncode(value[:last]) {
+					continue
+				}
+				collection, err := tree.DecodeCollection(value[:last]); if err != nil {
+					return 0, num, err
+				}
+				node.Values[i] = append(tree.EncodeCollection(collection), db.ValueTypeCollection)
+				isWrite = true
+			}
+		}
+		if isWrite {
+			if err := service.storage.PutNode(key, int64(pointer), tree.EncodeTreeNode(node)); err != nil {
+				return 0, num, err
+			}
+			num++
+		}
+	}
+	if util.IsJsonEncode(headBytes) {
+		if err := service.storage.PutHead(key, tree.EncodeTreeHead(head)); err != nil {
+			return 0, num, err
+		}
+		num++
+	}
+	return 0, num, nil
+}
+
+/**
+	删除树节点，叶子节点的关键字先按visit访问(用于删除关键字的链表)，节点不存在时跳过
+*/
+func (service *BPTreeImpl) DiscardNode(key db.ColumnKey, pointer tree.Pointer, visit func(kv *db.KV) error) error {
+	nodeBytes, err := service.storage.GetNode(key, int64(pointer)); if err != nil {
+		return err
+	}
+	if len(nodeBytes) == 0 {
+		return nil
+	}
+	node, err := tree.DecodeTreeNode(nodeBytes); if err != nil {
+		return err
+	}
+	if node.Type == tree.NodeTypeLeaf && visit != nil {
+		for i, value := range node.Values {
+			last := len(value) - 1
+			if i >= len(node.Keys) || last < 0 {
+				continue
+			}
+			if err := visit(&db.KV{Key:node.Keys[i],Value:value[:last],VType:value[last]}); err != nil {
+				return err
+			}
+		}
+	}
+	return service.storage.DelNode(key, int64(pointer))
+}
+
+/**
+	删除树头，树节点需要先删除
+*/
+func (service *BPTreeImpl) DiscardHead(key db.ColumnKey) error {
+	return service.storage.DelHead(key)
+}
+
+//树检查过程中的状态
+type treeChecker struct {
+	head *tree.TreeHead
+	visit func(kv *db.KV) error
+	check *db.IndexCheck
+	issues []db.CheckIssue
+	visited map[tree.Pointer]bool
+	leaves []*TreeNodePosition //按关键字顺序的叶子节点
+	keyNum int64 //所有节点关键字数量
+}
+
+func (checker *treeChecker) addIssue(pointer tree.Pointer, format string, args ...interface{}) {
+	checker.issues = append(checker.issues, db.CheckIssue{Kind:db.CheckKindTree,Column:checker.head.Key.Column,Pointer:pointer,
+		Error:fmt.Sprintf(format, args...),Repair:db.RepairRebuildIndex})
+}
+
+/**
+	从根节点递归检查，子节点i的关键字在[keys[i],keys[i+1])区间内(最左子节点下界使用父级下界)
+ */
+func (service *BPTreeImpl) checkNode(checker *treeChecker, pointer tree.Pointer, parent *TreeNodePosition, depth int8, lower []byte, upper []byte) error {
+	head := checker.head
+	if pointer <= 0 || pointer > head.NodeOrder {
+		checker.addIssue(pointer, "node `%d` out of node order `%d`", pointer, head.NodeOrder)
+		return nil
+	}
+	if checker.visited[pointer] {
+		checker.addIssue(pointer, "node `%d` is referenced more than once", pointer)
+		return nil
+	}
+	checker.visited[pointer] = true
+	nodeBytes, err := service.storage.GetNode(head.Key, int64(pointer)); if err != nil {
+		return err
+	}
+	if len(nodeBytes) == 0 {
+		checker.addIssue(pointer, "node `%d` not found", pointer)
+		return nil
+	}
+	node, err := tree.DecodeTreeNode(nodeBytes); if err != nil {
+		checker.addIssue(pointer, "node `%d` decode error `%s`", pointer, err.Error())
+		return nil
+	}
+	checker.check.Nodes++
+	checker.keyNum += int64(len(node.Keys))
+	nodeType := tree.NodeTypeChild
+	if depth == head.Height {
+		nodeType = tree.NodeTypeLeaf
+	}else if depth == 1 {
+		nodeType = tree.NodeTypeRoot
+	}
+	if node.Type != nodeType {
+		checker.addIssue(pointer, "node `%d` type `%d` error at height `%d`, expect `%d`", pointer, node.Type, depth, nodeType)
+		return nil
+	}
+	if len(node.Keys) == 0 || len(node.Keys) != len(node.Values) {
+		checker.addIssue(pointer, "node `%d` keys `%d` values `%d` error", pointer, len(node.Keys), len(node.Values))
+		return nil
+	}
+	for i, key := range node.Keys {
+		if i > 0 && bytes.Compare(node.Keys[i-1], key) >= 0 {
+			checker.addIssue(pointer, "node `%d` key `%v` at `%d` is not ascending", pointer, key, i)
+			break
+		}
+		if (len(lower) > 0 && bytes.Compare(key, lower) < 0) || (len(upper) > 0 && bytes.Compare(key, upper) >= 0) {
+			checker.addIssue(pointer, "node `%d` key `%v` out of parent range [%v,%v)", pointer, key, lower, upper)
+			break
+		}
+	}
+	nodePosition := &TreeNodePosition{pointer, node, parent, nil, nil, 0}
+	if node.Type == tree.NodeTypeLeaf {
+		checker.leaves = append(checker.leaves, nodePosition)
+		for i, key := range node.Keys {
+			if len(node.Values[i]) == 0 {
+				checker.addIssue(pointer, "node `%d` key `%v` value is null", pointer, key)
+				continue
+			}
+			kv, err := service.parseValue(key, node.Values[i]); if err != nil {
+				return err
+			}
+			checker.check.Keys++
+			if err := checker.visit(kv); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for i, value := range node.Values {
+		if len(value) != tree.NODE_POINTER_SIZE {
+			checker.addIssue(pointer, "node `%d` child pointer at `%d` error", pointer, i)
+			continue
+		}
+		childLower, childUpper := lower, upper
+		if i > 0 {
+			childLower = node.Keys[i]
+		}
+		if i+1 < len(node.Keys) {
+			childUpper = node.Keys[i+1]
+		}
+		if err := service.checkNode(checker, tree.BytesToPointer(value), nodePosition, depth+1, childLower, childUpper); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/**
+	检查树结构：关键字顺序、叶子节点双向链表、首尾叶子、节点数量、关键字数量和高度
+	visit按关键字顺序访问叶子节点的值
+	关键字数量在已存在关键字更新值时也会累加，只验证不小于实际关键字数量
+ */
+func (service *BPTreeImpl) Check(head *tree.TreeHead, visit func(kv *db.KV) error) (*db.IndexCheck, []db.CheckIssue, error) {
+	if head == nil {
+		return nil, nil, fmt.Errorf("tree head is null")
+	}
+	checker := &treeChecker{head:head,visit:visit,check:&db.IndexCheck{Column:head.Key.Column,Height:head.Height},visited:map[tree.Pointer]bool{}}
+	if TreeIsNull(head) {
+		if head.NodeNum != 0 || head.KeyNum != 0 {
+			checker.addIssue(head.Root, "empty tree with nodes `%d` keys `%d`", head.NodeNum, head.KeyNum)
+		}
+		return checker.check, checker.issues, nil
+	}
+	if head.Height > tree.MAX_TREE_HEIGHT {
+		checker.addIssue(head.Root, "tree height `%d` exceeds %d", head.Height, tree.MAX_TREE_HEIGHT)
+		return checker.check, checker.issues, nil
+	}
+	if err := service.checkNode(checker, head.Root, nil, 1, nil, nil); err != nil {
+		return nil, nil, err
+	}
+	//叶子节点链表与树中顺序一致
+	leaves := checker.leaves
+	for i, leaf := range leaves {
+		prev, next := tree.Pointer(0), tree.Pointer(0)
+		if i > 0 {
+			prev = leaves[i-1].Pointer
+		}
+		if i+1 < len(leaves) {
+			next = leaves[i+1].Pointer
+		}
+		if leaf.Node.Prev != prev || leaf.Node.Next != next {
+			checker.addIssue(leaf.Pointer, "leaf `%d` prev `%d` next `%d` error, expect prev `%d` next `%d`", leaf.Pointer, leaf.Node.Prev, leaf.Node.Next, prev, next)
+		}
+		if i > 0 && bytes.Compare(leaves[i-1].Node.Keys[len(leaves[i-1].Node.Keys)-1], leaf.Node.Keys[0]) >= 0 {
+			checker.addIssue(leaf.Pointer, "leaf `%d` first key is not greater than prev leaf", leaf.Pointer)
+		}
+	}
+	if len(leaves) > 0 && (head.FirstLeaf != leaves[0].Pointer || head.LastLeaf != leaves[len(leaves)-1].Pointer) {
+		checker.addIssue(0, "first leaf `%d` last leaf `%d` error, expect `%d` `%d`", head.FirstLeaf, head.LastLeaf, leaves[0].Pointer, leaves[len(leaves)-1].Pointer)
+	}
+	if checker.check.Nodes != head.NodeNum {
+		checker.addIssue(0, "node num `%d` is not equal to reachable nodes `%d`", head.NodeNum, checker.check.Nodes)
+	}
+	if head.NodeOrder > checker.check.Nodes {//节点不会删除，序号内未访问到的节点不可达
+		for pointer := tree.Pointer(1); pointer <= head.NodeOrder; pointer++ {
+			if checker.visited[pointer] {
+				continue
+			}
+			nodeBytes, err := service.storage.GetNode(head.Key, int64(pointer)); if err != nil {
+				return nil, nil, err
+			}
+			if len(nodeBytes) > 0 {
+				checker.addIssue(pointer, "node `%d` is not reachable from root", pointer)
+			}
+		}
+	}
+	if head.KeyNum < checker.keyNum {
+		checker.addIssue(0, "key num `%d` is less than node keys `%d`", head.KeyNum, checker.keyNum)
+	}
+	return checker.check, checker.issues, nil
+}
@@ -0,0 +1,126 @@
+package tree
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/database-fabric/db"
+	"github.com/database-fabric/db/util"
+)
+
+/**
+	树头、节点、集合二进制编码(兼容读取旧JSON编码)
+	JSON编码会对[]byte做base64，节点体积增加约1/3，并且计算节点大小需要重新序列化
+ */
+
+func EncodeTreeHead(head *TreeHead) []byte {
+	encoder := util.NewEncoder(util.EncodeBinaryV1, 48)
+	encoder.PutInt64(int64(head.Key.Database))
+	encoder.PutInt64(int64(head.Key.Table))
+	encoder.PutInt64(int64(head.Key.Column))
+	encoder.PutInt64(int64(head.Type))
+	encoder.PutInt64(int64(head.Root))
+	encoder.PutInt64(int64(head.Height))
+	encoder.PutInt64(int64(head.NodeOrder))
+	encoder.PutInt64(int64(head.NodeNum))
+	encoder.PutInt64(head.KeyNum)
+	encoder.PutInt64(int64(head.FirstLeaf))
+	encoder.PutInt64(int64(head.LastLeaf))
+	encoder.PutInt64(int64(head.NodeSize))
+	encoder.PutInt64(int64(head.SplitRule))
+	encoder.PutInt64(int64(head.Key.Shard))
+	return encoder.Bytes()
+}
+
+func DecodeTreeHead(value []byte) (*TreeHead, error) {
+	head := &TreeHead{}
+	if util.IsJsonEncode(value) {
+		return head,json.Unmarshal(value, head)
+	}
+	decoder,version,err := util.NewDecoder(value); if err != nil {
+		return nil,err
+	}
+	if version != util.EncodeBinaryV1 {
+		return nil,fmt.Errorf("tree head encode version `%d` error", version)
+	}
+	head.Key.Database = db.DatabaseID(decoder.Int64())
+	head.Key.Table = db.TableID(decoder.Int64())
+	head.Key.Column = db.ColumnID(decoder.Int64())
+	head.Type = TreeType(decoder.Int64())
+	head.Root = Pointer(decoder.Int64())
+	head.Height = int8(decoder.Int64())
+	head.NodeOrder = Pointer(decoder.Int64())
+	head.NodeNum = Pointer(decoder.Int64())
+	head.KeyNum = decoder.Int64()
+	head.FirstLeaf = Pointer(decoder.Int64())
+	head.LastLeaf = Pointer(decoder.Int64())
+	if decoder.Remaining() > 0 {
+		head.NodeSize = int32(decoder.Int64())
+		head.SplitRule = int8(decoder.Int64())
+	}
+	if decoder.Remaining() > 0 {//主键索引分片
+		head.Key.Shard = int8(decoder.Int64())
+	}
+	return head,decoder.Err()
+}
+
+func EncodeTreeNode(node *TreeNode) []byte {
+	encoder := util.NewEncoder(util.EncodeBinaryV1, TreeNodeSize(node))
+	encoder.PutInt64(int64(node.Type))
+	encoder.PutInt64(int64(node.Prev))
+	encoder.PutInt64(int64(node.Next))
+	encoder.PutBytesList(node.Keys)
+	encoder.PutBytesList(node.Values)
+	return encoder.Bytes()
+}
+
+func DecodeTreeNode(value []byte) (*TreeNode, error) {
+	node := &TreeNode{}
+	if util.IsJsonEncode(value) {
+		return node,json.Unmarshal(value, node)
+	}
+	decoder,version,err := util.NewDecoder(value); if err != nil {
+		return nil,err
+	}
+	if version != util.EncodeBinaryV1 {
+		return nil,fmt.Errorf("tree node encode version `%d` error", version)
+	}
+	node.Type = NodeType(decoder.Int64())
+	node.Prev = Pointer(decoder.Int64())
+	node.Next = Pointer(decoder.Int64())
+	node.Keys = decoder.BytesList()
+	node.Values = decoder.BytesList()
+	return node,decoder.Err()
+}
+
+/**
+	节点二进制编码大小，直接计算无需序列化
+ */
+func TreeNodeSize(node *TreeNode) int {
+	return 1 + util.VarintSize(int64(node.Type)) + util.VarintSize(int64(node.Prev)) + util.VarintSize(int64(node.Next)) +
+		util.BytesListSize(node.Keys) + util.BytesListSize(node.Values)
+}
+
+func EncodeCollection(values [][]byte) []byte {
+	encoder := util.NewEncoder(util.EncodeBinaryV1, util.BytesListSize(values))
+	encoder.PutBytesList(values)
+	return encoder.Bytes()
+}
+
+func DecodeCollection(value []byte) ([][]byte, error) {
+	if util.IsJsonEncode(value) {
+		var collection Collection
+		err := json.Unmarshal(value, &collection)
+		return collection.Values,err
+	}
+	decoder,version,err := util.NewDecoder(value); if err != nil {
+		return nil,err
+	}
+	if version != util.EncodeBinaryV1 {
+		return nil,fmt.Errorf("collection encode version `%d` error", version)
+	}
+	values := decoder.BytesList()
+	if decoder.Remaining() > 0 {
+		return nil,fmt.Errorf("collection encode length error")
+	}
+	return values,decoder.Err()
+}
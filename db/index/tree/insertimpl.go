@@ -97,4 +97,19 @@ func(insertImpl *DefaultInsert) Append(kv *db.KV, oldKV *db.KV) (*RefNode,error)
 		kv.Value = value
 	}
 	return refNode,nil
-}
\ No newline at end of file
+}
+
+/*
+	重写原值，值为集合编码，集合元素超过50个时转换为链表结构(链表由引用节点的值重写)
+*/
+func(insertImpl *DefaultInsert) Rewrite(kv *db.KV, oldKV *db.KV) (*RefNode,error) {
+	collection,err := insertImpl.GetParse().CollectionBytes(kv.Value); if err != nil {
+		return nil,err
+	}
+	kv.VType = db.ValueTypeCollection
+	if len(collection) > 50 {
+		kv.VType = db.ValueTypeLinkedList
+		kv.Value = nil
+	}
+	return &RefNode{Update:true,Kv:kv,Values:collection},nil
+}
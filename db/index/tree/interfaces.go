@@ -11,10 +11,20 @@ type TreeInterface interface {
 
 	Search(head *TreeHead, key []byte) (*db.KV,error)
 	SearchByRange(head *TreeHead, startKey []byte, endKey []byte, order db.OrderType, size Pointer) ([]*db.KV, error)
+	SearchByCursor(head *TreeHead, startKey []byte, endKey []byte, cursor *db.Cursor, order db.OrderType, size Pointer) ([]*db.KV, *db.Cursor, error)
+	CountByRange(head *TreeHead, startKey []byte, endKey []byte, filter func(kv *db.KV) (bool,error)) (int64, error)
 
 	Insert(head *TreeHead, key []byte, value []byte, insertType InsertType) (*RefNode,error)
 
 	Print(head *TreeHead, printData bool) error
+
+	Migrate(key db.ColumnKey) (int, error)
+	MigrateNodes(key db.ColumnKey, start Pointer, size Pointer, visit func(kv *db.KV) error) (Pointer, int, error)
+
+	Check(head *TreeHead, visit func(kv *db.KV) error) (*db.IndexCheck, []db.CheckIssue, error)
+
+	DiscardNode(key db.ColumnKey, pointer Pointer, visit func(kv *db.KV) error) error
+	DiscardHead(key db.ColumnKey) error
 }
 
 type ValueInterface interface {
@@ -29,4 +39,5 @@ type InsertInterface interface {
 	Replace(kv *db.KV, oldKV *db.KV) error
 	Change(kv *db.KV, oldKV *db.KV) error
 	Append(kv *db.KV, oldKV *db.KV) (*RefNode,error)
+	Rewrite(kv *db.KV, oldKV *db.KV) (*RefNode,error)
 }
\ No newline at end of file
@@ -1,9 +1,7 @@
 package tree
 
 import (
-	"encoding/json"
 	"fmt"
-	"github.com/database-fabric/db/util"
 )
 
 type Parse struct {
@@ -38,12 +36,11 @@ func(parse *Parse) CollectionString(value []byte) ([]string, error) {
 }
 
 func(parse *Parse) CollectionBytes(value []byte) ([][]byte, error) {
-	var collection Collection
-	err := json.Unmarshal(value, &collection)
+	values,err := DecodeCollection(value)
 	if err != nil {
 		return nil,  fmt.Errorf("parse value to collection error `%s`", err.Error())
 	}
-	return collection.Values,  nil
+	return values,  nil
 }
 
 func(parse *Parse) CollectionFlip(values [][]byte) [][]byte {
@@ -54,8 +51,5 @@ func(parse *Parse) CollectionFlip(values [][]byte) [][]byte {
 }
 
 func(parse *Parse) BytesByCollectionBytes(value [][]byte) ([]byte, error) {
-	collection,err := util.ConvertJsonBytes(Collection{Values:value}); if err != nil {
-		return nil,err
-	}
-	return collection,nil
+	return EncodeCollection(value),nil
 }
\ No newline at end of file
@@ -1,6 +1,7 @@
 package tree
 
 import (
+	"fmt"
 	"github.com/database-fabric/db"
 	"github.com/database-fabric/db/util"
 )
@@ -11,11 +12,11 @@ const (
 	NODE_POINTER_SIZE = 4        //节点指针大小,对应Pointer类型占用空间
 	NODE_NAME_SIZE = NODE_PREFIX_MAX_SIZE + NODE_POINTER_SIZE  //节点名字占用空间
 )
-var NODE_SPLIT_RULE int8 //节点分裂规则：1为关键字个数验证，否则为容量验证
+var NODE_SPLIT_RULE int8 //默认节点分裂规则：1为关键字个数验证，否则为容量验证(树头未设置分裂规则时使用)
 
 const (
 	//容量值配置
-	MAX_NODE_SIZE     = 1024 * 4 //节点最大容量4KB
+	MAX_NODE_SIZE     = db.DefaultNodeSize //默认节点最大容量4KB(树头未设置节点容量时使用)
 	MAX_KEY_NUM       = 1000     //节点最大key数量，position为int16类型
 	MAX_NODE_NUM      = 100000   //树最大节点数量
 	MAX_TREE_HEIGHT   = 10        //树最大高度
@@ -33,6 +34,31 @@ const (
 	TreeTypeDescMid //排序为降序，分裂规则1/2
 )
 
+var treeTypeNames = []string{"default", "asc", "desc", "descMid"}
+
+func ParseTreeType(name string) (TreeType, error) {
+	for i,typeName := range treeTypeNames {
+		if typeName == name {
+			return TreeType(i),nil
+		}
+	}
+	return TreeTypeDefault,fmt.Errorf("tree type `%s` error", name)
+}
+
+func ValidateTreeType(treeType TreeType) error {
+	if treeType < TreeTypeDefault || int(treeType) >= len(treeTypeNames) {
+		return fmt.Errorf("tree type `%d` error", treeType)
+	}
+	return nil
+}
+
+func (treeType TreeType) String() string {
+	if ValidateTreeType(treeType) != nil {
+		return util.Int64ToString(int64(treeType))
+	}
+	return treeTypeNames[treeType]
+}
+
 type NodeType int8
 const (
 	NodeTypeRoot NodeType = iota
@@ -53,6 +79,7 @@ const (
 	InsertTypeReplace //插入存在可替换(直接替换原值类型)
 	InsertTypeChange //插入存在可更新(必须符合原值类型)
 	InsertTypeAppend //插入存在追加插入(集合类型)
+	InsertTypeRewrite //重写为集合(值为集合编码，超过容量转为链表)
 )
 
 //表字段索引树头信息
@@ -66,6 +93,8 @@ type TreeHead struct {
 	KeyNum    int64    `json:"keyNum"`    //关键字数量
 	FirstLeaf Pointer  `json:"firstLeaf"`      //叶子节点链表-头指针
 	LastLeaf  Pointer  `json:"lastLeaf"`      //叶子节点链表-尾指针
+	NodeSize  int32    `json:"nodeSize"`      //节点最大容量，0为默认容量
+	SplitRule int8     `json:"splitRule"`     //节点分裂规则，0为默认规则
 }
 
 //节点数据，节点存储标识规则为：索引前缀(前缀+表名+字段名)+排序值(自增)
@@ -87,6 +116,36 @@ type RefNode struct {
 	Values [][]byte
 }
 
+/**
+	设置树存储配置，树已有节点时配置不可修改
+ */
+func (head *TreeHead) SetConfig(treeType TreeType, nodeSize int32, splitRule int8) bool {
+	if head.NodeOrder > 0 {
+		return false
+	}
+	head.Type = treeType
+	head.NodeSize = nodeSize
+	head.SplitRule = splitRule
+	return true
+}
+
+func (head *TreeHead) GetNodeSize() int {
+	if head.NodeSize == 0 {
+		return MAX_NODE_SIZE
+	}
+	return int(head.NodeSize)
+}
+
+func (head *TreeHead) GetSplitRule() int8 {
+	if head.SplitRule != db.SplitRuleDefault {
+		return head.SplitRule
+	}
+	if NODE_SPLIT_RULE == 1 {
+		return db.SplitRuleKeyNum
+	}
+	return db.SplitRuleSize
+}
+
 func BytesToPointer(value []byte) Pointer {
 	return util.BytesToInt32(value)
 }
@@ -27,6 +27,8 @@ func(valueImpl *DefaultValue) Format(kv *db.KV, oldKV *db.KV, insertType InsertT
 			err = (*valueImpl.iInsert).Change(kv, oldKV)
 		case InsertTypeAppend:
 			refNode,err = (*valueImpl.iInsert).Append(kv, oldKV)
+		case InsertTypeRewrite:
+			refNode,err = (*valueImpl.iInsert).Rewrite(kv, oldKV)
 		default:
 			err = fmt.Errorf("insert type error")
 	}
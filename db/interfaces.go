@@ -1,12 +1,16 @@
 package db
 
 import (
+	"github.com/database-fabric/db/proof"
 	"github.com/database-fabric/protos/db/row"
 )
 
 type DatabaseInterface interface {
 	GetRelation() (*Relation,error)
 	GetRelationKeysByReference(reference ReferenceKey) ([]RelationKey,error)
+	PutRelation(relation *Relation) error
+
+	GetAllTable() ([]string,error)
 
 	GetTableTally(tableID TableID) (*TableTally,error)
 
@@ -16,18 +20,37 @@ type DatabaseInterface interface {
 	CreateTableData(table *TableData) (TableID,error)
 	UpdateTableData(table *TableData) error
 	DeleteTableData(tableID TableID) error
+	GetTableDrop(tableID TableID) (*TableDrop,error)
+	DropTable(tableID TableID, size int32) (*TableDrop,error)
+	MigrateTableData(tableID TableID, checkpoint TableMigrateCheckpoint, size int32) (*TableMigrateCheckpoint,error)
+	VerifyTable(tableID TableID) (*TableVerify,error)
+	CheckTable(tableID TableID) (*TableCheck,error)
+	RepairTable(tableID TableID, repairs []TableRepair) ([]TableRepair,error)
+	RebuildIndex(tableID TableID, column ColumnID, checkpoint RebuildCheckpoint, size int32) (*RebuildCheckpoint,error)
+	CompactTable(tableID TableID, checkpoint CompactCheckpoint, size int32) (*CompactCheckpoint,error)
+	QueryIndexStats(tableID TableID, column ColumnID) (*IndexStats,error)
+	QueryCounter(tableID TableID, name string, group int64) (*CounterValue,error)
+	QueryCounterByRange(tableID TableID, name string, start int64, end int64, size int32) ([]*CounterValue,error)
 
 	QueryTableDataByName(tableName string) (*TableData,error)
 	QueryTableDataByID(tableID TableID) (*TableData,error)
 
 	AddRowData(table *TableData, rows []*row.RowData) error
+	PutBlobData(value []byte) ([]byte,error)
+	GetBlobData(reference []byte) ([]byte,error)
 
 	QueryRowBlockID(table *TableData, rowID RowID) (BlockID,error)
-	QueryRowData(table *TableData, rowID RowID) (*row.RowData,error)
+	QueryRowVersion(table *TableData, rowID RowID) (*RowVersion,error)
+	QueryRowData(table *TableData, rowID RowID, columns ...ColumnID) (*row.RowData,error)
 	QueryRowIDByForeignKey(tableID TableID, foreignKey ForeignKey, referenceRowID RowID, size int32) ([]RowID,error)
+	QueryRowIDByForeignKeyCursor(tableID TableID, foreignKey ForeignKey, referenceRowID RowID, cursor *Cursor, size int32) ([]RowID,*Cursor,error)
 
-	QueryRowDataByRange(table *TableData, start RowID, end RowID, order OrderType, size int32) ([]*row.RowData,error)
+	QueryRowDataByRange(table *TableData, start RowID, end RowID, order OrderType, size int32, columns ...ColumnID) ([]*row.RowData,error)
+	QueryRowDataByCursor(table *TableData, start RowID, end RowID, cursor *Cursor, order OrderType, size int32, columns ...ColumnID) ([]*row.RowData,*Cursor,error)
+	QueryRowCountByRange(table *TableData, start RowID, end RowID) (Total,error)
 
 	QueryRowDataHistoryByRange(table *TableData, rowID RowID, order OrderType, size int32) ([]*RowDataHistory,Total,error)
+	QueryRowDataHistoryByCursor(table *TableData, rowID RowID, cursor *Cursor, order OrderType, size int32) ([]*RowDataHistory,Total,*Cursor,error)
+	QueryRowProof(table *TableData, rowID RowID, version int64) (*proof.RowProof,error)
 }
 
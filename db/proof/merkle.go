@@ -0,0 +1,135 @@
+package proof
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"github.com/database-fabric/protos/db/row"
+	"github.com/golang/protobuf/proto"
+)
+
+/**
+	表(统计分片)块哈希的Merkle山脉(MMR)累加器
+	叶子为块校验和，累加器由若干棵满二叉树组成(大小为叶子数量的二进制分解，从高到低)，每棵树的根为一个山峰
+	节点以(高度,同高度序号)定位，追加叶子只会新增节点，已有节点不会改变
+ */
+const (
+	leafPrefix byte = 0
+	nodePrefix byte = 1
+	rootPrefix byte = 2
+)
+
+type Position struct {
+	Height uint8
+	Index uint64
+}
+
+type Node struct {
+	Position
+	Hash []byte
+}
+
+type Accumulator struct {
+	LeafCount uint64
+	Peaks [][]byte //山峰哈希，按高度从高到低
+}
+
+func LeafHash(checksum []byte) []byte {
+	hash := sha256.New()
+	hash.Write([]byte{leafPrefix})
+	hash.Write(checksum)
+	return hash.Sum(nil)
+}
+
+func NodeHash(left []byte, right []byte) []byte {
+	hash := sha256.New()
+	hash.Write([]byte{nodePrefix})
+	hash.Write(left)
+	hash.Write(right)
+	return hash.Sum(nil)
+}
+
+func RootHash(leafCount uint64, peaks [][]byte) []byte {
+	hash := sha256.New()
+	var count [8]byte
+	binary.BigEndian.PutUint64(count[:], leafCount)
+	hash.Write([]byte{rootPrefix})
+	hash.Write(count[:])
+	for _,peak := range peaks {
+		hash.Write(peak)
+	}
+	return hash.Sum(nil)
+}
+
+/**
+	块校验和，Checksum为空时块protobuf编码的sha256
+ */
+func BlockChecksum(block *row.BlockData) ([]byte,error) {
+	checksum := block.Checksum
+	block.Checksum = nil
+	value,err := proto.Marshal(block)
+	block.Checksum = checksum
+	if err != nil {
+		return nil,err
+	}
+	hash := sha256.Sum256(value)
+	return hash[:],nil
+}
+
+/**
+	追加叶子，返回新增的节点(叶子和合并产生的节点)
+ */
+func (accumulator *Accumulator) Append(checksum []byte) []Node {
+	index := accumulator.LeafCount
+	hash := LeafHash(checksum)
+	nodes := []Node{{Position{0, index}, hash}}
+	for height := uint8(0); (index>>height)&1 == 1; height++ {
+		left := accumulator.Peaks[len(accumulator.Peaks)-1]
+		accumulator.Peaks = accumulator.Peaks[:len(accumulator.Peaks)-1]
+		hash = NodeHash(left, hash)
+		nodes = append(nodes, Node{Position{height+1, index>>(height+1)}, hash})
+	}
+	accumulator.Peaks = append(accumulator.Peaks, hash)
+	accumulator.LeafCount++
+	return nodes
+}
+
+func (accumulator *Accumulator) Root() []byte {
+	return RootHash(accumulator.LeafCount, accumulator.Peaks)
+}
+
+/**
+	山峰高度，按高度从高到低
+ */
+func PeakHeights(leafCount uint64) []uint8 {
+	var heights []uint8
+	for height := 63; height >= 0; height-- {
+		if (leafCount>>uint(height))&1 == 1 {
+			heights = append(heights, uint8(height))
+		}
+	}
+	return heights
+}
+
+/**
+	叶子证明路径：从叶子到所在山峰的兄弟节点位置，以及所在山峰的序号
+ */
+func ProofPositions(leafIndex uint64, leafCount uint64) ([]Position,int,error) {
+	if leafIndex >= leafCount {
+		return nil,0,fmt.Errorf("leaf index `%d` out of range `%d`", leafIndex, leafCount)
+	}
+	start := uint64(0)
+	for peak,height := range PeakHeights(leafCount) {
+		size := uint64(1)<<height
+		if leafIndex >= start+size {
+			start += size
+			continue
+		}
+		positions := make([]Position, 0, height)
+		for h := uint8(0); h < height; h++ {
+			positions = append(positions, Position{h, (leafIndex>>h)^1})
+		}
+		return positions,peak,nil
+	}
+	return nil,0,fmt.Errorf("leaf index `%d` peak not found", leafIndex)
+}
@@ -0,0 +1,62 @@
+package proof
+
+import (
+	"fmt"
+	"github.com/database-fabric/protos/db/row"
+	"github.com/golang/protobuf/proto"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestMerkle(t *testing.T) {
+	size := 33
+	blocks := make([][]byte, 0, size)
+	checksums := make([][]byte, 0, size)
+	for i:=0;i<size;i++ {
+		block := &row.BlockData{Id:int32(i+1),Rows:[]*row.RowData{{Id:int64(i+1)}}}
+		checksum,err := BlockChecksum(block); if err != nil {
+			panic(err.Error())
+		}
+		block.Checksum = checksum
+		value,err := proto.Marshal(block); if err != nil {
+			panic(err.Error())
+		}
+		blocks = append(blocks, value)
+		checksums = append(checksums, checksum)
+	}
+	//每次追加后所有叶子都可以证明
+	{
+		accumulator := &Accumulator{}
+		nodes := make(map[Position][]byte)
+		for count:=1;count<=size;count++ {
+			for _,node := range accumulator.Append(checksums[count-1]) {
+				nodes[node.Position] = node.Hash
+			}
+			assert.EqualValues(t, count, accumulator.LeafCount, "leaf count error")
+			assert.Len(t, accumulator.Peaks, len(PeakHeights(uint64(count))), "peaks length error")
+			for i:=0;i<count;i++ {
+				positions,_,err := ProofPositions(uint64(i), uint64(count)); if err != nil {
+					panic(err.Error())
+				}
+				blockProof := BlockProof{BlockID:int32(i+1),Block:blocks[i],LeafIndex:uint64(i)}
+				for _,position := range positions {
+					hash,ok := nodes[position]
+					assert.True(t, ok, fmt.Sprintf("node `%d-%d` not found", position.Height, position.Index))
+					blockProof.Siblings = append(blockProof.Siblings, hash)
+				}
+				_,err = VerifyBlockProof(blockProof, uint64(count), accumulator.Peaks)
+				assert.Nil(t, err, fmt.Sprintf("leaf `%d` of `%d` proof error", i, count))
+			}
+		}
+		_,_,err := ProofPositions(uint64(size), uint64(size))
+		assert.NotNil(t, err, "leaf index out of range error")
+	}
+	//追加叶子后根改变
+	{
+		accumulator := &Accumulator{}
+		accumulator.Append(checksums[0])
+		root := accumulator.Root()
+		accumulator.Append(checksums[1])
+		assert.NotEqual(t, root, accumulator.Root(), "root error")
+	}
+}
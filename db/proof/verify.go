@@ -0,0 +1,204 @@
+package proof
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/database-fabric/protos/db/row"
+	"github.com/golang/protobuf/proto"
+)
+
+/**
+	行版本证明，客户端可以离线使用VerifyRowProof验证，不依赖合约接口
+	证明行数据包含在块中，块包含在统计分片的累加器中，累加器根为Root
+	Root需要通过背书的查询单独获取(例如查询表统计)
+ */
+type RowProof struct {
+	TableID int16 `json:"tableID"`
+	RowID int64 `json:"rowID"`
+	Version int64 `json:"version"` //行版本，从1开始，0为最新版本
+	Shard int8 `json:"shard"` //统计分片
+	Row []byte `json:"row"` //合并增量后的完整行protobuf编码(row.RowData)
+	Blocks []BlockProof `json:"blocks"` //行所在的块，行分裂时有多个块
+	LeafCount uint64 `json:"leafCount"`
+	Peaks [][]byte `json:"peaks"`
+	Root []byte `json:"root"`
+	Base *RowProof `json:"base,omitempty"` //增量行的基础版本证明，基础版本可能在其他统计分片，Version为0
+	Origin int32 `json:"origin,omitempty"` //基础版本被压缩移动时，增量行引用的原块ID
+}
+
+type BlockProof struct {
+	BlockID int32 `json:"blockID"`
+	Block []byte `json:"block"` //块protobuf编码(row.BlockData，未压缩)
+	LeafIndex uint64 `json:"leafIndex"`
+	Siblings [][]byte `json:"siblings"` //从叶子到山峰的兄弟节点哈希
+}
+
+/**
+	验证行版本证明，增量行沿基础版本证明逐个验证并合并为完整行，与Row比较
+	基础版本被压缩移动时只检查Origin与增量行引用的块ID一致，压缩记录不在证明中
+ */
+func VerifyRowProof(rowProof *RowProof) error {
+	_,err := verifyRowProof(rowProof)
+	return err
+}
+
+func verifyRowProof(rowProof *RowProof) (*row.RowData,error) {
+	if len(rowProof.Blocks) == 0 {
+		return nil,fmt.Errorf("proof blocks is null")
+	}
+	if !bytes.Equal(RootHash(rowProof.LeafCount, rowProof.Peaks), rowProof.Root) {
+		return nil,fmt.Errorf("proof root error")
+	}
+	if len(rowProof.Peaks) != len(PeakHeights(rowProof.LeafCount)) {
+		return nil,fmt.Errorf("proof peaks length error")
+	}
+	blocks := make([]*row.BlockData, 0, len(rowProof.Blocks))
+	for i,blockProof := range rowProof.Blocks {
+		block,err := VerifyBlockProof(blockProof, rowProof.LeafCount, rowProof.Peaks); if err != nil {
+			return nil,err
+		}
+		if i > 0 {
+			prev := blocks[i-1]
+			if block.Id != prev.Id+1 || !bytes.Equal(block.PrevHash, prev.Checksum) || prev.Join == row.BlockData_JOIN_NONE {
+				return nil,fmt.Errorf("proof block `%d` is not joined to block `%d`", block.Id, prev.Id)
+			}
+		}
+		blocks = append(blocks, block)
+	}
+	rowData,err := JoinRowData(blocks, rowProof.RowID); if err != nil {
+		return nil,err
+	}
+	if rowData.Base > 0 {
+		baseProof := rowProof.Base
+		if baseProof == nil {
+			return nil,fmt.Errorf("proof row `%d` base proof is null", rowProof.RowID)
+		}
+		if baseProof.TableID != rowProof.TableID || baseProof.RowID != rowProof.RowID {
+			return nil,fmt.Errorf("proof row `%d` base proof row error", rowProof.RowID)
+		}
+		origin := baseProof.Origin
+		if origin == 0 && len(baseProof.Blocks) > 0 {
+			origin = baseProof.Blocks[0].BlockID
+		}
+		if origin != rowData.Base {
+			return nil,fmt.Errorf("proof row `%d` base block `%d` is not equal to `%d`", rowProof.RowID, origin, rowData.Base)
+		}
+		baseRow,err := verifyRowProof(baseProof); if err != nil {
+			return nil,err
+		}
+		rowData = MergeDeltaRow(baseRow, rowData)
+	}else if rowProof.Base != nil {
+		return nil,fmt.Errorf("proof row `%d` is not delta row", rowProof.RowID)
+	}
+	proofRow := &row.RowData{}
+	if err := proto.Unmarshal(rowProof.Row, proofRow); err != nil {
+		return nil,err
+	}
+	if !proto.Equal(rowData, proofRow) {
+		return nil,fmt.Errorf("proof row `%d` data error", rowProof.RowID)
+	}
+	return rowData,nil
+}
+
+/**
+	增量行合并到基础版本的完整行，返回新行，使用增量行的操作类型
+ */
+func MergeDeltaRow(baseRow *row.RowData, delta *row.RowData) *row.RowData {
+	rowData := &row.RowData{Id:delta.Id,Op:delta.Op,Columns:make([]*row.ColumnData, 0, len(baseRow.Columns))}
+	for _,columnData := range baseRow.Columns {
+		rowData.Columns = append(rowData.Columns, &row.ColumnData{Data:append([]byte{}, columnData.Data...)})
+	}
+	ApplyDelta(rowData, delta)
+	return rowData
+}
+
+/**
+	按Delta列下标覆盖列数据
+ */
+func ApplyDelta(rowData *row.RowData, delta *row.RowData) {
+	for i,index := range delta.Delta {
+		for int(index) >= len(rowData.Columns) {
+			rowData.Columns = append(rowData.Columns, &row.ColumnData{})
+		}
+		rowData.Columns[index] = delta.Columns[i]
+	}
+}
+
+/**
+	验证块校验和，以及从叶子到山峰的路径
+ */
+func VerifyBlockProof(blockProof BlockProof, leafCount uint64, peaks [][]byte) (*row.BlockData,error) {
+	block := &row.BlockData{}
+	if err := proto.Unmarshal(blockProof.Block, block); err != nil {
+		return nil,err
+	}
+	if block.Id != blockProof.BlockID {
+		return nil,fmt.Errorf("proof block `%d` id error", blockProof.BlockID)
+	}
+	checksum,err := BlockChecksum(block); if err != nil {
+		return nil,err
+	}
+	if !bytes.Equal(checksum, block.Checksum) {
+		return nil,fmt.Errorf("proof block `%d` checksum error", blockProof.BlockID)
+	}
+	positions,peak,err := ProofPositions(blockProof.LeafIndex, leafCount); if err != nil {
+		return nil,err
+	}
+	if len(positions) != len(blockProof.Siblings) || peak >= len(peaks) {
+		return nil,fmt.Errorf("proof block `%d` siblings length error", blockProof.BlockID)
+	}
+	hash := LeafHash(checksum)
+	for i,position := range positions {
+		if position.Index&1 == 1 {//兄弟节点在右边
+			hash = NodeHash(hash, blockProof.Siblings[i])
+		}else{
+			hash = NodeHash(blockProof.Siblings[i], hash)
+		}
+	}
+	if !bytes.Equal(hash, peaks[peak]) {
+		return nil,fmt.Errorf("proof block `%d` path error", blockProof.BlockID)
+	}
+	return block,nil
+}
+
+/**
+	按块连接方式拼接行数据，第一个块之后行必须为块中第一行，除最后一个块外行必须为块中最后一行
+ */
+func JoinRowData(blocks []*row.BlockData, rowID int64) (*row.RowData,error) {
+	var rowData *row.RowData
+	for i,block := range blocks {
+		var joinRow *row.RowData
+		index := 0
+		for j,blockRow := range block.Rows {
+			if blockRow.Id == rowID && (i == 0 || j == 0) {
+				joinRow = blockRow
+				index = j
+				break
+			}
+		}
+		if joinRow == nil {
+			return nil,fmt.Errorf("row `%d` is not found in block `%d`", rowID, block.Id)
+		}
+		isJoin := index == len(block.Rows)-1 && block.Join != row.BlockData_JOIN_NONE //行在下一个块中继续
+		if isJoin != (i < len(blocks)-1) {
+			return nil,fmt.Errorf("row `%d` blocks are incomplete in block `%d`", rowID, block.Id)
+		}
+		if i == 0 {
+			rowData = &row.RowData{Id:joinRow.Id,Op:joinRow.Op,Columns:make([]*row.ColumnData, 0, len(joinRow.Columns)),Base:joinRow.Base,Depth:joinRow.Depth,Delta:joinRow.Delta}
+			for _,columnData := range joinRow.Columns {
+				rowData.Columns = append(rowData.Columns, &row.ColumnData{Data:append([]byte{}, columnData.Data...)})
+			}
+			continue
+		}
+		columns := joinRow.Columns
+		if blocks[i-1].Join == row.BlockData_JOIN_COLUMN && len(columns) > 0 && len(rowData.Columns) > 0 {
+			last := rowData.Columns[len(rowData.Columns)-1]
+			last.Data = append(last.Data, columns[0].Data...)
+			columns = columns[1:]
+		}
+		for _,columnData := range columns {
+			rowData.Columns = append(rowData.Columns, &row.ColumnData{Data:append([]byte{}, columnData.Data...)})
+		}
+	}
+	return rowData,nil
+}
@@ -6,9 +6,18 @@ import (
 	"github.com/database-fabric/db"
 	"github.com/database-fabric/db/storage/state"
 	"github.com/database-fabric/db/util"
+	"strings"
+	"unicode/utf8"
 )
 ////////////////////////////////////// Common Storage //////////////////////////////////////
 
+//Key区间，Prefix为false时为单个Key
+type KeyRange struct {
+	Key string
+	Prefix bool
+}
+
+
 type CommonStorage struct {
 	state state.ChainCodeState
 }
@@ -75,27 +84,55 @@ func (storage *CommonStorage) getChainDataKey() string {
 }
 
 func (storage *CommonStorage) getDataBaseDataKey(database db.DatabaseID) string {
-	return storage.state.PrefixAddKey(util.UInt8ToString(db.DataBaseKeyType), util.DatabaseIDToString(database))
+	return storage.state.PrefixAddKey(util.UInt8ToString(db.DataBaseKeyType), util.Int64ToKeyString(int64(database)))
 }
 
 func (storage *CommonStorage) getRelationDataKey(database db.DatabaseID) string {
-	return storage.state.PrefixAddKey(util.UInt8ToString(db.RelationKeyType), util.DatabaseIDToString(database))
+	return storage.state.PrefixAddKey(util.UInt8ToString(db.RelationKeyType), util.Int64ToKeyString(int64(database)))
 }
 
 func (storage *CommonStorage) getTallyDataKey(database db.DatabaseID, table db.TableID) string {
-	return storage.state.PrefixAddKey(util.UInt8ToString(db.TallyKeyType), storage.state.CompositeKey(util.DatabaseIDToString(database), util.TableIDToString(table)))
+	return storage.state.PrefixAddKey(util.UInt8ToString(db.TallyKeyType), storage.state.CompositeKey(util.Int64ToKeyString(int64(database)), util.Int64ToKeyString(int64(table))))
+}
+
+func (storage *CommonStorage) getTallyShardDataKey(database db.DatabaseID, table db.TableID, shard int8) string {
+	return storage.state.PrefixAddKey(util.UInt8ToString(db.TallyKeyType), storage.state.CompositeKey(util.Int64ToKeyString(int64(database)), util.Int64ToKeyString(int64(table)), util.Int64ToKeyString(int64(shard))))
 }
 
 func (storage *CommonStorage) getTableDataKey(database db.DatabaseID, table db.TableID) string {
-	return storage.state.PrefixAddKey(util.UInt8ToString(db.TableKeyType), storage.state.CompositeKey(util.DatabaseIDToString(database), util.TableIDToString(table)))
+	return storage.state.PrefixAddKey(util.UInt8ToString(db.TableKeyType), storage.state.CompositeKey(util.Int64ToKeyString(int64(database)), util.Int64ToKeyString(int64(table))))
 }
 
 func (storage *CommonStorage) getBlockDataKey(database db.DatabaseID, table db.TableID, block db.BlockID) string {
-	return storage.state.PrefixAddKey(util.UInt8ToString(db.BlockKeyType), storage.state.CompositeKey(util.DatabaseIDToString(database), util.TableIDToString(table), util.BlockIDToString(block)))
+	return storage.state.PrefixAddKey(util.UInt8ToString(db.BlockKeyType), storage.state.CompositeKey(util.Int64ToKeyString(int64(database)), util.Int64ToKeyString(int64(table)), util.Int64ToKeyString(int64(block))))
+}
+
+func (storage *CommonStorage) getMerkleNodeKey(database db.DatabaseID, table db.TableID, shard int8, height uint8, index uint64) string {
+	return storage.state.PrefixAddKey(util.UInt8ToString(db.MerkleKeyType), storage.state.CompositeKey(util.Int64ToKeyString(int64(database)), util.Int64ToKeyString(int64(table)), util.Int64ToKeyString(int64(shard)), util.Int64ToKeyString(int64(height)), util.Int64ToKeyString(int64(index))))
+}
+
+func (storage *CommonStorage) getBlockCompactKey(database db.DatabaseID, table db.TableID, block db.BlockID) string {
+	return storage.state.PrefixAddKey(util.UInt8ToString(db.CompactKeyType), storage.state.CompositeKey(util.Int64ToKeyString(int64(database)), util.Int64ToKeyString(int64(table)), util.Int64ToKeyString(int64(block))))
+}
+
+func (storage *CommonStorage) getTableDropKey(database db.DatabaseID, table db.TableID) string {
+	return storage.state.PrefixAddKey(util.UInt8ToString(db.DropKeyType), storage.state.CompositeKey(util.Int64ToKeyString(int64(database)), util.Int64ToKeyString(int64(table))))
+}
+
+func (storage *CommonStorage) getCounterKey(database db.DatabaseID, table db.TableID, counter int, group int64) string {
+	return storage.state.PrefixAddKey(util.UInt8ToString(db.CounterKeyType), storage.state.CompositeKey(util.Int64ToKeyString(int64(database)), util.Int64ToKeyString(int64(table)), util.Int64ToKeyString(int64(counter)), util.Int64ToKeyString(group)))
+}
+
+func (storage *CommonStorage) getChunkDataKey(database db.DatabaseID, hash string, index int64) string {
+	return storage.state.PrefixAddKey(util.UInt8ToString(db.ChunkKeyType), storage.state.CompositeKey(util.Int64ToKeyString(int64(database)), hash, util.Int64ToKeyString(index)))
+}
+
+func (storage *CommonStorage) getChunkRefKey(database db.DatabaseID, hash string) string {
+	return storage.state.PrefixAddKey(util.UInt8ToString(db.ChunkRefKeyType), storage.state.CompositeKey(util.Int64ToKeyString(int64(database)), hash))
 }
 
 func (storage *CommonStorage) getIndexDataKey(indexType db.IndexType, key db.ColumnKey, values ...string) string {
-	compositeKey := storage.state.CompositeKey(util.DatabaseIDToString(key.Database), util.TableIDToString(key.Table), util.ColumnIDToString(key.Column))
+	compositeKey := storage.state.CompositeKey(util.Int64ToKeyString(int64(key.Database)), util.Int64ToKeyString(int64(key.Table)), util.Int64ToKeyString(int64(key.Column)))
 	for _,val := range values {
 		if len(val) > 0 {
 			compositeKey = storage.state.CompositeKey(compositeKey, val)
@@ -105,8 +142,16 @@ func (storage *CommonStorage) getIndexDataKey(indexType db.IndexType, key db.Col
 }
 
 func (storage *CommonStorage) createDataBase(name string) (db.DatabaseID,error) {
-	id,err := storage.addName(storage.getChainDataKey(), name)
-	return db.DatabaseID(id),err
+	id,err := storage.addName(storage.getChainDataKey(), name); if err != nil {
+		return 0,err
+	}
+	// 第一个数据库时记录新布局，已有数据库的旧链需要迁移后才有布局记录
+	if id == 1 {
+		if err := storage.putKeyLayout(db.KeyLayoutOrdered); err != nil {
+			return 0,err
+		}
+	}
+	return db.DatabaseID(id),nil
 }
 
 func (storage *CommonStorage) getDataBase(name string) (db.DatabaseID,error) {
@@ -182,6 +227,14 @@ func (storage *DatabaseStorage) PutTableTally(database db.DatabaseID, tableID db
 	return storage.state.PutOrDelKey(storage.getTallyDataKey(database, tableID), value, db.SetState)
 }
 
+func (storage *DatabaseStorage) GetTableTallyShard(database db.DatabaseID, tableID db.TableID, shard int8) ([]byte,error) {
+	return storage.state.GetKey(storage.getTallyShardDataKey(database, tableID, shard))
+}
+
+func (storage *DatabaseStorage) PutTableTallyShard(database db.DatabaseID, tableID db.TableID, shard int8, value []byte) error {
+	return storage.state.PutOrDelKey(storage.getTallyShardDataKey(database, tableID, shard), value, db.SetState)
+}
+
 func (storage *DatabaseStorage) CreateTable(database db.DatabaseID, tableName string) (db.TableID,error) {
 	return storage.createTable(database, tableName)
 }
@@ -206,6 +259,49 @@ func (storage *DatabaseStorage) GetAllTable(database db.DatabaseID) ([]string,er
 	return storage.getAllTable(database)
 }
 
+func (storage *DatabaseStorage) GetTableDrop(database db.DatabaseID, tableID db.TableID) ([]byte,error) {
+	return storage.state.GetKey(storage.getTableDropKey(database, tableID))
+}
+
+func (storage *DatabaseStorage) PutTableDrop(database db.DatabaseID, tableID db.TableID, value []byte) error {
+	return storage.state.PutOrDelKey(storage.getTableDropKey(database, tableID), value, db.SetState)
+}
+
+/**
+	表的所有Key区间，表结构和统计为单个Key，其他为组合Key前缀(以分隔符结尾，不匹配ID前缀相同的其他表)
+	大字段分片按内容哈希在表之间共享，不属于表的Key区间，删除表时清除Key之前先释放块中的大字段引用，引用计数为0的分片删除
+ */
+func (storage *DatabaseStorage) GetTableKeyRanges(database db.DatabaseID, tableID db.TableID) []KeyRange {
+	prefix := func(keyType db.KeyType) string {
+		return storage.state.PrefixAddKey(util.UInt8ToString(keyType), storage.state.CompositeKey(util.Int64ToKeyString(int64(database)), util.Int64ToKeyString(int64(tableID)), ""))
+	}
+	ranges := []KeyRange{
+		{Key:storage.getTableDataKey(database, tableID)},
+		{Key:storage.getTallyDataKey(database, tableID)},
+		{Key:prefix(db.TallyKeyType),Prefix:true},
+		{Key:prefix(db.BlockKeyType),Prefix:true},
+	}
+	for _,indexType := range []db.IndexType{db.BPTreeHeadIndexType,db.BPTreeNodeIndexType,db.LinkedHeadIndexType,db.LinkedNodeIndexType} {
+		ranges = append(ranges, KeyRange{Key:storage.state.PrefixAddKey(util.UInt8ToString(db.IndexKeyType), prefix(indexType)),Prefix:true})
+	}
+	return append(ranges, KeyRange{Key:prefix(db.MerkleKeyType),Prefix:true}, KeyRange{Key:prefix(db.CompactKeyType),Prefix:true}, KeyRange{Key:prefix(db.CounterKeyType),Prefix:true})
+}
+
+/**
+	前缀下已提交的Key，最多返回size个
+ */
+func (storage *DatabaseStorage) GetKeysByPrefix(prefix string, size int32) ([]string,error) {
+	return storage.state.GetKeysByRange(prefix, prefix+string(utf8.MaxRune), size)
+}
+
+func (storage *DatabaseStorage) GetKey(key string) ([]byte,error) {
+	return storage.state.GetKey(key)
+}
+
+func (storage *DatabaseStorage) DelKey(key string) error {
+	return storage.state.PutOrDelKey(key, nil, db.DelState)
+}
+
 ////////////////////////////////////// Table Storage //////////////////////////////////////
 type TableStorage struct {
 	CommonStorage
@@ -242,10 +338,109 @@ func (storage *BlockStorage) GetBlockData(database db.DatabaseID, table db.Table
 	return storage.state.GetKey(storage.getBlockDataKey(database, table, block))
 }
 
+/**
+	按块ID顺序流式读取[start,end]区间中已提交的块，handler返回false时停止
+ */
+func (storage *BlockStorage) GetBlockDataByRange(database db.DatabaseID, table db.TableID, start db.BlockID, end db.BlockID, handler func(block db.BlockID, value []byte) (bool,error)) error {
+	return storage.state.GetValuesByRange(storage.getBlockDataKey(database, table, start), storage.getBlockDataKey(database, table, end+1), func(key string, value []byte) (bool,error) {
+		block,err := util.KeyStringToInt64(key[strings.LastIndex(key, "~")+1:]); if err != nil {
+			return false,err
+		}
+		return handler(db.BlockID(block), value)
+	})
+}
+
 func (storage *BlockStorage) PutBlockData(database db.DatabaseID, table db.TableID, block db.BlockID, value []byte) error {
 	return storage.state.PutOrDelKey(storage.getBlockDataKey(database, table, block), value, db.SetState)
 }
 
+func (storage *BlockStorage) DelBlockData(database db.DatabaseID, table db.TableID, block db.BlockID) error {
+	return storage.state.PutOrDelKey(storage.getBlockDataKey(database, table, block), nil, db.DelState)
+}
+
+func (storage *BlockStorage) GetBlockCompact(database db.DatabaseID, table db.TableID, block db.BlockID) ([]byte,error) {
+	return storage.state.GetKey(storage.getBlockCompactKey(database, table, block))
+}
+
+func (storage *BlockStorage) PutBlockCompact(database db.DatabaseID, table db.TableID, block db.BlockID, value []byte) error {
+	return storage.state.PutOrDelKey(storage.getBlockCompactKey(database, table, block), value, db.SetState)
+}
+
+func (storage *BlockStorage) GetMerkleNode(database db.DatabaseID, table db.TableID, shard int8, height uint8, index uint64) ([]byte,error) {
+	return storage.state.GetKey(storage.getMerkleNodeKey(database, table, shard, height, index))
+}
+
+func (storage *BlockStorage) PutMerkleNode(database db.DatabaseID, table db.TableID, shard int8, height uint8, index uint64, value []byte) error {
+	return storage.state.PutOrDelKey(storage.getMerkleNodeKey(database, table, shard, height, index), value, db.SetState)
+}
+
+/**
+	计数器分组的值，counter为计数器在表定义中的下标
+ */
+func (storage *BlockStorage) GetCounter(database db.DatabaseID, table db.TableID, counter int, group int64) ([]byte,error) {
+	return storage.state.GetKey(storage.getCounterKey(database, table, counter, group))
+}
+
+func (storage *BlockStorage) PutCounter(database db.DatabaseID, table db.TableID, counter int, group int64, value []byte) error {
+	return storage.state.PutOrDelKey(storage.getCounterKey(database, table, counter, group), value, db.SetState)
+}
+
+func (storage *BlockStorage) DelCounter(database db.DatabaseID, table db.TableID, counter int, group int64) error {
+	return storage.state.PutOrDelKey(storage.getCounterKey(database, table, counter, group), nil, db.DelState)
+}
+
+/**
+	按分组值顺序流式读取[start,end]区间中已提交的计数器分组，handler返回false时停止
+ */
+func (storage *BlockStorage) GetCounterByRange(database db.DatabaseID, table db.TableID, counter int, start int64, end int64, handler func(group int64, value []byte) (bool,error)) error {
+	//分组值的保序编码没有前缀关系，结束Key加分隔符包含end
+	endKey := storage.getCounterKey(database, table, counter, end) + "~"
+	return storage.state.GetValuesByRange(storage.getCounterKey(database, table, counter, start), endKey, func(key string, value []byte) (bool,error) {
+		group,err := util.KeyStringToInt64(key[strings.LastIndex(key, "~")+1:]); if err != nil {
+			return false,err
+		}
+		return handler(group, value)
+	})
+}
+
+////////////////////////////////////// Blob Storage //////////////////////////////////////
+type BlobStorage struct {
+	CommonStorage
+}
+
+func NewBlobStorage(state state.ChainCodeState) *BlobStorage {
+	storage := new(BlobStorage)
+	storage.Init(state)
+	return storage
+}
+
+func (storage *BlobStorage) GetChunkData(database db.DatabaseID, hash string, index int64) ([]byte,error) {
+	return storage.state.GetKey(storage.getChunkDataKey(database, hash, index))
+}
+
+func (storage *BlobStorage) PutChunkData(database db.DatabaseID, hash string, index int64, value []byte) error {
+	return storage.state.PutOrDelKey(storage.getChunkDataKey(database, hash, index), value, db.SetState)
+}
+
+func (storage *BlobStorage) DelChunkData(database db.DatabaseID, hash string, index int64) error {
+	return storage.state.PutOrDelKey(storage.getChunkDataKey(database, hash, index), nil, db.DelState)
+}
+
+/**
+	大字段引用计数，没有计数的分片为旧版本写入(引用未记录)，不删除
+ */
+func (storage *BlobStorage) GetChunkRef(database db.DatabaseID, hash string) ([]byte,error) {
+	return storage.state.GetKey(storage.getChunkRefKey(database, hash))
+}
+
+func (storage *BlobStorage) PutChunkRef(database db.DatabaseID, hash string, value []byte) error {
+	return storage.state.PutOrDelKey(storage.getChunkRefKey(database, hash), value, db.SetState)
+}
+
+func (storage *BlobStorage) DelChunkRef(database db.DatabaseID, hash string) error {
+	return storage.state.PutOrDelKey(storage.getChunkRefKey(database, hash), nil, db.DelState)
+}
+
 ////////////////////////////////////// BPTree Storage //////////////////////////////////////
 
 type BPTreeStorage struct {
@@ -258,20 +453,43 @@ func NewBPTreeStorage(state state.ChainCodeState) *BPTreeStorage {
 	return storage
 }
 
+/**
+	主键索引分片的树Key在列之后增加分片号，分片0沿用原Key，关键字链表按行区分不需要分片
+ */
+func (storage *BPTreeStorage) getTreeKey(indexType db.IndexType, key db.ColumnKey, value string) string {
+	if key.Shard > 0 {
+		shard := util.Int64ToKeyString(int64(key.Shard))
+		if len(value) > 0 {
+			value = storage.state.CompositeKey(shard, value)
+		}else{
+			value = shard
+		}
+	}
+	return storage.getIndexDataKey(indexType, key, value)
+}
+
 func (storage *BPTreeStorage) PutHead(key db.ColumnKey, value []byte) error {
-	return storage.state.PutOrDelKey(storage.getIndexDataKey(db.BPTreeHeadIndexType, key,""), value, db.SetState)
+	return storage.state.PutOrDelKey(storage.getTreeKey(db.BPTreeHeadIndexType, key,""), value, db.SetState)
 }
 
 func (storage *BPTreeStorage) GetHead(key db.ColumnKey) ([]byte,error) {
-	return storage.state.GetKey(storage.getIndexDataKey(db.BPTreeHeadIndexType, key,""))
+	return storage.state.GetKey(storage.getTreeKey(db.BPTreeHeadIndexType, key,""))
 }
 
-func (storage *BPTreeStorage) PutNode(key db.ColumnKey, pointer string, value []byte) error {
-	return storage.state.PutOrDelKey(storage.getIndexDataKey(db.BPTreeNodeIndexType, key, pointer), value, db.SetState)
+func (storage *BPTreeStorage) PutNode(key db.ColumnKey, pointer int64, value []byte) error {
+	return storage.state.PutOrDelKey(storage.getTreeKey(db.BPTreeNodeIndexType, key, util.Int64ToKeyString(pointer)), value, db.SetState)
 }
 
-func (storage *BPTreeStorage) GetNode(key db.ColumnKey, pointer string) ([]byte,error) {
-	return storage.state.GetKey(storage.getIndexDataKey(db.BPTreeNodeIndexType, key, pointer))
+func (storage *BPTreeStorage) GetNode(key db.ColumnKey, pointer int64) ([]byte,error) {
+	return storage.state.GetKey(storage.getTreeKey(db.BPTreeNodeIndexType, key, util.Int64ToKeyString(pointer)))
+}
+
+func (storage *BPTreeStorage) DelHead(key db.ColumnKey) error {
+	return storage.state.PutOrDelKey(storage.getTreeKey(db.BPTreeHeadIndexType, key,""), nil, db.DelState)
+}
+
+func (storage *BPTreeStorage) DelNode(key db.ColumnKey, pointer int64) error {
+	return storage.state.PutOrDelKey(storage.getTreeKey(db.BPTreeNodeIndexType, key, util.Int64ToKeyString(pointer)), nil, db.DelState)
 }
 
 ////////////////////////////////////// LinkedList Storage //////////////////////////////////////
@@ -287,19 +505,27 @@ func NewLinkedListStorage(state state.ChainCodeState) *LinkedListStorage {
 }
 
 func (storage *LinkedListStorage) PutHead(key db.ColumnRowKey, value []byte) error {
-	return storage.state.PutOrDelKey(storage.getIndexDataKey(db.LinkedHeadIndexType, key.ColumnKey, util.RowIDToString(key.Row)), value, db.SetState)
+	return storage.state.PutOrDelKey(storage.getIndexDataKey(db.LinkedHeadIndexType, key.ColumnKey, util.Int64ToKeyString(int64(key.Row))), value, db.SetState)
 }
 
 func (storage *LinkedListStorage) GetHead(key db.ColumnRowKey) ([]byte,error) {
-	return storage.state.GetKey(storage.getIndexDataKey(db.LinkedHeadIndexType, key.ColumnKey, util.RowIDToString(key.Row)))
+	return storage.state.GetKey(storage.getIndexDataKey(db.LinkedHeadIndexType, key.ColumnKey, util.Int64ToKeyString(int64(key.Row))))
+}
+
+func (storage *LinkedListStorage) PutNode(key db.ColumnRowKey, pointer int64, value []byte) error {
+	return storage.state.PutOrDelKey(storage.getIndexDataKey(db.LinkedNodeIndexType, key.ColumnKey, util.Int64ToKeyString(int64(key.Row)), util.Int64ToKeyString(pointer)), value, db.SetState)
+}
+
+func (storage *LinkedListStorage) GetNode(key db.ColumnRowKey, pointer int64) ([]byte,error) {
+	return storage.state.GetKey(storage.getIndexDataKey(db.LinkedNodeIndexType, key.ColumnKey, util.Int64ToKeyString(int64(key.Row)), util.Int64ToKeyString(pointer)))
 }
 
-func (storage *LinkedListStorage) PutNode(key db.ColumnRowKey, pointer string, value []byte) error {
-	return storage.state.PutOrDelKey(storage.getIndexDataKey(db.LinkedNodeIndexType, key.ColumnKey, util.RowIDToString(key.Row), pointer), value, db.SetState)
+func (storage *LinkedListStorage) DelHead(key db.ColumnRowKey) error {
+	return storage.state.PutOrDelKey(storage.getIndexDataKey(db.LinkedHeadIndexType, key.ColumnKey, util.Int64ToKeyString(int64(key.Row))), nil, db.DelState)
 }
 
-func (storage *LinkedListStorage) GetNode(key db.ColumnRowKey, pointer string) ([]byte,error) {
-	return storage.state.GetKey(storage.getIndexDataKey(db.LinkedNodeIndexType, key.ColumnKey, util.RowIDToString(key.Row), pointer))
+func (storage *LinkedListStorage) DelNode(key db.ColumnRowKey, pointer int64) error {
+	return storage.state.PutOrDelKey(storage.getIndexDataKey(db.LinkedNodeIndexType, key.ColumnKey, util.Int64ToKeyString(int64(key.Row)), util.Int64ToKeyString(pointer)), nil, db.DelState)
 }
 
 
@@ -0,0 +1,153 @@
+package storage
+
+import (
+	"fmt"
+	"github.com/database-fabric/db"
+	"github.com/database-fabric/db/storage/state"
+	"github.com/database-fabric/db/util"
+	"strconv"
+	"strings"
+)
+
+////////////////////////////////////// Layout Storage //////////////////////////////////////
+
+/**
+	Key布局：旧布局Key中的整数为十进制字符串，区间查询按字符串顺序返回(10在9之前)
+	新布局使用保序编码(util.Int64ToKeyString)，旧布局的数据使用MigrateKeyLayout分批迁移
+ */
+type LayoutStorage struct {
+	CommonStorage
+}
+
+func NewLayoutStorage(state state.ChainCodeState) *LayoutStorage {
+	storage := new(LayoutStorage)
+	storage.Init(state)
+	return storage
+}
+
+func (storage *CommonStorage) getKeyLayoutKey() string {
+	return storage.state.PrefixAddKey(db.ChainPrefix, util.UInt8ToString(db.LayoutKeyType))
+}
+
+/**
+	当前Key布局，没有布局记录时存在链Key为旧布局，否则为新布局
+ */
+func (storage *LayoutStorage) GetKeyLayout() (db.KeyLayout,error) {
+	value,err := storage.state.GetKey(storage.getKeyLayoutKey()); if err != nil {
+		return 0,err
+	}
+	if len(value) > 0 {
+		layout,err := strconv.ParseUint(string(value), 10, 8); if err != nil {
+			return 0,fmt.Errorf("key layout `%s` error", string(value))
+		}
+		return db.KeyLayout(layout),nil
+	}
+	chain,err := storage.state.GetKey(storage.getChainDataKey()); if err != nil {
+		return 0,err
+	}
+	if len(chain) > 0 {
+		return db.KeyLayoutDecimal,nil
+	}
+	return db.KeyLayoutOrdered,nil
+}
+
+func (storage *CommonStorage) putKeyLayout(layout db.KeyLayout) error {
+	return storage.state.PutOrDelKey(storage.getKeyLayoutKey(), []byte(util.UInt8ToString(layout)), db.SetState)
+}
+
+/**
+	旧布局未迁移时不能读写数据
+ */
+func (storage *LayoutStorage) CheckKeyLayout() error {
+	layout,err := storage.GetKeyLayout(); if err != nil {
+		return err
+	}
+	if layout != db.KeyLayoutOrdered {
+		return fmt.Errorf("key layout `%d` must be migrated", layout)
+	}
+	return nil
+}
+
+/**
+	需要迁移的Key类型前缀，链Key没有整数不需要迁移
+ */
+func (storage *LayoutStorage) getLayoutPrefixes() []string {
+	var prefixes []string
+	for keyType:=db.DataBaseKeyType;keyType<db.LayoutKeyType;keyType++ {
+		if keyType != db.IndexKeyType {
+			prefixes = append(prefixes, storage.state.PrefixAddKey(util.UInt8ToString(keyType), ""))
+			continue
+		}
+		for _,indexType := range []db.IndexType{db.BPTreeHeadIndexType,db.BPTreeNodeIndexType,db.LinkedHeadIndexType,db.LinkedNodeIndexType} {
+			prefixes = append(prefixes, storage.state.PrefixAddKey(storage.state.PrefixAddKey(util.UInt8ToString(keyType), util.UInt8ToString(indexType)), ""))
+		}
+	}
+	return prefixes
+}
+
+/**
+	旧布局Key转换为新布局，组合键各部分的十进制整数转换为保序编码，大字段分片Key的哈希不转换
+ */
+func (storage *LayoutStorage) convertLegacyKey(prefix string, key string) (string,error) {
+	parts := strings.Split(key[len(prefix):], "~")
+	chunk := strings.HasPrefix(prefix, util.UInt8ToString(db.ChunkKeyType)+"-")
+	for i,part := range parts {
+		if chunk && i == 1 {
+			continue
+		}
+		value,err := strconv.ParseInt(part, 10, 64); if err != nil {
+			return "",fmt.Errorf("legacy key `%s` part `%s` error", key, part)
+		}
+		parts[i] = util.Int64ToKeyString(value)
+	}
+	return prefix + storage.state.CompositeKey(parts...),nil
+}
+
+/**
+	分批迁移旧布局的Key，每批最多迁移size个Key，返回位置的done为false时在新的事务中继续
+	同一类型中旧布局Key以数字开始，排在新布局Key(以字母开始)之前，按[前缀0,前缀:)区间查询
+	区间查询只返回已提交的Key，一个区间返回的Key数量达到批次大小时，剩余的Key在下一个事务中查询
+	全部迁移完成后保存新布局记录
+ */
+func (storage *LayoutStorage) MigrateKeyLayout(checkpoint db.KeyMigrateCheckpoint, size int32) (*db.KeyMigrateCheckpoint,error) {
+	if checkpoint.Done {
+		return &checkpoint,nil
+	}
+	if size <= 0 {
+		size = db.DefaultMigrateBatchSize
+	}
+	prefixes := storage.getLayoutPrefixes()
+	for checkpoint.Ranges < int32(len(prefixes)) && size > 0 {
+		prefix := prefixes[checkpoint.Ranges]
+		keys,err := storage.state.GetKeysByRange(prefix+"0", prefix+":", size); if err != nil {
+			return nil,err
+		}
+		for _,key := range keys {
+			newKey,err := storage.convertLegacyKey(prefix, key); if err != nil {
+				return nil,err
+			}
+			value,err := storage.state.GetKey(key); if err != nil {
+				return nil,err
+			}
+			if err := storage.state.PutOrDelKey(newKey, value, db.SetState); err != nil {
+				return nil,err
+			}
+			if err := storage.state.PutOrDelKey(key, nil, db.DelState); err != nil {
+				return nil,err
+			}
+		}
+		checkpoint.Keys += db.Total(len(keys))
+		if int32(len(keys)) == size {
+			break
+		}
+		size -= int32(len(keys))
+		checkpoint.Ranges++
+	}
+	if checkpoint.Ranges == int32(len(prefixes)) {
+		if err := storage.putKeyLayout(db.KeyLayoutOrdered); err != nil {
+			return nil,err
+		}
+		checkpoint.Done = true
+	}
+	return &checkpoint,nil
+}
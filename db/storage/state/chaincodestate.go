@@ -14,6 +14,8 @@ type ChainCodeState interface {
 
 	PutOrDelKey(key string, value []byte, op db.StateType) error
 	GetKey(key string) ([]byte,error)
+	GetKeysByRange(startKey string, endKey string, size int32) ([]string,error)
+	GetValuesByRange(startKey string, endKey string, handler func(key string, value []byte) (bool,error)) error
 
 	//GetCompositeKeyList(objectTypePrefix string, objectType string, prefixKeys []string, keys []string, pageSize int32) ([]string,error)
 	//
@@ -0,0 +1,77 @@
+package leveldb
+
+import (
+	"github.com/database-fabric/db/storage/state"
+	goleveldb "github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/storage"
+	"time"
+)
+
+/**
+	基于LevelDB持久化的ChainCodeState，用于本地开发和离线工具
+	每个事务以Begin开始，以Commit或Rollback结束，提交后数据重启可见
+ */
+type LevelDBState struct {
+	*state.StateImpl
+	db   *goleveldb.DB
+	stub *LevelDBStub
+}
+
+func NewLevelDBState(path string) (*LevelDBState, error) {
+	db, err := goleveldb.OpenFile(path, nil); if err != nil {
+		return nil, err
+	}
+	return newLevelDBState(db)
+}
+
+/**
+	内存存储，用于加载状态快照和测试，关闭后数据丢弃
+ */
+func NewMemLevelDBState() (*LevelDBState, error) {
+	db, err := goleveldb.Open(storage.NewMemStorage(), nil); if err != nil {
+		return nil, err
+	}
+	return newLevelDBState(db)
+}
+
+func newLevelDBState(db *goleveldb.DB) (*LevelDBState, error) {
+	stub, err := NewLevelDBStub(db); if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &LevelDBState{state.NewStateImpl(stub), db, stub}, nil
+}
+
+func (levelDBState *LevelDBState) GetLevelDBStub() *LevelDBStub {
+	return levelDBState.stub
+}
+
+/**
+	开始新事务，args为合约函数和参数(第一个参数为私有数据集合，为空使用公有数据)
+ */
+func (levelDBState *LevelDBState) Begin(txID string, timestamp time.Time, args... string) {
+	levelDBState.clearTxCache()
+	levelDBState.stub.Begin(txID, timestamp, args)
+}
+
+func (levelDBState *LevelDBState) Commit() (*TxLog, error) {
+	levelDBState.clearTxCache()
+	return levelDBState.stub.Commit()
+}
+
+func (levelDBState *LevelDBState) Rollback() {
+	levelDBState.clearTxCache()
+	levelDBState.stub.Rollback()
+}
+
+func (levelDBState *LevelDBState) Close() error {
+	levelDBState.Rollback()
+	return levelDBState.db.Close()
+}
+
+func (levelDBState *LevelDBState) clearTxCache() {
+	txCache := levelDBState.GetTxCache()
+	for key := range txCache {
+		delete(txCache, key)
+	}
+}
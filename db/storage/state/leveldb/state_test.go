@@ -0,0 +1,85 @@
+package leveldb
+
+import (
+	"github.com/database-fabric/db"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestLevelDBState(t *testing.T) {
+	path, err := ioutil.TempDir("", "leveldb-state"); if err != nil {
+		panic(err.Error())
+	}
+	defer os.RemoveAll(path)
+	levelDBState, err := NewLevelDBState(path); if err != nil {
+		panic(err.Error())
+	}
+	//事务提交前读不到已提交数据，事务内通过缓存可读
+	{
+		levelDBState.Begin("tx1", time.Now(), "Invoke", "")
+		for _, key := range []string{"b", "a", "c"} {
+			if err := levelDBState.PutOrDelKey(key, []byte(key), db.SetState); err != nil {
+				panic(err.Error())
+			}
+		}
+		value, err := levelDBState.GetKey("a"); if err != nil {
+			panic(err.Error())
+		}
+		assert.Equal(t, "a", string(value))
+		value, err = levelDBState.GetLevelDBStub().GetState("a"); if err != nil {
+			panic(err.Error())
+		}
+		assert.Nil(t, value)
+		txLog, err := levelDBState.Commit(); if err != nil {
+			panic(err.Error())
+		}
+		assert.EqualValues(t, 1, txLog.Seq)
+	}
+	//回滚不落盘
+	{
+		levelDBState.Begin("tx2", time.Now(), "Invoke", "")
+		if err := levelDBState.PutOrDelKey("a", nil, db.DelState); err != nil {
+			panic(err.Error())
+		}
+		levelDBState.Rollback()
+		if err := levelDBState.Close(); err != nil {
+			panic(err.Error())
+		}
+	}
+	//重启后数据可见，区间查询有序
+	{
+		levelDBState, err = NewLevelDBState(path); if err != nil {
+			panic(err.Error())
+		}
+		defer levelDBState.Close()
+		assert.EqualValues(t, 1, levelDBState.GetLevelDBStub().GetLogSequence())
+		levelDBState.Begin("tx3", time.Now(), "Invoke", "")
+		iter, err := levelDBState.GetLevelDBStub().GetStateByRange("a", "c"); if err != nil {
+			panic(err.Error())
+		}
+		var keys []string
+		for iter.HasNext() {
+			kv, err := iter.Next(); if err != nil {
+				panic(err.Error())
+			}
+			keys = append(keys, kv.Key)
+		}
+		assert.Equal(t, []string{"a", "b"}, keys)
+	}
+	//回放日志
+	{
+		var txLogs []*TxLog
+		err := levelDBState.GetLevelDBStub().Replay(1, func(txLog *TxLog) error {
+			txLogs = append(txLogs, txLog)
+			return nil
+		}); if err != nil {
+			panic(err.Error())
+		}
+		assert.Equal(t, 1, len(txLogs))
+		assert.Equal(t, "tx1", txLogs[0].TxID)
+		assert.Equal(t, 3, len(txLogs[0].Writes))
+	}
+}
@@ -0,0 +1,479 @@
+package leveldb
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/database-fabric/db/util"
+	"github.com/golang/protobuf/ptypes/timestamp"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/ledger/queryresult"
+	pb "github.com/hyperledger/fabric/protos/peer"
+	goleveldb "github.com/syndtr/goleveldb/leveldb"
+	leveldbutil "github.com/syndtr/goleveldb/leveldb/util"
+	"time"
+	"unicode/utf8"
+)
+
+//本地存储Key命名空间，公有数据、私有数据、回放日志、元数据互相隔离
+const (
+	stateNamespace   = "s\x00"
+	privateNamespace = "p\x00"
+	logNamespace     = "l\x00"
+	metaNamespace    = "m\x00"
+	logSequenceKey   = metaNamespace + "logSequence"
+
+	compositeKeyNamespace = "\x00"
+	minUnicodeRuneValue   = 0            //U+0000
+	maxUnicodeRuneValue   = utf8.MaxRune //U+10FFFF
+)
+
+//事务写集中的单个Key
+type TxWrite struct {
+	Collection string `json:"collection"` //私有数据集合，公有数据为空
+	Key        string `json:"key"`
+	Value      []byte `json:"value"`
+	IsDelete   bool   `json:"isDelete"`
+}
+
+//回放日志，每个提交的事务对应一条，按提交顺序递增
+type TxLog struct {
+	Seq    uint64    `json:"seq"`
+	TxID   string    `json:"txID"`
+	Time   int64     `json:"time"`
+	Writes []TxWrite `json:"writes"`
+}
+
+/**
+	基于LevelDB的合约Stub，模拟节点背书行为：
+	读只读取已提交数据(与Fabric一致，事务内读不到自己的写，由StateImpl缓存处理)
+	写入缓存到事务写集中，Commit时与回放日志在同一批次原子写入
+	未用到的节点能力(跨合约调用、富查询、事件等)返回不支持错误
+ */
+type LevelDBStub struct {
+	db        *goleveldb.DB
+	logSeq    uint64
+	txID      string
+	timestamp time.Time
+	args      []string
+	writes    map[string]*TxWrite
+	order     []string
+}
+
+func NewLevelDBStub(db *goleveldb.DB) (*LevelDBStub, error) {
+	stub := &LevelDBStub{db: db, writes: map[string]*TxWrite{}}
+	value, err := stub.get(logSequenceKey); if err != nil {
+		return nil, err
+	}
+	if len(value) > 0 {
+		stub.logSeq = uint64(util.BytesToInt64(value))
+	}
+	return stub, nil
+}
+
+///////////////////// Transaction Function //////////////////////
+
+/**
+	开始新事务，丢弃未提交写集
+ */
+func (stub *LevelDBStub) Begin(txID string, timestamp time.Time, args []string) {
+	stub.txID = txID
+	stub.timestamp = timestamp
+	stub.args = args
+	stub.Rollback()
+}
+
+/**
+	提交事务写集，写集和回放日志在同一个批次中原子写入
+ */
+func (stub *LevelDBStub) Commit() (*TxLog, error) {
+	if len(stub.order) == 0 {
+		return nil, nil
+	}
+	txLog := &TxLog{Seq: stub.logSeq + 1, TxID: stub.txID, Time: stub.timestamp.Unix(), Writes: make([]TxWrite, 0, len(stub.order))}
+	for _, name := range stub.order {
+		txLog.Writes = append(txLog.Writes, *stub.writes[name])
+	}
+	if err := stub.Apply(txLog); err != nil {
+		return nil, err
+	}
+	stub.Rollback()
+	return txLog, nil
+}
+
+/**
+	丢弃未提交写集
+ */
+func (stub *LevelDBStub) Rollback() {
+	stub.writes = map[string]*TxWrite{}
+	stub.order = nil
+}
+
+/**
+	应用一条回放日志(本地提交或从其他存储复制过来的日志)，日志序号必须连续
+ */
+func (stub *LevelDBStub) Apply(txLog *TxLog) error {
+	if txLog.Seq != stub.logSeq+1 {
+		return fmt.Errorf("tx log seq `%d` is not continuous, last seq `%d`", txLog.Seq, stub.logSeq)
+	}
+	logBytes, err := json.Marshal(txLog); if err != nil {
+		return err
+	}
+	batch := new(goleveldb.Batch)
+	for _, write := range txLog.Writes {
+		name := stub.namespaceKey(write.Collection, write.Key)
+		if write.IsDelete {
+			batch.Delete([]byte(name))
+		} else {
+			batch.Put([]byte(name), write.Value)
+		}
+	}
+	batch.Put(logKey(txLog.Seq), logBytes)
+	batch.Put([]byte(logSequenceKey), util.Int64ToBytes(int64(txLog.Seq)))
+	if err := stub.db.Write(batch, nil); err != nil {
+		return err
+	}
+	stub.logSeq = txLog.Seq
+	return nil
+}
+
+/**
+	从指定序号开始按顺序回放日志
+ */
+func (stub *LevelDBStub) Replay(from uint64, fn func(txLog *TxLog) error) error {
+	iter := stub.db.NewIterator(&leveldbutil.Range{Start: logKey(from), Limit: logKey(stub.logSeq + 1)}, nil)
+	defer iter.Release()
+	for iter.Next() {
+		txLog := &TxLog{}
+		if err := json.Unmarshal(iter.Value(), txLog); err != nil {
+			return err
+		}
+		if err := fn(txLog); err != nil {
+			return err
+		}
+	}
+	return iter.Error()
+}
+
+func (stub *LevelDBStub) GetLogSequence() uint64 {
+	return stub.logSeq
+}
+
+///////////////////// Private Function //////////////////////
+
+func logKey(seq uint64) []byte {
+	return append([]byte(logNamespace), util.Int64ToBytes(int64(seq))...)
+}
+
+func (stub *LevelDBStub) namespaceKey(collection string, key string) string {
+	if collection == "" {
+		return stateNamespace + key
+	}
+	return privateNamespace + collection + "\x00" + key
+}
+
+func (stub *LevelDBStub) get(name string) ([]byte, error) {
+	value, err := stub.db.Get([]byte(name), nil)
+	if err == goleveldb.ErrNotFound {
+		return nil, nil
+	}
+	return value, err
+}
+
+func (stub *LevelDBStub) put(collection string, key string, value []byte, isDelete bool) error {
+	if key == "" {
+		return fmt.Errorf("key must not be an empty string")
+	}
+	name := stub.namespaceKey(collection, key)
+	if _, ok := stub.writes[name]; !ok {
+		stub.order = append(stub.order, name)
+	}
+	stub.writes[name] = &TxWrite{Collection: collection, Key: key, Value: value, IsDelete: isDelete}
+	return nil
+}
+
+/**
+	区间查询已提交数据，区间为[startKey,endKey)，endKey为空表示到命名空间末尾
+ */
+func (stub *LevelDBStub) getByRange(collection string, startKey string, endKey string) (*StateQueryIterator, error) {
+	prefix := stub.namespaceKey(collection, "")
+	limit := []byte(prefix + endKey)
+	if endKey == "" {
+		limit = leveldbutil.BytesPrefix([]byte(prefix)).Limit
+	}
+	iter := stub.db.NewIterator(&leveldbutil.Range{Start: []byte(prefix + startKey), Limit: limit}, nil)
+	defer iter.Release()
+	var results []*queryresult.KV
+	for iter.Next() {
+		value := make([]byte, len(iter.Value()))
+		copy(value, iter.Value())
+		results = append(results, &queryresult.KV{Namespace: collection, Key: string(iter.Key()[len(prefix):]), Value: value})
+	}
+	if err := iter.Error(); err != nil {
+		return nil, err
+	}
+	return &StateQueryIterator{results: results}, nil
+}
+
+func createCompositeKey(objectType string, attributes []string) (string, error) {
+	if err := validateCompositeKeyAttribute(objectType); err != nil {
+		return "", err
+	}
+	ck := compositeKeyNamespace + objectType + string(rune(minUnicodeRuneValue))
+	for _, att := range attributes {
+		if err := validateCompositeKeyAttribute(att); err != nil {
+			return "", err
+		}
+		ck += att + string(rune(minUnicodeRuneValue))
+	}
+	return ck, nil
+}
+
+func validateCompositeKeyAttribute(str string) error {
+	if !utf8.ValidString(str) {
+		return fmt.Errorf("not a valid utf8 string: [%x]", str)
+	}
+	for index, runeValue := range str {
+		if runeValue == minUnicodeRuneValue || runeValue == maxUnicodeRuneValue {
+			return fmt.Errorf("input contain unicode %#U starting at position [%d]", runeValue, index)
+		}
+	}
+	return nil
+}
+
+///////////////////// Query Iterator //////////////////////
+
+type StateQueryIterator struct {
+	results []*queryresult.KV
+	current int
+}
+
+func (iter *StateQueryIterator) HasNext() bool {
+	return iter.current < len(iter.results)
+}
+
+func (iter *StateQueryIterator) Next() (*queryresult.KV, error) {
+	if !iter.HasNext() {
+		return nil, fmt.Errorf("result not found")
+	}
+	kv := iter.results[iter.current]
+	iter.current++
+	return kv, nil
+}
+
+func (iter *StateQueryIterator) Close() error {
+	return nil
+}
+
+///////////////////// Implement ChaincodeStub Interface //////////////////////
+
+func (stub *LevelDBStub) GetArgs() [][]byte {
+	args := make([][]byte, 0, len(stub.args))
+	for _, arg := range stub.args {
+		args = append(args, []byte(arg))
+	}
+	return args
+}
+
+func (stub *LevelDBStub) GetStringArgs() []string {
+	return stub.args
+}
+
+func (stub *LevelDBStub) GetFunctionAndParameters() (string, []string) {
+	if len(stub.args) == 0 {
+		return "", []string{}
+	}
+	return stub.args[0], stub.args[1:]
+}
+
+func (stub *LevelDBStub) GetArgsSlice() ([]byte, error) {
+	var slice []byte
+	for _, arg := range stub.args {
+		slice = append(slice, []byte(arg)...)
+	}
+	return slice, nil
+}
+
+func (stub *LevelDBStub) GetTxID() string {
+	return stub.txID
+}
+
+func (stub *LevelDBStub) GetChannelID() string {
+	return ""
+}
+
+func (stub *LevelDBStub) InvokeChaincode(chaincodeName string, args [][]byte, channel string) pb.Response {
+	return shim.Error("invoke chaincode is not supported by leveldb stub")
+}
+
+func (stub *LevelDBStub) GetState(key string) ([]byte, error) {
+	if key == "" {
+		return nil, fmt.Errorf("key must not be an empty string")
+	}
+	return stub.get(stub.namespaceKey("", key))
+}
+
+func (stub *LevelDBStub) PutState(key string, value []byte) error {
+	return stub.put("", key, value, false)
+}
+
+func (stub *LevelDBStub) DelState(key string) error {
+	return stub.put("", key, nil, true)
+}
+
+func (stub *LevelDBStub) SetStateValidationParameter(key string, ep []byte) error {
+	return fmt.Errorf("state validation parameter is not supported by leveldb stub")
+}
+
+func (stub *LevelDBStub) GetStateValidationParameter(key string) ([]byte, error) {
+	return nil, fmt.Errorf("state validation parameter is not supported by leveldb stub")
+}
+
+func (stub *LevelDBStub) GetStateByRange(startKey, endKey string) (shim.StateQueryIteratorInterface, error) {
+	return stub.getByRange("", startKey, endKey)
+}
+
+func (stub *LevelDBStub) GetStateByRangeWithPagination(startKey, endKey string, pageSize int32,
+	bookmark string) (shim.StateQueryIteratorInterface, *pb.QueryResponseMetadata, error) {
+	if bookmark != "" {
+		startKey = bookmark
+	}
+	iter, err := stub.getByRange("", startKey, endKey); if err != nil {
+		return nil, nil, err
+	}
+	metadata := &pb.QueryResponseMetadata{}
+	if pageSize > 0 && int32(len(iter.results)) > pageSize {
+		metadata.Bookmark = iter.results[pageSize].Key
+		iter.results = iter.results[:pageSize]
+	}
+	metadata.FetchedRecordsCount = int32(len(iter.results))
+	return iter, metadata, nil
+}
+
+func (stub *LevelDBStub) GetStateByPartialCompositeKey(objectType string, keys []string) (shim.StateQueryIteratorInterface, error) {
+	startKey, err := createCompositeKey(objectType, keys); if err != nil {
+		return nil, err
+	}
+	return stub.getByRange("", startKey, startKey+string(maxUnicodeRuneValue))
+}
+
+func (stub *LevelDBStub) GetStateByPartialCompositeKeyWithPagination(objectType string, keys []string,
+	pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *pb.QueryResponseMetadata, error) {
+	startKey, err := createCompositeKey(objectType, keys); if err != nil {
+		return nil, nil, err
+	}
+	return stub.GetStateByRangeWithPagination(startKey, startKey+string(maxUnicodeRuneValue), pageSize, bookmark)
+}
+
+func (stub *LevelDBStub) CreateCompositeKey(objectType string, attributes []string) (string, error) {
+	return createCompositeKey(objectType, attributes)
+}
+
+func (stub *LevelDBStub) SplitCompositeKey(compositeKey string) (string, []string, error) {
+	componentIndex := 1
+	var components []string
+	for i := 1; i < len(compositeKey); i++ {
+		if compositeKey[i] == minUnicodeRuneValue {
+			components = append(components, compositeKey[componentIndex:i])
+			componentIndex = i + 1
+		}
+	}
+	if len(components) == 0 {
+		return "", nil, fmt.Errorf("composite key `%s` error", compositeKey)
+	}
+	return components[0], components[1:], nil
+}
+
+func (stub *LevelDBStub) GetQueryResult(query string) (shim.StateQueryIteratorInterface, error) {
+	return nil, fmt.Errorf("rich query is not supported by leveldb stub")
+}
+
+func (stub *LevelDBStub) GetQueryResultWithPagination(query string, pageSize int32,
+	bookmark string) (shim.StateQueryIteratorInterface, *pb.QueryResponseMetadata, error) {
+	return nil, nil, fmt.Errorf("rich query is not supported by leveldb stub")
+}
+
+func (stub *LevelDBStub) GetHistoryForKey(key string) (shim.HistoryQueryIteratorInterface, error) {
+	return nil, fmt.Errorf("history query is not supported by leveldb stub, use Replay")
+}
+
+func (stub *LevelDBStub) GetPrivateData(collection, key string) ([]byte, error) {
+	if collection == "" {
+		return nil, fmt.Errorf("collection must not be an empty string")
+	}
+	return stub.get(stub.namespaceKey(collection, key))
+}
+
+func (stub *LevelDBStub) GetPrivateDataHash(collection, key string) ([]byte, error) {
+	return nil, fmt.Errorf("private data hash is not supported by leveldb stub")
+}
+
+func (stub *LevelDBStub) PutPrivateData(collection string, key string, value []byte) error {
+	if collection == "" {
+		return fmt.Errorf("collection must not be an empty string")
+	}
+	return stub.put(collection, key, value, false)
+}
+
+func (stub *LevelDBStub) DelPrivateData(collection, key string) error {
+	if collection == "" {
+		return fmt.Errorf("collection must not be an empty string")
+	}
+	return stub.put(collection, key, nil, true)
+}
+
+func (stub *LevelDBStub) SetPrivateDataValidationParameter(collection, key string, ep []byte) error {
+	return fmt.Errorf("private data validation parameter is not supported by leveldb stub")
+}
+
+func (stub *LevelDBStub) GetPrivateDataValidationParameter(collection, key string) ([]byte, error) {
+	return nil, fmt.Errorf("private data validation parameter is not supported by leveldb stub")
+}
+
+func (stub *LevelDBStub) GetPrivateDataByRange(collection, startKey, endKey string) (shim.StateQueryIteratorInterface, error) {
+	if collection == "" {
+		return nil, fmt.Errorf("collection must not be an empty string")
+	}
+	return stub.getByRange(collection, startKey, endKey)
+}
+
+func (stub *LevelDBStub) GetPrivateDataByPartialCompositeKey(collection, objectType string, keys []string) (shim.StateQueryIteratorInterface, error) {
+	if collection == "" {
+		return nil, fmt.Errorf("collection must not be an empty string")
+	}
+	startKey, err := createCompositeKey(objectType, keys); if err != nil {
+		return nil, err
+	}
+	return stub.getByRange(collection, startKey, startKey+string(maxUnicodeRuneValue))
+}
+
+func (stub *LevelDBStub) GetPrivateDataQueryResult(collection, query string) (shim.StateQueryIteratorInterface, error) {
+	return nil, fmt.Errorf("rich query is not supported by leveldb stub")
+}
+
+func (stub *LevelDBStub) GetCreator() ([]byte, error) {
+	return nil, nil
+}
+
+func (stub *LevelDBStub) GetTransient() (map[string][]byte, error) {
+	return nil, nil
+}
+
+func (stub *LevelDBStub) GetBinding() ([]byte, error) {
+	return nil, nil
+}
+
+func (stub *LevelDBStub) GetDecorations() map[string][]byte {
+	return nil
+}
+
+func (stub *LevelDBStub) GetSignedProposal() (*pb.SignedProposal, error) {
+	return nil, nil
+}
+
+func (stub *LevelDBStub) GetTxTimestamp() (*timestamp.Timestamp, error) {
+	return &timestamp.Timestamp{Seconds: stub.timestamp.Unix(), Nanos: int32(stub.timestamp.Nanosecond())}, nil
+}
+
+func (stub *LevelDBStub) SetEvent(name string, payload []byte) error {
+	return nil
+}
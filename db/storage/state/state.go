@@ -61,6 +61,62 @@ func (state *StateImpl) GetKey(key string) ([]byte,error) {
 	return state.getData(collection, key)
 }
 
+/**
+	区间[startKey,endKey)中已提交的Key，最多返回size个(事务内的写入和删除不可见)
+ */
+func (state *StateImpl) GetKeysByRange(startKey string, endKey string, size int32) ([]string,error) {
+	collection,err := state.getCollectionKey()
+	if err != nil {
+		return nil,err
+	}
+	resultsIterator,err := state.getDataByRangeIterator(collection, startKey, endKey)
+	if err != nil {
+		return nil,err
+	}
+	if resultsIterator == nil {
+		return nil,nil
+	}
+	defer resultsIterator.Close()
+	var keys []string
+	for int32(len(keys)) < size && resultsIterator.HasNext() {
+		responseRange,err := resultsIterator.Next()
+		if err != nil {
+			return nil,err
+		}
+		keys = append(keys, responseRange.Key)
+	}
+	return keys,nil
+}
+
+/**
+	流式读取区间[startKey,endKey)中已提交的值，一次迭代代替逐个GetKey，读取的值放入事务缓存
+	事务内已写入的Key使用缓存中的值，handler返回false时停止迭代(读集只包含已迭代的Key)
+ */
+func (state *StateImpl) GetValuesByRange(startKey string, endKey string, handler func(key string, value []byte) (bool,error)) error {
+	collection,err := state.getCollectionKey()
+	if err != nil {
+		return err
+	}
+	resultsIterator,err := state.getDataByRangeIterator(collection, startKey, endKey)
+	if err != nil {
+		return err
+	}
+	if resultsIterator == nil {
+		return nil
+	}
+	defer resultsIterator.Close()
+	for resultsIterator.HasNext() {
+		responseRange,err := resultsIterator.Next()
+		if err != nil {
+			return err
+		}
+		next,err := handler(responseRange.Key, state.getCacheValue(responseRange.Key, responseRange.Value)); if err != nil || !next {
+			return err
+		}
+	}
+	return nil
+}
+
 /////////////////// Other State Function ///////////////////
 
 func (state *StateImpl) GetState(collection,  key string) ([]byte,error) {
@@ -0,0 +1,139 @@
+package table
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/database-fabric/db"
+	"github.com/database-fabric/db/util"
+)
+
+/**
+	表结构二进制编码(兼容读取旧JSON编码)
+	新增字段追加在末尾，旧数据没有该字段时保持零值
+ */
+
+func EncodeTableData(table *db.TableData) []byte {
+	encoder := util.NewEncoder(util.EncodeBinaryV1, 64*len(table.Columns)+32)
+	encoder.PutInt64(int64(table.Id))
+	encoder.PutString(table.Name)
+	encoder.PutUint64(uint64(len(table.Columns)))
+	for _,column := range table.Columns {
+		encoder.PutInt64(int64(column.Id))
+		encoder.PutString(column.Name)
+		encoder.PutInt64(int64(column.Type))
+		encoder.PutBool(column.Default != nil)
+		encoder.PutBytes(column.Default)
+		encoder.PutBool(column.NotNull)
+		encoder.PutString(column.Desc)
+		encoder.PutBool(column.IsDeleted)
+		encoder.PutInt64(int64(column.Order))
+	}
+	encoder.PutInt64(int64(table.PrimaryKey.ColumnID))
+	encoder.PutBool(table.PrimaryKey.AutoIncrement)
+	encoder.PutUint64(uint64(len(table.ForeignKeys)))
+	for _,foreignKey := range table.ForeignKeys {
+		encoder.PutInt64(int64(foreignKey.ColumnID))
+		encoder.PutInt64(int64(foreignKey.Reference.TableID))
+		encoder.PutInt64(int64(foreignKey.Reference.ColumnID))
+	}
+	encoder.PutInt64(int64(table.TallyShards))
+	encoder.PutInt64(int64(table.Storage.BlockSize))
+	encoder.PutInt64(int64(table.Storage.NodeSize))
+	encoder.PutInt64(int64(table.Storage.SplitRule))
+	encoder.PutInt64(int64(table.Storage.TreeType))
+	encoder.PutInt64(int64(table.Storage.Compression))
+	encoder.PutInt64(int64(table.Retention.KeepVersions))
+	encoder.PutInt64(table.Retention.KeepSeconds)
+	encoder.PutInt64(table.Retention.PurgeDeletedBefore)
+	encoder.PutUint64(uint64(len(table.Counters)))
+	for _,counter := range table.Counters {
+		encoder.PutString(counter.Name)
+		encoder.PutInt64(int64(counter.Column))
+		encoder.PutInt64(int64(counter.GroupBy))
+	}
+	encoder.PutInt64(int64(table.PrimaryShards))
+	return encoder.Bytes()
+}
+
+func DecodeTableData(value []byte) (*db.TableData,error) {
+	table := &db.TableData{}
+	if util.IsJsonEncode(value) {
+		return table,json.Unmarshal(value, table)
+	}
+	decoder,version,err := util.NewDecoder(value); if err != nil {
+		return nil,err
+	}
+	if version != util.EncodeBinaryV1 {
+		return nil,fmt.Errorf("table encode version `%d` error", version)
+	}
+	table.Id = db.TableID(decoder.Int64())
+	table.Name = decoder.String()
+	columnNum := decoder.Uint64()
+	if decoder.Err() == nil && columnNum > uint64(decoder.Remaining()) {
+		return nil,fmt.Errorf("table columns length `%d` error", columnNum)
+	}
+	table.Columns = make([]db.Column, 0, columnNum)
+	for i:=uint64(0);i<columnNum;i++ {
+		column := db.Column{}
+		column.Id = db.ColumnID(decoder.Int64())
+		column.Name = decoder.String()
+		column.Type = db.DataType(decoder.Int64())
+		hasDefault := decoder.Bool()
+		column.Default = decoder.Bytes()
+		if !hasDefault {
+			column.Default = nil
+		}
+		column.NotNull = decoder.Bool()
+		column.Desc = decoder.String()
+		column.IsDeleted = decoder.Bool()
+		column.Order = int8(decoder.Int64())
+		table.Columns = append(table.Columns, column)
+	}
+	table.PrimaryKey.ColumnID = db.ColumnID(decoder.Int64())
+	table.PrimaryKey.AutoIncrement = decoder.Bool()
+	foreignKeyNum := decoder.Uint64()
+	if decoder.Err() == nil && foreignKeyNum > uint64(decoder.Remaining()) {
+		return nil,fmt.Errorf("table foreign keys length `%d` error", foreignKeyNum)
+	}
+	table.ForeignKeys = make([]db.ForeignKey, 0, foreignKeyNum)
+	for i:=uint64(0);i<foreignKeyNum;i++ {
+		foreignKey := db.ForeignKey{}
+		foreignKey.ColumnID = db.ColumnID(decoder.Int64())
+		foreignKey.Reference.TableID = db.TableID(decoder.Int64())
+		foreignKey.Reference.ColumnID = db.ColumnID(decoder.Int64())
+		table.ForeignKeys = append(table.ForeignKeys, foreignKey)
+	}
+	table.TallyShards = int8(decoder.Int64())
+	if decoder.Remaining() > 0 {
+		table.Storage.BlockSize = int32(decoder.Int64())
+		table.Storage.NodeSize = int32(decoder.Int64())
+		table.Storage.SplitRule = int8(decoder.Int64())
+		table.Storage.TreeType = int8(decoder.Int64())
+	}
+	if decoder.Remaining() > 0 {
+		table.Storage.Compression = int8(decoder.Int64())
+	}
+	if decoder.Remaining() > 0 {
+		table.Retention.KeepVersions = int32(decoder.Int64())
+		table.Retention.KeepSeconds = decoder.Int64()
+		table.Retention.PurgeDeletedBefore = decoder.Int64()
+	}
+	if decoder.Remaining() > 0 {
+		counterNum := decoder.Uint64()
+		if decoder.Err() == nil && counterNum > uint64(decoder.Remaining()) {
+			return nil,fmt.Errorf("table counters length `%d` error", counterNum)
+		}
+		table.Counters = make([]db.CounterConfig, 0, counterNum)
+		for i:=uint64(0);i<counterNum;i++ {
+			counter := db.CounterConfig{}
+			counter.Name = decoder.String()
+			counter.Column = db.ColumnID(decoder.Int64())
+			counter.GroupBy = db.ColumnID(decoder.Int64())
+			table.Counters = append(table.Counters, counter)
+		}
+	}
+	if decoder.Remaining() > 0 {
+		table.PrimaryShards = int8(decoder.Int64())
+	}
+	return table,decoder.Err()
+}
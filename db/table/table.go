@@ -2,7 +2,6 @@ package table
 
 import (
 	"bytes"
-	"encoding/json"
 	"github.com/database-fabric/db"
 	"github.com/database-fabric/db/storage"
 	"github.com/database-fabric/db/storage/state"
@@ -25,7 +24,7 @@ func (service *TableService) QueryTable(tableID db.TableID) (*db.TableData,error
 		return nil,err
 	}
 	if len(tableBytes) > 0 {
-		err = json.Unmarshal(tableBytes, table)
+		table,err = DecodeTableData(tableBytes)
 		if err != nil {
 			return nil,err
 		}
@@ -34,10 +33,23 @@ func (service *TableService) QueryTable(tableID db.TableID) (*db.TableData,error
 }
 
 func (service *TableService) PutTableData(table *db.TableData) error {
-	bytes,err := util.ConvertJsonBytes(*table); if err != nil {
-		return err
+	return service.storage.PutTableData(service.database.Id, table.Id, EncodeTableData(table))
+}
+
+/**
+	旧JSON编码的表结构重写为二进制编码
+ */
+func (service *TableService) MigrateTable(tableID db.TableID) (bool,error) {
+	tableBytes,err := service.storage.GetTableData(service.database.Id, tableID); if err != nil {
+		return false,err
+	}
+	if !util.IsJsonEncode(tableBytes) {
+		return false,nil
+	}
+	table,err := DecodeTableData(tableBytes); if err != nil {
+		return false,err
 	}
-	return service.storage.PutTableData(service.database.Id, table.Id, bytes)
+	return true,service.PutTableData(table)
 }
 
 func (service *TableService) CompareTable(table1 *db.TableData, table2 *db.TableData) string {
@@ -71,6 +83,14 @@ func (service *TableService) CompareTable(table1 *db.TableData, table2 *db.Table
 		return "PrimaryKey ColumnID error"
 	}else if table1.PrimaryKey.AutoIncrement != table2.PrimaryKey.AutoIncrement {
 		return "PrimaryKey AutoIncrement error"
+	}else if table1.TallyShards != table2.TallyShards {
+		return "TallyShards error"
+	}else if table1.PrimaryShards != table2.PrimaryShards {
+		return "PrimaryShards error"
+	}else if table1.Storage != table2.Storage {
+		return "Storage error"
+	}else if table1.Retention != table2.Retention {
+		return "Retention error"
 	}else if len(table1.ForeignKeys) != len(table2.ForeignKeys) {
 		return "ForeignKeys len error"
 	}
@@ -83,5 +103,13 @@ func (service *TableService) CompareTable(table1 *db.TableData, table2 *db.Table
 			return "ForeignKey Reference ColumnID error"
 		}
 	}
+	if len(table1.Counters) != len(table2.Counters) {
+		return "Counters len error"
+	}
+	for i:=0;i< len(table1.Counters);i++ {
+		if table1.Counters[i] != table2.Counters[i] {
+			return "Counter error"
+		}
+	}
 	return ""
 }
\ No newline at end of file
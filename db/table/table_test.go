@@ -3,7 +3,9 @@ package table
 import (
 	"github.com/database-fabric/db"
 	"github.com/database-fabric/db/storage/state"
+	"github.com/database-fabric/db/util"
 	"github.com/database-fabric/test"
+	"github.com/stretchr/testify/assert"
 	"testing"
 )
 
@@ -12,13 +14,15 @@ func TestTable(t *testing.T) {
 	state := state.NewStateImpl(stub)
 	database := &db.DataBase{Id:db.DatabaseID(1)}
 	tableService := NewTableService(database, state)
-	tableData := &db.TableData{Id:db.TableID(1),Name:"TestTable",
+	tableData := &db.TableData{Id:db.TableID(1),Name:"TestTable",TallyShards:4,PrimaryShards:4,
 		Columns:[]db.Column{
 			{Id:db.ColumnID(1),ColumnConfig:db.ColumnConfig{Name:"id",Type:db.INT,Default:nil,NotNull:true,Desc:"主键"},IsDeleted:false,Order:1},
 			{Id:db.ColumnID(2),ColumnConfig:db.ColumnConfig{Name:"name",Type:db.VARCHAR,Default:nil,NotNull:false,Desc:"名字"},IsDeleted:false,Order:2},
 		},
 		PrimaryKey:db.PrimaryKey{ColumnID:db.ColumnID(1),AutoIncrement:true},
-		ForeignKeys:[]db.ForeignKey{{ColumnID:db.ColumnID(2),Reference:db.ReferenceKey{TableID:db.TableID(2),ColumnID:db.ColumnID(1)}}}}
+		ForeignKeys:[]db.ForeignKey{{ColumnID:db.ColumnID(2),Reference:db.ReferenceKey{TableID:db.TableID(2),ColumnID:db.ColumnID(1)}}},
+		Retention:db.RetentionConfig{KeepVersions:3,KeepSeconds:3600},
+		Counters:[]db.CounterConfig{{Name:"total",Column:db.ColumnID(1),GroupBy:db.ColumnID(2)}}}
 	err := tableService.PutTableData(tableData); if err != nil {
 		panic(err.Error())
 	}
@@ -29,4 +33,30 @@ func TestTable(t *testing.T) {
 	if msg != "" {
 		panic(msg)
 	}
+	//旧JSON编码兼容读取并迁移
+	{
+		jsonBytes,err := util.ConvertJsonBytes(*tableData); if err != nil {
+			panic(err.Error())
+		}
+		if err := tableService.storage.PutTableData(database.Id, tableData.Id, jsonBytes); err != nil {
+			panic(err.Error())
+		}
+		queryTableData,err := tableService.QueryTable(tableData.Id); if err != nil {
+			panic(err.Error())
+		}
+		assert.Equal(t, "", tableService.CompareTable(tableData, queryTableData))
+		migrated,err := tableService.MigrateTable(tableData.Id); if err != nil {
+			panic(err.Error())
+		}
+		assert.True(t, migrated)
+		tableBytes,err := tableService.storage.GetTableData(database.Id, tableData.Id); if err != nil {
+			panic(err.Error())
+		}
+		assert.False(t, util.IsJsonEncode(tableBytes))
+		assert.True(t, len(tableBytes) < len(jsonBytes))
+		migrated,err = tableService.MigrateTable(tableData.Id); if err != nil {
+			panic(err.Error())
+		}
+		assert.False(t, migrated)
+	}
 }
\ No newline at end of file
@@ -409,11 +409,20 @@ func FormatColumnData(column db.Column, value interface{}) ([]byte,error) {
 		return nil,nil
 	}
 	columnType := column.Type
-	if columnType == db.VARCHAR {
+	if columnType == db.VARCHAR || columnType == db.TEXT {
 		data,err := ConvertString(value); if err != nil {
 			return nil, fmt.Errorf("column `%s` data `%s` convert string error %s", column.Name, value, err)
 		}
 		return []byte(data),nil
+	} else if columnType == db.BLOB {
+		data,ok := value.(string)
+		if !ok {
+			return nil, fmt.Errorf("column `%s` blob data must is base64 string", column.Name)
+		}
+		blob,err := base64.StdEncoding.DecodeString(data); if err != nil {
+			return nil, fmt.Errorf("column `%s` data convert blob error %s", column.Name, err)
+		}
+		return blob,nil
 	} else{
 		convertType, vType := ConvertDataType(value)
 		if columnType == db.BOOL && (convertType == db.VARCHAR || convertType == db.BOOL) {
@@ -439,6 +448,21 @@ func FormatColumnData(column db.Column, value interface{}) ([]byte,error) {
 
 
 func ParseRowData(table *db.Table, rowData *row.RowData) (db.JsonData,error) {
+	return ParseRowDataWithColumns(table, rowData, nil, nil)
+}
+
+/**
+	解析行数据，columns为投影列，为空时解析全部列，未投影的列不解析
+	大字段列通过readBlob按引用读取值，readBlob为空时不读取大字段值，返回大字段引用(哈希与长度)
+ */
+func ParseRowDataWithColumns(table *db.Table, rowData *row.RowData, columns []db.ColumnID, readBlob func(reference []byte) ([]byte,error)) (db.JsonData,error) {
+	var projection map[db.ColumnID]bool
+	if len(columns) > 0 {
+		projection = make(map[db.ColumnID]bool, len(columns))
+		for _,column := range columns {
+			projection[column] = true
+		}
+	}
 	var err error
 	dataLength := 0
 	if rowData != nil {
@@ -446,7 +470,7 @@ func ParseRowData(table *db.Table, rowData *row.RowData) (db.JsonData,error) {
 	}
 	rowJson := db.JsonData{}
 	for i,column := range table.Data.Columns {
-		if column.IsDeleted {
+		if column.IsDeleted || (projection != nil && !projection[column.Id]) {
 			continue
 		}
 		columnData := &row.ColumnData{}
@@ -460,6 +484,10 @@ func ParseRowData(table *db.Table, rowData *row.RowData) (db.JsonData,error) {
 			value,err = ParseColumnDataByNull(column); if err != nil {
 				return nil,err
 			}
+		}else if db.IsBlobType(column.Type) {
+			value,err = ParseBlobColumnData(column, columnData.Data, readBlob); if err != nil {
+				return nil,err
+			}
 		}else {
 			value,err = ParseColumnData(column, columnData.Data); if err != nil {
 				return nil,err
@@ -470,9 +498,19 @@ func ParseRowData(table *db.Table, rowData *row.RowData) (db.JsonData,error) {
 	return rowJson,nil
 }
 
+func ParseBlobColumnData(column db.Column, reference []byte, readBlob func(reference []byte) ([]byte,error)) (interface{},error) {
+	if readBlob == nil {
+		return DecodeBlobReference(reference)
+	}
+	value,err := readBlob(reference); if err != nil {
+		return nil,fmt.Errorf("column `%s` read blob error %s", column.Name, err)
+	}
+	return ParseColumnData(column, value)
+}
+
 func ParseColumnDataByNull(column db.Column) (interface{},error) {
 	switch column.Type {
-	case db.VARCHAR:
+	case db.VARCHAR,db.TEXT,db.BLOB:
 		return "",nil
 	case db.BOOL:
 		return false,nil
@@ -486,8 +524,10 @@ func ParseColumnDataByNull(column db.Column) (interface{},error) {
 
 func ParseColumnData(column db.Column, value []byte) (interface{},error) {
 	switch column.Type {
-		case db.VARCHAR:
+		case db.VARCHAR,db.TEXT:
 			return string(value),nil
+		case db.BLOB:
+			return base64.StdEncoding.EncodeToString(value),nil
 		case db.BOOL:
 			return StringToBool(string(value))
 		case db.INT:
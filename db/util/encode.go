@@ -0,0 +1,261 @@
+package util
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"github.com/database-fabric/db"
+)
+
+//存储编码格式标记，JSON编码以'{'开头，二进制编码第一个字节为格式版本号
+const (
+	EncodeJsonMarker byte = '{'
+	EncodeBinaryV1 byte = 1
+)
+
+func IsJsonEncode(value []byte) bool {
+	return len(value) > 0 && value[0] == EncodeJsonMarker
+}
+
+/**
+	二进制编码，整数使用varint，字节数组和字符串使用长度前缀
+	新增字段只能追加在末尾，解码时通过Remaining判断旧数据是否包含该字段
+ */
+type Encoder struct {
+	buf []byte
+}
+
+func NewEncoder(version byte, size int) *Encoder {
+	buf := make([]byte, 1, size+1)
+	buf[0] = version
+	return &Encoder{buf}
+}
+
+func (encoder *Encoder) PutInt64(value int64) {
+	var temp [binary.MaxVarintLen64]byte
+	n := binary.PutVarint(temp[:], value)
+	encoder.buf = append(encoder.buf, temp[:n]...)
+}
+
+func (encoder *Encoder) PutUint64(value uint64) {
+	var temp [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(temp[:], value)
+	encoder.buf = append(encoder.buf, temp[:n]...)
+}
+
+func (encoder *Encoder) PutBool(value bool) {
+	if value {
+		encoder.buf = append(encoder.buf, 1)
+	}else{
+		encoder.buf = append(encoder.buf, 0)
+	}
+}
+
+func (encoder *Encoder) PutBytes(value []byte) {
+	encoder.PutUint64(uint64(len(value)))
+	encoder.buf = append(encoder.buf, value...)
+}
+
+func (encoder *Encoder) PutString(value string) {
+	encoder.PutUint64(uint64(len(value)))
+	encoder.buf = append(encoder.buf, value...)
+}
+
+func (encoder *Encoder) PutBytesList(values [][]byte) {
+	encoder.PutUint64(uint64(len(values)))
+	for _,value := range values {
+		encoder.PutBytes(value)
+	}
+}
+
+func (encoder *Encoder) Bytes() []byte {
+	return encoder.buf
+}
+
+/**
+	二进制解码，出现错误后续读取均返回零值，最后统一通过Err判断
+ */
+type Decoder struct {
+	buf []byte
+	err error
+}
+
+func NewDecoder(value []byte) (*Decoder,byte,error) {
+	if len(value) == 0 {
+		return nil,0,fmt.Errorf("decode value is null")
+	}
+	return &Decoder{value[1:],nil},value[0],nil
+}
+
+func (decoder *Decoder) Int64() int64 {
+	if decoder.err != nil {
+		return 0
+	}
+	value,n := binary.Varint(decoder.buf)
+	if n <= 0 {
+		decoder.err = fmt.Errorf("decode varint error")
+		return 0
+	}
+	decoder.buf = decoder.buf[n:]
+	return value
+}
+
+func (decoder *Decoder) Uint64() uint64 {
+	if decoder.err != nil {
+		return 0
+	}
+	value,n := binary.Uvarint(decoder.buf)
+	if n <= 0 {
+		decoder.err = fmt.Errorf("decode uvarint error")
+		return 0
+	}
+	decoder.buf = decoder.buf[n:]
+	return value
+}
+
+func (decoder *Decoder) Bool() bool {
+	if decoder.err != nil {
+		return false
+	}
+	if len(decoder.buf) == 0 {
+		decoder.err = fmt.Errorf("decode bool error")
+		return false
+	}
+	value := decoder.buf[0] == 1
+	decoder.buf = decoder.buf[1:]
+	return value
+}
+
+func (decoder *Decoder) Bytes() []byte {
+	length := decoder.Uint64()
+	if decoder.err != nil {
+		return nil
+	}
+	if uint64(len(decoder.buf)) < length {
+		decoder.err = fmt.Errorf("decode bytes length `%d` error", length)
+		return nil
+	}
+	value := decoder.buf[:length:length]
+	decoder.buf = decoder.buf[length:]
+	return value
+}
+
+func (decoder *Decoder) String() string {
+	return string(decoder.Bytes())
+}
+
+func (decoder *Decoder) BytesList() [][]byte {
+	length := decoder.Uint64()
+	if decoder.err != nil {
+		return nil
+	}
+	if uint64(len(decoder.buf)) < length {
+		decoder.err = fmt.Errorf("decode list length `%d` error", length)
+		return nil
+	}
+	values := make([][]byte, 0, length)
+	for i:=uint64(0);i<length;i++ {
+		values = append(values, decoder.Bytes())
+	}
+	return values
+}
+
+func (decoder *Decoder) Remaining() int {
+	return len(decoder.buf)
+}
+
+func (decoder *Decoder) Err() error {
+	return decoder.err
+}
+
+//////////////////// Encode Size ////////////////////
+
+func VarintSize(value int64) int {
+	ux := uint64(value) << 1
+	if value < 0 {
+		ux = ^ux
+	}
+	return UvarintSize(ux)
+}
+
+func UvarintSize(value uint64) int {
+	size := 1
+	for value >= 0x80 {
+		value >>= 7
+		size++
+	}
+	return size
+}
+
+func BytesSize(value []byte) int {
+	return UvarintSize(uint64(len(value))) + len(value)
+}
+
+func BytesListSize(values [][]byte) int {
+	size := UvarintSize(uint64(len(values)))
+	for _,value := range values {
+		size += BytesSize(value)
+	}
+	return size
+}
+
+//大字段引用编码：版本号(1字节)+哈希(32字节)+长度(8字节)
+const blobReferenceSize = 1+sha256.Size+8
+
+func EncodeBlobReference(reference *db.BlobReference) []byte {
+	buf := make([]byte, blobReferenceSize)
+	buf[0] = EncodeBinaryV1
+	copy(buf[1:], reference.Hash)
+	binary.BigEndian.PutUint64(buf[1+sha256.Size:], uint64(reference.Length))
+	return buf
+}
+
+func DecodeBlobReference(value []byte) (*db.BlobReference,error) {
+	if len(value) != blobReferenceSize || value[0] != EncodeBinaryV1 {
+		return nil,fmt.Errorf("blob reference format error")
+	}
+	hash := make([]byte, sha256.Size)
+	copy(hash, value[1:])
+	return &db.BlobReference{Hash:hash,Length:int64(binary.BigEndian.Uint64(value[1+sha256.Size:]))},nil
+}
+
+/**
+	分页游标编码为不透明字符串(二进制编码后base64)，客户端原样传回，空游标编码为空字符串
+ */
+func EncodeCursor(cursor *db.Cursor) string {
+	if cursor == nil {
+		return ""
+	}
+	encoder := NewEncoder(EncodeBinaryV1, 16+len(cursor.Key))
+	encoder.PutUint64(uint64(cursor.Order))
+	encoder.PutInt64(cursor.Node)
+	encoder.PutInt64(int64(cursor.Position))
+	encoder.PutInt64(cursor.Index)
+	encoder.PutBytes(cursor.Key)
+	encoder.PutUint64(uint64(cursor.Total))
+	return base64.RawURLEncoding.EncodeToString(encoder.Bytes())
+}
+
+func DecodeCursor(token string) (*db.Cursor,error) {
+	if token == "" {
+		return nil,nil
+	}
+	value,err := base64.RawURLEncoding.DecodeString(token); if err != nil {
+		return nil,fmt.Errorf("cursor `%s` format error", token)
+	}
+	decoder,version,err := NewDecoder(value); if err != nil {
+		return nil,err
+	}
+	if version != EncodeBinaryV1 {
+		return nil,fmt.Errorf("cursor version `%d` error", version)
+	}
+	cursor := &db.Cursor{Order:db.OrderType(decoder.Uint64()),Node:decoder.Int64(),Position:int32(decoder.Int64()),Index:decoder.Int64(),Key:decoder.Bytes()}
+	if decoder.Remaining() > 0 {
+		cursor.Total = db.Total(decoder.Uint64())
+	}
+	if err := decoder.Err(); err != nil {
+		return nil,fmt.Errorf("cursor `%s` decode error %s", token, err)
+	}
+	return cursor,nil
+}
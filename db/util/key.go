@@ -0,0 +1,64 @@
+package util
+
+import (
+	"fmt"
+	"strconv"
+)
+
+/**
+	Key中整数的保序编码，字符串顺序与数值顺序一致，用于区间查询
+	非负数：第一个字符为十进制位数('a'为1位)，之后为十进制数字，如5为a5，12为b12
+	负数：第一个字符为'A'开始按位数倒序(位数越多越小)，之后为各位数字的补数(9-d)
+ */
+const maxKeyDigits = 20
+
+func Int64ToKeyString(value int64) string {
+	if value >= 0 {
+		digits := strconv.FormatUint(uint64(value), 10)
+		return string(rune('a'+len(digits)-1)) + digits
+	}
+	digits := []byte(strconv.FormatUint(uint64(-(value+1))+1, 10))
+	for i,d := range digits {
+		digits[i] = '9'-(d-'0')
+	}
+	return string(rune('A'+maxKeyDigits-len(digits))) + string(digits)
+}
+
+func KeyStringToInt64(key string) (int64,error) {
+	if len(key) < 2 {
+		return 0,fmt.Errorf("key `%s` length error", key)
+	}
+	head,digits := key[0],[]byte(key[1:])
+	switch {
+	case head >= 'a' && head < 'a'+maxKeyDigits:
+		if int(head-'a')+1 != len(digits) {
+			return 0,fmt.Errorf("key `%s` digits error", key)
+		}
+		value,err := strconv.ParseUint(string(digits), 10, 63); if err != nil {
+			return 0,fmt.Errorf("key `%s` parse error %s", key, err)
+		}
+		return int64(value),nil
+	case head >= 'A' && head < 'A'+maxKeyDigits:
+		if maxKeyDigits-int(head-'A') != len(digits) {
+			return 0,fmt.Errorf("key `%s` digits error", key)
+		}
+		for i,d := range digits {
+			if d < '0' || d > '9' {
+				return 0,fmt.Errorf("key `%s` digits error", key)
+			}
+			digits[i] = '9'-(d-'0')
+		}
+		value,err := strconv.ParseUint(string(digits), 10, 64); if err != nil || value > 1<<63 {
+			return 0,fmt.Errorf("key `%s` parse error", key)
+		}
+		return -int64(value-1)-1,nil
+	}
+	return 0,fmt.Errorf("key `%s` head error", key)
+}
+
+/**
+	Key中的整数是否为旧的十进制编码
+ */
+func IsDecimalKeyString(key string) bool {
+	return len(key) > 0 && key[0] >= '0' && key[0] <= '9'
+}
@@ -0,0 +1,515 @@
+package aggregate
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/database-fabric/db"
+	"github.com/database-fabric/db/util"
+	"github.com/database-fabric/op/table"
+	"github.com/database-fabric/protos/db/row"
+	"github.com/shopspring/decimal"
+	"strings"
+)
+
+const (
+	DefaultBudget = int32(1000) //每次调用默认扫描行数
+	MaxBudget = int32(10000) //每次调用最多扫描行数
+)
+
+//聚合函数
+const (
+	FuncCount = "count"
+	FuncSum = "sum"
+	FuncMin = "min"
+	FuncMax = "max"
+	FuncAvg = "avg"
+)
+
+type AggregateOperation struct {
+	iDatabase db.DatabaseInterface
+}
+
+func NewAggregateOperation(iDatabase db.DatabaseInterface) *AggregateOperation {
+	return &AggregateOperation{iDatabase}
+}
+
+/**
+	聚合配置，扫描主键区间[Start,End](为0时不限制)，设置ForeignKey时使用外键索引只扫描引用Reference行的行
+	Where为列值等值过滤，GroupBy为分组列，多次调用时配置必须一致
+ */
+type AggregateConfig struct {
+	Start db.RowID `json:"start"`
+	End db.RowID `json:"end"`
+	ForeignKey string `json:"foreignKey"` //外键列名
+	Reference db.RowID `json:"reference"` //外键引用的行ID
+	Where db.JsonData `json:"where"`
+	GroupBy []string `json:"groupBy"`
+	Aggregates []Aggregate `json:"aggregates"`
+	Budget int32 `json:"budget"` //每次调用最多扫描的行数(包括已删除和过滤的行)，为空使用默认值
+}
+
+/**
+	聚合列，count的列为空时统计行数，否则统计非空值数量，sum、min、max、avg只支持INT和DECIMAL列
+ */
+type Aggregate struct {
+	Func string `json:"func"`
+	Column string `json:"column"`
+	As string `json:"as"` //结果列名，为空时为func(column)
+}
+
+/**
+	聚合位置，大表分多次调用，未完成时使用返回的位置继续，分组中保存已扫描行的中间结果
+ */
+type Checkpoint struct {
+	Config string `json:"config"` //聚合配置(不包括Budget)的哈希，继续扫描时配置必须一致
+	Cursor string `json:"cursor"` //索引游标(主键区间或外键引用行的行ID)
+	Scanned db.Total `json:"scanned"` //累计扫描的行数量
+	Groups []*Group `json:"groups"`
+	Done bool `json:"done"`
+}
+
+type Group struct {
+	Keys [][]byte `json:"keys"` //分组列值(列存储格式)
+	Values []*Accumulator `json:"values"` //与聚合列对应
+}
+
+//聚合中间结果，数值使用decimal字符串保存精度
+type Accumulator struct {
+	Count db.Total `json:"count"`
+	Sum string `json:"sum,omitempty"`
+	Min string `json:"min,omitempty"`
+	Max string `json:"max,omitempty"`
+}
+
+type Result struct {
+	Checkpoint *Checkpoint `json:"checkpoint"`
+	Rows []db.JsonData `json:"rows,omitempty"` //完成时的聚合结果，每个分组一行
+}
+
+type aggregator struct {
+	table *db.Table
+	config *AggregateConfig
+	where []*db.Column
+	whereValues [][]byte
+	foreignKey *db.ForeignKey
+	groupBy []*db.Column
+	columns []*db.Column //与聚合列对应，count(*)为空
+	projection []db.ColumnID
+	groups map[string]*group
+	checkpoint *Checkpoint
+}
+
+type group struct {
+	keys [][]byte
+	values []*accumulator
+}
+
+type accumulator struct {
+	count db.Total
+	sum decimal.Decimal
+	min decimal.Decimal
+	max decimal.Decimal
+}
+
+////////////////// Public Function //////////////////
+/**
+	聚合查询，configJson为AggregateConfig，checkpointJson为上一次返回的位置，为空时开始扫描
+	每次最多扫描Budget行，返回位置的done为false时使用返回的位置继续
+ */
+func (operation *AggregateOperation) AggregateBytes(tableName string, configJson string, checkpointJson string) ([]byte,error) {
+	t,err := table.ValidateNullOfData(tableName, operation.iDatabase); if err != nil {
+		return nil,err
+	}
+	var config AggregateConfig
+	if err := json.Unmarshal([]byte(configJson), &config); err != nil {
+		return nil,fmt.Errorf("aggregate config json %s", err)
+	}
+	var checkpoint Checkpoint
+	if checkpointJson != "" {
+		if err := json.Unmarshal([]byte(checkpointJson), &checkpoint); err != nil {
+			return nil,fmt.Errorf("aggregate checkpoint json %s", err)
+		}
+	}
+	result,err := operation.Aggregate(t, &config, checkpoint); if err != nil {
+		return nil,err
+	}
+	return util.ConvertJsonBytes(*result)
+}
+
+func (operation *AggregateOperation) Aggregate(table *db.Table, config *AggregateConfig, checkpoint Checkpoint) (*Result,error) {
+	aggregator,err := newAggregator(table, config, &checkpoint); if err != nil {
+		return nil,err
+	}
+	if !checkpoint.Done {
+		if config.ForeignKey != "" {
+			err = operation.scanForeignKey(aggregator)
+		}else{
+			err = operation.scanPrimaryKey(aggregator)
+		}
+		if err != nil {
+			return nil,err
+		}
+	}
+	return aggregator.result()
+}
+
+////////////////// Private Function //////////////////
+func findColumn(table *db.Table, name string) (*db.Column,error) {
+	for i,column := range table.Data.Columns {
+		if column.Name == name && !column.IsDeleted {
+			return &table.Data.Columns[i],nil
+		}
+	}
+	return nil,fmt.Errorf("column `%s` not found in table `%s`", name, table.Data.Name)
+}
+
+func isNumeric(column *db.Column) bool {
+	return column.Type == db.INT || column.Type == db.DECIMAL
+}
+
+/**
+	配置哈希，Budget每次调用可以不同，不参与计算
+ */
+func configHash(config *AggregateConfig) (string,error) {
+	hashConfig := *config
+	hashConfig.Budget = 0
+	configBytes,err := json.Marshal(hashConfig); if err != nil {
+		return "",err
+	}
+	hash := sha256.Sum256(configBytes)
+	return hex.EncodeToString(hash[:]),nil
+}
+
+/**
+	验证配置并恢复位置中的分组中间结果
+ */
+func newAggregator(table *db.Table, config *AggregateConfig, checkpoint *Checkpoint) (*aggregator,error) {
+	if config.Budget == 0 {
+		config.Budget = DefaultBudget
+	}
+	if config.Budget < 0 || config.Budget > MaxBudget {
+		return nil,fmt.Errorf("aggregate budget must between 1 and %d", MaxBudget)
+	}
+	if config.Start > 0 && config.End > 0 && config.Start > config.End {
+		return nil,fmt.Errorf("aggregate start `%d` must <= end `%d`", config.Start, config.End)
+	}
+	if len(config.Aggregates) == 0 {
+		return nil,fmt.Errorf("aggregates is null")
+	}
+	hash,err := configHash(config); if err != nil {
+		return nil,err
+	}
+	if checkpoint.Config == "" && checkpoint.Scanned == 0 && checkpoint.Cursor == "" {//第一次调用
+		checkpoint.Config = hash
+	}else if checkpoint.Config != hash {
+		return nil,fmt.Errorf("aggregate checkpoint config not match")
+	}
+	aggregator := &aggregator{table:table,config:config,groups:map[string]*group{},checkpoint:checkpoint}
+	projection := map[db.ColumnID]bool{table.Primary.Id:true}
+	addColumn := func(name string) (*db.Column,error) {
+		column,err := findColumn(table, name); if err != nil {
+			return nil,err
+		}
+		if db.IsBlobType(column.Type) {
+			return nil,fmt.Errorf("aggregate column `%s` is blob", name)
+		}
+		projection[column.Id] = true
+		return column,nil
+	}
+	for name,value := range config.Where {
+		column,err := addColumn(name); if err != nil {
+			return nil,err
+		}
+		data,err := util.FormatColumnData(*column, value); if err != nil {
+			return nil,err
+		}
+		aggregator.where = append(aggregator.where, column)
+		aggregator.whereValues = append(aggregator.whereValues, data)
+	}
+	if config.ForeignKey != "" {//外键值修改后旧值的索引不删除，需要验证行当前的外键值
+		column,err := addColumn(config.ForeignKey); if err != nil {
+			return nil,err
+		}
+		foreignKey,ok := table.ForeignKeys[column.Id]
+		if !ok {
+			return nil,fmt.Errorf("column `%s` is not foreign key in table `%s`", config.ForeignKey, table.Data.Name)
+		}
+		aggregator.foreignKey = foreignKey
+		aggregator.where = append(aggregator.where, column)
+		aggregator.whereValues = append(aggregator.whereValues, util.Int64ToBytes(config.Reference))
+	}
+	for _,name := range config.GroupBy {
+		column,err := addColumn(name); if err != nil {
+			return nil,err
+		}
+		aggregator.groupBy = append(aggregator.groupBy, column)
+	}
+	for i,aggregate := range config.Aggregates {
+		var column *db.Column
+		if aggregate.Column != "" {
+			var err error
+			column,err = addColumn(aggregate.Column); if err != nil {
+				return nil,err
+			}
+		}
+		switch aggregate.Func {
+		case FuncCount:
+		case FuncSum,FuncMin,FuncMax,FuncAvg:
+			if column == nil || !isNumeric(column) {
+				return nil,fmt.Errorf("aggregate `%s` column `%s` must be int or decimal", aggregate.Func, aggregate.Column)
+			}
+		default:
+			return nil,fmt.Errorf("aggregate func `%s` error", aggregate.Func)
+		}
+		if aggregate.As == "" {
+			config.Aggregates[i].As = aggregate.Func+"("+aggregate.Column+")"
+		}
+		aggregator.columns = append(aggregator.columns, column)
+	}
+	for _,column := range table.Data.Columns {
+		if projection[column.Id] {
+			aggregator.projection = append(aggregator.projection, column.Id)
+		}
+	}
+	for _,g := range checkpoint.Groups {
+		if len(g.Keys) != len(aggregator.groupBy) || len(g.Values) != len(aggregator.columns) {
+			return nil,fmt.Errorf("aggregate checkpoint groups not match config")
+		}
+		restored := &group{keys:g.Keys,values:make([]*accumulator, len(g.Values))}
+		for i,value := range g.Values {
+			acc,err := value.accumulator(); if err != nil {
+				return nil,err
+			}
+			restored.values[i] = acc
+		}
+		aggregator.groups[groupKey(g.Keys)] = restored
+	}
+	return aggregator,nil
+}
+
+func (value *Accumulator) accumulator() (*accumulator,error) {
+	acc := &accumulator{count:value.Count}
+	var err error
+	for _,v := range []struct{s string; d *decimal.Decimal}{{value.Sum,&acc.sum},{value.Min,&acc.min},{value.Max,&acc.max}} {
+		*v.d,err = util.StringToDecimal(v.s); if err != nil {
+			return nil,fmt.Errorf("aggregate checkpoint value `%s` error", v.s)
+		}
+	}
+	return acc,nil
+}
+
+/**
+	分组关键字，列值按长度前缀拼接
+ */
+func groupKey(keys [][]byte) string {
+	var builder strings.Builder
+	for _,key := range keys {
+		builder.WriteString(util.Int64ToString(int64(len(key))))
+		builder.WriteByte(':')
+		builder.Write(key)
+	}
+	return builder.String()
+}
+
+/**
+	主键区间游标扫描，本次扫描的行数量不足预算时扫描完成
+ */
+func (operation *AggregateOperation) scanPrimaryKey(aggregator *aggregator) error {
+	checkpoint := aggregator.checkpoint
+	cursor,err := util.DecodeCursor(checkpoint.Cursor); if err != nil {
+		return err
+	}
+	tally,err := operation.iDatabase.GetTableTally(aggregator.table.Data.Id); if err != nil {
+		return err
+	}
+	if tally.AddRow == 0 {//空表没有主键索引
+		checkpoint.Done = true
+		return nil
+	}
+	rows,next,err := operation.iDatabase.QueryRowDataByCursor(aggregator.table.Data, aggregator.config.Start, aggregator.config.End, cursor, db.ASC, aggregator.config.Budget, aggregator.projection...); if err != nil {
+		return err
+	}
+	for _,rowData := range rows {
+		if err := aggregator.addRow(rowData); err != nil {
+			return err
+		}
+	}
+	checkpoint.Scanned += db.Total(len(rows))
+	checkpoint.Cursor = util.EncodeCursor(next)
+	checkpoint.Done = next == nil
+	return nil
+}
+
+/**
+	外键索引扫描，索引中的行ID按写入顺序，使用游标继续
+	新增、修改行时写入外键索引，外键值修改后旧值的索引不删除，按行当前的外键值过滤
+ */
+func (operation *AggregateOperation) scanForeignKey(aggregator *aggregator) error {
+	checkpoint := aggregator.checkpoint
+	table := aggregator.table
+	cursor,err := util.DecodeCursor(checkpoint.Cursor); if err != nil {
+		return err
+	}
+	rowIDs,next,err := operation.iDatabase.QueryRowIDByForeignKeyCursor(table.Data.Id, *aggregator.foreignKey, aggregator.config.Reference, cursor, aggregator.config.Budget); if err != nil {
+		return err
+	}
+	seen := make(map[db.RowID]bool, len(rowIDs))
+	for _,rowID := range rowIDs {
+		if (aggregator.config.Start > 0 && rowID < aggregator.config.Start) || (aggregator.config.End > 0 && rowID > aggregator.config.End) {
+			continue
+		}
+		if seen[rowID] {//旧版本删除后重新新增时重复写入的索引(重建外键索引后去除)
+			continue
+		}
+		seen[rowID] = true
+		rowData,err := operation.iDatabase.QueryRowData(table.Data, rowID, aggregator.projection...); if err != nil {
+			return err
+		}
+		if err := aggregator.addRow(rowData); err != nil {
+			return err
+		}
+	}
+	checkpoint.Scanned += db.Total(len(rowIDs))
+	checkpoint.Cursor = util.EncodeCursor(next)
+	checkpoint.Done = next == nil
+	return nil
+}
+
+func (aggregator *aggregator) columnData(rowData *row.RowData, column *db.Column) []byte {
+	if column.Id == aggregator.table.Primary.Id {
+		return util.Int64ToBytes(rowData.Id)
+	}
+	index := int(column.Id)-1
+	if index < len(rowData.Columns) {
+		return rowData.Columns[index].Data
+	}
+	return column.Default
+}
+
+func (aggregator *aggregator) columnDecimal(column *db.Column, data []byte) (decimal.Decimal,error) {
+	if column.Type == db.INT {
+		return decimal.NewFromInt(util.BytesToInt64(data)),nil
+	}
+	return util.StringToDecimal(string(data))
+}
+
+/**
+	已删除的行和不满足过滤条件的行不参与聚合，空值不参与count(列)以外的聚合
+ */
+func (aggregator *aggregator) addRow(rowData *row.RowData) error {
+	if rowData == nil || rowData.Id == 0 || uint8(rowData.Op) == db.DELETE {
+		return nil
+	}
+	for i,column := range aggregator.where {
+		if !bytes.Equal(aggregator.columnData(rowData, column), aggregator.whereValues[i]) {
+			return nil
+		}
+	}
+	keys := make([][]byte, len(aggregator.groupBy))
+	for i,column := range aggregator.groupBy {
+		keys[i] = aggregator.columnData(rowData, column)
+	}
+	name := groupKey(keys)
+	g,ok := aggregator.groups[name]
+	if !ok {
+		g = aggregator.newGroup(keys)
+	}
+	for i,column := range aggregator.columns {
+		acc := g.values[i]
+		if column == nil {
+			acc.count++
+			continue
+		}
+		data := aggregator.columnData(rowData, column)
+		if len(data) == 0 {
+			continue
+		}
+		acc.count++
+		if !isNumeric(column) {
+			continue
+		}
+		value,err := aggregator.columnDecimal(column, data); if err != nil {
+			return err
+		}
+		acc.sum = acc.sum.Add(value)
+		if acc.count == 1 || value.Cmp(acc.min) < 0 {
+			acc.min = value
+		}
+		if acc.count == 1 || value.Cmp(acc.max) > 0 {
+			acc.max = value
+		}
+	}
+	return nil
+}
+
+func (aggregator *aggregator) newGroup(keys [][]byte) *group {
+	g := &group{keys:keys,values:make([]*accumulator, len(aggregator.columns))}
+	for i := range g.values {
+		g.values[i] = &accumulator{}
+	}
+	aggregator.groups[groupKey(keys)] = g
+	aggregator.checkpoint.Groups = append(aggregator.checkpoint.Groups, &Group{Keys:keys})
+	return g
+}
+
+/**
+	中间结果写回位置，完成时按分组首次出现的顺序生成结果行，没有分组列时至少返回一行
+ */
+func (aggregator *aggregator) result() (*Result,error) {
+	checkpoint := aggregator.checkpoint
+	if len(aggregator.groupBy) == 0 && len(checkpoint.Groups) == 0 {
+		aggregator.newGroup([][]byte{})
+	}
+	for _,g := range checkpoint.Groups {
+		values := aggregator.groups[groupKey(g.Keys)].values
+		g.Values = make([]*Accumulator, len(values))
+		for i,acc := range values {
+			g.Values[i] = &Accumulator{Count:acc.count,Sum:util.DecimalToString(acc.sum),Min:util.DecimalToString(acc.min),Max:util.DecimalToString(acc.max)}
+		}
+	}
+	result := &Result{Checkpoint:checkpoint}
+	if !checkpoint.Done {
+		return result,nil
+	}
+	result.Rows = make([]db.JsonData, 0, len(checkpoint.Groups))
+	for _,g := range checkpoint.Groups {
+		rowJson := db.JsonData{}
+		for i,column := range aggregator.groupBy {
+			var value interface{}
+			var err error
+			if len(g.Keys[i]) == 0 {
+				value,err = util.ParseColumnDataByNull(*column)
+			}else{
+				value,err = util.ParseColumnData(*column, g.Keys[i])
+			}
+			if err != nil {
+				return nil,err
+			}
+			rowJson[column.Name] = value
+		}
+		for i,aggregate := range aggregator.config.Aggregates {
+			acc := aggregator.groups[groupKey(g.Keys)].values[i]
+			var value interface{}
+			switch {
+			case aggregate.Func == FuncCount:
+				value = acc.count
+			case acc.count == 0://没有非空值
+				value = nil
+			case aggregate.Func == FuncSum:
+				value = util.DecimalToString(acc.sum)
+			case aggregate.Func == FuncMin:
+				value = util.DecimalToString(acc.min)
+			case aggregate.Func == FuncMax:
+				value = util.DecimalToString(acc.max)
+			case aggregate.Func == FuncAvg:
+				value = util.DecimalToString(acc.sum.Div(decimal.NewFromInt(acc.count)))
+			}
+			rowJson[aggregate.As] = value
+		}
+		result.Rows = append(result.Rows, rowJson)
+	}
+	return result,nil
+}
@@ -0,0 +1,190 @@
+package aggregate
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/database-fabric/db"
+	"github.com/database-fabric/db/database"
+	"github.com/database-fabric/db/storage/state"
+	"github.com/database-fabric/db/util"
+	rowop "github.com/database-fabric/op/row"
+	"github.com/database-fabric/op/table"
+	"github.com/database-fabric/test"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestAggregate(t *testing.T) {
+	var stub = new(test.TestChaincodeStub)
+	databaseImpl := database.NewDatabaseImpl(&db.DataBase{Id:db.DatabaseID(1),Relation:&db.Relation{}}, state.NewStateImpl(stub))
+	customerTable := &db.TableData{Name:"Customer",
+		Columns:[]db.Column{
+			{Id:db.ColumnID(1),ColumnConfig:db.ColumnConfig{Name:"id",Type:db.INT,NotNull:true},Order:1},
+			{Id:db.ColumnID(2),ColumnConfig:db.ColumnConfig{Name:"name",Type:db.VARCHAR},Order:2},
+		},
+		PrimaryKey:db.PrimaryKey{ColumnID:db.ColumnID(1)}}
+	orderTable := &db.TableData{Name:"Order",
+		Columns:[]db.Column{
+			{Id:db.ColumnID(1),ColumnConfig:db.ColumnConfig{Name:"id",Type:db.INT,NotNull:true},Order:1},
+			{Id:db.ColumnID(2),ColumnConfig:db.ColumnConfig{Name:"customer",Type:db.INT},Order:2},
+			{Id:db.ColumnID(3),ColumnConfig:db.ColumnConfig{Name:"region",Type:db.VARCHAR},Order:3},
+			{Id:db.ColumnID(4),ColumnConfig:db.ColumnConfig{Name:"amount",Type:db.DECIMAL},Order:4},
+			{Id:db.ColumnID(5),ColumnConfig:db.ColumnConfig{Name:"qty",Type:db.INT},Order:5},
+			{Id:db.ColumnID(6),ColumnConfig:db.ColumnConfig{Name:"note",Type:db.TEXT},Order:6},
+		},
+		PrimaryKey:db.PrimaryKey{ColumnID:db.ColumnID(1),AutoIncrement:true},
+		ForeignKeys:[]db.ForeignKey{{ColumnID:db.ColumnID(2),Reference:db.ReferenceKey{TableID:db.TableID(1),ColumnID:db.ColumnID(1)}}}}
+	for _,tableData := range []*db.TableData{customerTable, orderTable} {
+		if _,err := databaseImpl.CreateTableData(tableData); err != nil {
+			panic(err.Error())
+		}
+	}
+	operation := rowop.NewRowOperation(databaseImpl)
+	if _,err := operation.Add(customerTable.Name, `[{"id":"1","name":"a"},{"id":"2","name":"b"}]`); err != nil {
+		panic(err.Error())
+	}
+	//订单金额0.1的累加使用decimal精度
+	for i:=1;i<=20;i++ {
+		region := "east"
+		if i%2 == 0 {
+			region = "west"
+		}
+		rowJson := fmt.Sprintf(`[{"customer":"%d","region":"%s","amount":"0.1","qty":"%d"}]`, i%2+1, region, i)
+		if i == 20 {//空金额不参与sum、min、max、avg
+			rowJson = fmt.Sprintf(`[{"customer":"1","region":"%s","qty":"%d"}]`, region, i)
+		}
+		if _,err := operation.Add(orderTable.Name, rowJson); err != nil {
+			panic(err.Error())
+		}
+	}
+	if _,err := operation.Delete(orderTable.Name, []db.RowID{1}); err != nil {
+		panic(err.Error())
+	}
+	orders,err := table.ValidateNullOfData(orderTable.Name, databaseImpl); if err != nil {
+		panic(err.Error())
+	}
+	aggregateOperation := NewAggregateOperation(databaseImpl)
+	aggregates := []Aggregate{{Func:FuncCount},{Func:FuncSum,Column:"amount",As:"total"},{Func:FuncMin,Column:"qty"},{Func:FuncMax,Column:"qty"},{Func:FuncAvg,Column:"amount"},{Func:FuncCount,Column:"amount"}}
+	//分组聚合，按预算分多次扫描，分组按首次出现的顺序(第1行已删除)
+	{
+		config := &AggregateConfig{GroupBy:[]string{"region"},Aggregates:aggregates,Budget:3}
+		var result *Result
+		checkpoint := Checkpoint{}
+		calls := 0
+		for !checkpoint.Done {
+			checkpointBytes,err := util.ConvertJsonBytes(checkpoint); if err != nil {
+				panic(err.Error())
+			}
+			configBytes,err := util.ConvertJsonBytes(*config); if err != nil {
+				panic(err.Error())
+			}
+			resultBytes,err := aggregateOperation.AggregateBytes(orderTable.Name, string(configBytes), string(checkpointBytes)); if err != nil {
+				panic(err.Error())
+			}
+			result = &Result{}
+			if err := json.Unmarshal(resultBytes, result); err != nil {
+				panic(err.Error())
+			}
+			checkpoint = *result.Checkpoint
+			calls++
+			if !checkpoint.Done {
+				assert.Len(t, result.Rows, 0, "aggregate rows before done error")
+			}
+		}
+		assert.Equal(t, 7, calls, "aggregate calls error")
+		assert.EqualValues(t, 20, checkpoint.Scanned, "aggregate scanned error")
+		assert.Len(t, result.Rows, 2, "aggregate groups error")
+		west,east := result.Rows[0],result.Rows[1]
+		assert.Equal(t, "west", west["region"], "aggregate group order error")
+		assert.EqualValues(t, 9, east["count()"], "aggregate count error")
+		assert.Equal(t, "0.9", east["total"], "aggregate sum error")
+		assert.Equal(t, "3", east["min(qty)"], "aggregate min error")
+		assert.Equal(t, "19", east["max(qty)"], "aggregate max error")
+		assert.Equal(t, "0.1", east["avg(amount)"], "aggregate avg error")
+		assert.EqualValues(t, 10, west["count()"], "aggregate west count error")
+		assert.EqualValues(t, 9, west["count(amount)"], "aggregate null count error")
+		assert.Equal(t, "0.9", west["total"], "aggregate west sum error")
+		assert.Equal(t, "20", west["max(qty)"], "aggregate west max error")
+	}
+	//外键索引与过滤，没有分组列时返回一行
+	{
+		config := &AggregateConfig{ForeignKey:"customer",Reference:1,Where:db.JsonData{"region":"west"},Aggregates:aggregates}
+		result,err := aggregateOperation.Aggregate(orders, config, Checkpoint{}); if err != nil {
+			panic(err.Error())
+		}
+		assert.True(t, result.Checkpoint.Done, "aggregate foreign key done error")
+		assert.Len(t, result.Rows, 1, "aggregate foreign key rows error")
+		assert.EqualValues(t, 10, result.Rows[0]["count()"], "aggregate foreign key count error")
+		assert.EqualValues(t, "0.9", result.Rows[0]["total"], "aggregate foreign key sum error")
+		config = &AggregateConfig{Start:10,End:12,Where:db.JsonData{"region":"east"},Aggregates:aggregates}
+		result,err = aggregateOperation.Aggregate(orders, config, Checkpoint{}); if err != nil {
+			panic(err.Error())
+		}
+		assert.EqualValues(t, 1, result.Rows[0]["count()"], "aggregate range count error")
+		config = &AggregateConfig{ForeignKey:"customer",Reference:3,Aggregates:aggregates}
+		result,err = aggregateOperation.Aggregate(orders, config, Checkpoint{}); if err != nil {
+			panic(err.Error())
+		}
+		assert.EqualValues(t, 0, result.Rows[0]["count()"], "aggregate empty count error")
+		assert.Nil(t, result.Rows[0]["total"], "aggregate empty sum error")
+	}
+	//外键索引按游标分多次扫描，继续扫描时配置必须一致
+	{
+		config := &AggregateConfig{ForeignKey:"customer",Reference:1,Where:db.JsonData{"region":"west"},Aggregates:aggregates,Budget:3}
+		checkpoint := Checkpoint{}
+		var result *Result
+		calls := 0
+		for !checkpoint.Done {
+			var err error
+			result,err = aggregateOperation.Aggregate(orders, config, checkpoint); if err != nil {
+				panic(err.Error())
+			}
+			checkpoint = *result.Checkpoint
+			calls++
+			if calls == 1 {
+				changed := &AggregateConfig{ForeignKey:"customer",Reference:1,Where:db.JsonData{"region":"east"},Aggregates:aggregates,Budget:3}
+				_,err = aggregateOperation.Aggregate(orders, changed, checkpoint)
+				assert.NotNil(t, err, "aggregate checkpoint config error")
+			}
+		}
+		assert.True(t, calls > 1, "aggregate foreign key calls error")
+		assert.EqualValues(t, 10, result.Rows[0]["count()"], "aggregate foreign key cursor count error")
+		assert.EqualValues(t, "0.9", result.Rows[0]["total"], "aggregate foreign key cursor sum error")
+	}
+	//删除后重新新增、修改外键值的行按当前外键值聚合，每行只统计一次
+	{
+		if _,err := operation.Delete(orderTable.Name, []db.RowID{2}); err != nil {
+			panic(err.Error())
+		}
+		if _,err := operation.Upsert(orderTable.Name, `[{"id":"2","customer":"1","region":"west","amount":"0.1","qty":"2"}]`); err != nil {
+			panic(err.Error())
+		}
+		if _,err := operation.Update(orderTable.Name, `[{"id":"3","customer":"1"}]`); err != nil {
+			panic(err.Error())
+		}
+		config := &AggregateConfig{ForeignKey:"customer",Reference:1,Aggregates:[]Aggregate{{Func:FuncCount},{Func:FuncSum,Column:"qty"}}}
+		result,err := aggregateOperation.Aggregate(orders, config, Checkpoint{}); if err != nil {
+			panic(err.Error())
+		}
+		assert.EqualValues(t, 11, result.Rows[0]["count()"], "aggregate re-added count error")
+		assert.EqualValues(t, "113", result.Rows[0]["sum(qty)"], "aggregate re-added sum error")
+		config = &AggregateConfig{ForeignKey:"customer",Reference:2,Aggregates:[]Aggregate{{Func:FuncCount}}}
+		result,err = aggregateOperation.Aggregate(orders, config, Checkpoint{}); if err != nil {
+			panic(err.Error())
+		}
+		assert.EqualValues(t, 8, result.Rows[0]["count()"], "aggregate updated count error")
+	}
+	//配置验证
+	{
+		_,err := aggregateOperation.Aggregate(orders, &AggregateConfig{Aggregates:aggregates,Budget:MaxBudget+1}, Checkpoint{})
+		assert.NotNil(t, err, "aggregate budget error")
+		_,err = aggregateOperation.Aggregate(orders, &AggregateConfig{Aggregates:[]Aggregate{{Func:FuncSum,Column:"region"}}}, Checkpoint{})
+		assert.NotNil(t, err, "aggregate column type error")
+		_,err = aggregateOperation.Aggregate(orders, &AggregateConfig{GroupBy:[]string{"note"},Aggregates:aggregates}, Checkpoint{})
+		assert.NotNil(t, err, "aggregate blob column error")
+		_,err = aggregateOperation.Aggregate(orders, &AggregateConfig{Aggregates:[]Aggregate{{Func:"median",Column:"qty"}}}, Checkpoint{})
+		assert.NotNil(t, err, "aggregate func error")
+		_,err = aggregateOperation.Aggregate(orders, &AggregateConfig{GroupBy:[]string{"region","customer"},Aggregates:aggregates}, Checkpoint{Groups:[]*Group{{Keys:[][]byte{[]byte("east")}}}})
+		assert.NotNil(t, err, "aggregate checkpoint error")
+	}
+}
@@ -0,0 +1,505 @@
+package dump
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/database-fabric/db"
+	"github.com/database-fabric/db/database"
+	"github.com/database-fabric/db/util"
+	rowop "github.com/database-fabric/op/row"
+	"github.com/database-fabric/op/table"
+	"github.com/database-fabric/protos/db/row"
+	"io"
+	"math"
+)
+
+const (
+	DumpVersion = 1 //导出格式版本
+	DefaultPageSize = int32(100) //每次读取行数
+	DefaultRestoreBatch = 100 //恢复时每次写入行数
+	maxLineSize = 64*1024*1024 //单行记录上限，包含大字段
+)
+
+//导出记录类型
+const (
+	KindHeader = "header"
+	KindTable = "table"
+	KindRow = "row"
+	KindRelation = "relation"
+)
+
+type DumpOperation struct {
+	iDatabase db.DatabaseInterface
+}
+
+func NewDumpOperation(iDatabase db.DatabaseInterface) *DumpOperation {
+	return &DumpOperation{iDatabase}
+}
+
+/**
+	导出配置，Tables为空导出全部表，外键引用的表自动导出
+ */
+type DumpConfig struct {
+	Tables []string `json:"tables"`
+	History bool `json:"history"` //导出行的全部版本
+	PageSize int32 `json:"pageSize"` //每次读取行数，为空使用默认值
+}
+
+/**
+	导出文件为NDJSON，每行一条记录：
+	header(格式版本与表顺序)、table(表结构，后面跟该表的row)、relation(数据库关系，最后一行)
+	表按外键依赖顺序导出，被引用的表在前
+ */
+type Record struct {
+	Kind string `json:"kind"`
+	Header *Header `json:"header,omitempty"`
+	Table *table.Data `json:"table,omitempty"`
+	TableName string `json:"tableName,omitempty"`
+	Row db.JsonData `json:"row,omitempty"`
+	Op *db.OpType `json:"op,omitempty"` //历史版本操作类型
+	TxID string `json:"tx,omitempty"`
+	Time int64 `json:"time,omitempty"`
+	Relations []Relation `json:"relations,omitempty"`
+}
+
+type Header struct {
+	Version int `json:"version"`
+	History bool `json:"history"`
+	Tables []string `json:"tables"`
+}
+
+//关系键使用表名与列名，恢复时转换为新数据库中的ID
+type Relation struct {
+	Table string `json:"table"`
+	Column string `json:"column"`
+	Reference string `json:"reference"`
+	ReferenceColumn string `json:"referenceColumn"`
+}
+
+type RestoreResult struct {
+	Tables []string `json:"tables"`
+	Rows int64 `json:"rows"` //写入的行记录数(历史导出为版本数)
+	Relations int `json:"relations"`
+}
+
+////////////////// Public Function //////////////////
+func (operation *DumpOperation) DumpBytes(configJson string) ([]byte,error) {
+	var config DumpConfig
+	if configJson != "" {
+		if err := json.Unmarshal([]byte(configJson), &config); err != nil {
+			return nil,fmt.Errorf("dump config json %s", err)
+		}
+	}
+	var buffer bytes.Buffer
+	if err := operation.Dump(&buffer, config); err != nil {
+		return nil,err
+	}
+	return buffer.Bytes(),nil
+}
+
+/**
+	按主键顺序导出表结构、行数据和数据库关系
+ */
+func (operation *DumpOperation) Dump(writer io.Writer, config DumpConfig) error {
+	tables,err := operation.sortTables(config.Tables); if err != nil {
+		return err
+	}
+	pageSize := config.PageSize
+	if pageSize <= 0 {
+		pageSize = DefaultPageSize
+	}
+	encoder := json.NewEncoder(writer)
+	names := make([]string, 0, len(tables))
+	for _,t := range tables {
+		names = append(names, t.Data.Name)
+	}
+	if err := encoder.Encode(Record{Kind:KindHeader,Header:&Header{Version:DumpVersion,History:config.History,Tables:names}}); err != nil {
+		return err
+	}
+	tableOperation := table.NewTableOperation(operation.iDatabase)
+	for _,t := range tables {
+		data,err := tableOperation.ParseTableData(t); if err != nil {
+			return err
+		}
+		if err := encoder.Encode(Record{Kind:KindTable,Table:&data}); err != nil {
+			return err
+		}
+		if err := operation.dumpRows(encoder, t, pageSize, config.History); err != nil {
+			return fmt.Errorf("dump table `%s` %s", t.Data.Name, err)
+		}
+	}
+	relations,err := operation.dumpRelations(tables); if err != nil {
+		return err
+	}
+	return encoder.Encode(Record{Kind:KindRelation,Relations:relations})
+}
+
+func (operation *DumpOperation) RestoreBytes(data []byte) ([]byte,error) {
+	result,err := operation.Restore(bytes.NewReader(data)); if err != nil {
+		return nil,err
+	}
+	return util.ConvertJsonBytes(*result)
+}
+
+/**
+	按导出顺序创建表、写入行和关系，目标数据库中不能存在同名表
+	历史导出按版本顺序重放，读取需要能看到本次写入的状态(如离线LevelDB状态)
+ */
+func (operation *DumpOperation) Restore(reader io.Reader) (*RestoreResult,error) {
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxLineSize)
+	restore := &restorer{
+		iDatabase:operation.iDatabase,
+		tableOperation:table.NewTableOperation(operation.iDatabase),
+		rowOperation:rowop.NewRowOperation(operation.iDatabase),
+		result:&RestoreResult{Tables:make([]string, 0)},
+	}
+	var header *Header
+	line := 0
+	for scanner.Scan() {
+		line++
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var record Record
+		decoder := json.NewDecoder(bytes.NewReader(scanner.Bytes()))
+		decoder.UseNumber()//保留int64精度
+		if err := decoder.Decode(&record); err != nil {
+			return nil,fmt.Errorf("dump line %d %s", line, err)
+		}
+		if header == nil {
+			if record.Kind != KindHeader || record.Header == nil {
+				return nil,fmt.Errorf("dump header is null")
+			}
+			if record.Header.Version > DumpVersion {
+				return nil,fmt.Errorf("dump version %d is not supported", record.Header.Version)
+			}
+			header = record.Header
+			continue
+		}
+		if err := restore.apply(&record); err != nil {
+			return nil,fmt.Errorf("dump line %d %s", line, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil,err
+	}
+	if header == nil {
+		return nil,fmt.Errorf("dump header is null")
+	}
+	if err := restore.flush(); err != nil {
+		return nil,err
+	}
+	return restore.result,nil
+}
+
+////////////////// Private Function //////////////////
+
+/**
+	按外键依赖排序(被引用的表在前)，同层按表ID顺序，忽略自引用
+ */
+func (operation *DumpOperation) sortTables(tableNames []string) ([]*db.Table,error) {
+	if len(tableNames) == 0 {
+		allTables,err := operation.iDatabase.GetAllTable(); if err != nil {
+			return nil,err
+		}
+		for _,name := range allTables {
+			if name != "" {
+				tableNames = append(tableNames, name)
+			}
+		}
+	}
+	tables := make([]*db.Table, 0, len(tableNames))
+	states := make(map[db.TableID]int)//1:排序中 2:已排序
+	var visit func(name string) error
+	visit = func(name string) error {
+		t,err := table.ValidateNullOfData(name, operation.iDatabase); if err != nil {
+			return err
+		}
+		switch states[t.Data.Id] {
+		case 1:
+			return fmt.Errorf("table `%s` foreign key cycle", name)
+		case 2:
+			return nil
+		}
+		states[t.Data.Id] = 1
+		for _,foreignKey := range t.Data.ForeignKeys {
+			if foreignKey.Reference.TableID == t.Data.Id {
+				continue
+			}
+			reference,err := operation.iDatabase.GetTableName(foreignKey.Reference.TableID); if err != nil {
+				return err
+			}
+			if err := visit(reference); err != nil {
+				return err
+			}
+		}
+		states[t.Data.Id] = 2
+		tables = append(tables, t)
+		return nil
+	}
+	for _,name := range tableNames {
+		if err := visit(name); err != nil {
+			return nil,err
+		}
+	}
+	return tables,nil
+}
+
+func (operation *DumpOperation) dumpRows(encoder *json.Encoder, t *db.Table, pageSize int32, history bool) error {
+	tally,err := operation.iDatabase.GetTableTally(t.Data.Id); if err != nil {
+		return err
+	}
+	if tally.AddRow == 0 {//空表
+		return nil
+	}
+	start := db.RowID(0)
+	for {
+		rows,err := operation.iDatabase.QueryRowDataByRange(t.Data, start, 0, db.ASC, pageSize); if err != nil {
+			return err
+		}
+		for _,rowData := range rows {
+			if rowData == nil || rowData.Id == 0 {
+				continue
+			}
+			if history {
+				if err := operation.dumpRowHistory(encoder, t, rowData.Id); err != nil {
+					return err
+				}
+			}else if rowData.Op != uint32(db.DELETE) {
+				rowJson,err := operation.parseRow(t, rowData); if err != nil {
+					return err
+				}
+				if err := encoder.Encode(Record{Kind:KindRow,TableName:t.Data.Name,Row:rowJson}); err != nil {
+					return err
+				}
+			}
+		}
+		if int32(len(rows)) < pageSize {
+			return nil
+		}
+		start = rows[len(rows)-1].Id+1
+	}
+}
+
+func (operation *DumpOperation) dumpRowHistory(encoder *json.Encoder, t *db.Table, rowID db.RowID) error {
+	versions,_,err := operation.iDatabase.QueryRowDataHistoryByRange(t.Data, rowID, db.ASC, math.MaxInt32); if err != nil {
+		return err
+	}
+	for _,version := range versions {
+		if version == nil || version.Row == nil {
+			continue
+		}
+		op := db.OpType(version.Row.Op)
+		rowJson := db.JsonData{t.Primary.Name:rowID}
+		if op != db.DELETE {
+			rowJson,err = operation.parseRow(t, version.Row); if err != nil {
+				return err
+			}
+		}
+		record := Record{Kind:KindRow,TableName:t.Data.Name,Row:rowJson,Op:&op,TxID:version.TxID,Time:version.Time}
+		if err := encoder.Encode(record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/**
+	行数据转换为json，空值列不导出(恢复时使用默认值规则)，大字段导出原始数据
+ */
+func (operation *DumpOperation) parseRow(t *db.Table, rowData *row.RowData) (db.JsonData,error) {
+	rowJson := db.JsonData{t.Primary.Name:rowData.Id}
+	for i,column := range t.Data.Columns {
+		if column.IsDeleted || column.Id == t.Data.PrimaryKey.ColumnID || i >= len(rowData.Columns) {
+			continue
+		}
+		data := rowData.Columns[i].Data
+		if len(data) == 0 {
+			continue
+		}
+		var value interface{}
+		var err error
+		if db.IsBlobType(column.Type) {
+			value,err = util.ParseBlobColumnData(column, data, operation.iDatabase.GetBlobData)
+		}else{
+			value,err = util.ParseColumnData(column, data)
+		}
+		if err != nil {
+			return nil,err
+		}
+		if column.Type == db.INT || column.Type == db.DECIMAL {//数字导出为字符串，保留精度
+			value = fmt.Sprint(value)
+		}
+		rowJson[column.Name] = value
+	}
+	return rowJson,nil
+}
+
+/**
+	导出两端表都已导出的关系
+ */
+func (operation *DumpOperation) dumpRelations(tables []*db.Table) ([]Relation,error) {
+	relation,err := operation.iDatabase.GetRelation(); if err != nil {
+		return nil,err
+	}
+	dumped := make(map[db.TableID]bool, len(tables))
+	for _,t := range tables {
+		dumped[t.Data.Id] = true
+	}
+	relations := make([]Relation, 0, len(relation.Keys))
+	for _,key := range relation.Keys {
+		if key.IsDeleted || !dumped[key.TableID] || !dumped[key.ForeignKey.Reference.TableID] {
+			continue
+		}
+		foreign,err := operation.queryTableByID(key.TableID); if err != nil {
+			return nil,err
+		}
+		reference,err := operation.queryTableByID(key.ForeignKey.Reference.TableID); if err != nil {
+			return nil,err
+		}
+		relations = append(relations, Relation{
+			Table:foreign.Name,
+			Column:foreign.Columns[key.ForeignKey.ColumnID-1].Name,
+			Reference:reference.Name,
+			ReferenceColumn:reference.Columns[key.ForeignKey.Reference.ColumnID-1].Name,
+		})
+	}
+	return relations,nil
+}
+
+func (operation *DumpOperation) queryTableByID(tableID db.TableID) (*db.TableData,error) {
+	name,err := operation.iDatabase.GetTableName(tableID); if err != nil {
+		return nil,err
+	}
+	if name == "" {
+		return nil,fmt.Errorf("relation table `%d` not exists", tableID)
+	}
+	t,err := table.ValidateNullOfData(name, operation.iDatabase); if err != nil {
+		return nil,err
+	}
+	return t.Data,nil
+}
+
+/**
+	恢复状态，连续的新增行按批写入
+ */
+type restorer struct {
+	iDatabase db.DatabaseInterface
+	tableOperation *table.TableOperation
+	rowOperation *rowop.RowOperation
+	result *RestoreResult
+	table *db.Table
+	rows []db.JsonData
+}
+
+func (restore *restorer) apply(record *Record) error {
+	switch record.Kind {
+	case KindTable:
+		if record.Table == nil {
+			return fmt.Errorf("table is null")
+		}
+		if err := restore.flush(); err != nil {
+			return err
+		}
+		tableJson,err := util.ConvertJsonBytes(*record.Table); if err != nil {
+			return err
+		}
+		if _,err := restore.tableOperation.Create(string(tableJson)); err != nil {
+			return err
+		}
+		restore.table,err = table.ValidateNullOfData(record.Table.Name, restore.iDatabase); if err != nil {
+			return err
+		}
+		restore.result.Tables = append(restore.result.Tables, record.Table.Name)
+	case KindRow:
+		if restore.table == nil || restore.table.Data.Name != record.TableName {
+			return fmt.Errorf("row table `%s` is not restored", record.TableName)
+		}
+		rowJson := make(db.JsonData, len(record.Row))
+		for name,value := range record.Row {
+			if number,ok := value.(json.Number); ok {
+				value = number.String()
+			}
+			rowJson[name] = value
+		}
+		op := db.ADD
+		if record.Op != nil {
+			op = *record.Op
+		}
+		if op == db.ADD {
+			restore.rows = append(restore.rows, rowJson)
+			if len(restore.rows) >= DefaultRestoreBatch {
+				return restore.flush()
+			}
+			return nil
+		}
+		if err := restore.flush(); err != nil {
+			return err
+		}
+		if _,err := restore.rowOperation.SetRow(restore.table, []db.JsonData{rowJson}, op); err != nil {
+			return err
+		}
+		restore.result.Rows++
+	case KindRelation:
+		if err := restore.flush(); err != nil {
+			return err
+		}
+		return restore.restoreRelations(record.Relations)
+	default:
+		return fmt.Errorf("record kind `%s` is not supported", record.Kind)
+	}
+	return nil
+}
+
+func (restore *restorer) flush() error {
+	if len(restore.rows) == 0 {
+		return nil
+	}
+	if _,err := restore.rowOperation.SetRow(restore.table, restore.rows, db.ADD); err != nil {
+		return err
+	}
+	restore.result.Rows += int64(len(restore.rows))
+	restore.rows = nil
+	return nil
+}
+
+func (restore *restorer) restoreRelations(relations []Relation) error {
+	if len(relations) == 0 {
+		return nil
+	}
+	relation,err := restore.iDatabase.GetRelation(); if err != nil {
+		return err
+	}
+	for _,r := range relations {
+		foreign,err := table.ValidateNullOfData(r.Table, restore.iDatabase); if err != nil {
+			return err
+		}
+		reference,err := table.ValidateNullOfData(r.Reference, restore.iDatabase); if err != nil {
+			return err
+		}
+		column,err := findColumn(foreign.Data, r.Column); if err != nil {
+			return err
+		}
+		referenceColumn,err := findColumn(reference.Data, r.ReferenceColumn); if err != nil {
+			return err
+		}
+		key := db.RelationKey{TableID:foreign.Data.Id,ForeignKey:db.ForeignKey{ColumnID:column.Id,Reference:db.ReferenceKey{TableID:reference.Data.Id,ColumnID:referenceColumn.Id}}}
+		if err := database.AddRelationKey(key, relation); err != nil {
+			return err
+		}
+	}
+	restore.result.Relations = len(relations)
+	return restore.iDatabase.PutRelation(relation)
+}
+
+func findColumn(tableData *db.TableData, name string) (*db.Column,error) {
+	for i := range tableData.Columns {
+		if tableData.Columns[i].Name == name && !tableData.Columns[i].IsDeleted {
+			return &tableData.Columns[i],nil
+		}
+	}
+	return nil,fmt.Errorf("column `%s` not found in table `%s`", name, tableData.Name)
+}
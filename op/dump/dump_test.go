@@ -0,0 +1,128 @@
+package dump
+
+import (
+	"bytes"
+	"github.com/database-fabric/db"
+	"github.com/database-fabric/db/database"
+	"github.com/database-fabric/db/storage/state"
+	rowop "github.com/database-fabric/op/row"
+	"github.com/database-fabric/op/table"
+	"github.com/database-fabric/test"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func newDatabase() *database.DatabaseImpl {
+	var stub = new(test.TestChaincodeStub)
+	return database.NewDatabaseImpl(&db.DataBase{Id:db.DatabaseID(1),Relation:&db.Relation{}}, state.NewStateImpl(stub))
+}
+
+func TestDump(t *testing.T) {
+	source := newDatabase()
+	//子表先创建，导出时父表在前
+	childTable := &db.TableData{Name:"Child",
+		Columns:[]db.Column{
+			{Id:db.ColumnID(1),ColumnConfig:db.ColumnConfig{Name:"id",Type:db.INT,NotNull:true},Order:1},
+			{Id:db.ColumnID(2),ColumnConfig:db.ColumnConfig{Name:"parent",Type:db.INT},Order:2},
+			{Id:db.ColumnID(3),ColumnConfig:db.ColumnConfig{Name:"note",Type:db.TEXT},Order:3},
+		},
+		PrimaryKey:db.PrimaryKey{ColumnID:db.ColumnID(1),AutoIncrement:true},
+		ForeignKeys:[]db.ForeignKey{{ColumnID:db.ColumnID(2),Reference:db.ReferenceKey{TableID:db.TableID(2),ColumnID:db.ColumnID(1)}}}}
+	parentTable := &db.TableData{Name:"Parent",
+		Columns:[]db.Column{
+			{Id:db.ColumnID(1),ColumnConfig:db.ColumnConfig{Name:"id",Type:db.INT,NotNull:true},Order:1},
+			{Id:db.ColumnID(2),ColumnConfig:db.ColumnConfig{Name:"name",Type:db.VARCHAR},Order:2},
+			{Id:db.ColumnID(3),ColumnConfig:db.ColumnConfig{Name:"amount",Type:db.INT},Order:3},
+		},
+		PrimaryKey:db.PrimaryKey{ColumnID:db.ColumnID(1)}}
+	for _,tableData := range []*db.TableData{childTable, parentTable} {
+		if _,err := source.CreateTableData(tableData); err != nil {
+			panic(err.Error())
+		}
+	}
+	relation := &db.Relation{}
+	if err := database.AddRelationKey(db.RelationKey{TableID:childTable.Id,ForeignKey:childTable.ForeignKeys[0]}, relation); err != nil {
+		panic(err.Error())
+	}
+	if err := source.PutRelation(relation); err != nil {
+		panic(err.Error())
+	}
+	operation := rowop.NewRowOperation(source)
+	if _,err := operation.Add(parentTable.Name, `[{"id":"1","name":"a","amount":"9007199254740993"},{"id":"2","name":"b"},{"id":"3","name":"c"}]`); err != nil {
+		panic(err.Error())
+	}
+	if _,err := operation.Add(childTable.Name, `[{"parent":"1","note":"x"},{"parent":"2","note":"y"}]`); err != nil {
+		panic(err.Error())
+	}
+	if _,err := operation.Update(parentTable.Name, `[{"id":"2","name":"b2"}]`); err != nil {
+		panic(err.Error())
+	}
+	if _,err := operation.Delete(parentTable.Name, []db.RowID{3}); err != nil {
+		panic(err.Error())
+	}
+	queryRow := func(iDatabase db.DatabaseInterface, tableName string, rowID db.RowID) db.JsonData {
+		t,err := table.ValidateNullOfData(tableName, iDatabase); if err != nil {
+			panic(err.Error())
+		}
+		rowJson,err := rowop.NewRowOperation(iDatabase).QueryRow(t, rowID); if err != nil {
+			panic(err.Error())
+		}
+		return rowJson
+	}
+	//当前数据导出与恢复
+	{
+		dumpBytes,err := NewDumpOperation(source).DumpBytes(""); if err != nil {
+			panic(err.Error())
+		}
+		target := newDatabase()
+		result,err := NewDumpOperation(target).RestoreBytes(dumpBytes); if err != nil {
+			panic(err.Error())
+		}
+		assert.Contains(t, string(result), `"tables":["Parent","Child"]`, "restore table order error")
+		assert.Contains(t, string(result), `"rows":4`, "restore rows error")
+		assert.EqualValues(t, 9007199254740993, queryRow(target, parentTable.Name, 1)["amount"], "restore int64 error")
+		assert.Equal(t, "b2", queryRow(target, parentTable.Name, 2)["name"], "restore update error")
+		assert.Nil(t, queryRow(target, parentTable.Name, 3), "restore deleted row error")
+		assert.Equal(t, "y", queryRow(target, childTable.Name, 2)["note"], "restore text error")
+		//自增计数恢复
+		if _,err := rowop.NewRowOperation(target).Add(childTable.Name, `[{"parent":"1","note":"z"}]`); err != nil {
+			panic(err.Error())
+		}
+		assert.Equal(t, "z", queryRow(target, childTable.Name, 3)["note"], "restore increment error")
+		//关系
+		targetRelation,err := target.GetRelation(); if err != nil {
+			panic(err.Error())
+		}
+		assert.Equal(t, 1, len(targetRelation.Keys), "restore relation error")
+		parentID,err := target.GetTableID(parentTable.Name); if err != nil {
+			panic(err.Error())
+		}
+		assert.Equal(t, parentID, targetRelation.Keys[0].ForeignKey.Reference.TableID, "restore relation table error")
+		//重复恢复表已存在
+		_,err = NewDumpOperation(target).RestoreBytes(dumpBytes)
+		assert.NotNil(t, err, "restore exists table error")
+	}
+	//历史版本导出与恢复
+	{
+		dumpBytes,err := NewDumpOperation(source).DumpBytes(`{"tables":["Child"],"history":true,"pageSize":1}`); if err != nil {
+			panic(err.Error())
+		}
+		target := newDatabase()
+		if _,err := NewDumpOperation(target).Restore(bytes.NewReader(dumpBytes)); err != nil {
+			panic(err.Error())
+		}
+		assert.Equal(t, "b2", queryRow(target, parentTable.Name, 2)["name"], "restore history update error")
+		parentData,err := target.QueryTableDataByName(parentTable.Name); if err != nil {
+			panic(err.Error())
+		}
+		version,err := target.QueryRowVersion(parentData, db.RowID(2)); if err != nil {
+			panic(err.Error())
+		}
+		assert.EqualValues(t, 2, version.Version, "restore history update version error")
+		versions,total,err := target.QueryRowDataHistoryByRange(parentData, db.RowID(3), db.ASC, 10); if err != nil {
+			panic(err.Error())
+		}
+		assert.EqualValues(t, 2, total, "restore history versions error")
+		assert.EqualValues(t, db.DELETE, versions[1].Row.Op, "restore history op error")
+	}
+}
@@ -15,11 +15,14 @@ func NewHistoryOperation(iDatabase db.DatabaseInterface) *HistoryOperation {
 }
 
 ////////////////// Public Function //////////////////
-func (operation *HistoryOperation) QueryRowHistoryWithPaginationBytes(tableName string, rowID db.RowID, order db.OrderType, pageSize int32) ([]byte,error) {
+/**
+	分页查询行历史版本，cursor为上一页返回的游标，为空时从第一个(升序)或最新(降序)版本开始
+ */
+func (operation *HistoryOperation) QueryRowHistoryWithPaginationBytes(tableName string, rowID db.RowID, order db.OrderType, pageSize int32, cursor string) ([]byte,error) {
 	table,err := table.ValidateNullOfData(tableName, operation.iDatabase); if err != nil {
 		return nil,err
 	}
-	pagination,err := operation.QueryRowHistoryWithPagination(table, rowID, order, pageSize); if err != nil {
+	pagination,err := operation.QueryRowHistoryWithPagination(table, rowID, order, pageSize, cursor); if err != nil {
 		return nil,err
 	}
 	paginationBytes,err := util.ConvertJsonBytes(pagination); if err != nil {
@@ -28,9 +31,25 @@ func (operation *HistoryOperation) QueryRowHistoryWithPaginationBytes(tableName
 	return paginationBytes,nil
 }
 
-func (operation *HistoryOperation) QueryRowHistoryWithPagination(table *db.Table, rowID db.RowID, order db.OrderType, pageSize int32) (db.Pagination,error) {
+/**
+	行版本证明，version从1开始，0为最新版本，客户端使用proof.VerifyRowProof离线验证
+ */
+func (operation *HistoryOperation) QueryRowProofBytes(tableName string, rowID db.RowID, version int64) ([]byte,error) {
+	table,err := table.ValidateNullOfData(tableName, operation.iDatabase); if err != nil {
+		return nil,err
+	}
+	rowProof,err := operation.iDatabase.QueryRowProof(table.Data, rowID, version); if err != nil {
+		return nil,err
+	}
+	return util.ConvertJsonBytes(*rowProof)
+}
+
+func (operation *HistoryOperation) QueryRowHistoryWithPagination(table *db.Table, rowID db.RowID, order db.OrderType, pageSize int32, cursor string) (db.Pagination,error) {
 	pagination := db.Pagination{}
-	rows,total,err := operation.iDatabase.QueryRowDataHistoryByRange(table.Data, rowID, order, pageSize); if err != nil {
+	pageCursor,err := util.DecodeCursor(cursor); if err != nil {
+		return pagination,err
+	}
+	rows,total,next,err := operation.iDatabase.QueryRowDataHistoryByCursor(table.Data, rowID, pageCursor, order, pageSize); if err != nil {
 		return pagination,err
 	}
 	list := make([]db.JsonData, 0, len(rows))
@@ -43,7 +62,7 @@ func (operation *HistoryOperation) QueryRowHistoryWithPagination(table *db.Table
 		if rowData != nil && rowData.Id > 0 {
 			rowJson := db.JsonData{}
 			if len(rowData.Columns) > 0 {
-				rowJson,err = util.ParseRowData(table, rowData); if err != nil {
+				rowJson,err = util.ParseRowDataWithColumns(table, rowData, nil, operation.iDatabase.GetBlobData); if err != nil {
 					return pagination,err
 				}
 			}else{
@@ -53,5 +72,7 @@ func (operation *HistoryOperation) QueryRowHistoryWithPagination(table *db.Table
 		}
 		list = append(list, historyJson)
 	}
-	return util.Pagination(pageSize, total, list),nil
+	pagination = util.Pagination(pageSize, total, list)
+	pagination.Cursor = util.EncodeCursor(next)
+	return pagination,nil
 }
\ No newline at end of file
@@ -0,0 +1,35 @@
+package other
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/database-fabric/db"
+	"github.com/database-fabric/db/storage"
+	"github.com/database-fabric/db/storage/state"
+	"github.com/database-fabric/db/util"
+)
+
+type LayoutService struct {
+	*storage.LayoutStorage
+}
+
+func NewLayoutService(state state.ChainCodeState) *LayoutService {
+	return &LayoutService{storage.NewLayoutStorage(state)}
+}
+
+/**
+	旧布局Key迁移为保序编码，checkpointJson为上一批返回的位置，为空时开始迁移
+	每次执行一批，返回位置的done为false时在新的事务中继续，迁移完成前CheckKeyLayout返回错误
+ */
+func (service *LayoutService) MigrateLayout(checkpointJson string) ([]byte,error) {
+	var checkpoint db.KeyMigrateCheckpoint
+	if checkpointJson != "" {
+		if err := json.Unmarshal([]byte(checkpointJson), &checkpoint); err != nil {
+			return nil,fmt.Errorf("migrate checkpoint json %s", err)
+		}
+	}
+	result,err := service.MigrateKeyLayout(checkpoint,0); if err != nil {
+		return nil,err
+	}
+	return util.ConvertJsonBytes(*result)
+}
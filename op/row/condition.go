@@ -0,0 +1,148 @@
+package row
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/database-fabric/db"
+	"github.com/database-fabric/db/util"
+	"github.com/database-fabric/op/table"
+)
+
+/**
+	条件写入(乐观并发控制)，行当前版本或列值与期望值一致时才写入
+	BlockID为行最新版本块ID，Version为行版本数(新增、修改、删除各为一个版本)，可以通过QueryRowVersion获取
+ */
+type Condition struct {
+	BlockID db.BlockID `json:"blockID"` //期望的最新版本块ID，为0时不验证
+	Version db.Total `json:"version"` //期望的版本数，为0时不验证
+	Columns db.JsonData `json:"columns"` //期望的列值
+}
+
+type ConditionalRow struct {
+	Row db.JsonData `json:"row"`
+	Condition Condition `json:"condition"`
+}
+
+//条件不满足错误
+type PreconditionError struct {
+	Table string
+	RowID db.RowID
+	Reason string
+}
+
+func (err *PreconditionError) Error() string {
+	return fmt.Sprintf("row `%d` precondition failed in table `%s`: %s", err.RowID, err.Table, err.Reason)
+}
+
+func IsPreconditionError(err error) bool {
+	_,ok := err.(*PreconditionError)
+	return ok
+}
+
+////////////////// Public Function //////////////////
+func (operation *RowOperation) Upsert(tableName string, jsonString string) ([]db.RowID,error) {
+	return operation.AddOrUpdate(tableName, jsonString, db.UPSERT)
+}
+
+/**
+	条件修改，json为ConditionalRow数组
+ */
+func (operation *RowOperation) UpdateIf(tableName string, jsonString string) ([]db.RowID,error) {
+	return operation.setConditional(tableName, jsonString, db.UPDATE)
+}
+
+/**
+	条件删除，json为ConditionalRow数组，row只需要主键
+ */
+func (operation *RowOperation) DeleteIf(tableName string, jsonString string) ([]db.RowID,error) {
+	return operation.setConditional(tableName, jsonString, db.DELETE)
+}
+
+/**
+	行当前版本，用于条件写入
+ */
+func (operation *RowOperation) QueryRowVersionBytes(tableName string, rowID db.RowID) ([]byte,error) {
+	table,err := table.ValidateNullOfData(tableName, operation.iDatabase); if err != nil {
+		return nil,err
+	}
+	version,err := operation.iDatabase.QueryRowVersion(table.Data, rowID); if err != nil {
+		return nil,err
+	}
+	return util.ConvertJsonBytes(*version)
+}
+
+////////////////// Private Function //////////////////
+func (operation *RowOperation) setConditional(tableName string, jsonString string, op db.OpType) ([]db.RowID,error) {
+	if jsonString == "" {
+		return nil,fmt.Errorf("row json is null")
+	}
+	var conditionalRows []ConditionalRow
+	if err := json.Unmarshal([]byte(jsonString), &conditionalRows); err != nil {
+		return nil,fmt.Errorf("row json  %s", err)
+	}
+	table,err := table.ValidateNullOfData(tableName, operation.iDatabase); if err != nil {
+		return nil,err
+	}
+	rowJsonArray := make([]db.JsonData, 0, len(conditionalRows))
+	conditions := make([]*Condition, 0, len(conditionalRows))
+	for i := range conditionalRows {
+		rowJsonArray = append(rowJsonArray, conditionalRows[i].Row)
+		conditions = append(conditions, &conditionalRows[i].Condition)
+	}
+	return operation.setConditionalRow(table, rowJsonArray, op, conditions)
+}
+
+/**
+	验证条件，行不存在或已删除时条件不满足
+ */
+func (operation *RowOperation) checkCondition(table *db.Table, rowID db.RowID, condition *Condition) error {
+	version,err := operation.iDatabase.QueryRowVersion(table.Data, rowID); if err != nil {
+		return err
+	}
+	if version.BlockID == 0 || version.Op == db.DELETE {
+		return &PreconditionError{table.Data.Name,rowID,"row is null or deleted"}
+	}
+	if condition.BlockID > 0 && version.BlockID != condition.BlockID {
+		return &PreconditionError{table.Data.Name,rowID,fmt.Sprintf("block is `%d` expected `%d`", version.BlockID, condition.BlockID)}
+	}
+	if condition.Version > 0 && version.Version != condition.Version {
+		return &PreconditionError{table.Data.Name,rowID,fmt.Sprintf("version is `%d` expected `%d`", version.Version, condition.Version)}
+	}
+	if len(condition.Columns) == 0 {
+		return nil
+	}
+	columns := make([]*db.Column, 0, len(condition.Columns))
+	columnIDs := make([]db.ColumnID, 0, len(condition.Columns))
+	for name := range condition.Columns {
+		column,err := operation.findColumn(table, name); if err != nil {
+			return err
+		}
+		columns = append(columns, column)
+		columnIDs = append(columnIDs, column.Id)
+	}
+	rowData,err := operation.iDatabase.QueryRowData(table.Data, rowID, columnIDs...); if err != nil {
+		return err
+	}
+	if rowData == nil {
+		return &PreconditionError{table.Data.Name,rowID,"row is null"}
+	}
+	for _,column := range columns {
+		expected,err := util.FormatColumnData(*column, condition.Columns[column.Name]); if err != nil {
+			return err
+		}
+		actual := column.Default
+		if int(column.Id) <= len(rowData.Columns) {
+			actual = rowData.Columns[column.Id-1].Data
+		}
+		if db.IsBlobType(column.Type) && len(actual) > 0 {
+			actual,err = operation.iDatabase.GetBlobData(actual); if err != nil {
+				return err
+			}
+		}
+		if !bytes.Equal(expected, actual) {
+			return &PreconditionError{table.Data.Name,rowID,fmt.Sprintf("column `%s` value is not expected", column.Name)}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,320 @@
+package row
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"github.com/database-fabric/db"
+	"github.com/database-fabric/db/util"
+	"github.com/database-fabric/op/table"
+	"github.com/database-fabric/protos/db/row"
+	"io"
+	"strings"
+)
+
+//导入格式
+const (
+	ImportFormatCSV = "csv"
+	ImportFormatNDJSON = "ndjson"
+)
+
+const (
+	DefaultImportMaxKeys = 1000 //每批写入Key默认上限
+	DefaultImportMaxBytes = 1024*1024 //每批写入数据默认上限1MB
+	importRowSize = 16 //行编码估算开销
+	csvNull = "\\N" //csv空值(mysql导出格式)
+)
+
+/**
+	导入配置，每次导入一批(一个事务)，从Checkpoint开始导入，返回下一批的Checkpoint
+ */
+type ImportConfig struct {
+	Format string `json:"format"` //csv、ndjson，为空使用csv
+	Upsert bool `json:"upsert"` //行存在时修改，否则新增
+	MaxKeys int `json:"maxKeys"` //每批写入Key上限(估算)，为空使用默认值
+	MaxBytes int `json:"maxBytes"` //每批写入数据字节上限(估算)，为空使用默认值
+	Columns map[string]string `json:"columns"` //源字段名到列名映射，未设置时使用同名列
+	Checkpoint ImportCheckpoint `json:"checkpoint"`
+}
+
+//导入位置，已处理(导入或拒绝)的记录数，csv不包含表头
+type ImportCheckpoint struct {
+	Record int64 `json:"record"`
+}
+
+type ImportRejected struct {
+	Record int64 `json:"record"` //记录序号，从1开始
+	Reason string `json:"reason"`
+}
+
+type ImportResult struct {
+	RowIDs []db.RowID `json:"rowIDs"` //导入的非自增行ID
+	Imported int64 `json:"imported"` //导入的行数
+	Rejected []ImportRejected `json:"rejected"`
+	Checkpoint ImportCheckpoint `json:"checkpoint"` //下一批导入位置
+	Done bool `json:"done"` //全部导入完成
+}
+
+////////////////// Public Function //////////////////
+func (operation *RowOperation) ImportBytes(tableName string, data string, configJson string) ([]byte,error) {
+	var config ImportConfig
+	if configJson != "" {
+		if err := json.Unmarshal([]byte(configJson), &config); err != nil {
+			return nil,fmt.Errorf("import config json %s", err)
+		}
+	}
+	result,err := operation.Import(tableName, strings.NewReader(data), config); if err != nil {
+		return nil,err
+	}
+	return util.ConvertJsonBytes(*result)
+}
+
+/**
+	从Checkpoint开始导入一批记录，按Key与字节上限分批，格式或约束错误的记录拒绝并返回原因
+	同一批中主键重复的记录拒绝，Upsert时重复的记录在下一批中修改
+	未完成时使用返回的Checkpoint在新的事务中继续导入
+ */
+func (operation *RowOperation) Import(tableName string, reader io.Reader, config ImportConfig) (*ImportResult,error) {
+	table,err := table.ValidateNullOfData(tableName, operation.iDatabase); if err != nil {
+		return nil,err
+	}
+	records,err := newRecordReader(table, reader, config); if err != nil {
+		return nil,err
+	}
+	maxKeys,maxBytes := config.MaxKeys,config.MaxBytes
+	if maxKeys <= 0 {
+		maxKeys = DefaultImportMaxKeys
+	}
+	if maxBytes <= 0 {
+		maxBytes = DefaultImportMaxBytes
+	}
+	result := &ImportResult{Checkpoint:config.Checkpoint}
+	for i:=int64(0);i<config.Checkpoint.Record;i++ {//跳过已处理的记录
+		if _,err := records.next(); err == io.EOF {
+			result.Done = true
+			return result,nil
+		}else if err != nil && !isRecordError(err) {
+			return nil,fmt.Errorf("import record %d %s", i+1, err)
+		}
+	}
+	rows := make([]*row.RowData, 0)
+	batchIDs := map[db.RowID]int64{} //本批中的行ID与记录序号
+	keys,size := 0,0
+	blockSize := int(table.Data.Storage.GetBlockSize())
+	for {
+		rowJson,err := records.next()
+		if err == io.EOF {
+			result.Done = true
+			break
+		}
+		record := result.Checkpoint.Record+1
+		if err != nil && !isRecordError(err) {//读取错误(如行超出长度上限)无法跳过，结束本批，未导入任何行时返回错误
+			if len(rows) == 0 {
+				return nil,fmt.Errorf("import record %d %s", record, err)
+			}
+			break
+		}
+		if err != nil {
+			result.reject(record, err)
+			continue
+		}
+		rowKeys,rowSize := estimateRow(table, rowJson)
+		blockKeys := (size+rowSize+blockSize-1)/blockSize
+		if len(rows) > 0 && (keys+rowKeys+blockKeys > maxKeys || size+rowSize > maxBytes) {//超出本批上限，下一批导入
+			break
+		}
+		rowID,err := rowJsonID(table, rowJson); if err != nil {
+			result.reject(record, err)
+			continue
+		}
+		if prev,ok := batchIDs[rowID]; ok && rowID > 0 {//同一批中写入同一行会合并为一个版本
+			if config.Upsert {//在下一批中修改
+				break
+			}
+			result.reject(record, fmt.Errorf("row `%d` is duplicated with record %d", rowID, prev))
+			continue
+		}
+		op := db.ADD
+		if config.Upsert {
+			op = db.UPSERT
+		}
+		rowData,err := operation.FormatRowData(table, rowJson, op); if err != nil {
+			result.reject(record, err)
+			continue
+		}
+		rows = append(rows, rowData)
+		batchIDs[rowID] = record
+		keys += rowKeys
+		size += rowSize
+		result.Checkpoint.Record++
+	}
+	if len(rows) > 0 {
+		result.RowIDs,err = operation.addRows(table, rows); if err != nil {
+			return nil,err
+		}
+		result.Imported = int64(len(rows))//批中的行ID不重复，每条记录写入一行
+	}
+	return result,nil
+}
+
+////////////////// Private Function //////////////////
+func (result *ImportResult) reject(record int64, err error) {
+	result.Rejected = append(result.Rejected, ImportRejected{Record:record,Reason:err.Error()})
+	result.Checkpoint.Record++
+}
+
+/**
+	估算行写入的Key数量(主键索引与大字段分片)和数据大小
+ */
+func estimateRow(table *db.Table, rowJson db.JsonData) (int,int) {
+	keys,size := 1,importRowSize
+	for _,column := range table.Data.Columns {
+		value,ok := rowJson[column.Name]
+		if !ok || value == nil {
+			continue
+		}
+		length := len(fmt.Sprint(value))
+		if db.IsBlobType(column.Type) {
+			if column.Type == db.BLOB {
+				length = length*3/4
+			}
+			keys += (length+db.BlobChunkSize-1)/db.BlobChunkSize
+			size += 41 //大字段引用
+		}else{
+			size += length
+		}
+	}
+	return keys,size
+}
+
+/**
+	读取记录，单条记录格式错误返回recordError，可拒绝后继续读取下一条，其他错误不可恢复
+ */
+type recordReader interface {
+	next() (db.JsonData,error)
+}
+
+type recordError struct {
+	err error
+}
+
+func (err *recordError) Error() string {
+	return err.err.Error()
+}
+
+func isRecordError(err error) bool {
+	_,ok := err.(*recordError)
+	return ok
+}
+
+func newRecordReader(table *db.Table, reader io.Reader, config ImportConfig) (recordReader,error) {
+	columns := make(map[string]db.Column, len(table.Data.Columns))
+	for _,column := range table.Data.Columns {
+		if !column.IsDeleted {
+			columns[column.Name] = column
+		}
+	}
+	mapping := &columnMapping{config.Columns,columns}
+	switch config.Format {
+	case ImportFormatCSV,"":
+		csvReader := csv.NewReader(reader)
+		csvReader.ReuseRecord = true
+		header,err := csvReader.Read(); if err != nil {
+			return nil,fmt.Errorf("csv header %s", err)
+		}
+		names := make([]string, 0, len(header))
+		for _,field := range header {
+			column,err := mapping.column(field); if err != nil {
+				return nil,err
+			}
+			names = append(names, column.Name)
+		}
+		return &csvRecordReader{csvReader,names,mapping},nil
+	case ImportFormatNDJSON:
+		scanner := bufio.NewScanner(reader)
+		scanner.Buffer(make([]byte, 0, 64*1024), DefaultImportMaxBytes)
+		return &ndjsonRecordReader{scanner,mapping},nil
+	}
+	return nil,fmt.Errorf("import format `%s` is not supported", config.Format)
+}
+
+type columnMapping struct {
+	names map[string]string
+	columns map[string]db.Column
+}
+
+func (mapping *columnMapping) column(field string) (db.Column,error) {
+	name := field
+	if mapped,ok := mapping.names[field]; ok {
+		name = mapped
+	}
+	column,ok := mapping.columns[name]
+	if !ok {
+		return column,fmt.Errorf("field `%s` column `%s` is not found", field, name)
+	}
+	return column,nil
+}
+
+type csvRecordReader struct {
+	reader *csv.Reader
+	names []string
+	mapping *columnMapping
+}
+
+/**
+	csv值为字符串，\N为空值，非字符串列空字符串为空值
+ */
+func (reader *csvRecordReader) next() (db.JsonData,error) {
+	record,err := reader.reader.Read(); if err != nil {
+		if _,ok := err.(*csv.ParseError); ok {
+			return nil,&recordError{err}
+		}
+		return nil,err
+	}
+	rowJson := make(db.JsonData, len(record))
+	for i,value := range record {
+		column := reader.mapping.columns[reader.names[i]]
+		if value == csvNull || (value == "" && column.Type != db.VARCHAR && column.Type != db.TEXT) {
+			continue
+		}
+		rowJson[column.Name] = value
+	}
+	return rowJson,nil
+}
+
+type ndjsonRecordReader struct {
+	scanner *bufio.Scanner
+	mapping *columnMapping
+}
+
+/**
+	每行一个json对象，跳过空行
+ */
+func (reader *ndjsonRecordReader) next() (db.JsonData,error) {
+	for reader.scanner.Scan() {
+		line := bytes.TrimSpace(reader.scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var record db.JsonData
+		if err := json.Unmarshal(line, &record); err != nil {
+			return nil,&recordError{fmt.Errorf("ndjson %s", err)}
+		}
+		rowJson := make(db.JsonData, len(record))
+		for field,value := range record {
+			column,err := reader.mapping.column(field); if err != nil {
+				return nil,&recordError{err}
+			}
+			if value != nil {
+				rowJson[column.Name] = value
+			}
+		}
+		return rowJson,nil
+	}
+	if err := reader.scanner.Err(); err != nil {
+		return nil,err
+	}
+	return nil,io.EOF
+}
@@ -37,21 +37,54 @@ func (operation *RowOperation) Delete(tableName string, rowIDs []db.RowID) ([]db
 	return operation.SetRow(table, rowJsonArray, db.DELETE)
 }
 
-func (operation *RowOperation) QueryRowBytes(tableName string, rowID db.RowID) ([]byte,error) {
+/**
+	查询行数据，columnNames为投影列名，为空时返回全部列
+ */
+func (operation *RowOperation) QueryRowBytes(tableName string, rowID db.RowID, columnNames ...string) ([]byte,error) {
+	table,err := table.ValidateNullOfData(tableName, operation.iDatabase); if err != nil {
+		return nil,err
+	}
+	columns,err := operation.validateColumns(table, columnNames); if err != nil {
+		return nil,err
+	}
+	jsonData,err := operation.QueryRow(table, rowID, columns...); if err != nil {
+		return nil,err
+	}
+	return util.ConvertJsonBytes(jsonData)
+}
+
+/**
+	查询行数据，不读取大字段值，大字段列返回引用(哈希与长度)
+ */
+func (operation *RowOperation) QueryRowWithoutBlobBytes(tableName string, rowID db.RowID) ([]byte,error) {
 	table,err := table.ValidateNullOfData(tableName, operation.iDatabase); if err != nil {
 		return nil,err
 	}
-	jsonData,err := operation.QueryRow(table, rowID); if err != nil {
+	rowData,err := operation.iDatabase.QueryRowData(table.Data, rowID); if err != nil {
+		return nil,err
+	}
+	if rowData == nil {
+		return util.ConvertJsonBytes(nil)
+	}
+	jsonData,err := util.ParseRowData(table, rowData); if err != nil {
 		return nil,err
 	}
 	return util.ConvertJsonBytes(jsonData)
 }
 
-func (operation *RowOperation) QueryRowWithPaginationBytes(tableName string, start db.RowID, end db.RowID, order db.OrderType, pageSize int32) ([]byte,error) {
+/**
+	分页查询行数据，columnNames为投影列名，为空时返回全部列
+	cursor为上一页返回的游标，为空时从start开始，不为空时从游标位置继续(start、end、order与上一页相同)
+	区间总数在第一页统计后由游标携带，翻页不重复统计，之后写入的行不影响返回的总数
+ */
+func (operation *RowOperation) QueryRowWithPaginationBytes(tableName string, start db.RowID, end db.RowID, order db.OrderType, pageSize int32, cursor string, columnNames ...string) ([]byte,error) {
 	table,err := table.ValidateNullOfData(tableName, operation.iDatabase); if err != nil {
 		return nil,err
 	}
-	pagination,err := operation.QueryRowWithPagination(table, start, end, order, pageSize); if err != nil {
+	columns,err := operation.validateColumns(table, columnNames); if err != nil {
+		return nil,err
+	}
+	pagination,err := operation.QueryRowWithPagination(table, start, end, order, pageSize, cursor, columns...); if err != nil {
 		return nil,err
 	}
 	paginationBytes,err := util.ConvertJsonBytes(pagination); if err != nil {
@@ -60,6 +93,16 @@ func (operation *RowOperation) QueryRowWithPaginationBytes(tableName string, sta
 	return paginationBytes,nil
 }
 
+/**
+	区间[start,end]未删除的行数量，start、end为0时不限制(读取统计)，否则扫描区间内的主键索引
+ */
+func (operation *RowOperation) QueryRowCount(tableName string, start db.RowID, end db.RowID) (db.Total,error) {
+	table,err := table.ValidateNullOfData(tableName, operation.iDatabase); if err != nil {
+		return 0,err
+	}
+	return operation.iDatabase.QueryRowCountByRange(table.Data, start, end)
+}
+
 func (operation *RowOperation) QueryRowDemo(tableName string) (map[string]interface{},error) {
 	table,err := table.ValidateNullOfData(tableName, operation.iDatabase); if err != nil {
 		return nil,err
@@ -87,17 +130,41 @@ func (operation *RowOperation) AddOrUpdate(tableName string, jsonString string,
 	行记录汇总
 */
 func (operation *RowOperation) SetRow(table *db.Table, rowJsonArray []db.JsonData, op db.OpType) ([]db.RowID,error) {
-	rowMaps := make(map[db.RowID]*row.RowData, len(rowJsonArray))
-	rowIDs := make([]db.RowID, 0, len(rowJsonArray))
-	newRows := make([]*row.RowData, 0, len(rowJsonArray))
-	var incrementRows []*row.RowData
-	if op == db.ADD {
-		incrementRows = make([]*row.RowData, 0, len(rowJsonArray))
-	}
-	for _,rowJson := range rowJsonArray {
+	return operation.setConditionalRow(table, rowJsonArray, op, nil)
+}
+
+/**
+	条件行记录汇总，conditions与rowJsonArray一一对应，条件不满足时返回PreconditionError
+	UPSERT按行是否存在转换为ADD或UPDATE(FormatRowData)
+*/
+func (operation *RowOperation) setConditionalRow(table *db.Table, rowJsonArray []db.JsonData, op db.OpType, conditions []*Condition) ([]db.RowID,error) {
+	rows := make([]*row.RowData, 0, len(rowJsonArray))
+	for i,rowJson := range rowJsonArray {
+		if conditions != nil && conditions[i] != nil {//格式化之前验证条件(格式化时验证外键、写入大字段)
+			rowID,err := rowJsonID(table, rowJson); if err != nil {
+				return nil,err
+			}
+			if err := operation.checkCondition(table, rowID, conditions[i]); err != nil {
+				return nil,err
+			}
+		}
 		rowData,err := operation.FormatRowData(table, rowJson, op); if err != nil {
 			return nil,err
 		}
+		rows = append(rows, rowData)
+	}
+	return operation.addRows(table, rows)
+}
+
+/**
+	合并重复行后写入，自增行追加到尾端，返回非自增行ID
+*/
+func (operation *RowOperation) addRows(table *db.Table, rows []*row.RowData) ([]db.RowID,error) {
+	rowMaps := make(map[db.RowID]*row.RowData, len(rows))
+	rowIDs := make([]db.RowID, 0, len(rows))
+	newRows := make([]*row.RowData, 0, len(rows))
+	var incrementRows []*row.RowData
+	for _,rowData := range rows {
 		if rowData.Id == 0 {
 			if table.Data.PrimaryKey.AutoIncrement {//自增行不合并
 				incrementRows = append(incrementRows, rowData)
@@ -108,7 +175,11 @@ func (operation *RowOperation) SetRow(table *db.Table, rowJsonArray []db.JsonDat
 		}
 		prev,ok := rowMaps[rowData.Id]
 		if ok {//存在
-			prev.Columns = rowData.Columns//合并到上一次记录中
+			if prev.Base > 0 {//增量行合并修改的列
+				mergeDeltaColumns(prev, rowData)
+			}else{
+				prev.Columns = rowData.Columns//合并到上一次记录中
+			}
 			rowData = nil//清空当前记录
 		}else{
 			newRows = append(newRows, rowData)
@@ -123,31 +194,41 @@ func (operation *RowOperation) SetRow(table *db.Table, rowJsonArray []db.JsonDat
 	return rowIDs,nil
 }
 
-func (operation *RowOperation) QueryRow(table *db.Table, rowID db.RowID) (db.JsonData,error) {
-	rowData,err := operation.iDatabase.QueryRowData(table.Data, rowID); if err != nil {
+func (operation *RowOperation) QueryRow(table *db.Table, rowID db.RowID, columns ...db.ColumnID) (db.JsonData,error) {
+	rowData,err := operation.iDatabase.QueryRowData(table.Data, rowID, columns...); if err != nil {
 		return nil, err
 	}
 	if rowData == nil {
 		return nil, nil
 	}
-	return util.ParseRowData(table, rowData)
+	return util.ParseRowDataWithColumns(table, rowData, columns, operation.iDatabase.GetBlobData)
 }
 
-func (operation *RowOperation) QueryRowWithPagination(table *db.Table, start db.RowID, end db.RowID, order db.OrderType, pageSize int32) (db.Pagination,error) {
+func (operation *RowOperation) QueryRowWithPagination(table *db.Table, start db.RowID, end db.RowID, order db.OrderType, pageSize int32, cursor string, columns ...db.ColumnID) (db.Pagination,error) {
 	pagination := db.Pagination{}
-	tally,err := operation.iDatabase.GetTableTally(table.Data.Id); if err != nil {
+	pageCursor,err := util.DecodeCursor(cursor); if err != nil {
 		return pagination,err
 	}
-	count := tally.AddRow - tally.DelRow
-	rows,err := operation.iDatabase.QueryRowDataByRange(table.Data, start, end, order, pageSize); if err != nil {
+	count := db.UnknownTotal
+	if pageCursor != nil {//总数只在第一页统计，之后的页使用游标携带的总数
+		count = pageCursor.Total
+	}else if start == 0 && end == 0 {//不限制区间时读取统计中的行数量，区间行数量需要扫描主键索引，分页时不统计
+		count,err = operation.iDatabase.QueryRowCountByRange(table.Data, start, end); if err != nil {
+			return pagination,err
+		}
+	}
+	rows,next,err := operation.iDatabase.QueryRowDataByCursor(table.Data, start, end, pageCursor, order, pageSize, columns...); if err != nil {
 		return pagination,err
 	}
+	if next != nil {
+		next.Total = count
+	}
 	list := make([]db.JsonData, 0, len(rows))
 	for _,rowData := range rows {
 		if rowData != nil && rowData.Id > 0 {
 			rowJson := db.JsonData{}
 			if len(rowData.Columns) > 0 {
-				rowJson,err = util.ParseRowData(table, rowData); if err != nil {
+				rowJson,err = util.ParseRowDataWithColumns(table, rowData, columns, operation.iDatabase.GetBlobData); if err != nil {
 					return pagination,err
 				}
 			}else{
@@ -156,5 +237,27 @@ func (operation *RowOperation) QueryRowWithPagination(table *db.Table, start db.
 			list = append(list, rowJson)
 		}
 	}
-	return util.Pagination(pageSize, count, list),nil
-}
\ No newline at end of file
+	pagination = util.Pagination(pageSize, count, list)
+	pagination.Cursor = util.EncodeCursor(next)
+	return pagination,nil
+}
+
+/**
+	同一行多次修改时合并增量列，后修改的列值覆盖之前的值
+ */
+func mergeDeltaColumns(prev *row.RowData, rowData *row.RowData) {
+	for i,index := range rowData.Delta {
+		found := false
+		for j,prevIndex := range prev.Delta {
+			if prevIndex == index {
+				prev.Columns[j] = rowData.Columns[i]
+				found = true
+				break
+			}
+		}
+		if !found {
+			prev.Delta = append(prev.Delta, index)
+			prev.Columns = append(prev.Columns, rowData.Columns[i])
+		}
+	}
+}
@@ -0,0 +1,512 @@
+package row
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/database-fabric/db"
+	"github.com/database-fabric/db/database"
+	"github.com/database-fabric/db/storage"
+	"github.com/database-fabric/db/storage/state"
+	"github.com/database-fabric/db/util"
+	"github.com/database-fabric/op/history"
+	"github.com/database-fabric/test"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+func TestRow(t *testing.T) {
+	var stub = new(test.TestChaincodeStub)
+	chainState := state.NewStateImpl(stub)
+	databaseImpl := database.NewDatabaseImpl(&db.DataBase{Id:db.DatabaseID(1),Relation:&db.Relation{}}, chainState)
+	operation := NewRowOperation(databaseImpl)
+	//引用统计分片表，外键按引用表的主键索引分片查询
+	{
+		parentTable := &db.TableData{Name:"ShardParent",
+			Columns:[]db.Column{
+				{Id:db.ColumnID(1),ColumnConfig:db.ColumnConfig{Name:"id",Type:db.INT,NotNull:true},Order:1},
+				{Id:db.ColumnID(2),ColumnConfig:db.ColumnConfig{Name:"name",Type:db.VARCHAR},Order:2},
+			},
+			PrimaryKey:db.PrimaryKey{ColumnID:db.ColumnID(1),AutoIncrement:true},
+			TallyShards:4}
+		parentID,err := databaseImpl.CreateTableData(parentTable); if err != nil {
+			panic(err.Error())
+		}
+		childTable := &db.TableData{Name:"ShardChild",
+			Columns:[]db.Column{
+				{Id:db.ColumnID(1),ColumnConfig:db.ColumnConfig{Name:"id",Type:db.INT,NotNull:true},Order:1},
+				{Id:db.ColumnID(2),ColumnConfig:db.ColumnConfig{Name:"parent",Type:db.INT},Order:2},
+			},
+			PrimaryKey:db.PrimaryKey{ColumnID:db.ColumnID(1),AutoIncrement:true},
+			ForeignKeys:[]db.ForeignKey{{ColumnID:db.ColumnID(2),Reference:db.ReferenceKey{TableID:parentID,ColumnID:db.ColumnID(1)}}}}
+		if _,err := databaseImpl.CreateTableData(childTable); err != nil {
+			panic(err.Error())
+		}
+		for i:=0;i<8;i++ {
+			stub.TxID = util.Int64ToString(int64(i))
+			if _,err := operation.Add(parentTable.Name, `[{"name":"p"}]`); err != nil {
+				panic(err.Error())
+			}
+		}
+		stub.TxID = ""
+		parentRows,err := databaseImpl.QueryRowDataByRange(parentTable, 0, 0, db.ASC,10); if err != nil {
+			panic(err.Error())
+		}
+		assert.Len(t, parentRows, 8, "parent rows error")
+		shards := map[int8]bool{}
+		for _,rowData := range parentRows {
+			shards[parentTable.PrimaryShard(rowData.Id)] = true
+			if _,err := operation.Add(childTable.Name, `[{"parent":"`+util.Int64ToString(rowData.Id)+`"}]`); err != nil {
+				panic(err.Error())
+			}
+		}
+		assert.True(t, len(shards) > 1, "parent primary shards error")
+		_,err = operation.Add(childTable.Name, `[{"parent":"999"}]`)
+		assert.NotNil(t, err, "reference row not exists error")
+	}
+	//大字段列json读写，BLOB为base64，列投影不读取未投影的大字段
+	{
+		blobTable := &db.TableData{Name:"TestBlobTable",
+			Columns:[]db.Column{
+				{Id:db.ColumnID(1),ColumnConfig:db.ColumnConfig{Name:"id",Type:db.INT,NotNull:true},Order:1},
+				{Id:db.ColumnID(2),ColumnConfig:db.ColumnConfig{Name:"data",Type:db.BLOB},Order:2},
+				{Id:db.ColumnID(3),ColumnConfig:db.ColumnConfig{Name:"note",Type:db.TEXT},Order:3},
+			},
+			PrimaryKey:db.PrimaryKey{ColumnID:db.ColumnID(1)}}
+		if _,err := databaseImpl.CreateTableData(blobTable); err != nil {
+			panic(err.Error())
+		}
+		value := make([]byte, 2*db.BlobChunkSize+100)
+		rand.Read(value)
+		encoded := base64.StdEncoding.EncodeToString(value)
+		if _,err := operation.Add(blobTable.Name, fmt.Sprintf(`[{"id":"1","data":"%s","note":"text"},{"id":"2"}]`, encoded)); err != nil {
+			panic(err.Error())
+		}
+		if _,err := operation.Update(blobTable.Name, fmt.Sprintf(`[{"id":"2","data":"%s"}]`, encoded)); err != nil {
+			panic(err.Error())
+		}
+		jsonBytes,err := operation.QueryRowBytes(blobTable.Name, db.RowID(1)); if err != nil {
+			panic(err.Error())
+		}
+		rowMap,err := util.ConvertMap(string(jsonBytes)); if err != nil {
+			panic(err.Error())
+		}
+		assert.Equal(t, encoded, rowMap["data"], "blob data error")
+		assert.Equal(t, "text", rowMap["note"], "text data error")
+		jsonBytes,err = operation.QueryRowWithoutBlobBytes(blobTable.Name, db.RowID(2)); if err != nil {
+			panic(err.Error())
+		}
+		rowMap,err = util.ConvertMap(string(jsonBytes)); if err != nil {
+			panic(err.Error())
+		}
+		assert.EqualValues(t, len(value), rowMap["data"].(map[string]interface{})["length"], "blob projection error")
+		assert.Equal(t, "", rowMap["note"], "text null error")
+		jsonBytes,err = operation.QueryRowBytes(blobTable.Name, db.RowID(1), "note"); if err != nil {
+			panic(err.Error())
+		}
+		rowMap,err = util.ConvertMap(string(jsonBytes)); if err != nil {
+			panic(err.Error())
+		}
+		assert.Len(t, rowMap, 2, "project row columns error")
+		assert.Equal(t, "text", rowMap["note"], "project row error")
+		_,err = operation.QueryRowBytes(blobTable.Name, db.RowID(1), "unknown")
+		assert.NotNil(t, err, "project unknown column error")
+		//篡改分片
+		rowData,err := databaseImpl.QueryRowData(blobTable, db.RowID(1)); if err != nil {
+			panic(err.Error())
+		}
+		reference,err := util.DecodeBlobReference(rowData.Columns[1].Data); if err != nil {
+			panic(err.Error())
+		}
+		if err := storage.NewBlobStorage(chainState).PutChunkData(db.DatabaseID(1), hex.EncodeToString(reference.Hash), 1, []byte("tampered")); err != nil {
+			panic(err.Error())
+		}
+		_,err = operation.QueryRowBytes(blobTable.Name, db.RowID(1))
+		assert.NotNil(t, err, "tampered blob error")
+		_,err = operation.QueryRowBytes(blobTable.Name, db.RowID(1), "note")
+		assert.Nil(t, err, "project tampered blob error")
+		//列验证失败的行不写入大字段分片
+		checkTable := &db.TableData{Name:"TestBlobCheckTable",
+			Columns:[]db.Column{
+				{Id:db.ColumnID(1),ColumnConfig:db.ColumnConfig{Name:"id",Type:db.INT,NotNull:true},Order:1},
+				{Id:db.ColumnID(2),ColumnConfig:db.ColumnConfig{Name:"data",Type:db.BLOB},Order:2},
+				{Id:db.ColumnID(3),ColumnConfig:db.ColumnConfig{Name:"age",Type:db.INT,NotNull:true},Order:3},
+			},
+			PrimaryKey:db.PrimaryKey{ColumnID:db.ColumnID(1)}}
+		if _,err := databaseImpl.CreateTableData(checkTable); err != nil {
+			panic(err.Error())
+		}
+		rejected := make([]byte, 100)
+		rand.Read(rejected)
+		_,err = operation.Add(checkTable.Name, fmt.Sprintf(`[{"id":"1","data":"%s"}]`, base64.StdEncoding.EncodeToString(rejected)))
+		assert.NotNil(t, err, "blob row not null error")
+		result,err := operation.Import(checkTable.Name, strings.NewReader(fmt.Sprintf("id,data,age\n2,%s,x\n", base64.StdEncoding.EncodeToString(rejected))), ImportConfig{}); if err != nil {
+			panic(err.Error())
+		}
+		assert.Len(t, result.Rejected, 1, "blob import rejected error")
+		hash := sha256.Sum256(rejected)
+		chunk,err := storage.NewBlobStorage(chainState).GetChunkData(db.DatabaseID(1), hex.EncodeToString(hash[:]), 0); if err != nil {
+			panic(err.Error())
+		}
+		assert.Empty(t, chunk, "blob rejected row chunk error")
+	}
+	//增量修改，已删除的行不能修改
+	{
+		deltaTable := &db.TableData{Name:"TestDeltaTable",
+			Columns:[]db.Column{
+				{Id:db.ColumnID(1),ColumnConfig:db.ColumnConfig{Name:"id",Type:db.INT,NotNull:true},Order:1},
+				{Id:db.ColumnID(2),ColumnConfig:db.ColumnConfig{Name:"name",Type:db.VARCHAR},Order:2},
+				{Id:db.ColumnID(3),ColumnConfig:db.ColumnConfig{Name:"age",Type:db.INT},Order:3},
+			},
+			PrimaryKey:db.PrimaryKey{ColumnID:db.ColumnID(1)}}
+		_,err := databaseImpl.CreateTableData(deltaTable); if err != nil {
+			panic(err.Error())
+		}
+		if _,err := operation.Add(deltaTable.Name, `[{"id":"1","name":"a","age":"10"}]`); err != nil {
+			panic(err.Error())
+		}
+		if _,err := operation.Update(deltaTable.Name, `[{"id":"1","age":"11"}]`); err != nil {
+			panic(err.Error())
+		}
+		jsonData,err := operation.QueryRow(&db.Table{Data:deltaTable,Primary:&deltaTable.Columns[0]}, db.RowID(1)); if err != nil {
+			panic(err.Error())
+		}
+		assert.Equal(t, "a", jsonData["name"], "delta update merge error")
+		assert.EqualValues(t, 11, jsonData["age"], "delta update error")
+		if _,err := operation.Delete(deltaTable.Name, []db.RowID{1}); err != nil {
+			panic(err.Error())
+		}
+		_,err = operation.Update(deltaTable.Name, `[{"id":"1","age":"12"}]`)
+		assert.NotNil(t, err, "update deleted row error")
+		_,err = operation.Update(deltaTable.Name, `[{"id":"2","age":"12"}]`)
+		assert.NotNil(t, err, "update null row error")
+		version,err := databaseImpl.QueryRowVersion(deltaTable, db.RowID(1)); if err != nil {
+			panic(err.Error())
+		}
+		assert.EqualValues(t, 3, version.Version, "deleted row version error")
+		assert.EqualValues(t, db.DELETE, version.Op, "deleted row op error")
+	}
+	//UPSERT与条件写入
+	{
+		casTable := &db.TableData{Name:"TestCasTable",
+			Columns:[]db.Column{
+				{Id:db.ColumnID(1),ColumnConfig:db.ColumnConfig{Name:"id",Type:db.INT,NotNull:true},Order:1},
+				{Id:db.ColumnID(2),ColumnConfig:db.ColumnConfig{Name:"status",Type:db.VARCHAR},Order:2},
+			},
+			PrimaryKey:db.PrimaryKey{ColumnID:db.ColumnID(1)}}
+		_,err := databaseImpl.CreateTableData(casTable); if err != nil {
+			panic(err.Error())
+		}
+		queryStatus := func(rowID db.RowID) interface{} {
+			jsonData,err := operation.QueryRow(&db.Table{Data:casTable,Primary:&casTable.Columns[0]}, rowID); if err != nil {
+				panic(err.Error())
+			}
+			return jsonData["status"]
+		}
+		if _,err := operation.Upsert(casTable.Name, `[{"id":"1","status":"a"}]`); err != nil {
+			panic(err.Error())
+		}
+		if _,err := operation.Upsert(casTable.Name, `[{"id":"1","status":"b"},{"id":"2","status":"x"}]`); err != nil {
+			panic(err.Error())
+		}
+		assert.Equal(t, "b", queryStatus(1), "upsert update error")
+		assert.Equal(t, "x", queryStatus(2), "upsert add error")
+		version,err := databaseImpl.QueryRowVersion(casTable, db.RowID(1)); if err != nil {
+			panic(err.Error())
+		}
+		assert.EqualValues(t, 2, version.Version, "row version error")
+		assert.EqualValues(t, db.UPDATE, version.Op, "row version op error")
+		//版本不一致
+		_,err = operation.UpdateIf(casTable.Name, `[{"row":{"id":"1","status":"c"},"condition":{"version":1}}]`)
+		assert.True(t, IsPreconditionError(err), "version precondition error")
+		_,err = operation.UpdateIf(casTable.Name, `[{"row":{"id":"1","status":"c"},"condition":{"columns":{"status":"a"}}}]`)
+		assert.True(t, IsPreconditionError(err), "column precondition error")
+		assert.Equal(t, "b", queryStatus(1), "precondition update error")
+		if _,err := operation.UpdateIf(casTable.Name, fmt.Sprintf(`[{"row":{"id":"1","status":"c"},"condition":{"blockID":%d,"version":2,"columns":{"status":"b"}}}]`, version.BlockID)); err != nil {
+			panic(err.Error())
+		}
+		assert.Equal(t, "c", queryStatus(1), "conditional update error")
+		//条件删除
+		_,err = operation.DeleteIf(casTable.Name, fmt.Sprintf(`[{"row":{"id":"1"},"condition":{"blockID":%d}}]`, version.BlockID))
+		assert.True(t, IsPreconditionError(err), "block precondition error")
+		if _,err := operation.DeleteIf(casTable.Name, `[{"row":{"id":"1"},"condition":{"version":3}}]`); err != nil {
+			panic(err.Error())
+		}
+		_,err = operation.Update(casTable.Name, `[{"id":"1","status":"d"}]`)
+		assert.NotNil(t, err, "update deleted row error")
+		//已删除或不存在的行条件不满足
+		_,err = operation.UpdateIf(casTable.Name, `[{"row":{"id":"1","status":"d"},"condition":{}}]`)
+		assert.True(t, IsPreconditionError(err), "update deleted row precondition error")
+		_,err = operation.DeleteIf(casTable.Name, `[{"row":{"id":"1"},"condition":{"version":3}}]`)
+		assert.True(t, IsPreconditionError(err), "delete deleted row precondition error")
+		_,err = operation.DeleteIf(casTable.Name, `[{"row":{"id":"99"},"condition":{}}]`)
+		assert.True(t, IsPreconditionError(err), "delete null row precondition error")
+		//删除后UPSERT新增
+		if _,err := operation.Upsert(casTable.Name, `[{"id":"1","status":"e"}]`); err != nil {
+			panic(err.Error())
+		}
+		assert.Equal(t, "e", queryStatus(1), "upsert deleted row error")
+		version,err = databaseImpl.QueryRowVersion(casTable, db.RowID(1)); if err != nil {
+			panic(err.Error())
+		}
+		assert.EqualValues(t, 5, version.Version, "row version after upsert error")
+		assert.EqualValues(t, db.ADD, version.Op, "row version op after upsert error")
+		//ADD不能新增已存在或已删除的行，删除后使用UPSERT重新新增
+		_,err = operation.Add(casTable.Name, `[{"id":"1","status":"f"}]`)
+		assert.NotNil(t, err, "add exists row error")
+		if _,err := operation.Delete(casTable.Name, []db.RowID{2}); err != nil {
+			panic(err.Error())
+		}
+		_,err = operation.Add(casTable.Name, `[{"id":"2","status":"y"}]`)
+		assert.NotNil(t, err, "add deleted row error")
+		if _,err := operation.Upsert(casTable.Name, `[{"id":"2","status":"y"}]`); err != nil {
+			panic(err.Error())
+		}
+		assert.Equal(t, "y", queryStatus(2), "upsert deleted row data error")
+		version,err = databaseImpl.QueryRowVersion(casTable, db.RowID(2)); if err != nil {
+			panic(err.Error())
+		}
+		assert.EqualValues(t, 3, version.Version, "row version after re-add error")
+		assert.EqualValues(t, db.ADD, version.Op, "row version op after re-add error")
+	}
+	//CSV与NDJSON批量导入
+	{
+		importTable := &db.TableData{Name:"TestImportTable",
+			Columns:[]db.Column{
+				{Id:db.ColumnID(1),ColumnConfig:db.ColumnConfig{Name:"id",Type:db.INT,NotNull:true},Order:1},
+				{Id:db.ColumnID(2),ColumnConfig:db.ColumnConfig{Name:"name",Type:db.VARCHAR},Order:2},
+				{Id:db.ColumnID(3),ColumnConfig:db.ColumnConfig{Name:"age",Type:db.INT,NotNull:true},Order:3},
+			},
+			PrimaryKey:db.PrimaryKey{ColumnID:db.ColumnID(1)}}
+		_,err := databaseImpl.CreateTableData(importTable); if err != nil {
+			panic(err.Error())
+		}
+		queryRow := func(rowID db.RowID) db.JsonData {
+			jsonData,err := operation.QueryRow(&db.Table{Data:importTable,Primary:&importTable.Columns[0]}, rowID); if err != nil {
+				panic(err.Error())
+			}
+			return jsonData
+		}
+		data := "id,full_name,age\n1,a,10\n2,b,x\n3,c,\\N\n4,\"d,d\",40\n5,e,50\n"
+		config := ImportConfig{MaxKeys:2,Columns:map[string]string{"full_name":"name"}}
+		rejected := make([]ImportRejected, 0)
+		imported,batches := int64(0),0
+		for {
+			result,err := operation.Import(importTable.Name, strings.NewReader(data), config); if err != nil {
+				panic(err.Error())
+			}
+			batches++
+			imported += result.Imported
+			rejected = append(rejected, result.Rejected...)
+			config.Checkpoint = result.Checkpoint
+			if result.Done {
+				break
+			}
+		}
+		assert.EqualValues(t, 3, imported, "csv import rows error")
+		assert.True(t, batches >= 3, "csv import batch error")
+		assert.EqualValues(t, 5, config.Checkpoint.Record, "csv import checkpoint error")
+		assert.Equal(t, 2, len(rejected), "csv import rejected error")
+		assert.EqualValues(t, 2, rejected[0].Record, "csv rejected record error")
+		assert.EqualValues(t, 3, rejected[1].Record, "csv rejected record error")
+		assert.Equal(t, "d,d", queryRow(4)["name"], "csv import data error")
+		//未知列
+		_,err = operation.Import(importTable.Name, strings.NewReader("id,unknown\n6,f\n"), ImportConfig{})
+		assert.NotNil(t, err, "csv unknown column error")
+		//NDJSON
+		ndjson := `{"id":"6","name":"f","age":"60"}` + "\n\n" + `{"id":"7","unknown":1}` + "\n" + `{"id":"1","name":"g","age":"11"}` + "\n"
+		resultBytes,err := operation.ImportBytes(importTable.Name, ndjson, `{"format":"ndjson","upsert":true}`); if err != nil {
+			panic(err.Error())
+		}
+		var result ImportResult
+		if err := json.Unmarshal(resultBytes, &result); err != nil {
+			panic(err.Error())
+		}
+		assert.True(t, result.Done, "ndjson import done error")
+		assert.EqualValues(t, 2, result.Imported, "ndjson import rows error")
+		assert.Equal(t, 1, len(result.Rejected), "ndjson import rejected error")
+		assert.Equal(t, "f", queryRow(6)["name"], "ndjson import data error")
+		assert.Equal(t, "g", queryRow(1)["name"], "ndjson upsert data error")
+		//同一批中主键重复的记录拒绝，Upsert时在下一批中修改
+		result3,err := operation.Import(importTable.Name, strings.NewReader("id,name,age\n11,a,1\n11,b,2\n12,c,3\n"), ImportConfig{}); if err != nil {
+			panic(err.Error())
+		}
+		assert.True(t, result3.Done, "csv duplicated done error")
+		assert.EqualValues(t, 2, result3.Imported, "csv duplicated rows error")
+		assert.Equal(t, []db.RowID{11,12}, result3.RowIDs, "csv duplicated row ids error")
+		assert.Len(t, result3.Rejected, 1, "csv duplicated rejected error")
+		assert.EqualValues(t, 2, result3.Rejected[0].Record, "csv duplicated rejected record error")
+		assert.Equal(t, "a", queryRow(11)["name"], "csv duplicated data error")
+		config = ImportConfig{Upsert:true}
+		data = "id,name,age\n13,a,1\n13,b,2\n14,c,3\n"
+		batches,imported = 0,0
+		for {
+			result,err := operation.Import(importTable.Name, strings.NewReader(data), config); if err != nil {
+				panic(err.Error())
+			}
+			assert.Empty(t, result.Rejected, "csv upsert duplicated rejected error")
+			batches++
+			imported += result.Imported
+			config.Checkpoint = result.Checkpoint
+			if result.Done {
+				break
+			}
+		}
+		assert.Equal(t, 2, batches, "csv upsert duplicated batch error")
+		assert.EqualValues(t, 3, imported, "csv upsert duplicated rows error")
+		assert.Equal(t, "b", queryRow(13)["name"], "csv upsert duplicated data error")
+		//超出长度上限的行无法跳过，先导入之前的记录，之后的批次返回错误
+		ndjson = `{"id":"8","name":"h","age":"80"}` + "\n" + `{"id":"9","name":"` + strings.Repeat("x", DefaultImportMaxBytes) + `","age":"90"}` + "\n" + `{"id":"10","name":"j","age":"100"}` + "\n"
+		config = ImportConfig{Format:ImportFormatNDJSON}
+		result2,err := operation.Import(importTable.Name, strings.NewReader(ndjson), config); if err != nil {
+			panic(err.Error())
+		}
+		assert.False(t, result2.Done, "ndjson oversized line done error")
+		assert.EqualValues(t, 1, result2.Imported, "ndjson oversized line rows error")
+		assert.EqualValues(t, 1, result2.Checkpoint.Record, "ndjson oversized line checkpoint error")
+		assert.Equal(t, "h", queryRow(8)["name"], "ndjson oversized line data error")
+		config.Checkpoint = result2.Checkpoint
+		_,err = operation.Import(importTable.Name, strings.NewReader(ndjson), config)
+		assert.NotNil(t, err, "ndjson oversized line error")
+		_,err = operation.Import(importTable.Name, strings.NewReader(ndjson), ImportConfig{Format:ImportFormatNDJSON,Checkpoint:ImportCheckpoint{Record:2}})
+		assert.NotNil(t, err, "ndjson oversized line skip error")
+	}
+	//游标分页
+	{
+		pageTable := &db.TableData{Name:"TestPageTable",
+			Columns:[]db.Column{
+				{Id:db.ColumnID(1),ColumnConfig:db.ColumnConfig{Name:"id",Type:db.INT,NotNull:true},Order:1},
+				{Id:db.ColumnID(2),ColumnConfig:db.ColumnConfig{Name:"name",Type:db.VARCHAR},Order:2},
+			},
+			PrimaryKey:db.PrimaryKey{ColumnID:db.ColumnID(1),AutoIncrement:true}}
+		tableID,err := databaseImpl.CreateTableData(pageTable); if err != nil {
+			panic(err.Error())
+		}
+		pageTable.Id = tableID
+		for i:=0;i<25;i++ {
+			if _,err := operation.Add(pageTable.Name, fmt.Sprintf(`[{"name":"n%d"}]`, i)); err != nil {
+				panic(err.Error())
+			}
+		}
+		table := &db.Table{Data:pageTable,Primary:&pageTable.Columns[0]}
+		var rowNames []interface{}
+		cursor := ""
+		pages := 0
+		for {
+			pagination,err := operation.QueryRowWithPagination(table, db.RowID(0), db.RowID(0), db.DESC,10, cursor); if err != nil {
+				panic(err.Error())
+			}
+			for _,rowJson := range pagination.List {
+				rowNames = append(rowNames, rowJson["name"])
+			}
+			pages++
+			if pagination.Cursor == "" {
+				break
+			}
+			cursor = pagination.Cursor
+		}
+		assert.Equal(t, 3, pages, "cursor pages error")
+		assert.Len(t, rowNames, 25, "cursor rows error")
+		assert.Equal(t, "n24", rowNames[0], "cursor first row error")
+		assert.Equal(t, "n0", rowNames[24], "cursor last row error")
+		//区间分页不统计总数
+		rangeCursor := ""
+		totals := make([]db.Total, 0)
+		rangeRows := 0
+		for {
+			pagination,err := operation.QueryRowWithPagination(table, db.RowID(3), db.RowID(22), db.ASC,8, rangeCursor); if err != nil {
+				panic(err.Error())
+			}
+			totals = append(totals, pagination.Total)
+			rangeRows += len(pagination.List)
+			if pagination.Cursor == "" {
+				break
+			}
+			pageCursor,err := util.DecodeCursor(pagination.Cursor); if err != nil {
+				panic(err.Error())
+			}
+			assert.EqualValues(t, db.UnknownTotal, pageCursor.Total, "cursor total error")
+			rangeCursor = pagination.Cursor
+		}
+		assert.Equal(t, []db.Total{db.UnknownTotal,db.UnknownTotal,db.UnknownTotal}, totals, "cursor range total error")
+		assert.Equal(t, 20, rangeRows, "cursor range rows error")
+		rangeCount,err := operation.QueryRowCount(pageTable.Name, db.RowID(3), db.RowID(22)); if err != nil {
+			panic(err.Error())
+		}
+		assert.EqualValues(t, 20, rangeCount, "cursor range count error")
+		_,err = operation.QueryRowWithPagination(table, db.RowID(0), db.RowID(0), db.ASC,10, cursor)
+		assert.NotNil(t, err, "cursor order error")
+		_,err = operation.QueryRowWithPagination(table, db.RowID(0), db.RowID(0), db.DESC,10, "!")
+		assert.NotNil(t, err, "cursor token error")
+		//行历史版本(链表)分页
+		for i:=0;i<40;i++ {
+			if _,err := operation.Update(pageTable.Name, fmt.Sprintf(`[{"id":"1","name":"v%d"}]`, i)); err != nil {
+				panic(err.Error())
+			}
+		}
+		historyOperation := history.NewHistoryOperation(databaseImpl)
+		var names []interface{}
+		cursor = ""
+		for {
+			pagination,err := historyOperation.QueryRowHistoryWithPagination(table, db.RowID(1), db.DESC,7, cursor); if err != nil {
+				panic(err.Error())
+			}
+			assert.EqualValues(t, 41, pagination.Total, "cursor history total error")
+			for _,historyJson := range pagination.List {
+				names = append(names, historyJson["data"].(db.JsonData)["name"])
+			}
+			if pagination.Cursor == "" {
+				break
+			}
+			cursor = pagination.Cursor
+		}
+		assert.Len(t, names, 41, "cursor history error")
+		assert.Equal(t, "v39", names[0], "cursor history latest error")
+		assert.Equal(t, "v0", names[39], "cursor history version error")
+		assert.Equal(t, "n0", names[40], "cursor history first error")
+	}
+	//行数量，删除后重新新增，同一批次重复删除只计算一次
+	{
+		countTable := &db.TableData{Name:"TestCountTable",
+			Columns:[]db.Column{
+				{Id:db.ColumnID(1),ColumnConfig:db.ColumnConfig{Name:"id",Type:db.INT,NotNull:true},Order:1},
+				{Id:db.ColumnID(2),ColumnConfig:db.ColumnConfig{Name:"name",Type:db.VARCHAR},Order:2},
+			},
+			PrimaryKey:db.PrimaryKey{ColumnID:db.ColumnID(1),AutoIncrement:true}}
+		tableID,err := databaseImpl.CreateTableData(countTable); if err != nil {
+			panic(err.Error())
+		}
+		countTable.Id = tableID
+		for i:=0;i<10;i++ {
+			if _,err := operation.Add(countTable.Name, fmt.Sprintf(`[{"name":"c%d"}]`, i)); err != nil {
+				panic(err.Error())
+			}
+		}
+		if _,err := operation.Delete(countTable.Name, []db.RowID{3,4}); err != nil {
+			panic(err.Error())
+		}
+		if _,err := operation.Upsert(countTable.Name, `[{"id":"3","name":"r3"}]`); err != nil {
+			panic(err.Error())
+		}
+		if _,err := operation.Delete(countTable.Name, []db.RowID{5,5}); err != nil {
+			panic(err.Error())
+		}
+		table := &db.Table{Data:countTable,Primary:&countTable.Columns[0]}
+		pagination,err := operation.QueryRowWithPagination(table, db.RowID(0), db.RowID(0), db.ASC,5, ""); if err != nil {
+			panic(err.Error())
+		}
+		assert.EqualValues(t, 8, pagination.Total, "pagination total error")
+		pagination,err = operation.QueryRowWithPagination(table, db.RowID(6), db.RowID(2), db.DESC,5, ""); if err != nil {
+			panic(err.Error())
+		}
+		assert.EqualValues(t, db.UnknownTotal, pagination.Total, "pagination range total error")
+		count,err := operation.QueryRowCount(countTable.Name, db.RowID(4), db.RowID(0)); if err != nil {
+			panic(err.Error())
+		}
+		assert.EqualValues(t, 5, count, "row count error")
+	}
+}
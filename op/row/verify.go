@@ -11,17 +11,17 @@ import (
 	行数据为空
  */
 func (operation *RowOperation) validateNull(table *db.TableData, rowID db.RowID) error {
-	blockID,err := operation.iDatabase.QueryRowBlockID(table, rowID); if err != nil {
+	exists,err := operation.rowExists(table, rowID); if err != nil {
 		return err
 	}
-	if blockID == 0 {
+	if !exists {
 		return fmt.Errorf("row `%d` not exists in table `%s`", rowID, table.Name)
 	}
 	return nil
 }
 
 /**
-	行数据已存在
+	行数据已存在，已删除的行也不能新增(使用UPSERT重新新增)
 */
 func (operation *RowOperation) validateExists(table *db.TableData, rowID db.RowID) error {
 	blockID,err := operation.iDatabase.QueryRowBlockID(table, rowID); if err != nil {
@@ -33,6 +33,30 @@ func (operation *RowOperation) validateExists(table *db.TableData, rowID db.RowI
 	return nil
 }
 
+/**
+	行存在并且最新版本不是删除
+*/
+func (operation *RowOperation) rowExists(table *db.TableData, rowID db.RowID) (bool,error) {
+	version,err := operation.iDatabase.QueryRowVersion(table, rowID); if err != nil {
+		return false,err
+	}
+	return version.BlockID > 0 && version.Op != db.DELETE,nil
+}
+
+/**
+	验证行存在并且最新版本不是删除，返回最新版本(修改的增量版本以最新版本为基础)
+	已删除的行块ID不为0，只按块ID判断会在删除版本上写入增量
+*/
+func (operation *RowOperation) validateNullOfVersion(table *db.TableData, rowID db.RowID) (*db.RowVersion,error) {
+	version,err := operation.iDatabase.QueryRowVersion(table, rowID); if err != nil {
+		return nil,err
+	}
+	if version.BlockID == 0 || version.Op == db.DELETE {
+		return nil,fmt.Errorf("row `%d` is null in table `%s`", rowID, table.Name)
+	}
+	return version,nil
+}
+
 /**
 	验证表中行数据必须不为空，并获取行数据
 */
@@ -59,9 +83,48 @@ func (operation *RowOperation) validateExistsByForeignKey(table *db.TableData, f
 	return nil
 }
 
+/**
+	列名转换为投影列ID，投影列包含主键列，columnNames为空时返回空(全部列)
+ */
+func (operation *RowOperation) validateColumns(table *db.Table, columnNames []string) ([]db.ColumnID,error) {
+	if len(columnNames) == 0 {
+		return nil,nil
+	}
+	columns := make([]db.ColumnID, 0, len(columnNames)+1)
+	columns = append(columns, table.Primary.Id)
+	for _,name := range columnNames {
+		column,err := operation.findColumn(table, name); if err != nil {
+			return nil,err
+		}
+		columns = append(columns, column.Id)
+	}
+	return columns,nil
+}
+
+/**
+	json数据中的主键行ID，未设置时为0(自增)
+ */
+func rowJsonID(table *db.Table, rowJson db.JsonData) (db.RowID,error) {
+	id,exists := rowJson[table.Primary.Name]
+	if !exists {
+		return 0,nil
+	}
+	return util.ConvertRowID(id)
+}
+
+func (operation *RowOperation) findColumn(table *db.Table, name string) (*db.Column,error) {
+	for i,column := range table.Data.Columns {
+		if column.Name == name && !column.IsDeleted {
+			return &table.Data.Columns[i],nil
+		}
+	}
+	return nil,fmt.Errorf("column `%s` not found in table `%s`", name, table.Data.Name)
+}
+
 /**
 	json数据格式化行数据(新增、修改、删除操作)
 	1、验证数据类型，2、序列化数据，3、验证外建约束，4、列数据组装成行
+	UPSERT按行是否存在转换为ADD或UPDATE，已删除的行重新新增，版本数在删除版本之后继续累加
 */
 func (operation *RowOperation) FormatRowData(table *db.Table, rowJson db.JsonData, op db.OpType) (*row.RowData,error) {
 	primaryColumn := table.Data.Columns[table.Data.PrimaryKey.ColumnID-1]
@@ -73,11 +136,34 @@ func (operation *RowOperation) FormatRowData(table *db.Table, rowJson db.JsonDat
 			return  nil,err
 		}
 	}
+	readd := false
+	if op == db.UPSERT {
+		op = db.ADD
+		if rowID > 0 {
+			exists,err := operation.rowExists(table.Data, rowID); if err != nil {
+				return nil,err
+			}
+			if exists {
+				op = db.UPDATE
+			}else{
+				readd = true
+			}
+		}
+	}
 	rowData := &row.RowData{Id: rowID, Op:uint32(op)}
-	if op == db.UPDATE || op == db.DELETE {
-		if rowData.Id == 0 {
-			return nil,fmt.Errorf("update or delete row must rowID")
+	if (op == db.UPDATE || op == db.DELETE) && rowData.Id == 0 {
+		return nil,fmt.Errorf("update or delete row must rowID")
+	}
+	if op == db.UPDATE {//修改只写入修改的列，不读取原行数据
+		version,err := operation.validateNullOfVersion(table.Data, rowData.Id); if err != nil {
+			return nil,err
 		}
+		rowData.Base = int32(version.BlockID)
+		if err := operation.formatDeltaRowData(table, rowJson, rowData); err != nil {
+			return nil,err
+		}
+		return rowData,nil
+	}else if op == db.DELETE {
 		oldRow,err := operation.validateNullOfData(table.Data, rowData.Id); if err != nil {
 			return nil,err
 		}
@@ -86,14 +172,14 @@ func (operation *RowOperation) FormatRowData(table *db.Table, rowJson db.JsonDat
 	}else if op == db.ADD {
 		if rowData.Id == 0 && !table.Data.PrimaryKey.AutoIncrement {//非自增
 			return nil,fmt.Errorf("add row must rowID or set autoIncrement=true")
-		}else{
+		}else if !readd {
 			if err := operation.validateExists(table.Data, rowData.Id); err != nil {
 				return nil,err
 			}
 		}
 	}
 
-	if op == db.ADD || op == db.UPDATE {
+	if op == db.ADD {
 		if err := operation.formatAddOrUpdateRowData(table, rowJson, rowData); err != nil {
 			return nil,err
 		}
@@ -114,6 +200,7 @@ func (operation *RowOperation) formatAddOrUpdateRowData(table *db.Table, rowJson
 	if len(table.Data.Columns) > len(rowData.Columns) {
 		adds = make([]*row.ColumnData, 0, len(table.Data.Columns)-len(rowData.Columns))
 	}
+	var blobs []*row.ColumnData
 	for i,column := range table.Data.Columns {
 		columnData := &row.ColumnData{}
 		if i < len(rowData.Columns) {//获取原行中列值
@@ -121,25 +208,14 @@ func (operation *RowOperation) formatAddOrUpdateRowData(table *db.Table, rowJson
 		}
 		if column.Id != table.Data.PrimaryKey.ColumnID && !column.IsDeleted { //过滤主键和删除列
 			value, ok := rowJson[column.Name] //匹配待写入列值
-			if ok { //待写入列值序列化字节
+			if ok || columnData.Data == nil { //待写入列值或未设置值
+				var blob bool
 				var err error
-				columnData.Data, err = util.FormatColumnData(column, value);
-				if err != nil {
+				columnData.Data, blob, err = operation.formatColumnValue(table, column, value); if err != nil {
 					return err
 				}
-			}
-			if columnData.Data == nil { //未设置值验证必填或设置默认值
-				if column.NotNull { //是否必填
-					return fmt.Errorf("table `%s` column `%s` value is not null", table.Data.Name, column.Name)
-				} else { //默认值
-					columnData.Data = column.Default
-					ok = true // 有默认值设置为待写入
-				}
-			}
-			//外建约束验证
-			if ok { //待写入列值需要验证
-				if err := operation.verifyForeignKey(table, column.Id, util.BytesToRowID(columnData.Data)); err != nil {
-					return err
+				if blob {
+					blobs = append(blobs, columnData)
 				}
 			}
 		}
@@ -153,6 +229,69 @@ func (operation *RowOperation) formatAddOrUpdateRowData(table *db.Table, rowJson
 	if len(adds) > 0 {
 		rowData.Columns = append(rowData.Columns, adds...)
 	}
+	return operation.putBlobColumns(blobs)
+}
+
+/**
+	格式化增量行数据，只包含json中修改的列，Delta为列下标
+ */
+func (operation *RowOperation) formatDeltaRowData(table *db.Table, rowJson db.JsonData, rowData *row.RowData) error {
+	var blobs []*row.ColumnData
+	for i,column := range table.Data.Columns {
+		if column.Id == table.Data.PrimaryKey.ColumnID || column.IsDeleted { //过滤主键和删除列
+			continue
+		}
+		value, ok := rowJson[column.Name]
+		if !ok {
+			continue
+		}
+		data,blob,err := operation.formatColumnValue(table, column, value); if err != nil {
+			return err
+		}
+		columnData := &row.ColumnData{Data:data}
+		if blob {
+			blobs = append(blobs, columnData)
+		}
+		rowData.Columns = append(rowData.Columns, columnData)
+		rowData.Delta = append(rowData.Delta, uint32(i))
+	}
+	return operation.putBlobColumns(blobs)
+}
+
+/**
+	格式化待写入列值：序列化字节，未设置值验证必填或设置默认值，验证外建约束
+	blob为true时列值为大字段内容，行的所有列验证通过后写入块外(putBlobColumns)，验证失败的行不写入大字段分片
+ */
+func (operation *RowOperation) formatColumnValue(table *db.Table, column db.Column, value interface{}) ([]byte,bool,error) {
+	data, err := util.FormatColumnData(column, value); if err != nil {
+		return nil,false,err
+	}
+	if db.IsBlobType(column.Type) && len(data) > 0 {
+		return data,true,nil
+	}
+	if data == nil { //未设置值验证必填或设置默认值
+		if column.NotNull { //是否必填
+			return nil,false,fmt.Errorf("table `%s` column `%s` value is not null", table.Data.Name, column.Name)
+		}
+		data = column.Default
+	}
+	//外建约束验证
+	if err := operation.verifyForeignKey(table, column.Id, util.BytesToRowID(data)); err != nil {
+		return nil,false,err
+	}
+	return data,false,nil
+}
+
+/**
+	大字段值写入块外，列中保存引用
+ */
+func (operation *RowOperation) putBlobColumns(blobs []*row.ColumnData) error {
+	for _,columnData := range blobs {
+		reference,err := operation.iDatabase.PutBlobData(columnData.Data); if err != nil {
+			return err
+		}
+		columnData.Data = reference
+	}
 	return nil
 }
 
@@ -162,10 +301,10 @@ func (operation *RowOperation) formatAddOrUpdateRowData(table *db.Table, rowJson
 func (operation *RowOperation) verifyForeignKey(table *db.Table, columnID db.ColumnID, referenceRowID db.RowID) error {
 	foreignKey,exists := table.ForeignKeys[columnID]
 	if exists {
-		tableName,err := operation.iDatabase.GetTableName(foreignKey.Reference.TableID); if err != nil {
+		referenceTable,err := operation.iDatabase.QueryTableDataByID(foreignKey.Reference.TableID); if err != nil {//引用表主键索引可能分片，需要完整的表配置
 			return err
 		}
-		referenceTable := &db.TableData{Id:foreignKey.Reference.TableID,Name:tableName,PrimaryKey:db.PrimaryKey{ColumnID:foreignKey.Reference.ColumnID}}
+		referenceTable.Id = foreignKey.Reference.TableID
 		if err := operation.validateNull(referenceTable, referenceRowID); err != nil {
 			return fmt.Errorf("foreignKey foreign table `%s` and reference Table `%s` (add or update row `%d` in table `%s` error `%s`)", table.Data.Name, referenceTable.Name, referenceRowID, table.Data.Name, err.Error())
 		}
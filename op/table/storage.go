@@ -0,0 +1,72 @@
+package table
+
+import (
+	"fmt"
+	"github.com/database-fabric/db"
+	"github.com/database-fabric/db/index/tree"
+)
+
+var splitRuleNames = map[int8]string{
+	db.SplitRuleKeyNum:"keyNum",
+	db.SplitRuleSize:"size",
+}
+
+var compressionNames = map[int8]string{
+	db.CompressionNone:"none",
+	db.CompressionSnappy:"snappy",
+}
+
+/**
+	格式化存储配置，自增主键的表只会在末尾追加，未指定树类型时使用升序树(右侧分裂)
+	统计分片的表各分片交替分配行ID，并且可以指定ID跳过自增，不保证只在末尾追加，不使用升序树
+ */
+func FormatStorage(storage Storage, autoIncrement bool, tallyShards int8) (db.StorageConfig,error) {
+	config := db.StorageConfig{BlockSize:storage.BlockSize,NodeSize:storage.NodeSize}
+	if storage.BlockSize != 0 && (storage.BlockSize < db.MinBlockSize || storage.BlockSize > db.MaxBlockSize) {
+		return config,fmt.Errorf("blockSize must between %d and %d", db.MinBlockSize, db.MaxBlockSize)
+	}
+	if storage.NodeSize != 0 && (storage.NodeSize < db.MinNodeSize || storage.NodeSize > db.MaxNodeSize) {
+		return config,fmt.Errorf("nodeSize must between %d and %d", db.MinNodeSize, db.MaxNodeSize)
+	}
+	if storage.SplitRule != "" {
+		config.SplitRule = -1
+		for splitRule,name := range splitRuleNames {
+			if name == storage.SplitRule {
+				config.SplitRule = splitRule
+			}
+		}
+		if config.SplitRule < 0 {
+			return config,fmt.Errorf("splitRule `%s` error", storage.SplitRule)
+		}
+	}
+	if storage.Compression != "" {
+		config.Compression = -1
+		for compression,name := range compressionNames {
+			if name == storage.Compression {
+				config.Compression = compression
+			}
+		}
+		if config.Compression < 0 {
+			return config,fmt.Errorf("compression `%s` not supported", storage.Compression)
+		}
+	}
+	if storage.TreeType != "" {
+		treeType,err := tree.ParseTreeType(storage.TreeType); if err != nil {
+			return config,err
+		}
+		config.TreeType = int8(treeType)
+	}else if autoIncrement && tallyShards <= 1 {
+		config.TreeType = int8(tree.TreeTypeAsc)
+	}
+	return config,nil
+}
+
+func ParseStorage(config db.StorageConfig) Storage {
+	return Storage{
+		BlockSize:config.BlockSize,
+		NodeSize:config.NodeSize,
+		SplitRule:splitRuleNames[config.SplitRule],
+		TreeType:tree.TreeType(config.TreeType).String(),
+		Compression:compressionNames[config.Compression],
+	}
+}
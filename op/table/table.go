@@ -20,6 +20,25 @@ type Data struct {
 	Columns []db.ColumnConfig `json:"columns"`
 	PrimaryKey PrimaryKey `json:"primaryKey"`
 	ForeignKeys []ForeignKey `json:"foreignKeys"`
+	TallyShards int8 `json:"tallyShards"` //统计分片数，高并发写入的表可设置，减少事务冲突
+	Storage Storage `json:"storage"` //存储配置，创建后不可修改
+	Retention db.RetentionConfig `json:"retention"` //数据保留策略，压缩时按该策略清除行版本
+	Counters []Counter `json:"counters"` //物化计数器，创建后不可修改
+}
+
+//物化计数器，如按user分组的amount合计：{"name":"userAmount","column":"amount","groupBy":"user"}
+type Counter struct {
+	Name string `json:"name"`
+	Column string `json:"column"` //求和列(INT或DECIMAL)，为空时只计数
+	GroupBy string `json:"groupBy"` //分组列(INT)，为空时不分组
+}
+
+type Storage struct {
+	BlockSize int32 `json:"blockSize"` //数据块容量，为空使用默认4KB
+	NodeSize int32 `json:"nodeSize"` //索引节点容量，为空使用默认4KB
+	SplitRule string `json:"splitRule"` //索引节点分裂规则：keyNum或size，为空使用默认规则
+	TreeType string `json:"treeType"` //主键索引树类型：default、asc、desc、descMid，为空时自增主键使用asc
+	Compression string `json:"compression"` //数据块压缩方式：none、snappy，为空不压缩
 }
 
 type PrimaryKey struct {
@@ -55,7 +74,24 @@ func (operation *TableOperation) DeleteTable(tableName string) (db.TableID,error
 	if len(relationKeys) > 0 {
 		return 0,fmt.Errorf("table reference key must is null")
 	}
-	return tableData.Id,operation.iDatabase.DeleteTableData(tableData.Id)
+	if err := operation.iDatabase.DeleteTableData(tableData.Id); err != nil {
+		return 0,err
+	}
+	//同一事务中清除第一批Key，未完成时使用DropTable继续
+	if _,err := operation.iDatabase.DropTable(tableData.Id,0); err != nil {
+		return 0,err
+	}
+	return tableData.Id,nil
+}
+
+/**
+	继续清除已删除表的Key，每次执行一批，返回墓碑的done为false时在新的事务中继续
+ */
+func (operation *TableOperation) DropTable(tableID db.TableID) ([]byte,error) {
+	drop,err := operation.iDatabase.DropTable(tableID,0); if err != nil {
+		return nil,err
+	}
+	return util.ConvertJsonBytes(*drop)
 }
 
 func (operation *TableOperation) QueryTableData(tableName string) ([]byte,error) {
@@ -65,12 +101,185 @@ func (operation *TableOperation) QueryTableData(tableName string) ([]byte,error)
 	return util.ConvertJsonBytes(*tableData)
 }
 
+/**
+	校验表数据块完整性，返回校验结果(breaks为空表示表历史完整)
+ */
+func (operation *TableOperation) VerifyTable(tableName string) ([]byte,error) {
+	tableID,err := ValidateNullOfID(tableName, operation.iDatabase); if err != nil {
+		return nil,err
+	}
+	verify,err := operation.iDatabase.VerifyTable(tableID); if err != nil {
+		return nil,err
+	}
+	return util.ConvertJsonBytes(*verify)
+}
+
+//表一致性检查结果，Repaired为已执行的修复
+type CheckResult struct {
+	Check *db.TableCheck `json:"check"`
+	Repairs []db.TableRepair `json:"repairs"`
+	Repaired []db.TableRepair `json:"repaired"`
+}
+
+/**
+	检查表索引、数据块和统计的一致性(issues为空表示表一致)，repair为true时执行可自动修复的操作
+ */
+func (operation *TableOperation) CheckTable(tableName string, repair bool) ([]byte,error) {
+	tableID,err := ValidateNullOfID(tableName, operation.iDatabase); if err != nil {
+		return nil,err
+	}
+	check,err := operation.iDatabase.CheckTable(tableID); if err != nil {
+		return nil,err
+	}
+	result := CheckResult{Check:check,Repairs:check.Repairs(),Repaired:[]db.TableRepair{}}
+	if repair && len(result.Repairs) > 0 {
+		result.Repaired,err = operation.iDatabase.RepairTable(tableID, result.Repairs); if err != nil {
+			return nil,err
+		}
+	}
+	return util.ConvertJsonBytes(result)
+}
+
+/**
+	按块数据重建列索引(主键或外键列)，checkpointJson为上一批返回的位置，为空时开始重建
+	每次执行一批，返回位置的done为false时在新的事务中继续
+ */
+func (operation *TableOperation) RebuildIndex(tableName string, columnName string, checkpointJson string) ([]byte,error) {
+	table,err := ValidateNullOfData(tableName, operation.iDatabase); if err != nil {
+		return nil,err
+	}
+	var checkpoint db.RebuildCheckpoint
+	if checkpointJson != "" {
+		if err := json.Unmarshal([]byte(checkpointJson), &checkpoint); err != nil {
+			return nil,fmt.Errorf("rebuild checkpoint json %s", err)
+		}
+	}
+	column,err := indexColumnID(table, columnName); if err != nil {
+		return nil,err
+	}
+	result,err := operation.iDatabase.RebuildIndex(table.Data.Id, column, checkpoint,0); if err != nil {
+		return nil,err
+	}
+	return util.ConvertJsonBytes(*result)
+}
+
+/**
+	列索引统计，columnName为主键或外键列
+ */
+func (operation *TableOperation) QueryIndexStats(tableName string, columnName string) ([]byte,error) {
+	table,err := ValidateNullOfData(tableName, operation.iDatabase); if err != nil {
+		return nil,err
+	}
+	column,err := indexColumnID(table, columnName); if err != nil {
+		return nil,err
+	}
+	stats,err := operation.iDatabase.QueryIndexStats(table.Data.Id, column); if err != nil {
+		return nil,err
+	}
+	return util.ConvertJsonBytes(*stats)
+}
+
+/**
+	物化计数器一个分组的值，没有分组列的计数器group为0
+ */
+func (operation *TableOperation) QueryCounter(tableName string, counterName string, group int64) ([]byte,error) {
+	table,err := ValidateNullOfData(tableName, operation.iDatabase); if err != nil {
+		return nil,err
+	}
+	value,err := operation.iDatabase.QueryCounter(table.Data.Id, counterName, group); if err != nil {
+		return nil,err
+	}
+	return util.ConvertJsonBytes(*value)
+}
+
+/**
+	物化计数器分组值区间[start,end]中的分组，按分组值升序最多返回size个
+ */
+func (operation *TableOperation) QueryCounterByRange(tableName string, counterName string, start int64, end int64, size int32) ([]byte,error) {
+	table,err := ValidateNullOfData(tableName, operation.iDatabase); if err != nil {
+		return nil,err
+	}
+	values,err := operation.iDatabase.QueryCounterByRange(table.Data.Id, counterName, start, end, size); if err != nil {
+		return nil,err
+	}
+	return util.ConvertJsonBytes(values)
+}
+
+func indexColumnID(table *db.Table, columnName string) (db.ColumnID,error) {
+	for _,columnData := range table.Data.Columns {
+		if !columnData.IsDeleted && columnData.Name == columnName {
+			return columnData.Id,nil
+		}
+	}
+	return 0,fmt.Errorf("column `%s` not found in table `%s`", columnName, table.Data.Name)
+}
+
+/**
+	修改表数据保留策略，retentionJson为RetentionConfig，0值不限制，修改后压缩时生效
+ */
+func (operation *TableOperation) SetRetention(tableName string, retentionJson string) error {
+	table,err := ValidateNullOfData(tableName, operation.iDatabase); if err != nil {
+		return err
+	}
+	var retention db.RetentionConfig
+	if err := json.Unmarshal([]byte(retentionJson), &retention); err != nil {
+		return fmt.Errorf("retention json %s", err)
+	}
+	tableData := *table.Data
+	tableData.Retention = retention
+	return operation.iDatabase.UpdateTableData(&tableData)
+}
+
+/**
+	按表保留策略压缩数据块，清除的版本和行从块中物理删除(用于数据删除请求)
+	checkpointJson为上一批返回的位置，为空时开始压缩，每次执行一批，返回位置的done为false时在新的事务中继续
+ */
+func (operation *TableOperation) CompactTable(tableName string, checkpointJson string) ([]byte,error) {
+	table,err := ValidateNullOfData(tableName, operation.iDatabase); if err != nil {
+		return nil,err
+	}
+	var checkpoint db.CompactCheckpoint
+	if checkpointJson != "" {
+		if err := json.Unmarshal([]byte(checkpointJson), &checkpoint); err != nil {
+			return nil,fmt.Errorf("compact checkpoint json %s", err)
+		}
+	}
+	result,err := operation.iDatabase.CompactTable(table.Data.Id, checkpoint,0); if err != nil {
+		return nil,err
+	}
+	return util.ConvertJsonBytes(*result)
+}
+
+/**
+	表结构和索引从旧JSON编码迁移为二进制编码，checkpointJson为上一批返回的位置，为空时开始迁移
+	每次执行一批，返回位置的done为false时在新的事务中继续
+ */
+func (operation *TableOperation) MigrateTable(tableName string, checkpointJson string) ([]byte,error) {
+	table,err := ValidateNullOfData(tableName, operation.iDatabase); if err != nil {
+		return nil,err
+	}
+	var checkpoint db.TableMigrateCheckpoint
+	if checkpointJson != "" {
+		if err := json.Unmarshal([]byte(checkpointJson), &checkpoint); err != nil {
+			return nil,fmt.Errorf("migrate checkpoint json %s", err)
+		}
+	}
+	result,err := operation.iDatabase.MigrateTableData(table.Data.Id, checkpoint,0); if err != nil {
+		return nil,err
+	}
+	return util.ConvertJsonBytes(*result)
+}
+
 func (operation *TableOperation) ParseTableData(table *db.Table) (Data,error) {
 	data := Data{
 		Name:table.Data.Name,
 		Columns:make([]db.ColumnConfig, 0, len(table.Data.Columns)),
 		PrimaryKey:PrimaryKey{ColumnName:table.Primary.Name,AutoIncrement:table.Data.PrimaryKey.AutoIncrement},
 		ForeignKeys:make([]ForeignKey,0 , len(table.Data.ForeignKeys)),
+		TallyShards:table.Data.TallyShards,
+		Storage:ParseStorage(table.Data.Storage),
+		Retention:table.Data.Retention,
+		Counters:make([]Counter, 0, len(table.Data.Counters)),
 	}
 	columnMaps := make(map[db.ColumnID]string, len(table.Data.Columns))
 	for _,column := range table.Data.Columns {
@@ -89,6 +298,9 @@ func (operation *TableOperation) ParseTableData(table *db.Table) (Data,error) {
 			data.ForeignKeys = append(data.ForeignKeys, ForeignKey{ColumnName:columnName,Reference:tableName})
 		}
 	}
+	for _,counter := range table.Data.Counters {
+		data.Counters = append(data.Counters, Counter{Name:counter.Name,Column:columnMaps[counter.Column],GroupBy:columnMaps[counter.GroupBy]})
+	}
 	return data,nil
 }
 
@@ -109,6 +321,12 @@ func (operation *TableOperation) FormatTableData(jsonString string) (*db.TableDa
 	if data.PrimaryKey.ColumnName == "" {
 		return nil,fmt.Errorf("primaryKey column is null")
 	}
+	if data.TallyShards < 0 || data.TallyShards > db.MaxTallyShards {
+		return nil,fmt.Errorf("tallyShards must between 0 and %d", db.MaxTallyShards)
+	}
+	storage,err := FormatStorage(data.Storage, data.PrimaryKey.AutoIncrement, data.TallyShards); if err != nil {
+		return nil,err
+	}
 	if err := ValidateExists(data.Name, operation.iDatabase); err != nil {
 		return nil,err
 	}
@@ -116,6 +334,9 @@ func (operation *TableOperation) FormatTableData(jsonString string) (*db.TableDa
 		Name:data.Name,
 		Columns:make([]db.Column, 0, len(data.Columns)),
 		ForeignKeys:make([]db.ForeignKey, 0, len(data.ForeignKeys)),
+		TallyShards:data.TallyShards,
+		Storage:storage,
+		Retention:data.Retention,
 	}
 	var primary *db.Column
 	columnMaps := make(map[string]*db.Column, len(data.Columns))
@@ -124,6 +345,9 @@ func (operation *TableOperation) FormatTableData(jsonString string) (*db.TableDa
 		if ok {
 			return nil,fmt.Errorf("column `%s` is repeat", c.Name)
 		}
+		if db.IsBlobType(c.Type) && len(c.Default) > 0 {
+			return nil,fmt.Errorf("column `%s` BLOB or TEXT type can not set default", c.Name)
+		}
 		id := db.ColumnID(i+1)
 		column := &db.Column{Id:id,ColumnConfig:c}
 		if column.Name == data.PrimaryKey.ColumnName {
@@ -153,5 +377,24 @@ func (operation *TableOperation) FormatTableData(jsonString string) (*db.TableDa
 		foreignKey := db.ForeignKey{ColumnID:column.Id,Reference:db.ReferenceKey{ColumnID:table.Primary.Id,TableID:table.Data.Id}}
 		tableData.ForeignKeys = append(tableData.ForeignKeys, foreignKey)
 	}
+	counterColumn := func(counter Counter, columnName string) (db.ColumnID,error) {
+		if columnName == "" {
+			return 0,nil
+		}
+		column,ok := columnMaps[columnName]
+		if !ok {
+			return 0,fmt.Errorf("counter `%s` column `%s` not found in columns", counter.Name, columnName)
+		}
+		return column.Id,nil
+	}
+	for _,counter := range data.Counters {
+		column,err := counterColumn(counter, counter.Column); if err != nil {
+			return nil,err
+		}
+		groupBy,err := counterColumn(counter, counter.GroupBy); if err != nil {
+			return nil,err
+		}
+		tableData.Counters = append(tableData.Counters, db.CounterConfig{Name:counter.Name,Column:column,GroupBy:groupBy})
+	}
 	return tableData,nil
 }
\ No newline at end of file
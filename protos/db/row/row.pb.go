@@ -58,6 +58,8 @@ type BlockData struct {
 	Time                 int64              `protobuf:"varint,3,opt,name=time,proto3" json:"time,omitempty"`
 	Rows                 []*RowData         `protobuf:"bytes,4,rep,name=rows,proto3" json:"rows,omitempty"`
 	Join                 BlockData_JoinType `protobuf:"varint,5,opt,name=join,proto3,enum=row.BlockData_JoinType" json:"join,omitempty"`
+	Checksum             []byte             `protobuf:"bytes,6,opt,name=checksum,proto3" json:"checksum,omitempty"`
+	PrevHash             []byte             `protobuf:"bytes,7,opt,name=prev_hash,json=prevHash,proto3" json:"prev_hash,omitempty"`
 	XXX_NoUnkeyedLiteral struct{}           `json:"-"`
 	XXX_unrecognized     []byte             `json:"-"`
 	XXX_sizecache        int32              `json:"-"`
@@ -123,13 +125,33 @@ func (m *BlockData) GetJoin() BlockData_JoinType {
 	return BlockData_JOIN_NONE
 }
 
+func (m *BlockData) GetChecksum() []byte {
+	if m != nil {
+		return m.Checksum
+	}
+	return nil
+}
+
+func (m *BlockData) GetPrevHash() []byte {
+	if m != nil {
+		return m.PrevHash
+	}
+	return nil
+}
+
 type RowData struct {
-	Id                   int64         `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
-	Op                   uint32        `protobuf:"varint,2,opt,name=op,proto3" json:"op,omitempty"`
-	Columns              []*ColumnData `protobuf:"bytes,3,rep,name=columns,proto3" json:"columns,omitempty"`
-	XXX_NoUnkeyedLiteral struct{}      `json:"-"`
-	XXX_unrecognized     []byte        `json:"-"`
-	XXX_sizecache        int32         `json:"-"`
+	Id      int64         `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+	Op      uint32        `protobuf:"varint,2,opt,name=op,proto3" json:"op,omitempty"`
+	Columns []*ColumnData `protobuf:"bytes,3,rep,name=columns,proto3" json:"columns,omitempty"`
+	//增量行的基础版本块ID，为0时为完整行
+	Base int32 `protobuf:"varint,4,opt,name=base,proto3" json:"base,omitempty"`
+	//增量行距离最近完整行的层数
+	Depth uint32 `protobuf:"varint,5,opt,name=depth,proto3" json:"depth,omitempty"`
+	//增量行修改的列下标，与columns一一对应
+	Delta                []uint32 `protobuf:"varint,6,rep,packed,name=delta,proto3" json:"delta,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
 }
 
 func (m *RowData) Reset()         { *m = RowData{} }
@@ -178,6 +200,27 @@ func (m *RowData) GetColumns() []*ColumnData {
 	return nil
 }
 
+func (m *RowData) GetBase() int32 {
+	if m != nil {
+		return m.Base
+	}
+	return 0
+}
+
+func (m *RowData) GetDepth() uint32 {
+	if m != nil {
+		return m.Depth
+	}
+	return 0
+}
+
+func (m *RowData) GetDelta() []uint32 {
+	if m != nil {
+		return m.Delta
+	}
+	return nil
+}
+
 type ColumnData struct {
 	Data                 []byte   `protobuf:"bytes,1,opt,name=data,proto3" json:"data,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
@@ -227,22 +270,27 @@ func init() {
 func init() { proto.RegisterFile("row.proto", fileDescriptor_dbfce2cce8f2e8cd) }
 
 var fileDescriptor_dbfce2cce8f2e8cd = []byte{
-	// 269 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x4c, 0x50, 0xbd, 0x4e, 0xc3, 0x30,
-	0x10, 0xc6, 0x76, 0x4a, 0x9b, 0xeb, 0x5f, 0x74, 0x0c, 0x78, 0xb4, 0x32, 0x05, 0x21, 0x65, 0x28,
-	0x0b, 0x33, 0x85, 0xa1, 0x15, 0x24, 0x92, 0x55, 0xc4, 0x58, 0x85, 0xa6, 0x83, 0xa1, 0xcd, 0x45,
-	0xa9, 0x51, 0xca, 0x43, 0xf2, 0x4e, 0x28, 0x27, 0x0a, 0x6c, 0x77, 0xdf, 0x9d, 0xbe, 0x3f, 0x08,
-	0x1b, 0x6a, 0xd3, 0xba, 0x21, 0x4f, 0xa8, 0x1a, 0x6a, 0xe3, 0x2f, 0x01, 0xe1, 0xdd, 0x8e, 0x36,
-	0xef, 0xf7, 0x85, 0x2f, 0x70, 0x02, 0xd2, 0x95, 0x5a, 0x18, 0x91, 0xf4, 0xac, 0x74, 0x25, 0x5e,
-	0x40, 0xcf, 0x1f, 0xd7, 0xae, 0xd4, 0xd2, 0x88, 0x24, 0xb4, 0x81, 0x3f, 0x2e, 0x4a, 0x44, 0x08,
-	0xbc, 0xdb, 0x6f, 0xb5, 0x32, 0x22, 0x51, 0x96, 0x67, 0x34, 0x10, 0x34, 0xd4, 0x1e, 0x74, 0x60,
-	0x54, 0x32, 0x9c, 0x8d, 0xd2, 0x4e, 0xc5, 0x52, 0xdb, 0x91, 0x5a, 0xbe, 0xe0, 0x35, 0x04, 0x6f,
-	0xe4, 0x2a, 0xdd, 0x33, 0x22, 0x99, 0xcc, 0x2e, 0xf9, 0xe3, 0x57, 0x38, 0x5d, 0x92, 0xab, 0x56,
-	0x9f, 0xf5, 0xd6, 0xf2, 0x53, 0x7c, 0x0b, 0x83, 0x13, 0x82, 0x63, 0x08, 0x97, 0xf9, 0x22, 0x5b,
-	0x67, 0x79, 0xf6, 0x10, 0x9d, 0xe1, 0x08, 0x06, 0xbc, 0xda, 0xfc, 0x25, 0x12, 0x38, 0x85, 0x21,
-	0x6f, 0xf3, 0xfc, 0xf1, 0xf9, 0x29, 0x8b, 0x64, 0xbc, 0x82, 0xfe, 0x8f, 0xee, 0xbf, 0x30, 0x8a,
-	0xc3, 0x4c, 0x40, 0x52, 0xcd, 0x49, 0xc6, 0x56, 0x52, 0x8d, 0x57, 0xd0, 0xdf, 0xd0, 0xee, 0x63,
-	0x5f, 0x1d, 0xb4, 0x62, 0xdb, 0x53, 0x36, 0x35, 0x67, 0x8c, 0x9d, 0x9f, 0xee, 0xb1, 0x01, 0xf8,
-	0x83, 0xbb, 0x02, 0xca, 0xc2, 0x17, 0x4c, 0x3d, 0xb2, 0x3c, 0xbf, 0x9e, 0x73, 0xa7, 0x37, 0xdf,
-	0x01, 0x00, 0x00, 0xff, 0xff, 0x6d, 0xb5, 0x13, 0xf6, 0x60, 0x01, 0x00, 0x00,
+	// 341 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x4c, 0x91, 0xcf, 0x4a, 0xc3, 0x40,
+	0x10, 0xc6, 0xdd, 0xfc, 0x69, 0x9b, 0x69, 0xfa, 0x87, 0x55, 0x70, 0xd1, 0xcb, 0x92, 0x53, 0x44,
+	0xe8, 0xa1, 0x5e, 0x3c, 0x5b, 0x05, 0x5b, 0x34, 0x81, 0x45, 0xf1, 0x58, 0xb6, 0x49, 0x20, 0xb1,
+	0x69, 0x37, 0x24, 0x5b, 0x53, 0x5f, 0xc0, 0x27, 0xf0, 0x81, 0x25, 0x13, 0x5b, 0xbd, 0xcd, 0xf7,
+	0xcd, 0xf2, 0xcd, 0xcc, 0x6f, 0xc1, 0x29, 0x55, 0x3d, 0x29, 0x4a, 0xa5, 0x15, 0x35, 0x4b, 0x55,
+	0x7b, 0x5f, 0x06, 0x38, 0x77, 0xb9, 0x8a, 0xd6, 0xf7, 0x52, 0x4b, 0x3a, 0x04, 0x23, 0x8b, 0x19,
+	0xe1, 0xc4, 0xb7, 0x85, 0x91, 0xc5, 0xf4, 0x14, 0x6c, 0xbd, 0x5f, 0x66, 0x31, 0x33, 0x38, 0xf1,
+	0x1d, 0x61, 0xe9, 0xfd, 0x3c, 0xa6, 0x14, 0x2c, 0x9d, 0x6d, 0x12, 0x66, 0x72, 0xe2, 0x9b, 0x02,
+	0x6b, 0xca, 0xc1, 0x2a, 0x55, 0x5d, 0x31, 0x8b, 0x9b, 0x7e, 0x7f, 0xea, 0x4e, 0x9a, 0x29, 0x42,
+	0xd5, 0x4d, 0xa8, 0xc0, 0x0e, 0xbd, 0x06, 0xeb, 0x5d, 0x65, 0x5b, 0x66, 0x73, 0xe2, 0x0f, 0xa7,
+	0xe7, 0xf8, 0xe2, 0x38, 0x78, 0xb2, 0x50, 0xd9, 0xf6, 0xe5, 0xb3, 0x48, 0x04, 0x3e, 0xa2, 0x17,
+	0xd0, 0x8b, 0xd2, 0x24, 0x5a, 0x57, 0xbb, 0x0d, 0xeb, 0x70, 0xe2, 0xbb, 0xe2, 0xa8, 0xe9, 0x25,
+	0x38, 0x45, 0x99, 0x7c, 0x2c, 0x53, 0x59, 0xa5, 0xac, 0xdb, 0x36, 0x1b, 0xe3, 0x51, 0x56, 0xa9,
+	0x77, 0x0b, 0xbd, 0x43, 0x14, 0x1d, 0x80, 0xb3, 0x08, 0xe7, 0xc1, 0x32, 0x08, 0x83, 0x87, 0xf1,
+	0x09, 0x75, 0xa1, 0x87, 0x52, 0x84, 0x6f, 0x63, 0x42, 0x47, 0xd0, 0x47, 0x35, 0x0b, 0x9f, 0x5e,
+	0x9f, 0x83, 0xb1, 0xe1, 0x7d, 0x13, 0xe8, 0xfe, 0x6e, 0xfc, 0x0f, 0x83, 0x89, 0x18, 0x86, 0x60,
+	0xa8, 0x02, 0x19, 0x0c, 0x84, 0xa1, 0x0a, 0x7a, 0x05, 0xdd, 0x48, 0xe5, 0xbb, 0xcd, 0xb6, 0x62,
+	0x26, 0x1e, 0x3c, 0xc2, 0x73, 0x66, 0xe8, 0xe1, 0xcd, 0x87, 0x7e, 0x03, 0x6b, 0x25, 0xab, 0x84,
+	0x59, 0xc8, 0x14, 0x6b, 0x7a, 0x06, 0x76, 0x9c, 0x14, 0x3a, 0x45, 0x16, 0x03, 0xd1, 0x8a, 0xd6,
+	0xcd, 0xb5, 0x64, 0x1d, 0x6e, 0xb6, 0x6e, 0xae, 0xa5, 0xc7, 0x01, 0xfe, 0x62, 0x9b, 0xb4, 0x58,
+	0x6a, 0x89, 0xab, 0xb9, 0x02, 0xeb, 0x55, 0x07, 0x7f, 0xf3, 0xe6, 0x67, 0x00, 0x3d, 0x39, 0x0f,
+	0x92, 0xda, 0x01, 0x00, 0x00,
 }
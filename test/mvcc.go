@@ -0,0 +1,350 @@
+package test
+
+import (
+	"fmt"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+	"sort"
+	"strconv"
+)
+
+//Key版本，与Fabric一致使用(区块号,交易号)
+type Version struct {
+	BlockNum uint64
+	TxNum    uint64
+}
+
+type versionedValue struct {
+	value   []byte
+	version Version
+}
+
+//读集中的单个Key，Version为nil表示读取时Key不存在
+type KVRead struct {
+	Collection string
+	Key        string
+	Version    *Version
+}
+
+type KVWrite struct {
+	Collection string
+	Key        string
+	Value      []byte
+	IsDelete   bool
+}
+
+//区间查询读集，用于提交时检测幻读
+type RangeRead struct {
+	Collection string
+	StartKey   string
+	EndKey     string
+	Reads      []KVRead
+}
+
+//交易验证结果
+type TxResult struct {
+	TxID           string
+	ValidationCode pb.TxValidationCode
+	ConflictKeys   []string //导致冲突的Key(私有数据为collection/key)
+}
+
+/**
+	MVCC模拟账本，维护带版本的世界状态
+	NewTx创建的交易基于当前已提交状态模拟执行并记录读写集
+	CommitBlock按提交顺序验证交易，读集版本变化的交易标记为MVCC_READ_CONFLICT并丢弃写集
+ */
+type MVCCLedger struct {
+	data      map[string]map[string]*versionedValue
+	blockNum  uint64
+	txIdNum   int64
+	conflicts map[string]int
+}
+
+func NewMVCCLedger() *MVCCLedger {
+	return &MVCCLedger{data: map[string]map[string]*versionedValue{}, conflicts: map[string]int{}}
+}
+
+/**
+	创建新交易，args为合约函数和参数
+ */
+func (ledger *MVCCLedger) NewTx(args ...string) *MVCCStub {
+	ledger.txIdNum++
+	stub := &MVCCStub{ledger: ledger, reads: map[string]KVRead{}, writes: map[string]KVWrite{}}
+	stub.TxID = strconv.FormatInt(ledger.txIdNum, 10)
+	stub.Args = args
+	return stub
+}
+
+/**
+	按顺序验证并提交一个区块的交易，返回每个交易的验证结果
+ */
+func (ledger *MVCCLedger) CommitBlock(txs ...*MVCCStub) []*TxResult {
+	ledger.blockNum++
+	results := make([]*TxResult, 0, len(txs))
+	for txNum, tx := range txs {
+		result := ledger.validate(tx)
+		if result.ValidationCode == pb.TxValidationCode_VALID {
+			ledger.apply(tx, Version{ledger.blockNum, uint64(txNum)})
+		} else {
+			for _, key := range result.ConflictKeys {
+				ledger.conflicts[key]++
+			}
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+/**
+	冲突热点Key，按冲突次数倒序
+ */
+func (ledger *MVCCLedger) Hotspots() []Hotspot {
+	hotspots := make([]Hotspot, 0, len(ledger.conflicts))
+	for key, count := range ledger.conflicts {
+		hotspots = append(hotspots, Hotspot{key, count})
+	}
+	sort.Slice(hotspots, func(i, j int) bool {
+		if hotspots[i].Conflicts != hotspots[j].Conflicts {
+			return hotspots[i].Conflicts > hotspots[j].Conflicts
+		}
+		return hotspots[i].Key < hotspots[j].Key
+	})
+	return hotspots
+}
+
+type Hotspot struct {
+	Key       string
+	Conflicts int
+}
+
+func (ledger *MVCCLedger) validate(tx *MVCCStub) *TxResult {
+	result := &TxResult{TxID: tx.TxID, ValidationCode: pb.TxValidationCode_VALID}
+	for _, read := range tx.readList() {
+		if !sameVersion(read.Version, ledger.getVersion(read.Collection, read.Key)) {
+			result.ValidationCode = pb.TxValidationCode_MVCC_READ_CONFLICT
+			result.ConflictKeys = append(result.ConflictKeys, displayKey(read.Collection, read.Key))
+		}
+	}
+	if result.ValidationCode != pb.TxValidationCode_VALID {
+		return result
+	}
+	for _, rangeRead := range tx.rangeReads {
+		if !ledger.sameRange(rangeRead) {
+			result.ValidationCode = pb.TxValidationCode_PHANTOM_READ_CONFLICT
+			result.ConflictKeys = append(result.ConflictKeys, displayKey(rangeRead.Collection, rangeRead.StartKey+".."+rangeRead.EndKey))
+		}
+	}
+	return result
+}
+
+func (ledger *MVCCLedger) sameRange(rangeRead RangeRead) bool {
+	reads := ledger.rangeReads(rangeRead.Collection, rangeRead.StartKey, rangeRead.EndKey)
+	if len(reads) != len(rangeRead.Reads) {
+		return false
+	}
+	for i, read := range reads {
+		if read.Key != rangeRead.Reads[i].Key || !sameVersion(read.Version, rangeRead.Reads[i].Version) {
+			return false
+		}
+	}
+	return true
+}
+
+func (ledger *MVCCLedger) apply(tx *MVCCStub, version Version) {
+	for _, write := range tx.writes {
+		if write.IsDelete {
+			delete(ledger.data[write.Collection], write.Key)
+			continue
+		}
+		if ledger.data[write.Collection] == nil {
+			ledger.data[write.Collection] = map[string]*versionedValue{}
+		}
+		ledger.data[write.Collection][write.Key] = &versionedValue{write.Value, version}
+	}
+}
+
+func (ledger *MVCCLedger) get(collection string, key string) *versionedValue {
+	if ledger.data[collection] == nil {
+		return nil
+	}
+	return ledger.data[collection][key]
+}
+
+func (ledger *MVCCLedger) getVersion(collection string, key string) *Version {
+	value := ledger.get(collection, key)
+	if value == nil {
+		return nil
+	}
+	version := value.version
+	return &version
+}
+
+//区间为[startKey,endKey)，endKey为空表示不限
+func (ledger *MVCCLedger) rangeReads(collection string, startKey string, endKey string) []KVRead {
+	var keys []string
+	for key := range ledger.data[collection] {
+		if key >= startKey && (endKey == "" || key < endKey) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	reads := make([]KVRead, 0, len(keys))
+	for _, key := range keys {
+		reads = append(reads, KVRead{collection, key, ledger.getVersion(collection, key)})
+	}
+	return reads
+}
+
+func sameVersion(v1 *Version, v2 *Version) bool {
+	if v1 == nil || v2 == nil {
+		return v1 == v2
+	}
+	return *v1 == *v2
+}
+
+func displayKey(collection string, key string) string {
+	if collection == DEFAULT_COLLECTION {
+		return key
+	}
+	return collection + "/" + key
+}
+
+/**
+	MVCC模拟交易，读取已提交数据(读不到本交易的写)，并记录读写集
+ */
+type MVCCStub struct {
+	TestChaincodeStub
+	ledger     *MVCCLedger
+	reads      map[string]KVRead
+	readOrder  []string
+	rangeReads []RangeRead
+	writes     map[string]KVWrite
+}
+
+func (stub *MVCCStub) GetTxID() string {
+	return stub.TxID
+}
+
+func (stub *MVCCStub) ReadSet() []KVRead {
+	return stub.readList()
+}
+
+func (stub *MVCCStub) RangeReadSet() []RangeRead {
+	return stub.rangeReads
+}
+
+func (stub *MVCCStub) WriteSet() []KVWrite {
+	writes := make([]KVWrite, 0, len(stub.writes))
+	for _, write := range stub.writes {
+		writes = append(writes, write)
+	}
+	sort.Slice(writes, func(i, j int) bool {
+		return displayKey(writes[i].Collection, writes[i].Key) < displayKey(writes[j].Collection, writes[j].Key)
+	})
+	return writes
+}
+
+func (stub *MVCCStub) readList() []KVRead {
+	reads := make([]KVRead, 0, len(stub.readOrder))
+	for _, name := range stub.readOrder {
+		reads = append(reads, stub.reads[name])
+	}
+	return reads
+}
+
+func (stub *MVCCStub) read(collection string, key string) ([]byte, error) {
+	if key == "" {
+		return nil, fmt.Errorf("key must not be an empty string")
+	}
+	name := collection + string(rune(minUnicodeRuneValue)) + key
+	if _, ok := stub.reads[name]; !ok {
+		stub.reads[name] = KVRead{collection, key, stub.ledger.getVersion(collection, key)}
+		stub.readOrder = append(stub.readOrder, name)
+	}
+	value := stub.ledger.get(collection, key)
+	if value == nil {
+		return nil, nil
+	}
+	return value.value, nil
+}
+
+func (stub *MVCCStub) write(collection string, key string, value []byte, isDelete bool) error {
+	if key == "" {
+		return fmt.Errorf("key must not be an empty string")
+	}
+	stub.writes[collection+string(rune(minUnicodeRuneValue))+key] = KVWrite{collection, key, value, isDelete}
+	return nil
+}
+
+func (stub *MVCCStub) readRange(collection string, startKey string, endKey string) (shim.StateQueryIteratorInterface, error) {
+	reads := stub.ledger.rangeReads(collection, startKey, endKey)
+	stub.rangeReads = append(stub.rangeReads, RangeRead{collection, startKey, endKey, reads})
+	var response []map[string][]byte
+	for _, read := range reads {
+		response = append(response, map[string][]byte{read.Key: stub.ledger.get(collection, read.Key).value})
+	}
+	return createStateQueryIterator(response), nil
+}
+
+func (stub *MVCCStub) GetState(key string) ([]byte, error) {
+	return stub.read(DEFAULT_COLLECTION, key)
+}
+
+func (stub *MVCCStub) PutState(key string, value []byte) error {
+	return stub.write(DEFAULT_COLLECTION, key, value, false)
+}
+
+func (stub *MVCCStub) DelState(key string) error {
+	return stub.write(DEFAULT_COLLECTION, key, nil, true)
+}
+
+func (stub *MVCCStub) GetStateByRange(startKey, endKey string) (shim.StateQueryIteratorInterface, error) {
+	return stub.readRange(DEFAULT_COLLECTION, startKey, endKey)
+}
+
+func (stub *MVCCStub) GetStateByPartialCompositeKey(objectType string, keys []string) (shim.StateQueryIteratorInterface, error) {
+	startKey, endKey, err := stub.createRangeKeysForPartialCompositeKey(objectType, keys)
+	if err != nil {
+		return nil, err
+	}
+	return stub.readRange(DEFAULT_COLLECTION, startKey, endKey)
+}
+
+func (stub *MVCCStub) GetPrivateData(collection string, key string) ([]byte, error) {
+	if collection == "" {
+		return nil, fmt.Errorf("collection must not be an empty string")
+	}
+	return stub.read(collection, key)
+}
+
+func (stub *MVCCStub) PutPrivateData(collection string, key string, value []byte) error {
+	if collection == "" {
+		return fmt.Errorf("collection must not be an empty string")
+	}
+	return stub.write(collection, key, value, false)
+}
+
+func (stub *MVCCStub) DelPrivateData(collection string, key string) error {
+	if collection == "" {
+		return fmt.Errorf("collection must not be an empty string")
+	}
+	return stub.write(collection, key, nil, true)
+}
+
+func (stub *MVCCStub) GetPrivateDataByRange(collection, startKey, endKey string) (shim.StateQueryIteratorInterface, error) {
+	if collection == "" {
+		return nil, fmt.Errorf("collection must not be an empty string")
+	}
+	return stub.readRange(collection, startKey, endKey)
+}
+
+func (stub *MVCCStub) GetPrivateDataByPartialCompositeKey(collection, objectType string, keys []string) (shim.StateQueryIteratorInterface, error) {
+	if collection == "" {
+		return nil, fmt.Errorf("collection must not be an empty string")
+	}
+	startKey, endKey, err := stub.createRangeKeysForPartialCompositeKey(objectType, keys)
+	if err != nil {
+		return nil, err
+	}
+	return stub.readRange(collection, startKey, endKey)
+}
@@ -0,0 +1,395 @@
+package test
+
+import (
+	"fmt"
+	"github.com/database-fabric/db"
+	"github.com/database-fabric/db/database"
+	"github.com/database-fabric/db/storage"
+	"github.com/database-fabric/db/storage/state"
+	"github.com/database-fabric/db/util"
+	"github.com/database-fabric/protos/db/row"
+	pb "github.com/hyperledger/fabric/protos/peer"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestMVCC(t *testing.T) {
+	ledger := NewMVCCLedger()
+	dataBase := &db.DataBase{Id:db.DatabaseID(1)}
+	tableData := &db.TableData{Name:"TestTable",
+		Columns:[]db.Column{
+			{Id:db.ColumnID(1),ColumnConfig:db.ColumnConfig{Name:"id",Type:db.INT,NotNull:true},Order:1},
+			{Id:db.ColumnID(2),ColumnConfig:db.ColumnConfig{Name:"name",Type:db.VARCHAR},Order:2},
+		},
+		PrimaryKey:db.PrimaryKey{ColumnID:db.ColumnID(1),AutoIncrement:true}}
+	//创建表
+	{
+		stub := ledger.NewTx("CreateTable", "")
+		tableID, err := database.NewDatabaseImpl(dataBase, state.NewStateImpl(stub)).CreateTableData(tableData); if err != nil {
+			panic(err.Error())
+		}
+		tableData.Id = tableID
+		results := ledger.CommitBlock(stub)
+		assert.Equal(t, pb.TxValidationCode_VALID, results[0].ValidationCode)
+	}
+	//同一区块并发插入同一张表，后提交的交易读集中的统计Key已变化
+	{
+		var txs []*MVCCStub
+		for i := 0; i < 3; i++ {
+			stub := ledger.NewTx("AddRow", "")
+			rows := []*row.RowData{{Op:uint32(db.ADD),Columns:[]*row.ColumnData{{Data:util.RowIDToBytes(0)},{Data:[]byte("name")}}}}
+			if err := database.NewDatabaseImpl(dataBase, state.NewStateImpl(stub)).AddRowData(tableData, rows); err != nil {
+				panic(err.Error())
+			}
+			assert.NotEmpty(t, stub.ReadSet())
+			assert.NotEmpty(t, stub.WriteSet())
+			txs = append(txs, stub)
+		}
+		results := ledger.CommitBlock(txs...)
+		assert.Equal(t, pb.TxValidationCode_VALID, results[0].ValidationCode)
+		tallyKey := "3-" + util.Int64ToKeyString(1) + "~" + util.Int64ToKeyString(int64(tableData.Id))
+		for _, result := range results[1:] {
+			assert.Equal(t, pb.TxValidationCode_MVCC_READ_CONFLICT, result.ValidationCode)
+			assert.Contains(t, result.ConflictKeys, tallyKey)
+		}
+		hotspots := ledger.Hotspots()
+		assert.NotEmpty(t, hotspots)
+		assert.Equal(t, 2, hotspots[0].Conflicts)
+	}
+	//统计分片表并发插入，统计Key和主键索引不再冲突
+	{
+		shardTable := *tableData
+		shardTable.Name = "TestShardTable"
+		shardTable.TallyShards = 16
+		stub := ledger.NewTx("CreateTable", "")
+		tableID, err := database.NewDatabaseImpl(dataBase, state.NewStateImpl(stub)).CreateTableData(&shardTable); if err != nil {
+			panic(err.Error())
+		}
+		ledger.CommitBlock(stub)
+		var txs []*MVCCStub
+		for i := 0; i < 3; i++ {
+			stub := ledger.NewTx("AddRow", "")
+			rows := []*row.RowData{{Op:uint32(db.ADD),Columns:[]*row.ColumnData{{Data:util.RowIDToBytes(0)},{Data:[]byte("name")}}}}
+			if err := database.NewDatabaseImpl(dataBase, state.NewStateImpl(stub)).AddRowData(&shardTable, rows); err != nil {
+				panic(err.Error())
+			}
+			txs = append(txs, stub)
+		}
+		tallyKey := "3-" + util.Int64ToKeyString(1) + "~" + util.Int64ToKeyString(int64(tableID)) + "~"
+		for _, result := range ledger.CommitBlock(txs...) {
+			assert.Equal(t, pb.TxValidationCode_VALID, result.ValidationCode, "shard tx conflict")
+			for _, key := range result.ConflictKeys {
+				assert.False(t, strings.HasPrefix(key, tallyKey), "tally key conflict")
+			}
+		}
+		//各分片主键索引树合并查询
+		reader := database.NewDatabaseImpl(dataBase, state.NewStateImpl(ledger.NewTx("Query", "")))
+		rows, err := reader.QueryRowDataByRange(&shardTable, 0, 0, db.ASC, 10); if err != nil {
+			panic(err.Error())
+		}
+		assert.Equal(t, 3, len(rows))
+		for i := 1; i < len(rows); i++ {
+			assert.True(t, rows[i-1].Id < rows[i].Id, "shard rows order error")
+		}
+		count, err := reader.QueryRowCountByRange(&shardTable, 0, 0); if err != nil {
+			panic(err.Error())
+		}
+		assert.EqualValues(t, 3, count)
+	}
+	//区间查询结果变化导致幻读
+	{
+		reader := ledger.NewTx("Query", "")
+		if _, err := reader.GetStateByRange("a", "c"); err != nil {
+			panic(err.Error())
+		}
+		writer := ledger.NewTx("Put", "")
+		if err := writer.PutState("b", []byte("b")); err != nil {
+			panic(err.Error())
+		}
+		results := ledger.CommitBlock(writer, reader)
+		assert.Equal(t, pb.TxValidationCode_VALID, results[0].ValidationCode)
+		assert.Equal(t, pb.TxValidationCode_PHANTOM_READ_CONFLICT, results[1].ValidationCode)
+	}
+	//删除表分批清除所有Key，每批在新的事务中提交
+	{
+		dropDatabase := &db.DataBase{Id:db.DatabaseID(2)}
+		var dropStorage *storage.DatabaseStorage
+		commit := func(f func(databaseImpl *database.DatabaseImpl)) {
+			stub := ledger.NewTx("DropTable", "")
+			chainCodeState := state.NewStateImpl(stub)
+			dropStorage = storage.NewDatabaseStorage(chainCodeState)
+			f(database.NewDatabaseImpl(dropDatabase, chainCodeState))
+			results := ledger.CommitBlock(stub)
+			assert.Equal(t, pb.TxValidationCode_VALID, results[0].ValidationCode, "drop table commit error")
+		}
+		newTable := func(name string) *db.TableData {
+			return &db.TableData{Name:name,
+				Columns:[]db.Column{
+					{Id:db.ColumnID(1),ColumnConfig:db.ColumnConfig{Name:"id",Type:db.INT,NotNull:true},Order:1},
+					{Id:db.ColumnID(2),ColumnConfig:db.ColumnConfig{Name:"name",Type:db.VARCHAR},Order:2},
+				},
+				PrimaryKey:db.PrimaryKey{ColumnID:db.ColumnID(1),AutoIncrement:true},
+				TallyShards:2}
+		}
+		//表1与表12的Key前缀相同
+		tables := make([]*db.TableData, 12)
+		commit(func(databaseImpl *database.DatabaseImpl) {
+			for i := range tables {
+				tables[i] = newTable(fmt.Sprintf("TestDropTable%d", i+1))
+				tableID,err := databaseImpl.CreateTableData(tables[i]); if err != nil {
+					panic(err.Error())
+				}
+				tables[i].Id = tableID
+			}
+			if err := databaseImpl.PutRelation(&db.Relation{Keys:[]db.RelationKey{{Id:1,TableID:tables[0].Id,ForeignKey:db.ForeignKey{ColumnID:db.ColumnID(2),Reference:db.ReferenceKey{TableID:tables[1].Id,ColumnID:db.ColumnID(1)}}}}}); err != nil {
+				panic(err.Error())
+			}
+		})
+		for i:=0;i<20;i++ {
+			commit(func(databaseImpl *database.DatabaseImpl) {
+				for _,table := range []*db.TableData{tables[0],tables[11]} {
+					rows := []*row.RowData{{Op:uint32(db.ADD),Columns:[]*row.ColumnData{{Data:util.RowIDToBytes(0)},{Data:[]byte(fmt.Sprintf("name%d", i))}}}}
+					if err := databaseImpl.AddRowData(table, rows); err != nil {
+						panic(err.Error())
+					}
+				}
+			})
+		}
+		commit(func(databaseImpl *database.DatabaseImpl) {
+			if err := databaseImpl.DeleteTableData(tables[0].Id); err != nil {
+				panic(err.Error())
+			}
+			err := databaseImpl.DeleteTableData(tables[0].Id)
+			assert.NotNil(t, err, "delete table twice error")
+			_,err = databaseImpl.DropTable(tables[1].Id,0)
+			assert.NotNil(t, err, "drop table not deleted error")
+		})
+		var drop *db.TableDrop
+		batches := 0
+		for drop == nil || !drop.Done {
+			commit(func(databaseImpl *database.DatabaseImpl) {
+				var err error
+				drop,err = databaseImpl.DropTable(tables[0].Id,8); if err != nil {
+					panic(err.Error())
+				}
+			})
+			batches++
+		}
+		assert.True(t, batches > 1, "drop table batches error")
+		assert.True(t, drop.Keys > 20, "drop table keys error")
+		assert.Equal(t, tables[0].Name, drop.Name, "drop table name error")
+		commit(func(databaseImpl *database.DatabaseImpl) {
+			for _,keyRange := range dropStorage.GetTableKeyRanges(dropDatabase.Id, tables[0].Id) {
+				if !keyRange.Prefix {
+					value,err := dropStorage.GetKey(keyRange.Key); if err != nil {
+						panic(err.Error())
+					}
+					assert.Empty(t, value, "drop table key error")
+					continue
+				}
+				keys,err := dropStorage.GetKeysByPrefix(keyRange.Key,10); if err != nil {
+					panic(err.Error())
+				}
+				assert.Empty(t, keys, "drop table prefix keys error")
+			}
+			relation,err := databaseImpl.GetRelation(); if err != nil {
+				panic(err.Error())
+			}
+			assert.True(t, relation.Keys[0].IsDeleted, "drop table relation error")
+			//前缀相同的表不受影响
+			rows,err := databaseImpl.QueryRowDataByRange(tables[11], db.RowID(1), db.RowID(20), db.ASC,100); if err != nil {
+				panic(err.Error())
+			}
+			assert.Equal(t, 20, len(rows), "drop table other table rows error")
+			//表名重新创建为新的表ID
+			tableID,err := databaseImpl.CreateTableData(newTable(tables[0].Name)); if err != nil {
+				panic(err.Error())
+			}
+			assert.EqualValues(t, 13, tableID, "drop table name reuse error")
+			again,err := databaseImpl.DropTable(tables[0].Id,0); if err != nil {
+				panic(err.Error())
+			}
+			assert.True(t, again.Done, "drop table again error")
+		})
+	}
+	//旧布局Key分批迁移为保序编码，迁移后区间查询按数值顺序返回
+	{
+		source := NewMVCCLedger()
+		layoutTable := &db.TableData{Name:"TestLayout",
+			Columns:[]db.Column{
+				{Id:db.ColumnID(1),ColumnConfig:db.ColumnConfig{Name:"id",Type:db.INT,NotNull:true},Order:1},
+				{Id:db.ColumnID(2),ColumnConfig:db.ColumnConfig{Name:"name",Type:db.VARCHAR},Order:2},
+			},
+			PrimaryKey:db.PrimaryKey{ColumnID:db.ColumnID(1),AutoIncrement:true}}
+		for i:=0;i<13;i++ {
+			stub := source.NewTx("AddRow", "")
+			databaseImpl := database.NewDatabaseImpl(dataBase, state.NewStateImpl(stub))
+			if i == 0 {
+				tableID,err := databaseImpl.CreateTableData(layoutTable); if err != nil {
+					panic(err.Error())
+				}
+				layoutTable.Id = tableID
+			} else {
+				rows := []*row.RowData{{Op:uint32(db.ADD),Columns:[]*row.ColumnData{{Data:util.RowIDToBytes(0)},{Data:[]byte(fmt.Sprintf("name%d", i))}}}}
+				if err := databaseImpl.AddRowData(layoutTable, rows); err != nil {
+					panic(err.Error())
+				}
+			}
+			source.CommitBlock(stub)
+		}
+		//按旧布局写入另一个账本，Key中的整数为十进制
+		toLegacy := func(key string) string {
+			position := strings.Index(key, "-")
+			prefix,rest := key[:position+1],key[position+1:]
+			if prefix == util.UInt8ToString(db.IndexKeyType)+"-" {
+				position = strings.Index(rest, "-")
+				prefix,rest = prefix+rest[:position+1],rest[position+1:]
+			}
+			parts := strings.Split(rest, "~")
+			for i,part := range parts {
+				if prefix == util.UInt8ToString(db.ChunkKeyType)+"-" && i == 1 {
+					continue
+				}
+				value,err := util.KeyStringToInt64(part); if err != nil {
+					panic(err.Error())
+				}
+				parts[i] = fmt.Sprintf("%d", value)
+			}
+			return prefix + strings.Join(parts, "~")
+		}
+		legacy := NewMVCCLedger()
+		stub := legacy.NewTx("Legacy", "")
+		migrated := 0
+		for key,value := range source.data[DEFAULT_COLLECTION] {
+			if strings.HasPrefix(key, "-") {
+				continue
+			}
+			if err := stub.PutState(toLegacy(key), value.value); err != nil {
+				panic(err.Error())
+			}
+			migrated++
+		}
+		if err := stub.PutState("-" + util.UInt8ToString(db.ChainKeyType), []byte(`["TestLayout"]`)); err != nil {
+			panic(err.Error())
+		}
+		legacy.CommitBlock(stub)
+		assert.NotNil(t, storage.NewLayoutStorage(state.NewStateImpl(legacy.NewTx("Check", ""))).CheckKeyLayout(), "legacy layout check error")
+		//迁移完成前不能写入和区间查询
+		legacyImpl := database.NewDatabaseImpl(dataBase, state.NewStateImpl(legacy.NewTx("AddRow", "")))
+		legacyRows := []*row.RowData{{Op:uint32(db.ADD),Columns:[]*row.ColumnData{{Data:util.RowIDToBytes(0)},{Data:[]byte("legacy")}}}}
+		assert.NotNil(t, legacyImpl.AddRowData(layoutTable, legacyRows), "legacy layout add row error")
+		_,err := legacyImpl.QueryRowDataByRange(layoutTable, 0, 0, db.ASC,10)
+		assert.NotNil(t, err, "legacy layout range query error")
+		checkpoint := &db.KeyMigrateCheckpoint{}
+		batches := 0
+		for !checkpoint.Done {
+			stub := legacy.NewTx("Migrate", "")
+			var err error
+			checkpoint,err = storage.NewLayoutStorage(state.NewStateImpl(stub)).MigrateKeyLayout(*checkpoint,8); if err != nil {
+				panic(err.Error())
+			}
+			results := legacy.CommitBlock(stub)
+			assert.Equal(t, pb.TxValidationCode_VALID, results[0].ValidationCode, "migrate commit error")
+			batches++
+		}
+		assert.True(t, batches > 1, "migrate batches error")
+		assert.EqualValues(t, migrated, checkpoint.Keys, "migrate keys error")
+		for key,value := range source.data[DEFAULT_COLLECTION] {
+			assert.Equal(t, value.value, legacy.get(DEFAULT_COLLECTION, key).value, "migrate key %s error", key)
+		}
+		stub = legacy.NewTx("Query", "")
+		chainCodeState := state.NewStateImpl(stub)
+		assert.Nil(t, storage.NewLayoutStorage(chainCodeState).CheckKeyLayout(), "migrated layout check error")
+		rowsData,err := database.NewDatabaseImpl(dataBase, chainCodeState).QueryRowDataByRange(layoutTable, 0, 0, db.ASC,20); if err != nil {
+			panic(err.Error())
+		}
+		assert.Len(t, rowsData, 12, "migrated range query error")
+		prefix := "5-" + util.Int64ToKeyString(int64(dataBase.Id)) + "~" + util.Int64ToKeyString(int64(layoutTable.Id)) + "~"
+		keys,err := chainCodeState.GetKeysByRange(prefix, prefix+string(utf8.MaxRune),100); if err != nil {
+			panic(err.Error())
+		}
+		assert.True(t, len(keys) >= 10, "migrate block keys error")
+		for i:=1;i<len(keys);i++ {
+			last,err := util.KeyStringToInt64(keys[i-1][len(prefix):]); if err != nil {
+				panic(err.Error())
+			}
+			current,err := util.KeyStringToInt64(keys[i][len(prefix):]); if err != nil {
+				panic(err.Error())
+			}
+			assert.True(t, last < current, "migrate block order error")
+		}
+		rows,err := database.NewDatabaseImpl(dataBase, chainCodeState).QueryRowDataByRange(layoutTable, db.RowID(1), db.RowID(12), db.ASC,100); if err != nil {
+			panic(err.Error())
+		}
+		assert.Equal(t, 12, len(rows), "migrate rows error")
+	}
+	//连续的块和行连接块使用区间流式读取，不逐个读取块Key
+	{
+		scanDatabase := &db.DataBase{Id:db.DatabaseID(3)}
+		scanTable := &db.TableData{Name:"TestScan",
+			Columns:[]db.Column{
+				{Id:db.ColumnID(1),ColumnConfig:db.ColumnConfig{Name:"id",Type:db.INT,NotNull:true},Order:1},
+				{Id:db.ColumnID(2),ColumnConfig:db.ColumnConfig{Name:"name",Type:db.VARCHAR},Order:2},
+			},
+			PrimaryKey:db.PrimaryKey{ColumnID:db.ColumnID(1),AutoIncrement:true}}
+		names := map[db.RowID][]byte{}
+		for i:=0;i<8;i++ {
+			stub := ledger.NewTx("AddRow", "")
+			databaseImpl := database.NewDatabaseImpl(scanDatabase, state.NewStateImpl(stub))
+			if i == 0 {
+				tableID,err := databaseImpl.CreateTableData(scanTable); if err != nil {
+					panic(err.Error())
+				}
+				scanTable.Id = tableID
+			}
+			//偶数行切割到多个块
+			name := []byte(fmt.Sprintf("name%d", i))
+			if i%2 == 0 {
+				name = []byte(strings.Repeat(fmt.Sprintf("%d", i), 3*db.DefaultBlockSize))
+			}
+			rowID := db.RowID(i+1)
+			op := db.ADD
+			if i == 7 {//更新第一行
+				rowID,op = db.RowID(1),db.UPDATE
+			}
+			names[rowID] = name
+			rows := []*row.RowData{{Id:int64(rowID),Op:uint32(op),Columns:[]*row.ColumnData{{Data:util.RowIDToBytes(rowID)},{Data:name}}}}
+			if err := databaseImpl.AddRowData(scanTable, rows); err != nil {
+				panic(err.Error())
+			}
+			results := ledger.CommitBlock(stub)
+			assert.Equal(t, pb.TxValidationCode_VALID, results[0].ValidationCode, "scan commit error")
+		}
+		blockReads := func(stub *MVCCStub) int {
+			count := 0
+			for _,read := range stub.ReadSet() {
+				if strings.HasPrefix(read.Key, util.UInt8ToString(db.BlockKeyType)+"-") {
+					count++
+				}
+			}
+			return count
+		}
+		stub := ledger.NewTx("QueryRange", "")
+		databaseImpl := database.NewDatabaseImpl(scanDatabase, state.NewStateImpl(stub))
+		rows,err := databaseImpl.QueryRowDataByRange(scanTable, db.RowID(1), db.RowID(7), db.ASC,100); if err != nil {
+			panic(err.Error())
+		}
+		assert.Equal(t, 7, len(rows), "scan rows error")
+		for _,rowData := range rows {
+			assert.Equal(t, names[db.RowID(rowData.Id)], rowData.Columns[1].Data, "scan row %d error", rowData.Id)
+		}
+		//只有更新行的块不与其他块连续，单独读取
+		assert.Equal(t, 1, blockReads(stub), "scan block point reads error")
+		assert.NotEmpty(t, stub.RangeReadSet(), "scan block range reads error")
+		stub = ledger.NewTx("QueryHistory", "")
+		databaseImpl = database.NewDatabaseImpl(scanDatabase, state.NewStateImpl(stub))
+		histories,_,err := databaseImpl.QueryRowDataHistoryByRange(scanTable, db.RowID(1), db.DESC,10); if err != nil {
+			panic(err.Error())
+		}
+		assert.Equal(t, 2, len(histories), "scan history error")
+		assert.Equal(t, names[db.RowID(1)], histories[0].Row.Columns[1].Data, "scan history latest error")
+		assert.Equal(t, []byte(strings.Repeat("0", 3*db.DefaultBlockSize)), histories[1].Row.Columns[1].Data, "scan history first error")
+	}
+}
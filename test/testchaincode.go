@@ -8,7 +8,6 @@ import (
 	pb "github.com/hyperledger/fabric/protos/peer"
 	"sort"
 	"strconv"
-	"strings"
 	"time"
 	"unicode/utf8"
 )
@@ -248,7 +247,7 @@ func (iter *StateQueryIterator) Next() (*queryresult.KV, error) {
 		result := iter.response[iter.currentLoc]
 		iter.currentLoc++
 		for k,v := range result {
-			return &queryresult.KV{"", k,v, struct{}{},nil,0 },nil
+			return &queryresult.KV{Key:k,Value:v},nil
 		}
 	}
 	return nil, fmt.Errorf("result not found")
@@ -309,12 +308,12 @@ func createCompositeKey(objectType string, attributes []string) (string, error)
 	if err := validateCompositeKeyAttribute(objectType); err != nil {
 		return "", err
 	}
-	ck := compositeKeyNamespace + objectType + string(minUnicodeRuneValue)
+	ck := compositeKeyNamespace + objectType + string(rune(minUnicodeRuneValue))
 	for _, att := range attributes {
 		if err := validateCompositeKeyAttribute(att); err != nil {
 			return "", err
 		}
-		ck += att + string(minUnicodeRuneValue)
+		ck += att + string(rune(minUnicodeRuneValue))
 	}
 	return ck, nil
 }
@@ -395,75 +394,22 @@ func (stub *TestChaincodeStub) GetPrivateDataByRange(collection, startKey, endKe
 func (stub *TestChaincodeStub) handleGetStateByRange(collection, startKey, endKey string) (shim.StateQueryIteratorInterface, error) {
 	var response []map[string][]byte
 	data := stub.Data[collection]
-	keys := FilterKey(endKey, data)
-	keys = OrderByKey(keys)
-
-	first := false
-	for _,k := range keys {
-
-		if !first && strings.Index(k,startKey) < 0 {
-			continue
-		}
-		first = true
-
-		if strings.Index(endKey, string(maxUnicodeRuneValue)) > 0 {
-			newEndKey := strings.Replace(endKey, string(maxUnicodeRuneValue), "", 1)
-			if strings.Index(k,newEndKey) < 0  {
-				break
-			}
+	//Key使用保序编码，按字符串顺序返回[startKey,endKey)区间
+	keys := make([]string, 0, len(data))
+	for k,_ := range data {
+		if k >= startKey && (endKey == "" || k < endKey) {
+			keys = append(keys, k)
 		}
-
+	}
+	sort.Strings(keys)
+	for _,k := range keys {
 		response = append(response, map[string][]byte{k:data[k]})
-
-		if strings.Index(k,endKey) >= 0 {
-			break
-		}
 	}
 
 	iterator := createStateQueryIterator(response)
 	return iterator,nil
 }
 
-func FilterKey(endKey string, data map[string][]byte) []string {
-	var keys []string
-	splitKeys := strings.Split(endKey, string(compositeKeyNamespace))
-	key := ""
-	for i,k := range splitKeys {
-		if i < len(splitKeys) - 1 {
-			key += k + string(compositeKeyNamespace)
-		}
-	}
-	for k,_ := range data {
-		if strings.Index(k, key) >= 0 {
-			keys = append(keys, k)
-		}
-	}
-
-	return keys
-}
-
-type Keys []string
-func (p Keys) Len() int           { return len(p) }
-func (p Keys) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
-type SortByLength struct{ Keys }
-func (p SortByLength) Less(i, j int) bool {	return len(p.Keys[i]) < len(p.Keys[j]) }
-type SortByName struct{ Keys }
-func (p SortByName) Less(i, j int) bool { return p.Keys[i] < p.Keys[j] && len(p.Keys[i]) == len(p.Keys[j]) }
-
-func OrderByKey(keys []string) []string {
-	sort.Sort(SortByLength{keys})
-
-	//str,_ := t.ConvertJsonBytes(keys)
-	//fmt.Println(string(str))
-
-	sort.Sort(SortByName{keys})
-
-	//str1,_ := t.ConvertJsonBytes(keys)
-	//fmt.Println(string(str1))
-
-	return keys
-}
-
 func createStateQueryIterator(response []map[string][]byte) *StateQueryIterator {
 	return &StateQueryIterator{CommonIterator: &CommonIterator{
 		channelId:  "",